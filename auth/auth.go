@@ -0,0 +1,376 @@
+// Package auth checks bearer tokens on the grpc apis.  Tokens are JWTs signed (HMAC:
+// HS256, HS384 or HS512) with one of the keys the nodes are configured with; they say
+// what the holder can do in their scope claim, and can be held to some customer ids.
+//
+//	{"sub": "billing", "exp": 1700000000, "scope": "read:customer write:customer", "customers": ["1-1000", "4242"]}
+//
+// read:TYPE and write:TYPE are for an aggregate type's streams (customer is the
+// ProtoStuff api, and the customer streams on EventStore), admin:cluster is
+// ClusterManagment.  The nodes have the keys too, and sign themselves node tokens for
+// calling each other; node is everything, including Replication.
+//
+// Without keys there's no auth at all, which is how it has always been.
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/rpcname"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Scopes.  read: and write: go with an aggregate type; these are the customer ones
+const (
+	ScopeReadCustomer  = "read:customer"
+	ScopeWriteCustomer = "write:customer"
+	ScopeAdminCluster  = "admin:cluster"
+	// ScopeNode is for the nodes calling each other: it can do anything
+	ScopeNode = "node"
+)
+
+// MinKeyLength is the shortest secret taken, in bytes
+const MinKeyLength = 32
+
+// Config is the keys tokens are signed with.  Issuer and Audience are optional; if
+// they're set, tokens have to have them
+type Config struct {
+	// Keys are base64 secrets, optionally with an id in front (id:secret) that tokens
+	// name in their kid header.  The first signs the nodes' own tokens; the rest are
+	// still taken, so keys can be rotated
+	Keys     []string
+	Issuer   string
+	Audience string
+
+	Logger *zap.Logger
+}
+
+// Key is a parsed signing key
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKey is the [id:]base64 form keys are configured in
+func ParseKey(s string) (Key, error) {
+	var k Key
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		k.ID, s = s[:i], s[i+1:]
+	}
+	secret, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return k, fmt.Errorf("key %q: %w", k.ID, err)
+	}
+	if len(secret) < MinKeyLength {
+		return k, fmt.Errorf("key %q: %d bytes; it needs at least %d", k.ID, len(secret), MinKeyLength)
+	}
+	k.Secret = secret
+	return k, nil
+}
+
+// Range of customer ids, inclusive
+type Range struct {
+	From, To uint64
+}
+
+// ParseRange is "from-to", or just "id"
+func ParseRange(s string) (Range, error) {
+	from, to := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	f, err := strconv.ParseUint(strings.TrimSpace(from), 10, 64)
+	if err != nil {
+		return Range{}, fmt.Errorf("customer range %q: %w", s, err)
+	}
+	t, err := strconv.ParseUint(strings.TrimSpace(to), 10, 64)
+	if err != nil {
+		return Range{}, fmt.Errorf("customer range %q: %w", s, err)
+	}
+	if t < f {
+		return Range{}, fmt.Errorf("customer range %q: ends before it starts", s)
+	}
+	return Range{From: f, To: t}, nil
+}
+
+// Claims in a token
+type Claims struct {
+	jwt.StandardClaims
+	// Scope is space separated, like oauth's
+	Scope string `json:"scope,omitempty"`
+	// Customers the token is held to: ranges of ids, "from-to" or "id".  None is all
+	// of them
+	Customers []string `json:"customers,omitempty"`
+
+	ranges []Range
+}
+
+// Valid is the standard claims' checks, plus: there has to be an expiry, and the
+// customer ranges have to make sense
+func (c *Claims) Valid() error {
+	if err := c.StandardClaims.Valid(); err != nil {
+		return err
+	}
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("token doesn't expire")
+	}
+	c.ranges = c.ranges[:0]
+	for _, s := range c.Customers {
+		r, err := ParseRange(s)
+		if err != nil {
+			return err
+		}
+		c.ranges = append(c.ranges, r)
+	}
+	return nil
+}
+
+// Allows is true if the token has scope (or is a node's)
+func (c *Claims) Allows(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope || s == ScopeNode {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsStream is true if the token can get at the stream with this id.  A token
+// held to some customers can only get at numeric ids, in its ranges
+func (c *Claims) AllowsStream(id string) bool {
+	if len(c.Customers) == 0 || c.Allows(ScopeNode) {
+		return true
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	// "007" isn't customer 7: it's its own stream
+	if err != nil || strconv.FormatUint(n, 10) != id {
+		return false
+	}
+	for _, r := range c.ranges {
+		if n >= r.From && n <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsKey struct{}
+
+// FromContext is the verified claims of the call, if there were any
+func FromContext(ctx context.Context) *Claims {
+	c, _ := ctx.Value(claimsKey{}).(*Claims)
+	return c
+}
+
+// Authenticator checks tokens, and signs them
+type Authenticator struct {
+	keys     []Key
+	byID     map[string]Key
+	issuer   string
+	audience string
+	log      *zap.Logger
+
+	// open methods (full name, or service) don't need a token: health
+	open map[string]bool
+}
+
+// New authenticator with c's keys; nil if there aren't any
+func New(c Config) (*Authenticator, error) {
+	if len(c.Keys) == 0 {
+		return nil, nil
+	}
+	a := &Authenticator{
+		byID:     map[string]Key{},
+		issuer:   c.Issuer,
+		audience: c.Audience,
+		log:      logging.Or(c.Logger).Named("auth"),
+		open:     map[string]bool{"grpc.health.v1.Health": true},
+	}
+	for _, s := range c.Keys {
+		k, err := ParseKey(s)
+		if err != nil {
+			return nil, err
+		}
+		if k.ID != "" {
+			if _, ok := a.byID[k.ID]; ok {
+				return nil, fmt.Errorf("key %q: given twice", k.ID)
+			}
+			a.byID[k.ID] = k
+		}
+		a.keys = append(a.keys, k)
+	}
+	return a, nil
+}
+
+// Open lets services (or methods: service/method) be called without a token
+func (a *Authenticator) Open(names ...string) {
+	for _, n := range names {
+		a.open[n] = true
+	}
+}
+
+// Sign claims with the first key.  Issuer and audience are filled in if they're set
+// and the claims don't have them
+func (a *Authenticator) Sign(c Claims) (string, error) {
+	if c.Issuer == "" {
+		c.Issuer = a.issuer
+	}
+	if c.Audience == "" {
+		c.Audience = a.audience
+	}
+	return Sign(a.keys[0], c)
+}
+
+// Sign claims with k, HS256
+func Sign(k Key, c Claims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, &c)
+	if k.ID != "" {
+		t.Header["kid"] = k.ID
+	}
+	return t.SignedString(k.Secret)
+}
+
+// Verify a token: its signature (with the key it names, or any of them if it
+// doesn't), expiry, issuer and audience
+func (a *Authenticator) Verify(token string) (*Claims, error) {
+	var claims *Claims
+	var err error
+	for _, k := range a.keys {
+		claims = &Claims{}
+		named := false
+		_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+			}
+			if kid, ok := t.Header["kid"].(string); ok && kid != "" {
+				named = true
+				byID, ok := a.byID[kid]
+				if !ok {
+					return nil, fmt.Errorf("unknown key %q", kid)
+				}
+				return byID.Secret, nil
+			}
+			return k.Secret, nil
+		})
+		// a token that names its key has had its only go
+		if err == nil || named {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.issuer != "" && !claims.VerifyIssuer(a.issuer, true) {
+		return nil, fmt.Errorf("token isn't from %s", a.issuer)
+	}
+	if a.audience != "" && !claims.VerifyAudience(a.audience, true) {
+		return nil, fmt.Errorf("token isn't for %s", a.audience)
+	}
+	return claims, nil
+}
+
+// authenticate the call in ctx: its bearer token, verified
+func (a *Authenticator) authenticate(ctx context.Context) (*Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "no token")
+	}
+	const prefix = "bearer "
+	token := values[0]
+	if len(token) < len(prefix) || !strings.EqualFold(token[:len(prefix)], prefix) {
+		return nil, status.Errorf(codes.Unauthenticated, "authorization isn't a bearer token")
+	}
+	claims, err := a.Verify(token[len(prefix):])
+	if err != nil {
+		logging.FromContext(ctx, a.log).Debug("bad token", zap.Error(err))
+		return nil, status.Errorf(codes.Unauthenticated, "bad token: %s", err)
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) isOpen(method string) bool {
+	for _, name := range rpcname.Names(method) {
+		if a.open[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryInterceptor wants a token good for the method, and the stream it's about
+func (a *Authenticator) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if a.isOpen(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	claims, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(claims, info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, claimsKey{}, claims), req)
+}
+
+// StreamInterceptor is UnaryInterceptor for streams.  The method is checked up front;
+// the stream it's about when the request comes in
+func (a *Authenticator) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if a.isOpen(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	claims, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	if err := authorize(claims, info.FullMethod, nil); err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), claimsKey{}, claims),
+		claims:       claims,
+		method:       info.FullMethod,
+	})
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	claims *Claims
+	method string
+}
+
+func (s *authorizedStream) Context() context.Context { return s.ctx }
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return authorize(s.claims, s.method, m)
+}
+
+// NodeCredentials are what a node calls the others with: a node token, signed
+// again a while before it runs out
+func (a *Authenticator) NodeCredentials(name string) *TokenCredentials {
+	return &TokenCredentials{refresh: func() (string, time.Time, error) {
+		exp := time.Now().Add(nodeTokenTTL)
+		token, err := a.Sign(Claims{
+			StandardClaims: jwt.StandardClaims{Subject: name, ExpiresAt: exp.Unix()},
+			Scope:          ScopeNode,
+		})
+		return token, exp, err
+	}}
+}
+
+const nodeTokenTTL = 10 * time.Minute
@@ -0,0 +1,247 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yarbelk/distributedservice/auth"
+	"github.com/yarbelk/distributedservice/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var (
+	key1 = "one:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", 32)))
+	key2 = "two:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("2", 32)))
+	// no id: tried against every key
+	key3 = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("3", 32)))
+)
+
+func authenticator(t *testing.T, c auth.Config) *auth.Authenticator {
+	t.Helper()
+	a, err := auth.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func sign(t *testing.T, key string, c auth.Claims) string {
+	t.Helper()
+	k, err := auth.ParseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ExpiresAt == 0 {
+		c.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	}
+	token, err := auth.Sign(k, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	a := authenticator(t, auth.Config{Keys: []string{key1, key2, key3}, Issuer: "us"})
+	other := "one:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32)))
+	from := func(scope string) auth.Claims {
+		return auth.Claims{StandardClaims: jwt.StandardClaims{Issuer: "us"}, Scope: scope}
+	}
+	expired := from("node")
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, &auth.Claims{
+		StandardClaims: jwt.StandardClaims{Issuer: "us", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	noExpiry, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{Issuer: "us"}).
+		SignedString([]byte(strings.Repeat("3", 32)))
+	badRange := from("read:customer")
+	badRange.Customers = []string{"10-1"}
+
+	for _, test := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"first key", sign(t, key1, from("node")), true},
+		{"second key", sign(t, key2, from("node")), true},
+		{"key without an id", sign(t, key3, from("node")), true},
+		{"someone else's key", sign(t, other, from("node")), false},
+		{"expired", sign(t, key1, expired), false},
+		{"no expiry", noExpiry, false},
+		{"alg none", none, false},
+		{"wrong issuer", sign(t, key1, auth.Claims{StandardClaims: jwt.StandardClaims{Issuer: "them"}}), false},
+		{"bad customer range", sign(t, key1, badRange), false},
+		{"garbage", "not.a.token", false},
+	} {
+		_, err := a.Verify(test.token)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.name, test.ok, err)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	for name, keys := range map[string][]string{
+		"short":      {short},
+		"not base64": {"id:!!!"},
+		"same id":    {key1, key1},
+	} {
+		if _, err := auth.New(auth.Config{Keys: keys}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if a, err := auth.New(auth.Config{}); a != nil || err != nil {
+		t.Errorf("expected no authenticator without keys, got %v %v", a, err)
+	}
+}
+
+// serve unimplemented services behind a's interceptors: Unimplemented is allowed
+func serve(t *testing.T, a *auth.Authenticator) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(a.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(a.StreamInterceptor),
+	)
+	proto.RegisterProtoStuffServer(srv, proto.UnimplementedProtoStuffServer{})
+	proto.RegisterEventStoreServer(srv, proto.UnimplementedEventStoreServer{})
+	proto.RegisterReplicationServer(srv, proto.UnimplementedReplicationServer{})
+	proto.RegisterClusterManagmentServer(srv, proto.UnimplementedClusterManagmentServer{})
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestScopes(t *testing.T) {
+	a := authenticator(t, auth.Config{Keys: []string{key1}})
+	conn := serve(t, a)
+	stuff := proto.NewProtoStuffClient(conn)
+	events := proto.NewEventStoreClient(conn)
+
+	calls := map[string]func(context.Context) error{
+		"read customer 5": func(ctx context.Context) error {
+			_, err := stuff.CustomerState(ctx, &proto.Customer{Id: 5})
+			return err
+		},
+		"read customer key 5": func(ctx context.Context) error {
+			_, err := stuff.CustomerState(ctx, &proto.Customer{Key: []byte("5")})
+			return err
+		},
+		"read customer key 005": func(ctx context.Context) error {
+			_, err := stuff.CustomerState(ctx, &proto.Customer{Key: []byte("005")})
+			return err
+		},
+		"stream customer 5": func(ctx context.Context) error {
+			s, err := stuff.StreamEventLog(ctx, &proto.Customer{Id: 5})
+			if err != nil {
+				return err
+			}
+			_, err = s.Recv()
+			return err
+		},
+		"stream customer 500": func(ctx context.Context) error {
+			s, err := stuff.StreamEventLog(ctx, &proto.Customer{Id: 500})
+			if err != nil {
+				return err
+			}
+			_, err = s.Recv()
+			return err
+		},
+		"write customer 5": func(ctx context.Context) error {
+			_, err := stuff.WriteLog(ctx, &proto.NewCustomerLog{CustomerID: 5})
+			return err
+		},
+		"write customer 500": func(ctx context.Context) error {
+			_, err := stuff.WriteLog(ctx, &proto.NewCustomerLog{CustomerID: 500})
+			return err
+		},
+		"append customer 5": func(ctx context.Context) error {
+			_, err := events.AppendEvent(ctx, &proto.NewEventLog{Stream: &proto.StreamID{AggregateType: "customer", Id: 5}})
+			return err
+		},
+		"read order 5": func(ctx context.Context) error {
+			_, err := events.AggregateState(ctx, &proto.StreamID{AggregateType: "order", Id: 5})
+			return err
+		},
+		"replicate": func(ctx context.Context) error {
+			_, err := proto.NewReplicationClient(conn).Replicate(ctx, &proto.ReplicateRequest{})
+			return err
+		},
+		"membership": func(ctx context.Context) error {
+			_, err := proto.NewClusterManagmentClient(conn).MembershipList(ctx, &emptypb.Empty{})
+			return err
+		},
+		"health": func(ctx context.Context) error {
+			_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			return err
+		},
+	}
+
+	const (
+		ok     = codes.Unimplemented
+		denied = codes.PermissionDenied
+		unauth = codes.Unauthenticated
+	)
+	for _, test := range []struct {
+		name     string
+		token    string
+		expected map[string]codes.Code
+	}{
+		{"no token", "", map[string]codes.Code{
+			"read customer 5": unauth, "write customer 5": unauth, "stream customer 5": unauth,
+			"replicate": unauth, "membership": unauth, "health": codes.OK,
+		}},
+		{"reader", sign(t, key1, auth.Claims{Scope: "read:customer"}), map[string]codes.Code{
+			"read customer 5": ok, "stream customer 500": ok, "write customer 5": denied,
+			"append customer 5": denied, "read order 5": denied, "replicate": denied, "membership": denied,
+		}},
+		{"writer for 1-100", sign(t, key1, auth.Claims{Scope: "read:customer write:customer", Customers: []string{"1-100"}}), map[string]codes.Code{
+			"read customer 5": ok, "read customer key 5": ok, "read customer key 005": denied,
+			"stream customer 5": ok, "stream customer 500": denied,
+			"write customer 5": ok, "write customer 500": denied, "append customer 5": ok,
+		}},
+		{"orders", sign(t, key1, auth.Claims{Scope: "read:order"}), map[string]codes.Code{
+			"read order 5": ok, "read customer 5": denied,
+		}},
+		{"admin", sign(t, key1, auth.Claims{Scope: "admin:cluster"}), map[string]codes.Code{
+			"membership": ok, "replicate": denied, "read customer 5": denied,
+		}},
+		{"node", sign(t, key1, auth.Claims{Scope: "node", Customers: []string{"1"}}), map[string]codes.Code{
+			"replicate": ok, "membership": ok, "write customer 500": ok, "read order 5": ok,
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for name, expected := range test.expected {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				if test.token != "" {
+					ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+test.token)
+				}
+				if got := status.Code(calls[name](ctx)); got != expected {
+					t.Errorf("%s: expected %s, got %s", name, expected, got)
+				}
+				cancel()
+			}
+		})
+	}
+}
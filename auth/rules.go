@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rule is what a method wants: a scope, or read/write on the type of the stream
+// it's called with
+type rule struct {
+	scope  string
+	access string
+}
+
+func methods(service string, r rule, names ...string) map[string]rule {
+	m := map[string]rule{}
+	for _, n := range names {
+		m["/"+service+"/"+n] = r
+	}
+	return m
+}
+
+// rules for every method; anything not in here is for nodes only
+var rules = func() map[string]rule {
+	all := map[string]rule{}
+	for _, m := range []map[string]rule{
+		methods(proto.ProtoStuff_ServiceDesc.ServiceName, rule{scope: ScopeReadCustomer},
+			"StreamEventLog", "CustomerState", "CustomerConflicts"),
+		methods(proto.ProtoStuff_ServiceDesc.ServiceName, rule{scope: ScopeWriteCustomer},
+			"WriteLog", "ResolveCustomerConflicts"),
+		methods(proto.EventStore_ServiceDesc.ServiceName, rule{access: "read"},
+			"AggregateState", "ListConflicts"),
+		methods(proto.EventStore_ServiceDesc.ServiceName, rule{access: "write"},
+			"AppendEvent", "ResolveConflicts"),
+	} {
+		for k, v := range m {
+			all[k] = v
+		}
+	}
+	for _, m := range proto.ClusterManagment_ServiceDesc.Methods {
+		all["/"+proto.ClusterManagment_ServiceDesc.ServiceName+"/"+m.MethodName] = rule{scope: ScopeAdminCluster}
+	}
+	for _, m := range proto.ClusterManagment_ServiceDesc.Streams {
+		all["/"+proto.ClusterManagment_ServiceDesc.ServiceName+"/"+m.StreamName] = rule{scope: ScopeAdminCluster}
+	}
+	return all
+}()
+
+// authorize claims to call method with req.  req is nil when a stream starts: the
+// method is checked then, and the stream when the request comes in
+func authorize(c *Claims, method string, req interface{}) error {
+	r, ok := rules[method]
+	if !ok {
+		r = rule{scope: ScopeNode}
+	}
+	stream, isStream := data.RequestStream(req)
+	scope := r.scope
+	if r.access != "" {
+		if !isStream {
+			if req == nil {
+				return nil
+			}
+			return status.Errorf(codes.PermissionDenied, "%s: no stream to check", method)
+		}
+		scope = r.access + ":" + stream.Type
+	}
+	if !c.Allows(scope) {
+		return status.Errorf(codes.PermissionDenied, "token doesn't have %s", scope)
+	}
+	if isStream && !c.AllowsStream(stream.ID) {
+		return status.Errorf(codes.PermissionDenied, "token isn't for this customer")
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// SameCluster turns away nodes gossiping another cluster's name or id: set it as the
+// memberlist config's Alive and Merge delegates, with the same Cluster and ClusterID
+// in the local node's Meta.  Without it two clusters that can reach each other (or a
+// node pointed at the wrong seed) merge into one ring.
+type SameCluster struct {
+	Name string
+	ID   string
+}
+
+func (c SameCluster) check(n *memberlist.Node) error {
+	meta, err := NodeMeta(n)
+	if err != nil {
+		return err
+	}
+	if meta.Cluster != c.Name || meta.ClusterID != c.ID {
+		return fmt.Errorf("node %s is in cluster %q (id %q), not %q (id %q)", n.Name, meta.Cluster, meta.ClusterID, c.Name, c.ID)
+	}
+	return nil
+}
+
+// NotifyAlive turns away alive messages from other clusters' nodes
+func (c SameCluster) NotifyAlive(n *memberlist.Node) error {
+	return c.check(n)
+}
+
+// NotifyMerge turns away joining (or being joined by) a cluster with any node from
+// another cluster in it
+func (c SameCluster) NotifyMerge(nodes []*memberlist.Node) error {
+	for _, n := range nodes {
+		if err := c.check(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeKey is a gossip encryption key from base64: 16, 24 or 32 bytes for AES-128,
+// 192 or 256
+func DecodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("key isn't base64: %s", err)
+	}
+	if err := memberlist.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey in base64
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// Keys is the gossip keyring: memberlist encrypts with the primary key and tries
+// them all to decrypt.  Changes are saved to File, if there is one, so a restarted
+// node has the keys the rest of the cluster moved on to.
+type Keys struct {
+	Keyring *memberlist.Keyring
+	File    string
+
+	lock sync.Mutex
+}
+
+// LoadKeys from file: a json list of base64 keys, the primary first.  If there's no
+// file (or no file name) it is started with keys.  No keys at all is nil: gossip
+// isn't encrypted
+func LoadKeys(file string, keys []string) (*Keys, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err == nil {
+			keys = nil
+			if err := json.Unmarshal(b, &keys); err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	decoded := make([][]byte, len(keys))
+	for i, k := range keys {
+		key, err := DecodeKey(k)
+		if err != nil {
+			return nil, err
+		}
+		decoded[i] = key
+	}
+	ring, err := memberlist.NewKeyring(decoded[1:], decoded[0])
+	if err != nil {
+		return nil, err
+	}
+	k := &Keys{Keyring: ring, File: file}
+	return k, k.save()
+}
+
+// List the keys, primary first
+func (k *Keys) List() []string {
+	var keys []string
+	for _, key := range k.Keyring.GetKeys() {
+		keys = append(keys, EncodeKey(key))
+	}
+	return keys
+}
+
+// Install a key: it can decrypt from now on
+func (k *Keys) Install(key []byte) error {
+	return k.change(func() error { return k.Keyring.AddKey(key) })
+}
+
+// Use an installed key to encrypt
+func (k *Keys) Use(key []byte) error {
+	return k.change(func() error { return k.Keyring.UseKey(key) })
+}
+
+// Remove a key that isn't the primary
+func (k *Keys) Remove(key []byte) error {
+	return k.change(func() error { return k.Keyring.RemoveKey(key) })
+}
+
+func (k *Keys) change(fn func() error) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if err := fn(); err != nil {
+		return err
+	}
+	return k.save()
+}
+
+// save the keys to File: written next to it and renamed, so a crash never leaves
+// half a keyring
+func (k *Keys) save() error {
+	if k.File == "" {
+		return nil
+	}
+	b, err := json.Marshal(k.List())
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(k.File), filepath.Base(k.File))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), k.File)
+}
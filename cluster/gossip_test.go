@@ -0,0 +1,110 @@
+package cluster_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+)
+
+var (
+	key1 = cluster.EncodeKey([]byte("0123456789abcdef"))
+	key2 = cluster.EncodeKey([]byte("fedcba9876543210"))
+)
+
+// gossiper is a member of a named cluster, with gossip keys
+func gossiper(t *testing.T, name, clusterName string, keys ...string) *memberlist.Memberlist {
+	cfg := memberlist.DefaultLocalConfig()
+	cfg.Name = name
+	cfg.BindAddr = "127.0.0.1"
+	cfg.BindPort = 0
+	cfg.LogOutput = testWriter{t}
+	cfg.Delegate = &cluster.Delegate{Meta: cluster.Meta{Cluster: clusterName}}
+	same := cluster.SameCluster{Name: clusterName}
+	cfg.Alive, cfg.Merge = same, same
+	ring, err := cluster.LoadKeys("", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring != nil {
+		cfg.Keyring = ring.Keyring
+	}
+	ml, err := memberlist.Create(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ml.Shutdown() })
+	return ml
+}
+
+func join(a, b *memberlist.Memberlist) error {
+	_, err := b.Join([]string{fmt.Sprintf("%s:%d", a.LocalNode().Addr, a.LocalNode().Port)})
+	return err
+}
+
+func TestGossipIsKeptInTheCluster(t *testing.T) {
+	a := gossiper(t, "a", "blue", key1)
+
+	if err := join(a, gossiper(t, "b", "blue", key1)); err != nil {
+		t.Errorf("same cluster and key: %s", err)
+	}
+	if err := join(a, gossiper(t, "c", "green", key1)); err == nil {
+		t.Error("joined a cluster with another name")
+	}
+	if err := join(a, gossiper(t, "d", "blue", key2)); err == nil {
+		t.Error("joined with the wrong key")
+	}
+	if err := join(a, gossiper(t, "e", "blue")); err == nil {
+		t.Error("joined without encryption")
+	}
+	// mid rotation: f has a new key installed, but still uses a's
+	if err := join(a, gossiper(t, "f", "blue", key1, key2)); err != nil {
+		t.Errorf("a's key with another installed: %s", err)
+	}
+	for _, n := range a.Members() {
+		if strings.ContainsAny(n.Name, "cde") {
+			t.Errorf("%s got in", n.Name)
+		}
+	}
+}
+
+func TestKeysFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keyring.json")
+	keys, err := cluster.LoadKeys(file, []string{key1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, _ := cluster.DecodeKey(key2)
+	if err := keys.Install(k2); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Use(k2); err != nil {
+		t.Fatal(err)
+	}
+	k1, _ := cluster.DecodeKey(key1)
+	if err := keys.Remove(k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Remove(k2); err == nil {
+		t.Error("removed the primary key")
+	}
+
+	// the file wins over the starting keys once it's there
+	again, err := cluster.LoadKeys(file, []string{key1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := again.List(); len(got) != 1 || got[0] != key2 {
+		t.Errorf("expected just %s from the file, got %v", key2, got)
+	}
+
+	if none, err := cluster.LoadKeys("", nil); none != nil || err != nil {
+		t.Errorf("expected no keyring without keys, got %v, %v", none, err)
+	}
+	if _, err := cluster.LoadKeys("", []string{"c2hvcnQ="}); err == nil {
+		t.Error("expected a 5 byte key to be an error")
+	}
+}
@@ -0,0 +1,107 @@
+// Package cluster is the glue between memberlist and the grpc services: what
+// each node gossips about itself, and how to get a connection to another node.
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Meta is what a node gossips about itself in its memberlist node meta.
+// memberlist caps this at 512 bytes (memberlist.MetaMaxSize) so keep it small.
+type Meta struct {
+	// GRPCAddr is where the node's grpc server listens.  The memberlist address is
+	// the gossip port, which is no use for talking to the services.
+	GRPCAddr string `json:"grpc"`
+	// RaftAddr is the raft transport, shared by all the node's raft groups.  Empty if
+	// the node isn't running raft.
+	RaftAddr string `json:"raft,omitempty"`
+	// Draining nodes are on their way out: take them off the ring now rather than
+	// when they finally leave.
+	Draining bool `json:"draining,omitempty"`
+	// Cluster and ClusterID have to be the same as ours for a node to be let in; see
+	// SameCluster
+	Cluster   string `json:"cluster,omitempty"`
+	ClusterID string `json:"cluster_id,omitempty"`
+}
+
+// NodeMeta decodes a node's meta
+func NodeMeta(n *memberlist.Node) (Meta, error) {
+	var m Meta
+	if len(n.Meta) == 0 {
+		return m, fmt.Errorf("node %s has no meta", n.Name)
+	}
+	err := json.Unmarshal(n.Meta, &m)
+	return m, err
+}
+
+// Delegate is the memberlist.Delegate that gossips Meta.  Set it as the
+// memberlist config's Delegate before creating the memberlist.  Meta can be set
+// directly until then; after that use Update.
+type Delegate struct {
+	Meta Meta
+
+	lock sync.Mutex
+}
+
+// Update the meta.  It goes out with the next alive message, or call
+// memberlist.UpdateNode to push it now.
+func (d *Delegate) Update(fn func(*Meta)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	fn(&d.Meta)
+}
+
+// NodeMeta is used to retrieve meta-data about the current node
+// when broadcasting an alive message.
+func (d *Delegate) NodeMeta(limit int) []byte {
+	d.lock.Lock()
+	b, err := json.Marshal(d.Meta)
+	d.lock.Unlock()
+	if err != nil || len(b) > limit {
+		// memberlist will panic on oversized meta; send nothing and let NodeMeta
+		// error on the other end instead
+		return nil
+	}
+	return b
+}
+
+// NotifyMsg we don't use user messages (yet)
+func (d *Delegate) NotifyMsg([]byte) {}
+
+// GetBroadcasts nothing to broadcast
+func (d *Delegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+
+// LocalState no push/pull state
+func (d *Delegate) LocalState(join bool) []byte { return nil }
+
+// MergeRemoteState no push/pull state
+func (d *Delegate) MergeRemoteState(buf []byte, join bool) {}
+
+// Events fans memberlist's single EventDelegate out to several
+type Events []memberlist.EventDelegate
+
+// NotifyJoin is invoked when a node is detected to have joined.
+func (e Events) NotifyJoin(n *memberlist.Node) {
+	for _, d := range e {
+		d.NotifyJoin(n)
+	}
+}
+
+// NotifyLeave is invoked when a node is detected to have left (or died).
+func (e Events) NotifyLeave(n *memberlist.Node) {
+	for _, d := range e {
+		d.NotifyLeave(n)
+	}
+}
+
+// NotifyUpdate is invoked when a node is detected to have updated, usually
+// involving the meta data.
+func (e Events) NotifyUpdate(n *memberlist.Node) {
+	for _, d := range e {
+		d.NotifyUpdate(n)
+	}
+}
@@ -0,0 +1,58 @@
+package cluster_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+)
+
+func newMember(t *testing.T, name, grpcAddr string) *memberlist.Memberlist {
+	cfg := memberlist.DefaultLocalConfig()
+	cfg.Name = name
+	cfg.BindAddr = "127.0.0.1"
+	cfg.BindPort = 0 // random
+	cfg.LogOutput = testWriter{t}
+	cfg.Delegate = &cluster.Delegate{Meta: cluster.Meta{GRPCAddr: grpcAddr}}
+	ml, err := memberlist.Create(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ml.Shutdown() })
+	return ml
+}
+
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(b []byte) (int, error) {
+	w.t.Log(string(b))
+	return len(b), nil
+}
+
+func TestMetaIsGossiped(t *testing.T) {
+	a := newMember(t, "a", "10.0.0.1:8080")
+	b := newMember(t, "b", "10.0.0.2:8080")
+	if _, err := b.Join([]string{fmt.Sprintf("%s:%d", a.LocalNode().Addr, a.LocalNode().Port)}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && a.NumMembers() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, n := range a.Members() {
+		if n.Name != "b" {
+			continue
+		}
+		meta, err := cluster.NodeMeta(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.GRPCAddr != "10.0.0.2:8080" {
+			t.Fatalf("expected b's grpc address, got %+v", meta)
+		}
+		return
+	}
+	t.Fatalf("a never saw b: %v", a.Members())
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+	"google.golang.org/grpc"
+)
+
+// ErrClosed is what Conn says once the pool is closed
+var ErrClosed = errors.New("peer connections are closed")
+
+// Peers is a pool of grpc connections to the other nodes, keyed on node name.
+// grpc connections reconnect on their own; so once dialed, we keep them until
+// the address changes.
+type Peers struct {
+	// DialOptions for new connections
+	DialOptions []grpc.DialOption
+
+	lock   sync.Mutex
+	conns  map[string]peerConn
+	closed bool
+}
+
+type peerConn struct {
+	addr string
+	conn *grpc.ClientConn
+}
+
+// Conn to the node's grpc server.  Dialing is non blocking; errors talking to
+// the node come back on the first call.
+func (p *Peers) Conn(n *memberlist.Node) (*grpc.ClientConn, error) {
+	meta, err := NodeMeta(n)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	if p.conns == nil {
+		p.conns = make(map[string]peerConn)
+	}
+	if pc, ok := p.conns[n.Name]; ok {
+		if pc.addr == meta.GRPCAddr {
+			return pc.conn, nil
+		}
+		pc.conn.Close()
+	}
+	conn, err := grpc.Dial(meta.GRPCAddr, p.DialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[n.Name] = peerConn{addr: meta.GRPCAddr, conn: conn}
+	return conn, nil
+}
+
+// Close all the connections; no new ones are made after this
+func (p *Peers) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closed = true
+	for name, pc := range p.conns {
+		pc.conn.Close()
+		delete(p.conns, name)
+	}
+}
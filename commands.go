@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/golang-jwt/jwt"
+	"github.com/yarbelk/distributedservice/auth"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/config"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/gateway"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gopkg.in/yaml.v2"
+)
+
+// commands are run as `distributedservice <command> [flags]`.  Anything else is the
+// flags (and join list) for running a node.
+var commands = map[string]func(args []string) error{
+	"backup":    backupCommand,
+	"config":    configCommand,
+	"drain":     drainCommand,
+	"inspect":   inspectCommand,
+	"keyring":   keyringCommand,
+	"log-level": logLevelCommand,
+	"openapi":   openAPICommand,
+	"restore":   restoreCommand,
+	"snapshot":  snapshotCommand,
+	"token":     tokenCommand,
+}
+
+// nodeFlags are how a command reaches a node: its address, and with tls the cluster
+// CA and a certificate from it (replication and management only take calls from nodes).
+// With auth, a token with admin:cluster
+type nodeFlags struct {
+	addr, ca, cert, key, serverName, token *string
+}
+
+func addNodeFlags(fs *flag.FlagSet, usage string) nodeFlags {
+	return nodeFlags{
+		addr:       fs.String("addr", "127.0.0.1:8080", usage),
+		ca:         fs.String("tls-ca", "", "cluster CA, if the node has tls"),
+		cert:       fs.String("tls-cert", "", "certificate signed by the cluster CA"),
+		key:        fs.String("tls-key", "", "key for -tls-cert"),
+		serverName: fs.String("tls-server-name", "", "name to check the node's certificate for. empty is -addr's host"),
+		token:      fs.String("token", "", "bearer token, if the node has auth (see the token command)"),
+	}
+}
+
+func (f nodeFlags) dial() (*grpc.ClientConn, error) {
+	return f.dialAddr(*f.addr)
+}
+
+// dialAddr dials another node with the same flags
+func (f nodeFlags) dialAddr(addr string) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if *f.ca != "" {
+		creds, err := tlsconfig.ClientCredentials(*f.ca, *f.cert, *f.key, *f.serverName)
+		if err != nil {
+			return nil, err
+		}
+		opts[0] = grpc.WithTransportCredentials(creds)
+	}
+	if *f.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.Token(*f.token)))
+	}
+	return grpc.Dial(addr, opts...)
+}
+
+func printDrain(st *proto.DrainStatus) {
+	fmt.Printf("%s: %d/%d streams handed off (%d logs acknowledged), %d failed",
+		st.GetState(), st.GetStreams(), st.GetTotalStreams(), st.GetLogs(), st.GetFailedStreams())
+	if st.GetError() != "" {
+		fmt.Printf("; last error: %s", st.GetError())
+	}
+	fmt.Println()
+}
+
+// drainCommand drains a node: `drain -addr host:port [tls flags] [-force] [-wait]`
+func drainCommand(args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	node := addNodeFlags(fs, "grpc address of the node to drain")
+	force := fs.Bool("force", false, "leave even if some streams can't be handed off")
+	wait := fs.Bool("wait", true, "wait for the drain to finish")
+	fs.Parse(args)
+
+	conn, err := node.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := proto.NewClusterManagmentClient(conn)
+
+	st, err := client.Drain(context.Background(), &proto.DrainRequest{Force: *force})
+	if err != nil {
+		return err
+	}
+	printDrain(st)
+	for *wait {
+		switch st.GetState() {
+		case proto.DrainStatus_LEAVING:
+			return nil
+		case proto.DrainStatus_FAILED:
+			return fmt.Errorf("drain failed; run it again with -force to leave anyway")
+		}
+		time.Sleep(time.Second)
+		next, err := client.DrainProgress(context.Background(), &emptypb.Empty{})
+		if status.Code(err) == codes.Unavailable {
+			// it has already shut down
+			fmt.Println("node has left")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if next.GetStreams() != st.GetStreams() || next.GetState() != st.GetState() {
+			printDrain(next)
+		}
+		st = next
+	}
+	return nil
+}
+
+// logLevelCommand shows or sets a node's log level: `log-level -addr host:port [tls flags] [level]`
+func logLevelCommand(args []string) error {
+	fs := flag.NewFlagSet("log-level", flag.ExitOnError)
+	node := addNodeFlags(fs, "grpc address of the node")
+	fs.Parse(args)
+
+	conn, err := node.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := proto.NewClusterManagmentClient(conn)
+
+	var level *proto.LogLevel
+	if fs.NArg() > 0 {
+		level, err = client.SetLogLevel(context.Background(), &proto.LogLevel{Level: fs.Arg(0)})
+	} else {
+		level, err = client.GetLogLevel(context.Background(), &emptypb.Empty{})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(level.GetLevel())
+	return nil
+}
+
+// openStore opens a data directory for backup and restore, without badger's chatter.
+// Not one a node is running on: badger locks it
+func openStore(dir string) (*data.BadgerStore, error) {
+	return data.Open(badger.DefaultOptions(dir).WithLogger(nil))
+}
+
+// backupCommand backs up a node: `backup -addr host:port [tls flags] [-since N] [-out file]`,
+// or a stopped node's data directory with `-data dir`.  -since is the version the last
+// one printed, for an incremental backup
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	node := addNodeFlags(fs, "grpc address of the node to back up")
+	dir := fs.String("data", "", "back up this data directory instead of a running node")
+	since := fs.Uint64("since", 0, "only what was written since this version (the last backup prints it). 0 is everything")
+	out := fs.String("out", "-", "file to write the backup to. - is stdout")
+	fs.Parse(args)
+
+	w := os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	var version uint64
+	if *dir != "" {
+		// badger would make an empty one
+		if _, err := os.Stat(*dir); err != nil {
+			return err
+		}
+		store, err := openStore(*dir)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if version, err = store.Backup(w, *since); err != nil {
+			return err
+		}
+	} else {
+		conn, err := node.dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		stream, err := proto.NewClusterManagmentClient(conn).Backup(context.Background(), &proto.BackupRequest{Since: *since})
+		if err != nil {
+			return err
+		}
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(chunk.GetData()); err != nil {
+				return err
+			}
+			version = chunk.GetVersion()
+		}
+	}
+	if *out != "-" {
+		if err := w.Sync(); err != nil {
+			return err
+		}
+	}
+	// stdout might be the backup
+	fmt.Fprintf(os.Stderr, "backed up; -since %d for the next incremental one\n", version)
+	return nil
+}
+
+// restoreCommand restores backups into a new node's empty data directory:
+// `restore -data dir full [incremental...]`, or into a running node that has no data
+// yet with `-addr host:port [tls flags]`.  The incrementals go in the order they
+// were taken.  A cluster wide snapshot goes into a new cluster's data directories,
+// however many there are: `restore -snapshot dir -nodes name=dir,... [-partitions N]
+// [-rep-factor N]`
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	node := addNodeFlags(fs, "grpc address of the node to restore into")
+	dir := fs.String("data", "", "restore into this data directory instead of a running node")
+	snapshot := addSnapshotFlags(fs)
+	fs.Parse(args)
+	if *snapshot.dir != "" {
+		if *snapshot.nodes == "" {
+			return fmt.Errorf("usage: restore -snapshot dir -nodes name=dir,... [-partitions N] [-rep-factor N]")
+		}
+		return restoreSnapshot(*snapshot.dir, *snapshot.nodes, *snapshot.partitions, *snapshot.replicationFactor)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: restore -data dir | -addr host:port [tls flags] full [incremental...]")
+	}
+
+	var backups []io.Reader
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		backups = append(backups, f)
+	}
+	r := io.MultiReader(backups...)
+
+	if *dir != "" {
+		store, err := openStore(*dir)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := store.Restore(r); err != nil {
+			return err
+		}
+		fmt.Println("restored")
+		return nil
+	}
+
+	conn, err := node.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stream, err := proto.NewClusterManagmentClient(conn).Restore(context.Background())
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if serr := stream.Send(&proto.BackupChunk{Data: append([]byte(nil), buf[:n]...)}); serr != nil {
+				// the node gave up; CloseAndRecv says why
+				break
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return err
+	}
+	fmt.Println("restored")
+	return nil
+}
+
+// configCommand checks a node's config without starting it, with the same file, flags
+// and environment it would get: `config check [-print] [-config file] [flags] [join...]`
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: config check [-print] [-config file] [node flags] [join addresses]")
+	}
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	print := fs.Bool("print", false, "print the config the node would run with")
+	c, err := config.Load(fs, args[1:], os.LookupEnv)
+	if err != nil {
+		return err
+	}
+
+	// a DS_ variable that isn't a setting is probably a typo
+	known := map[string]bool{}
+	for _, name := range config.EnvNames() {
+		known[name] = true
+	}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, config.EnvPrefix) && !known[name] {
+			fmt.Printf("warning: %s isn't a setting\n", name)
+		}
+	}
+
+	if *print {
+		// it ends up in terminals and ci logs
+		b, err := yaml.Marshal(c.Redacted())
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(b))
+	}
+	if err := c.Validate(); err != nil {
+		for _, e := range err.(config.Errors) {
+			fmt.Println(e)
+		}
+		return fmt.Errorf("the config has errors")
+	}
+	fmt.Println("config ok")
+	return nil
+}
+
+// openAPICommand prints the http gateway's OpenAPI document; a node with
+// -http-address serves the same at /openapi.json
+func openAPICommand(args []string) error {
+	b, err := json.MarshalIndent(gateway.OpenAPI(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// keyringCommand shows or changes the gossip keys on every node:
+// `keyring -addr host:port [tls flags] [-local] list|install KEY|use KEY|remove KEY`.
+// `keyring generate` prints a new key.
+func keyringCommand(args []string) error {
+	fs := flag.NewFlagSet("keyring", flag.ExitOnError)
+	node := addNodeFlags(fs, "grpc address of any node")
+	local := fs.Bool("local", false, "just the node at -addr")
+	fs.Parse(args)
+	op, key := fs.Arg(0), fs.Arg(1)
+
+	if op == "generate" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		fmt.Println(cluster.EncodeKey(b))
+		return nil
+	}
+
+	conn, err := node.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := proto.NewClusterManagmentClient(conn)
+	req := &proto.KeyRequest{Key: key, Local: *local}
+	ctx := context.Background()
+
+	var resp *proto.KeyringResponse
+	switch op {
+	case "list":
+		resp, err = client.ListKeys(ctx, req)
+	case "install":
+		resp, err = client.InstallKey(ctx, req)
+	case "use":
+		resp, err = client.UseKey(ctx, req)
+	case "remove":
+		resp, err = client.RemoveKey(ctx, req)
+	default:
+		return fmt.Errorf("usage: keyring [flags] list|install KEY|use KEY|remove KEY|generate")
+	}
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, n := range resp.GetNodes() {
+		if n.GetError() != "" {
+			failed++
+			fmt.Printf("%s: failed: %s\n", n.GetNode(), n.GetError())
+			continue
+		}
+		fmt.Printf("%s: %s\n", n.GetNode(), strings.Join(n.GetKeys(), " "))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d nodes failed; run it again once they're fixed", failed, len(resp.GetNodes()))
+	}
+	return nil
+}
+
+// tokenCommand makes api tokens: `token key` prints a new key for -auth-key, and
+// `token sign -key KEY -scope SCOPES [-customers RANGES] [-ttl 24h] [-sub NAME]`
+// prints a token signed with it
+func tokenCommand(args []string) error {
+	if len(args) > 0 && args[0] == "key" {
+		b := make([]byte, auth.MinKeyLength)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(b))
+		return nil
+	}
+	if len(args) == 0 || args[0] != "sign" {
+		return fmt.Errorf("usage: token key | token sign -key KEY -scope SCOPES [flags]")
+	}
+	fs := flag.NewFlagSet("token sign", flag.ExitOnError)
+	key := fs.String("key", "", "one of the nodes' -auth-key keys ([id:]base64)")
+	scope := fs.String("scope", "", "space separated: read:customer, write:customer, admin:cluster, read:TYPE ...")
+	var customers []string
+	fs.Var((*config.List)(&customers), "customers", "comma separated customer id ranges (1-100,42) the token is held to. empty is all")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the token is good for")
+	sub := fs.String("sub", "", "who the token is for")
+	issuer := fs.String("issuer", "", "iss, if the nodes have -auth-issuer")
+	audience := fs.String("audience", "", "aud, if the nodes have -auth-audience")
+	fs.Parse(args[1:])
+
+	k, err := auth.ParseKey(*key)
+	if err != nil {
+		return err
+	}
+	for _, c := range customers {
+		if _, err := auth.ParseRange(c); err != nil {
+			return err
+		}
+	}
+	if *scope == "" {
+		return fmt.Errorf("a token needs a -scope")
+	}
+	token, err := auth.Sign(k, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   *sub,
+			Issuer:    *issuer,
+			Audience:  *audience,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(*ttl).Unix(),
+		},
+		Scope:     *scope,
+		Customers: customers,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
@@ -0,0 +1,707 @@
+// Package config is a node's configuration: what used to be only main's flags, as a
+// typed struct that can also come from a YAML file and DS_ environment variables.
+//
+// Each setting is looked for in order, the last one found winning:
+//
+//   - the defaults (Default)
+//   - the -config file
+//   - environment variables: DS_ and the setting's YAML path in capitals, with _ between
+//     the parts; so anti_entropy.interval is DS_ANTI_ENTROPY_INTERVAL.  Lists are comma
+//     separated
+//   - the flags given on the command line, and the join addresses after them
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/auth"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/gateway"
+	"github.com/yarbelk/distributedservice/ratelimit"
+	"github.com/yarbelk/distributedservice/server"
+	"github.com/yarbelk/distributedservice/service"
+	"github.com/yarbelk/distributedservice/tlsconfig"
+	"github.com/yarbelk/distributedservice/tracing"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix goes in front of every environment variable
+const EnvPrefix = "DS_"
+
+// Config of a node, as it is written in the config file
+type Config struct {
+	// Name of the node: must be unique.  Empty is the hostname
+	Name string `yaml:"name"`
+	// Address to serve grpc on, and the address other nodes reach it on if that can't
+	// be worked out from it
+	Address   string   `yaml:"address"`
+	Advertise string   `yaml:"advertise"`
+	Join      []string `yaml:"join"`
+
+	Cluster Cluster `yaml:"cluster"`
+
+	Partitions        int `yaml:"partitions"`
+	ReplicationFactor int `yaml:"replication_factor"`
+	// Conflicts is how concurrent writes from replicas are resolved: siblings, lww or
+	// priority (with NodePriority)
+	Conflicts    string   `yaml:"conflicts"`
+	NodePriority []string `yaml:"node_priority"`
+
+	Data   string `yaml:"data"`
+	Badger Badger `yaml:"badger"`
+
+	Hints       Hints       `yaml:"hints"`
+	AntiEntropy AntiEntropy `yaml:"anti_entropy"`
+	Raft        Raft        `yaml:"raft"`
+
+	DebugAddress string `yaml:"debug_address"`
+	HTTPAddress  string `yaml:"http_address"`
+	Events       Events `yaml:"events"`
+	Reflection   bool   `yaml:"reflection"`
+
+	Readiness Readiness `yaml:"readiness"`
+	Admission Admission `yaml:"admission"`
+	TLS       TLS       `yaml:"tls"`
+	Auth      Auth      `yaml:"auth"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Log       Log       `yaml:"log"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Cluster is the gossip (memberlist) side.  The tuning knobs are memberlist's; zero
+// leaves the profile's value
+type Cluster struct {
+	// Profile is memberlist's defaults to start from: local, lan or wan
+	Profile string `yaml:"profile"`
+	// Addr and Port to gossip on.  An empty Addr is all of them
+	Addr string `yaml:"addr"`
+	Port int    `yaml:"port"`
+	// Name and ID of the cluster: nodes with different ones aren't let in
+	Name string `yaml:"name"`
+	ID   string `yaml:"id"`
+	// Keys encrypt gossip: base64, 16, 24 or 32 bytes, the primary first.  None is
+	// plaintext.  With a KeyringFile they only start it off; after that it has the
+	// keys, as the keyring command leaves them
+	Keys        []string `yaml:"keys"`
+	KeyringFile string   `yaml:"keyring_file"`
+
+	GossipInterval   time.Duration `yaml:"gossip_interval"`
+	GossipNodes      int           `yaml:"gossip_nodes"`
+	ProbeInterval    time.Duration `yaml:"probe_interval"`
+	ProbeTimeout     time.Duration `yaml:"probe_timeout"`
+	PushPullInterval time.Duration `yaml:"push_pull_interval"`
+	TCPTimeout       time.Duration `yaml:"tcp_timeout"`
+	SuspicionMult    int           `yaml:"suspicion_mult"`
+	RetransmitMult   int           `yaml:"retransmit_mult"`
+}
+
+// Badger tuning; zero leaves badger's default
+type Badger struct {
+	// SyncWrites fsyncs every write.  badger's default is true; unset leaves it
+	SyncWrites       *bool `yaml:"sync_writes"`
+	MaxTableSize     int64 `yaml:"max_table_size"`
+	ValueLogFileSize int64 `yaml:"value_log_file_size"`
+	ValueThreshold   int   `yaml:"value_threshold"`
+	NumMemtables     int   `yaml:"num_memtables"`
+	NumCompactors    int   `yaml:"num_compactors"`
+	// Truncate the value log if it's corrupt, instead of refusing to open
+	Truncate bool `yaml:"truncate"`
+}
+
+// Hints for replicas that are down
+type Hints struct {
+	Limit int           `yaml:"limit"`
+	TTL   time.Duration `yaml:"ttl"`
+}
+
+// AntiEntropy of 0 Interval is off; 0 Rate is unlimited
+type AntiEntropy struct {
+	Interval time.Duration `yaml:"interval"`
+	Rate     float64       `yaml:"rate"`
+}
+
+// Raft not Enabled is best effort replication
+type Raft struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Dir     string `yaml:"dir"`
+}
+
+// Events is the http gateway's event streams: how often they heartbeat, and how many
+// logs a client can fall behind by before it's cut off
+type Events struct {
+	Heartbeat  time.Duration `yaml:"heartbeat"`
+	SendBuffer int           `yaml:"send_buffer"`
+}
+
+// Readiness is when the node starts serving, and stops
+type Readiness struct {
+	MinMembers     int           `yaml:"min_members"`
+	Settle         time.Duration `yaml:"settle"`
+	MaxHealthScore int           `yaml:"max_health_score"`
+}
+
+// Admission is when the apis start turning calls away; see service.Admission.  0 is
+// don't look
+type Admission struct {
+	MaxInFlight     int           `yaml:"max_in_flight"`
+	MaxWriteLatency time.Duration `yaml:"max_write_latency"`
+	MaxHeapMB       int           `yaml:"max_heap_mb"`
+}
+
+// TLS for grpc, raft and calling other nodes; see the tlsconfig package.  No Cert is
+// plaintext
+type TLS struct {
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	CA         string `yaml:"ca"`
+	ClientCA   string `yaml:"client_ca"`
+	ClientAuth string `yaml:"client_auth"`
+	PeerName   string `yaml:"peer_name"`
+}
+
+// Auth is the keys for the apis' bearer tokens; see the auth package.  No keys is no
+// auth
+type Auth struct {
+	Keys     []string `yaml:"keys"`
+	Issuer   string   `yaml:"issuer"`
+	Audience string   `yaml:"audience"`
+}
+
+// RateLimit is the apis' limits, as method=rate[:burst] (a full method or a service;
+// see the ratelimit package).  Client limits are per client, customer limits per
+// stream
+type RateLimit struct {
+	Client   []string      `yaml:"client"`
+	Customer []string      `yaml:"customer"`
+	Idle     time.Duration `yaml:"idle"`
+}
+
+// Tracing is where spans go; see the tracing package
+type Tracing struct {
+	Exporter     string  `yaml:"exporter"`
+	File         string  `yaml:"file"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint"`
+	Sample       float64 `yaml:"sample"`
+}
+
+// Log level and format (json or console)
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Default config: what you get with no file, environment or flags
+func Default() *Config {
+	return &Config{
+		Address:           "0.0.0.0:8080",
+		Cluster:           Cluster{Profile: "local", Port: 7946},
+		Partitions:        1051,
+		ReplicationFactor: 3,
+		Conflicts:         "siblings",
+		Data:              "customer_data/",
+		Hints:             Hints{Limit: data.DefaultHintLimit, TTL: data.DefaultHintTTL},
+		AntiEntropy:       AntiEntropy{Interval: time.Minute, Rate: 10},
+		Raft:              Raft{Address: "0.0.0.0:8090", Dir: "raft_data/"},
+		Events:            Events{Heartbeat: gateway.DefaultHeartbeat, SendBuffer: gateway.DefaultSendBuffer},
+		Readiness:         Readiness{MinMembers: 1, Settle: time.Second, MaxHealthScore: service.DefaultMaxHealthScore},
+		Admission:         Admission{MaxInFlight: 1000, MaxWriteLatency: time.Second},
+		TLS:               TLS{ClientAuth: tlsconfig.ClientAuthNone},
+		Tracing:           Tracing{Exporter: "none", File: "traces.json", OTLPEndpoint: "localhost:4317", Sample: 1},
+		Log:               Log{Level: "info", Format: "json"},
+		ShutdownTimeout:   server.DefaultShutdownTimeout,
+	}
+}
+
+// LoadFile over c.  Keys that aren't settings are an error: they're probably typos
+func (c *Config) LoadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// Flags for c's settings, on fs.  They are the flags nodes have always had; the
+// ones without a flag can be set in the file or the environment
+func (c *Config) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Name, "name", c.Name, "name for node. must be unique")
+	fs.StringVar(&c.Address, "address", c.Address, "address to bind server too")
+	fs.StringVar(&c.Advertise, "advertise", c.Advertise, "grpc address other nodes use to reach this one. defaults to the gossip address with the -address port")
+	fs.StringVar(&c.Cluster.Addr, "cluster-addr", c.Cluster.Addr, "address to gossip on. empty is every address")
+	fs.IntVar(&c.Cluster.Port, "cluster-port", c.Cluster.Port, "port to gossip on. 0 picks a free one")
+	fs.StringVar(&c.Cluster.Name, "cluster-name", c.Cluster.Name, "name of the cluster. nodes gossiping another name aren't let in")
+	fs.Var((*List)(&c.Cluster.Keys), "gossip-key", "base64 gossip encryption key (16, 24 or 32 bytes); comma separated for more, the primary first")
+	fs.StringVar(&c.Cluster.KeyringFile, "keyring-file", c.Cluster.KeyringFile, "file to keep the gossip keys in, so keyring changes outlive a restart")
+	fs.StringVar(&c.Cluster.Profile, "cfg", c.Cluster.Profile, "default config type from memberlist: local, lan or wan")
+	fs.IntVar(&c.Partitions, "partitions", c.Partitions, "chose a big enough prime for balancing")
+	fs.IntVar(&c.ReplicationFactor, "rep-factor", c.ReplicationFactor, "how many replications")
+	fs.StringVar(&c.Conflicts, "conflicts", c.Conflicts, "how to resolve concurrent writes from replicas: siblings, lww or priority")
+	fs.Var((*List)(&c.NodePriority), "node-priority", "comma separated node names, highest priority first, for -conflicts=priority")
+	fs.IntVar(&c.Hints.Limit, "hint-limit", c.Hints.Limit, "how many writes to keep per replica that is down")
+	fs.DurationVar(&c.Hints.TTL, "hint-ttl", c.Hints.TTL, "how long to keep writes for a replica that is down")
+	fs.DurationVar(&c.AntiEntropy.Interval, "anti-entropy-interval", c.AntiEntropy.Interval, "how often to compare partitions with the other replicas. 0 turns anti-entropy off")
+	fs.Float64Var(&c.AntiEntropy.Rate, "anti-entropy-rate", c.AntiEntropy.Rate, "partitions a second anti-entropy compares. 0 is unlimited")
+	fs.StringVar(&c.DebugAddress, "debug-address", c.DebugAddress, "address to serve /metrics (prometheus) and /debug/vars on. empty is off")
+	fs.StringVar(&c.HTTPAddress, "http-address", c.HTTPAddress, "address to serve the apis as http and json on (/openapi.json has them). empty is off")
+
+	fs.BoolVar(&c.Raft.Enabled, "raft", c.Raft.Enabled, "order appends through a raft group per partition, instead of best effort replication")
+	fs.StringVar(&c.Raft.Address, "raft-address", c.Raft.Address, "address to bind the raft transport to, with -raft")
+	fs.StringVar(&c.Raft.Dir, "raft-data", c.Raft.Dir, "which directory to store raft snapshots in")
+
+	fs.StringVar(&c.Data, "data", c.Data, "which directory to store the event data in")
+
+	fs.IntVar(&c.Readiness.MinMembers, "min-members", c.Readiness.MinMembers, "members (this node included) to wait for before serving")
+	fs.DurationVar(&c.Readiness.Settle, "ready-settle", c.Readiness.Settle, "how long membership has to stay the same before serving")
+	fs.IntVar(&c.Readiness.MaxHealthScore, "max-health-score", c.Readiness.MaxHealthScore, "memberlist health score past which the grpc health service reports NOT_SERVING")
+	fs.IntVar(&c.Admission.MaxInFlight, "max-in-flight", c.Admission.MaxInFlight, "api calls in flight before turning more away (low priority ones a bit sooner). 0 is no limit")
+	fs.DurationVar(&c.Admission.MaxWriteLatency, "max-write-latency", c.Admission.MaxWriteLatency, "badger write latency at which api calls are turned away. 0 doesn't look")
+	fs.IntVar(&c.Admission.MaxHeapMB, "max-heap-mb", c.Admission.MaxHeapMB, "heap in use (MB) at which api calls are turned away. 0 doesn't look")
+	fs.BoolVar(&c.Reflection, "reflection", c.Reflection, "register grpc server reflection (for grpcurl)")
+
+	fs.StringVar(&c.TLS.Cert, "tls-cert", c.TLS.Cert, "this node's certificate (pem), signed by -tls-ca. empty is plaintext")
+	fs.StringVar(&c.TLS.Key, "tls-key", c.TLS.Key, "this node's certificate's key (pem)")
+	fs.StringVar(&c.TLS.CA, "tls-ca", c.TLS.CA, "the cluster CA (pem): other nodes' certificates have to be signed by it")
+	fs.StringVar(&c.TLS.ClientCA, "tls-client-ca", c.TLS.ClientCA, "CA (pem) for api clients' certificates, as well as -tls-ca")
+	fs.StringVar(&c.TLS.ClientAuth, "tls-client-auth", c.TLS.ClientAuth, "client certificates for the apis: none, optional or require")
+	fs.StringVar(&c.TLS.PeerName, "tls-peer-name", c.TLS.PeerName, "name to check other nodes' certificates for. empty is the address they're called on")
+
+	fs.Var((*List)(&c.Auth.Keys), "auth-key", "base64 key (at least 32 bytes, optionally id:key) for the apis' tokens; comma separated for more, the one to sign with first. empty is no auth")
+	fs.StringVar(&c.Auth.Issuer, "auth-issuer", c.Auth.Issuer, "iss tokens have to have, if set")
+	fs.StringVar(&c.Auth.Audience, "auth-audience", c.Auth.Audience, "aud tokens have to have, if set")
+
+	fs.Var((*List)(&c.RateLimit.Client), "rate-limit-client", "per client limits: method=rate[:burst], comma separated. method is proto.ProtoStuff/WriteLog, or a whole service")
+	fs.Var((*List)(&c.RateLimit.Customer), "rate-limit-customer", "per customer limits, like -rate-limit-client")
+
+	fs.StringVar(&c.Tracing.Exporter, "trace-exporter", c.Tracing.Exporter, "where spans go: "+strings.Join(tracing.Exporters(), ", "))
+	fs.StringVar(&c.Tracing.File, "trace-file", c.Tracing.File, "file to append spans to, for -trace-exporter=file")
+	fs.StringVar(&c.Tracing.OTLPEndpoint, "otlp-endpoint", c.Tracing.OTLPEndpoint, "otlp collector's grpc address, for -trace-exporter=otlp")
+	fs.Float64Var(&c.Tracing.Sample, "trace-sample", c.Tracing.Sample, "fraction of new traces to record")
+
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "debug, info, warn or error. the log-level command changes it while running")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "json, or console for people")
+
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long in flight rpcs and streams get to finish on SIGTERM/SIGINT before they're cut off")
+}
+
+// List is a comma separated flag; blanks are dropped
+type List []string
+
+func (l *List) String() string { return strings.Join(*l, ",") }
+
+// Set the list, replacing what it had
+func (l *List) Set(s string) error {
+	*l = splitList(s)
+	return nil
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// Load the config for a node's command line args (without the program's name): the
+// defaults, the -config file, the environment (lookup is os.LookupEnv outside of
+// tests), then the flags and join addresses in args.  It isn't validated.
+func Load(fs *flag.FlagSet, args []string, lookup func(string) (string, bool)) (*Config, error) {
+	// the flags go on a throw away config first, to find the file and which ones were
+	// given; they're given again once the file and environment are in
+	given := Default()
+	given.Flags(fs)
+	file := fs.String("config", "", "yaml config file. flags and DS_ environment variables override it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if *file != "" {
+		if err := c.LoadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadEnv(lookup); err != nil {
+		return nil, err
+	}
+	again := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	c.Flags(again)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		// the caller's own flags, and -config, aren't settings
+		if again.Lookup(f.Name) == nil || err != nil {
+			return
+		}
+		err = again.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		c.Join = fs.Args()
+	}
+	return c, nil
+}
+
+// redacted is what Redacted puts in place of a secret
+const redacted = "REDACTED"
+
+// Redacted is a copy of c without its secrets (the gossip and auth keys), for
+// printing.  Auth keys keep their id, if they have one
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Cluster.Keys = make([]string, len(c.Cluster.Keys))
+	for i := range r.Cluster.Keys {
+		r.Cluster.Keys[i] = redacted
+	}
+	r.Auth.Keys = make([]string, len(c.Auth.Keys))
+	for i, k := range c.Auth.Keys {
+		r.Auth.Keys[i] = redacted
+		if j := strings.Index(k, ":"); j > 0 {
+			r.Auth.Keys[i] = k[:j+1] + redacted
+		}
+	}
+	return &r
+}
+
+// Errors is everything wrong with a config
+type Errors []error
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Validate c: nil, or Errors with everything that is wrong with it
+func (c *Config) Validate() error {
+	var errs Errors
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	hostPort := func(name, addr string, empty bool) {
+		if addr == "" {
+			check(empty, "%s: must be set", name)
+			return
+		}
+		_, _, err := net.SplitHostPort(addr)
+		check(err == nil, "%s: %v", name, err)
+	}
+
+	hostPort("address", c.Address, false)
+	hostPort("advertise", c.Advertise, true)
+	for _, j := range c.Join {
+		check(j != "", "join: empty address")
+	}
+
+	switch c.Cluster.Profile {
+	case "local", "lan", "wan":
+	default:
+		check(false, "cluster.profile: %q isn't local, lan or wan", c.Cluster.Profile)
+	}
+	check(c.Cluster.Addr == "" || net.ParseIP(c.Cluster.Addr) != nil, "cluster.addr: %q isn't an ip address", c.Cluster.Addr)
+	check(c.Cluster.Port >= 0 && c.Cluster.Port <= 65535, "cluster.port: %d isn't a port", c.Cluster.Port)
+	for name, v := range map[string]time.Duration{
+		"gossip_interval":    c.Cluster.GossipInterval,
+		"probe_interval":     c.Cluster.ProbeInterval,
+		"probe_timeout":      c.Cluster.ProbeTimeout,
+		"push_pull_interval": c.Cluster.PushPullInterval,
+		"tcp_timeout":        c.Cluster.TCPTimeout,
+	} {
+		check(v >= 0, "cluster.%s: can't be negative", name)
+	}
+	for _, k := range c.Cluster.Keys {
+		_, err := cluster.DecodeKey(k)
+		check(err == nil, "cluster.keys: %v", err)
+	}
+	if c.Cluster.KeyringFile != "" {
+		_, err := os.Stat(filepath.Dir(c.Cluster.KeyringFile))
+		check(err == nil, "cluster.keyring_file: %v", err)
+	}
+	check(c.Cluster.GossipNodes >= 0, "cluster.gossip_nodes: can't be negative")
+	check(c.Cluster.SuspicionMult >= 0, "cluster.suspicion_mult: can't be negative")
+	check(c.Cluster.RetransmitMult >= 0, "cluster.retransmit_mult: can't be negative")
+
+	check(c.Partitions > 0, "partitions: must be more than 0")
+	check(c.ReplicationFactor > 0, "replication_factor: must be more than 0")
+	switch c.Conflicts {
+	case "siblings", "lww":
+	case "priority":
+		check(len(c.NodePriority) > 0, "node_priority: needed for priority conflicts")
+	default:
+		check(false, "conflicts: %q isn't siblings, lww or priority", c.Conflicts)
+	}
+
+	check(c.Data != "", "data: must be set")
+	check(c.Badger.MaxTableSize >= 0, "badger.max_table_size: can't be negative")
+	check(c.Badger.ValueLogFileSize >= 0, "badger.value_log_file_size: can't be negative")
+	check(c.Badger.ValueThreshold >= 0, "badger.value_threshold: can't be negative")
+	check(c.Badger.NumMemtables >= 0, "badger.num_memtables: can't be negative")
+	check(c.Badger.NumCompactors >= 0, "badger.num_compactors: can't be negative")
+
+	check(c.Hints.Limit >= 0, "hints.limit: can't be negative")
+	check(c.Hints.TTL >= 0, "hints.ttl: can't be negative")
+	check(c.AntiEntropy.Interval >= 0, "anti_entropy.interval: can't be negative")
+	check(c.AntiEntropy.Rate >= 0, "anti_entropy.rate: can't be negative")
+	if c.Raft.Enabled {
+		hostPort("raft.address", c.Raft.Address, false)
+		check(c.Raft.Dir != "", "raft.dir: must be set with raft groups")
+	}
+
+	hostPort("debug_address", c.DebugAddress, true)
+	hostPort("http_address", c.HTTPAddress, true)
+	check(c.Events.Heartbeat > 0, "events.heartbeat: must be more than 0")
+	check(c.Events.SendBuffer > 0, "events.send_buffer: must be more than 0")
+	check(c.Readiness.MinMembers >= 1, "readiness.min_members: must be at least 1")
+	check(c.Readiness.Settle >= 0, "readiness.settle: can't be negative")
+	check(c.Readiness.MaxHealthScore > 0, "readiness.max_health_score: must be more than 0")
+
+	check(c.Admission.MaxInFlight >= 0, "admission.max_in_flight: can't be negative")
+	check(c.Admission.MaxWriteLatency >= 0, "admission.max_write_latency: can't be negative")
+	check(c.Admission.MaxHeapMB >= 0, "admission.max_heap_mb: can't be negative")
+
+	if c.TLS.Cert != "" || c.TLS.Key != "" {
+		check(c.TLS.Cert != "" && c.TLS.Key != "", "tls: cert and key go together")
+		check(c.TLS.CA != "", "tls.ca: needed with a cert; nodes always check each other's certificates")
+	}
+	switch c.TLS.ClientAuth {
+	case tlsconfig.ClientAuthNone, tlsconfig.ClientAuthOptional, tlsconfig.ClientAuthRequire:
+		check(c.TLS.Cert != "" || c.TLS.ClientAuth == tlsconfig.ClientAuthNone, "tls.client_auth: needs tls")
+	default:
+		check(false, "tls.client_auth: %q isn't none, optional or require", c.TLS.ClientAuth)
+	}
+	for name, path := range map[string]string{"cert": c.TLS.Cert, "key": c.TLS.Key, "ca": c.TLS.CA, "client_ca": c.TLS.ClientCA} {
+		if path != "" {
+			_, err := os.Stat(path)
+			check(err == nil, "tls.%s: %v", name, err)
+		}
+	}
+
+	if _, err := auth.New(auth.Config{Keys: c.Auth.Keys}); err != nil {
+		check(false, "auth.keys: %v", err)
+	}
+	check(len(c.Auth.Keys) > 0 || c.Auth.Issuer == "" && c.Auth.Audience == "", "auth: issuer and audience need keys")
+
+	for name, limits := range map[string][]string{"client": c.RateLimit.Client, "customer": c.RateLimit.Customer} {
+		_, err := ratelimit.ParseLimits(limits)
+		check(err == nil, "rate_limit.%s: %v", name, err)
+	}
+	check(c.RateLimit.Idle >= 0, "rate_limit.idle: can't be negative")
+
+	exporters := tracing.Exporters()
+	known := false
+	for _, e := range exporters {
+		known = known || e == c.Tracing.Exporter
+	}
+	check(known, "tracing.exporter: %q isn't one of %s", c.Tracing.Exporter, strings.Join(exporters, ", "))
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file: needed for the file exporter")
+	check(c.Tracing.Sample >= 0 && c.Tracing.Sample <= 1, "tracing.sample: %v isn't between 0 and 1", c.Tracing.Sample)
+
+	var level zapcore.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level: %q isn't debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "console", "log.format: %q isn't json or console", c.Log.Format)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout: must be more than 0")
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Memberlist config for the cluster settings
+func (c *Config) Memberlist() *memberlist.Config {
+	var ml *memberlist.Config
+	switch c.Cluster.Profile {
+	case "lan":
+		ml = memberlist.DefaultLANConfig()
+	case "wan":
+		ml = memberlist.DefaultWANConfig()
+	default:
+		ml = memberlist.DefaultLocalConfig()
+	}
+	if c.Name != "" {
+		ml.Name = c.Name
+	}
+	if c.Cluster.Addr != "" {
+		ml.BindAddr = c.Cluster.Addr
+	}
+	ml.BindPort, ml.AdvertisePort = c.Cluster.Port, c.Cluster.Port
+
+	k := c.Cluster
+	setDuration := func(to *time.Duration, v time.Duration) {
+		if v != 0 {
+			*to = v
+		}
+	}
+	setInt := func(to *int, v int) {
+		if v != 0 {
+			*to = v
+		}
+	}
+	setDuration(&ml.GossipInterval, k.GossipInterval)
+	setDuration(&ml.ProbeInterval, k.ProbeInterval)
+	setDuration(&ml.ProbeTimeout, k.ProbeTimeout)
+	setDuration(&ml.PushPullInterval, k.PushPullInterval)
+	setDuration(&ml.TCPTimeout, k.TCPTimeout)
+	setInt(&ml.GossipNodes, k.GossipNodes)
+	setInt(&ml.SuspicionMult, k.SuspicionMult)
+	setInt(&ml.RetransmitMult, k.RetransmitMult)
+	return ml
+}
+
+// BadgerOptions for the badger settings
+func (c *Config) BadgerOptions() badger.Options {
+	opts := badger.DefaultOptions(c.Data)
+	b := c.Badger
+	if b.SyncWrites != nil {
+		opts.SyncWrites = *b.SyncWrites
+	}
+	if b.MaxTableSize != 0 {
+		opts.MaxTableSize = b.MaxTableSize
+	}
+	if b.ValueLogFileSize != 0 {
+		opts.ValueLogFileSize = b.ValueLogFileSize
+	}
+	if b.ValueThreshold != 0 {
+		opts.ValueThreshold = b.ValueThreshold
+	}
+	if b.NumMemtables != 0 {
+		opts.NumMemtables = b.NumMemtables
+	}
+	if b.NumCompactors != 0 {
+		opts.NumCompactors = b.NumCompactors
+	}
+	opts.Truncate = b.Truncate
+	return opts
+}
+
+// Resolver for the conflicts setting
+func (c *Config) Resolver() data.ConflictResolver {
+	switch c.Conflicts {
+	case "lww":
+		return data.LastWriterWins{}
+	case "priority":
+		return data.NodePriority{Nodes: c.NodePriority}
+	default:
+		return data.KeepSiblings{}
+	}
+}
+
+// LogLevel of the log settings; info if it isn't one (Validate says so)
+func (c *Config) LogLevel() zap.AtomicLevel {
+	level := zap.NewAtomicLevel()
+	level.UnmarshalText([]byte(c.Log.Level))
+	return level
+}
+
+// TLSConfig for the tls settings; nil without a cert
+func (c *Config) TLSConfig() *tlsconfig.Config {
+	if c.TLS.Cert == "" {
+		return nil
+	}
+	return &tlsconfig.Config{
+		Cert:       c.TLS.Cert,
+		Key:        c.TLS.Key,
+		CA:         c.TLS.CA,
+		ClientCA:   c.TLS.ClientCA,
+		ClientAuth: c.TLS.ClientAuth,
+		PeerName:   c.TLS.PeerName,
+	}
+}
+
+// AuthConfig for the auth settings; nil without keys
+func (c *Config) AuthConfig() *auth.Config {
+	if len(c.Auth.Keys) == 0 {
+		return nil
+	}
+	return &auth.Config{Keys: c.Auth.Keys, Issuer: c.Auth.Issuer, Audience: c.Auth.Audience}
+}
+
+// RateLimitConfig for the rate limits; nil without any.  They've been validated
+func (c *Config) RateLimitConfig() *ratelimit.Config {
+	if len(c.RateLimit.Client) == 0 && len(c.RateLimit.Customer) == 0 {
+		return nil
+	}
+	client, _ := ratelimit.ParseLimits(c.RateLimit.Client)
+	customer, _ := ratelimit.ParseLimits(c.RateLimit.Customer)
+	return &ratelimit.Config{Client: client, Customer: customer, Idle: c.RateLimit.Idle}
+}
+
+// TracingConfig for tracing.Setup
+func (c *Config) TracingConfig(node string) tracing.Config {
+	return tracing.Config{
+		Exporter:     c.Tracing.Exporter,
+		File:         c.Tracing.File,
+		OTLPEndpoint: c.Tracing.OTLPEndpoint,
+		SampleRatio:  c.Tracing.Sample,
+		Node:         node,
+	}
+}
+
+// Server config for everything else.  logger and level are the node's, from LogLevel
+func (c *Config) Server(logger *zap.Logger, level *zap.AtomicLevel) server.Config {
+	opts := c.BadgerOptions()
+	return server.Config{
+		Memberlist:          c.Memberlist(),
+		Join:                c.Join,
+		ClusterName:         c.Cluster.Name,
+		ClusterID:           c.Cluster.ID,
+		GossipKeys:          c.Cluster.Keys,
+		KeyringFile:         c.Cluster.KeyringFile,
+		Address:             c.Address,
+		Advertise:           c.Advertise,
+		Partitions:          c.Partitions,
+		ReplicationFactor:   c.ReplicationFactor,
+		DataDir:             c.Data,
+		Badger:              &opts,
+		Resolver:            c.Resolver(),
+		HintLimit:           c.Hints.Limit,
+		HintTTL:             c.Hints.TTL,
+		AntiEntropyInterval: c.AntiEntropy.Interval,
+		AntiEntropyRate:     c.AntiEntropy.Rate,
+		Raft:                c.Raft.Enabled,
+		RaftAddress:         c.Raft.Address,
+		RaftDir:             c.Raft.Dir,
+		DebugAddress:        c.DebugAddress,
+		HTTPAddress:         c.HTTPAddress,
+		EventsHeartbeat:     c.Events.Heartbeat,
+		EventsSendBuffer:    c.Events.SendBuffer,
+		MinMembers:          c.Readiness.MinMembers,
+		ReadySettle:         c.Readiness.Settle,
+		MaxHealthScore:      c.Readiness.MaxHealthScore,
+		MaxInFlight:         c.Admission.MaxInFlight,
+		MaxWriteLatency:     c.Admission.MaxWriteLatency,
+		MaxHeap:             uint64(c.Admission.MaxHeapMB) << 20,
+		Reflection:          c.Reflection,
+		TLS:                 c.TLSConfig(),
+		Auth:                c.AuthConfig(),
+		RateLimit:           c.RateLimitConfig(),
+		Logger:              logger,
+		LogLevel:            level,
+		ShutdownTimeout:     c.ShutdownTimeout,
+	}
+}
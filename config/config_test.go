@@ -0,0 +1,158 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yarbelk/distributedservice/config"
+	"gopkg.in/yaml.v2"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoadOrder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "node.yaml")
+	err := ioutil.WriteFile(file, []byte(`
+name: from-file
+partitions: 13
+cluster:
+  port: 8000
+  probe_timeout: 2s
+anti_entropy:
+  rate: 5
+badger:
+  sync_writes: false
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c, err := config.Load(fs, []string{"-config", file, "-cluster-addr", "127.0.0.1", "-partitions", "17", "a:1", "b:2"}, env(map[string]string{
+		"DS_PARTITIONS":        "11",
+		"DS_ANTI_ENTROPY_RATE": "2.5",
+		"DS_NODE_PRIORITY":     "x, y",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flags beat the environment, which beats the file, which beats the defaults
+	if c.Partitions != 17 {
+		t.Errorf("partitions %d, expected the flag's 17", c.Partitions)
+	}
+	if c.AntiEntropy.Rate != 2.5 {
+		t.Errorf("anti-entropy rate %v, expected the environment's 2.5", c.AntiEntropy.Rate)
+	}
+	if c.Name != "from-file" || c.Cluster.Port != 8000 {
+		t.Errorf("name %q and port %d, expected the file's", c.Name, c.Cluster.Port)
+	}
+	if c.ReplicationFactor != 3 {
+		t.Errorf("replication factor %d, expected the default 3", c.ReplicationFactor)
+	}
+	if strings.Join(c.Join, " ") != "a:1 b:2" || strings.Join(c.NodePriority, " ") != "x y" {
+		t.Errorf("join %v, node priority %v", c.Join, c.NodePriority)
+	}
+
+	ml := c.Memberlist()
+	if ml.Name != "from-file" || ml.BindAddr != "127.0.0.1" || ml.BindPort != 8000 || ml.ProbeTimeout != 2*time.Second {
+		t.Errorf("memberlist config isn't the cluster settings: %s %s:%d %s", ml.Name, ml.BindAddr, ml.BindPort, ml.ProbeTimeout)
+	}
+	opts := c.BadgerOptions()
+	if opts.SyncWrites || opts.Dir != c.Data {
+		t.Errorf("badger options aren't the badger settings: sync %v, dir %s", opts.SyncWrites, opts.Dir)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "node.yaml")
+	if err := ioutil.WriteFile(file, []byte("partitons: 13\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", file}, env(nil)); err == nil {
+		t.Error("expected a misspelt key in the file to be an error")
+	}
+	_, err := config.Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, env(map[string]string{"DS_HINTS_TTL": "soon"}))
+	if err == nil || !strings.Contains(err.Error(), "DS_HINTS_TTL") {
+		t.Errorf("expected an error about DS_HINTS_TTL, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := config.Default().Validate(); err != nil {
+		t.Fatalf("the defaults aren't valid: %s", err)
+	}
+
+	c := config.Default()
+	c.Cluster.Profile = "moon"
+	c.Partitions = 5
+	c.Raft.Enabled = true
+	c.Raft.Dir = ""
+	c.Conflicts = "priority"
+	c.Tracing.Sample = 2
+	c.Log.Level = "loud"
+	c.Auth.Keys = []string{"c2hvcnQ="}
+	err := c.Validate()
+	errs, ok := err.(config.Errors)
+	if !ok {
+		t.Fatalf("expected config.Errors, got %v", err)
+	}
+	for _, expected := range []string{"cluster.profile", "raft.dir", "node_priority", "tracing.sample", "log.level", "auth.keys"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("nothing about %s in %s", expected, err)
+		}
+	}
+	if len(errs) != 6 {
+		t.Errorf("expected 6 errors, got %d:\n%s", len(errs), err)
+	}
+}
+
+func TestEnvNames(t *testing.T) {
+	names := map[string]bool{}
+	for _, n := range config.EnvNames() {
+		names[n] = true
+	}
+	for _, n := range []string{"DS_NAME", "DS_CLUSTER_PORT", "DS_ANTI_ENTROPY_INTERVAL", "DS_BADGER_SYNC_WRITES", "DS_SHUTDOWN_TIMEOUT"} {
+		if !names[n] {
+			t.Errorf("no %s in %v", n, config.EnvNames())
+		}
+	}
+	if names["DS_CLUSTER"] {
+		t.Error("sections aren't settings")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := config.Default()
+	c.Cluster.Keys = []string{"Z29zc2lwIGtleSAxNiBieXRlcw=="}
+	c.Auth.Keys = []string{"primary:c2lnbmluZyBrZXkgdGhhdCBpcyBhdCBsZWFzdCAzMiBieXRlcw==", "dGhlIG90aGVyIGtleSB0aGF0IGlzIGF0IGxlYXN0IDMyIGJ5dGVz"}
+	b, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	for _, k := range append(append([]string{}, c.Cluster.Keys...), "c2lnbmluZyBrZXkgdGhhdCBpcyBhdCBsZWFzdCAzMiBieXRlcw==", c.Auth.Keys[1]) {
+		if strings.Contains(out, k) {
+			t.Errorf("%s is in the printed config:\n%s", k, out)
+		}
+	}
+	if !strings.Contains(out, "primary:REDACTED") {
+		t.Errorf("expected the auth key's id kept:\n%s", out)
+	}
+	// and c still has them
+	if c.Cluster.Keys[0] != "Z29zc2lwIGtleSAxNiBieXRlcw==" || !strings.HasPrefix(c.Auth.Keys[0], "primary:c2ln") {
+		t.Errorf("redacting changed the config: %v %v", c.Cluster.Keys, c.Auth.Keys)
+	}
+}
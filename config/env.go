@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadEnv over c: every setting can be set with EnvPrefix and its YAML path, like
+// DS_CLUSTER_PORT.  lookup is os.LookupEnv, bar tests
+func (c *Config) LoadEnv(lookup func(string) (string, bool)) error {
+	return loadEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup)
+}
+
+// EnvNames of every setting, in the order they're in the config
+func EnvNames() []string {
+	var names []string
+	envNames(reflect.TypeOf(Config{}), EnvPrefix, &names)
+	return names
+}
+
+func envName(prefix string, f reflect.StructField) (string, bool) {
+	tag := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if tag == "" || tag == "-" {
+		return "", false
+	}
+	return prefix + strings.ToUpper(tag), true
+}
+
+func envNames(t reflect.Type, prefix string, names *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := envName(prefix, t.Field(i))
+		if !ok {
+			continue
+		}
+		if ft := t.Field(i).Type; ft.Kind() == reflect.Struct {
+			envNames(ft, name+"_", names)
+			continue
+		}
+		*names = append(*names, name)
+	}
+}
+
+func loadEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := envName(prefix, t.Field(i))
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := loadEnv(field, name+"_", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setString(field, s); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// setString parses s into v, by v's type
+func setString(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setString(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(splitList(s)))
+	default:
+		return fmt.Errorf("can't set a %s from the environment", v.Type())
+	}
+	return nil
+}
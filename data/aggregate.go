@@ -0,0 +1,138 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// CustomerAggregate is the aggregate type name customers are stored under.
+const CustomerAggregate = "customer"
+
+const (
+	UnknownAggregateError Error = "Unknown aggregate type"
+)
+
+// StreamID is the identity of one aggregate's event stream.  It is both the
+// storage prefix and the thing we consistently hash to pick an owner.
+//
+// ID is opaque: a UUID, some upstream systems string key, raw bytes; whatever.
+// Numeric ids are stored as their decimal string, see NumericID.
+type StreamID struct {
+	Type string
+	ID   string
+}
+
+// NumericID is how the old uint64 ids map on to opaque ids.  Customer 1 and
+// the key "1" are the same stream.
+func NumericID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
+// KeyOrID is a request's stream id, by the rule in the protos: an opaque key wins,
+// otherwise the numeric id
+func KeyOrID(key []byte, id uint64) string {
+	if len(key) > 0 {
+		return string(key)
+	}
+	return NumericID(id)
+}
+
+// CustomerStream is shorthand for the customer aggregate stream; this is what
+// the old customer only api maps on to.
+func CustomerStream(id string) StreamID {
+	return StreamID{Type: CustomerAggregate, ID: id}
+}
+
+// RequestStream is the stream an api request is about, by the key/id rule from the
+// protos (an opaque key wins, otherwise the numeric id); false for requests that
+// aren't about one.  It's for the interceptors that look at requests
+func RequestStream(req interface{}) (StreamID, bool) {
+	id := KeyOrID
+	switch r := req.(type) {
+	case *proto.Customer:
+		return CustomerStream(id(r.GetKey(), r.GetId())), true
+	case *proto.NewCustomerLog:
+		return CustomerStream(id(r.GetCustomerKey(), r.GetCustomerID())), true
+	case *proto.ResolveCustomerConflicts:
+		return CustomerStream(id(r.GetCustomerKey(), r.GetCustomerID())), true
+	case *proto.StreamID:
+		return StreamID{Type: r.GetAggregateType(), ID: id(r.GetKey(), r.GetId())}, true
+	case *proto.NewEventLog:
+		return RequestStream(r.GetStream())
+	case *proto.ResolveConflicts:
+		return RequestStream(r.GetStream())
+	}
+	return StreamID{}, false
+}
+
+// String is for logging; ids can be any bytes so they get quoted
+func (s StreamID) String() string {
+	return fmt.Sprintf("%s:%q", s.Type, s.ID)
+}
+
+// HashKey is the key to look up in the consistent hash ring.  It's the same
+// length prefixed encoding as storage uses, so ids can't bleed into each other.
+func (s StreamID) HashKey() []byte {
+	return encodeStream(nil, s)
+}
+
+// Aggregate is a root aggregate that is rebuilt by replaying its event logs in
+// sequence order.  Each aggregate type decides what 'Apply' means for it; an error
+// stops the replay, and comes back from GetState as a *CorruptStreamError.
+type Aggregate interface {
+	Apply(l *proto.CustomerEventLog) error
+	// Sequence is the sequenceId of the last applied log.
+	Sequence() uint64
+	// VectorClock is the merged clock of the applied logs.
+	VectorClock() VectorClock
+	// Message is the wire representation of the aggregate.  It is packed into an
+	// Any for the generic AggregateState rpc.
+	Message(s StreamID) protobuf.Message
+}
+
+// Registry maps aggregate type names to a constructor for an empty aggregate.
+// Storage uses it to know how to replay a stream.
+type Registry struct {
+	lock  sync.RWMutex
+	types map[string]func() Aggregate
+}
+
+func NewRegistry() *Registry {
+	return &Registry{types: make(map[string]func() Aggregate)}
+}
+
+// DefaultRegistry has the customer aggregate registered; add your own in an init
+// func (or build a Registry and give it to the BadgerStore)
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	DefaultRegistry.Register(CustomerAggregate, func() Aggregate { return new(CustomerState) })
+}
+
+// Register an aggregate type.  Re-registering a name is a programming error.
+func (r *Registry) Register(aggregateType string, factory func() Aggregate) {
+	if aggregateType == "" {
+		panic(fmt.Sprintf("invalid aggregate type name %q", aggregateType))
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.types[aggregateType]; ok {
+		panic(fmt.Sprintf("aggregate type %q registered twice", aggregateType))
+	}
+	r.types[aggregateType] = factory
+}
+
+// New empty aggregate of the given type, ready to have logs applied.
+func (r *Registry) New(aggregateType string) (Aggregate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	factory, ok := r.types[aggregateType]
+	if !ok {
+		return nil, UnknownAggregateError
+	}
+	return factory(), nil
+}
@@ -0,0 +1,81 @@
+package data_test
+
+import (
+	"testing"
+
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// orderState is a stand in for some other teams aggregate: it just counts
+type orderState struct {
+	lines    int
+	sequence uint64
+}
+
+func (o *orderState) Apply(l *proto.CustomerEventLog) error {
+	o.lines++
+	o.sequence = l.SequenceId
+	return nil
+}
+
+func (o *orderState) Sequence() uint64 {
+	return o.sequence
+}
+
+func (o *orderState) VectorClock() data.VectorClock {
+	return nil
+}
+
+func (o *orderState) Message(s data.StreamID) protobuf.Message {
+	return &proto.CustomerState{Key: []byte(s.ID), CurrentSequence: o.sequence}
+}
+
+func TestAggregateTypes(t *testing.T) {
+	registry := data.NewRegistry()
+	registry.Register(data.CustomerAggregate, func() data.Aggregate { return new(data.CustomerState) })
+	registry.Register("order", func() data.Aggregate { return new(orderState) })
+
+	dir := t.TempDir()
+	ds := data.New(dir)
+	defer ds.Close()
+	ds.Registry = registry
+
+	t.Run("Same id in different aggregate types are different streams", func(t *testing.T) {
+		customer := data.CustomerStream("1")
+		order := data.StreamID{Type: "order", ID: "1"}
+		for i := uint64(0); i < 3; i++ {
+			if err := ds.Append(order, &proto.CustomerEventLog{SequenceId: i, Action: &proto.Action{Action: "add line"}}); err != nil {
+				t.Fatalf("can't append order log %d: %s", i, err)
+			}
+		}
+		if err := ds.Append(customer, &proto.CustomerEventLog{SequenceId: 0, Action: &proto.Action{Action: "Create"}}); err != nil {
+			t.Fatalf("customer stream should start at 0, got %s", err)
+		}
+
+		agg, err := ds.GetState(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o := agg.(*orderState); o.lines != 3 || o.Sequence() != 2 {
+			t.Fatalf("expected 3 lines up to sequence 2, got %+v", o)
+		}
+		cs, err := ds.GetCustomerState(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cs.LastAction != "Create" || cs.CurrentSequence != 0 {
+			t.Fatalf("customer state leaked across aggregate types: %+v", cs)
+		}
+	})
+	t.Run("Unknown aggregate types are rejected", func(t *testing.T) {
+		s := data.StreamID{Type: "account", ID: "1"}
+		if err := ds.Append(s, &proto.CustomerEventLog{SequenceId: 0}); err != data.UnknownAggregateError {
+			t.Fatalf("expected %s on append, got %v", data.UnknownAggregateError, err)
+		}
+		if _, err := ds.GetState(s); err != data.UnknownAggregateError {
+			t.Fatalf("expected %s on replay, got %v", data.UnknownAggregateError, err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"io"
+
+	"github.com/dgraph-io/badger"
+)
+
+// NotEmptyError is restoring into a store that has something in it already
+const NotEmptyError Error = "Store isn't empty. Restore into a new data directory"
+
+// restorePending is how many writes badger's Load keeps in flight
+const restorePending = 256
+
+// Backuper can dump everything it holds, and load it back into an empty store
+type Backuper interface {
+	// Backup writes everything written at or after version since (0 is everything),
+	// and returns the version to pass next time for the one after
+	Backup(w io.Writer, since uint64) (uint64, error)
+	// Restore a backup, or a full one and the incrementals after it back to back
+	Restore(r io.Reader) error
+}
+
+// Backup is badger's: every key (logs, hints, raft's applied indexes...) with its
+// version, and deletes that haven't been compacted away yet.  A delete that has been
+// (a hint that was delivered, say) is missed by an incremental one, and the hint is
+// delivered again after a restore: harmless
+func (b *BadgerStore) Backup(w io.Writer, since uint64) (uint64, error) {
+	last, err := b.LogDB.Backup(w, since)
+	if err != nil {
+		return 0, err
+	}
+	// badger's is the last version in it, which it would send again; or 0 if there
+	// was nothing new
+	if last < since {
+		return since, nil
+	}
+	return last + 1, nil
+}
+
+// Restore r, which Backup wrote, into b.  b has to be empty: merging a backup with
+// what's there would be a mess of clocks
+func (b *BadgerStore) Restore(r io.Reader) error {
+	empty, err := b.Empty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return NotEmptyError
+	}
+	if err := b.LogDB.Load(r, restorePending); err != nil {
+		return err
+	}
+	// it brings its hints: count them again
+	b.hints.lock.Lock()
+	b.hints.created = nil
+	b.hints.lock.Unlock()
+	b.watchers.writtenAll()
+	return nil
+}
+
+// Empty is no keys at all, bar the format version
+func (b *BadgerStore) Empty() (bool, error) {
+	empty := true
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if !isFormatKey(it.Item().Key()) {
+				empty = false
+				return nil
+			}
+		}
+		return nil
+	})
+	return empty, err
+}
@@ -0,0 +1,74 @@
+package data_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ds := data.New(t.TempDir())
+	defer ds.Close()
+	const customers = 20
+	write := func(id, seq uint64) {
+		t.Helper()
+		el := &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: fmt.Sprintf("%d.%d", id, seq)}}
+		if err := ds.Append(data.CustomerStream(data.NumericID(id)), el); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := uint64(0); id < customers; id++ {
+		for seq := uint64(0); seq <= id%4; seq++ {
+			write(id, seq)
+		}
+	}
+
+	var full, incremental bytes.Buffer
+	version, err := ds.Backup(&full, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more logs for some, and some new customers
+	for id := uint64(customers / 2); id < customers+5; id++ {
+		seq := uint64(0)
+		if id < customers {
+			seq = id%4 + 1
+		}
+		write(id, seq)
+	}
+	if _, err := ds.Backup(&incremental, version); err != nil {
+		t.Fatal(err)
+	}
+	if incremental.Len() >= full.Len() {
+		t.Errorf("expected the incremental backup to be smaller than the full one: %d, %d", incremental.Len(), full.Len())
+	}
+
+	restored := data.New(t.TempDir())
+	defer restored.Close()
+	if err := restored.Restore(io.MultiReader(bytes.NewReader(full.Bytes()), bytes.NewReader(incremental.Bytes()))); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(0); id < customers+5; id++ {
+		expected, err := ds.GetCustomerState(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := restored.GetCustomerState(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("customer %d: expected %+v, got %+v", id, expected, got)
+		}
+	}
+
+	// only into an empty store
+	if err := restored.Restore(bytes.NewReader(full.Bytes())); err != data.NotEmptyError {
+		t.Errorf("expected restoring over data to fail, got %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"encoding/binary"
+
+	"github.com/cespare/xxhash"
+	"github.com/dgraph-io/badger"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// Resolution is what a ConflictResolver decided to do with two versions of the
+// same sequenceId.
+type Resolution int
+
+const (
+	// KeepBoth stores the remote version as a sibling for the application to merge
+	KeepBoth Resolution = iota
+	// KeepLocal drops the remote version
+	KeepLocal
+	// TakeRemote replaces the local version with the remote one
+	TakeRemote
+)
+
+// ConflictResolver decides between the local and a replicated log when two nodes
+// wrote the same sequenceId without seeing each other (their clocks are concurrent).
+// It has to be deterministic: every replica resolves on its own, and they only
+// converge if they all pick the same winner.
+type ConflictResolver interface {
+	Resolve(local, remote *proto.CustomerEventLog) Resolution
+}
+
+// KeepSiblings never picks: every conflict is kept for the application to resolve
+// with a merge.  This is the default since it is the only one that can't lose a write.
+type KeepSiblings struct{}
+
+func (KeepSiblings) Resolve(local, remote *proto.CustomerEventLog) Resolution {
+	return KeepBoth
+}
+
+// LastWriterWins takes the log with the latest coordinator wall clock; ties go to the
+// lower node name.  Wall clocks drift, so "last" is only as good as your ntp.
+type LastWriterWins struct{}
+
+func (LastWriterWins) Resolve(local, remote *proto.CustomerEventLog) Resolution {
+	l, r := local.GetTimestamp(), remote.GetTimestamp()
+	switch {
+	case r.GetWallTime() > l.GetWallTime():
+		return TakeRemote
+	case r.GetWallTime() < l.GetWallTime():
+		return KeepLocal
+	case r.GetNode() < l.GetNode():
+		return TakeRemote
+	}
+	return KeepLocal
+}
+
+// NodePriority takes the log written by the node earliest in Nodes.  Nodes that
+// aren't listed lose to the ones that are, and then it is the lower node name.
+// Logs from the same node fall back to LastWriterWins.
+type NodePriority struct {
+	Nodes []string
+}
+
+func (np NodePriority) rank(node string) int {
+	for i, n := range np.Nodes {
+		if n == node {
+			return i
+		}
+	}
+	return len(np.Nodes)
+}
+
+func (np NodePriority) Resolve(local, remote *proto.CustomerEventLog) Resolution {
+	ln, rn := local.GetTimestamp().GetNode(), remote.GetTimestamp().GetNode()
+	lr, rr := np.rank(ln), np.rank(rn)
+	switch {
+	case rr < lr:
+		return TakeRemote
+	case rr > lr:
+		return KeepLocal
+	case rn < ln:
+		return TakeRemote
+	case rn > ln:
+		return KeepLocal
+	}
+	return LastWriterWins{}.Resolve(local, remote)
+}
+
+// Conflict is one sequenceId that has unresolved siblings
+type Conflict struct {
+	Sequence uint64
+	// Current is the log applied at Sequence; nil if this node never had one (the
+	// stream diverged before this sequence)
+	Current  *proto.CustomerEventLog
+	Siblings []*proto.CustomerEventLog
+}
+
+// Conflict keys are
+//
+//	'c' | stream (as in event keys) | sequence (8 bytes, big endian) | sibling id (8 bytes)
+//
+// the sibling id is a hash of the log, so getting the same sibling twice is a no-op
+const conflictKeyspace byte = 'c'
+
+func conflictPrefix(s StreamID) []byte {
+	return encodeStream([]byte{conflictKeyspace}, s)
+}
+
+func conflictKey(s StreamID, el *proto.CustomerEventLog, v []byte) []byte {
+	k := conflictPrefix(s)
+	var b [2 * sequenceLen]byte
+	binary.BigEndian.PutUint64(b[:sequenceLen], el.SequenceId)
+	binary.BigEndian.PutUint64(b[sequenceLen:], xxhash.Sum64(v))
+	return append(k, b[:]...)
+}
+
+func (b *BadgerStore) resolver() ConflictResolver {
+	if b.Resolver == nil {
+		return KeepSiblings{}
+	}
+	return b.Resolver
+}
+
+// recordSibling stores a log that conflicts with the stream
+func recordSibling(txn *badger.Txn, s StreamID, el *proto.CustomerEventLog) error {
+	v, err := marshalLog(el)
+	if err != nil {
+		return err
+	}
+	return txn.Set(conflictKey(s, el, v), v)
+}
+
+// resolve a replicated log that has a different version at the same sequenceId locally.
+// The resolver only gets to replace the local version if it is the head of the stream
+// (and the remote version follows on from the log before it): rewriting history
+// under later logs isn't a thing, so those are always kept as siblings.
+// Returns true if the remote log was kept as a sibling.
+func (b *BadgerStore) resolve(txn *badger.Txn, s StreamID, local, remote *proto.CustomerEventLog) (bool, error) {
+	switch b.resolver().Resolve(local, remote) {
+	case KeepLocal:
+		return false, nil
+	case TakeRemote:
+		last, err := lastLog(txn, s)
+		if err != nil {
+			return false, err
+		}
+		var prevClock VectorClock
+		if remote.SequenceId > 0 {
+			prev, err := getLog(txn, s, remote.SequenceId-1)
+			if err != nil {
+				return false, err
+			}
+			prevClock = ClockFrom(prev.GetTimestamp())
+		}
+		if last.SequenceId == remote.SequenceId && validClock(prevClock, remote) == nil {
+			return false, writeLog(txn, s, remote)
+		}
+	}
+	return true, recordSibling(txn, s, remote)
+}
+
+// clearDominated drops the siblings that happened-before (or are) clock.  Once a
+// log that has seen a sibling is written, the sibling has been merged.
+func clearDominated(txn *badger.Txn, s StreamID, clock VectorClock) error {
+	prefix := conflictPrefix(s)
+	opts := badger.DefaultIteratorOptions
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	var dominated [][]byte
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		sibling, err := unmarshalLog(it.Item())
+		if err != nil {
+			return err
+		}
+		if o := ClockFrom(sibling.GetTimestamp()).Compare(clock); o == Before || o == Equal {
+			dominated = append(dominated, it.Item().KeyCopy(nil))
+		}
+	}
+	for _, k := range dominated {
+		if err := txn.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Conflicts are the outstanding siblings of a stream, in sequence order
+func (b *BadgerStore) Conflicts(s StreamID) ([]Conflict, error) {
+	var conflicts []Conflict
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		prefix := conflictPrefix(s)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			sibling, err := unmarshalLog(it.Item())
+			if err != nil {
+				return err
+			}
+			if n := len(conflicts); n == 0 || conflicts[n-1].Sequence != sibling.SequenceId {
+				current, err := getLog(txn, s, sibling.SequenceId)
+				if err != nil {
+					return err
+				}
+				conflicts = append(conflicts, Conflict{Sequence: sibling.SequenceId, Current: current})
+			}
+			c := &conflicts[len(conflicts)-1]
+			c.Siblings = append(c.Siblings, sibling)
+		}
+		return nil
+	})
+	return conflicts, err
+}
+
+// ResolveConflicts for a stream.  A nil merged log discards the siblings: the current
+// logs win.  Otherwise merged is appended like any other write; except its clock
+// also covers every sibling, which is what clears them (here, and on the replicas
+// when it is replicated to them).  Like Append, merged gets its clock stamped.
+func (b *BadgerStore) ResolveConflicts(s StreamID, merged *proto.CustomerEventLog) error {
+	if _, err := b.Registry.New(s.Type); err != nil {
+		return err
+	}
+	return b.update(s, func(txn *badger.Txn) error {
+		siblings := make(VectorClock)
+		var keys [][]byte
+		prefix := conflictPrefix(s)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			sibling, err := unmarshalLog(it.Item())
+			if err != nil {
+				it.Close()
+				return err
+			}
+			siblings = siblings.Merge(ClockFrom(sibling.GetTimestamp()))
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+
+		if merged == nil {
+			for _, k := range keys {
+				if err := txn.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return appendTxn(txn, s, merged, siblings, b.stamp())
+	})
+}
@@ -0,0 +1,167 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// written is a log as a coordinator would have stamped it
+func written(seq uint64, action, node string, wallTime int64, clock data.VectorClock) *proto.CustomerEventLog {
+	el := clocked(seq, action, clock)
+	el.Timestamp.Node = node
+	el.Timestamp.WallTime = wallTime
+	return el
+}
+
+func TestConflictResolvers(t *testing.T) {
+	a := written(0, "from a", "a", 10, data.VectorClock{"a": 1})
+	b := written(0, "from b", "b", 20, data.VectorClock{"b": 1})
+	table := []struct {
+		name     string
+		resolver data.ConflictResolver
+		local    *proto.CustomerEventLog
+		remote   *proto.CustomerEventLog
+		expected data.Resolution
+	}{
+		{"siblings keeps both", data.KeepSiblings{}, a, b, data.KeepBoth},
+		{"lww takes the later remote", data.LastWriterWins{}, a, b, data.TakeRemote},
+		{"lww keeps the later local", data.LastWriterWins{}, b, a, data.KeepLocal},
+		{"lww tie goes to lower node", data.LastWriterWins{}, written(0, "", "b", 1, nil), written(0, "", "a", 1, nil), data.TakeRemote},
+		{"priority takes listed remote", data.NodePriority{Nodes: []string{"b", "a"}}, a, b, data.TakeRemote},
+		{"priority keeps listed local", data.NodePriority{Nodes: []string{"a"}}, a, b, data.KeepLocal},
+		{"priority unlisted goes by name", data.NodePriority{}, b, a, data.TakeRemote},
+	}
+	for _, tt := range table {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.resolver.Resolve(tt.local, tt.remote)
+			if got != tt.expected {
+				t.Fatalf("expected %d got %d", tt.expected, got)
+			}
+			if got == data.KeepBoth {
+				return
+			}
+			// every replica has to come to the same answer from the other side
+			flipped := tt.resolver.Resolve(tt.remote, tt.local)
+			if winner(tt.local, tt.remote, got) != winner(tt.remote, tt.local, flipped) {
+				t.Fatalf("resolver picks differently depending on which side is local")
+			}
+		})
+	}
+}
+
+func winner(local, remote *proto.CustomerEventLog, r data.Resolution) *proto.CustomerEventLog {
+	if r == data.TakeRemote {
+		return remote
+	}
+	return local
+}
+
+func TestReplicatedConflicts(t *testing.T) {
+	s := data.CustomerStream("1")
+	setup := func(t *testing.T, resolver data.ConflictResolver) *data.BadgerStore {
+		ds := data.New(t.TempDir())
+		t.Cleanup(func() { ds.Close() })
+		ds.NodeID = "a"
+		ds.Resolver = resolver
+		if err := ds.Append(s, clocked(0, "from a", nil)); err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	}
+	fromB := func() *proto.CustomerEventLog {
+		return written(0, "from b", "b", 1<<62, data.VectorClock{"b": 1})
+	}
+
+	t.Run("siblings are kept and listed", func(t *testing.T) {
+		ds := setup(t, data.KeepSiblings{})
+		if err := ds.Replicate(s, fromB()); err != data.ConflictError {
+			t.Fatalf("expected %s got %v", data.ConflictError, err)
+		}
+		// redelivery doesn't add another sibling
+		ds.Replicate(s, fromB())
+		conflicts, err := ds.Conflicts(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts) != 1 || len(conflicts[0].Siblings) != 1 {
+			t.Fatalf("expected one conflict with one sibling, got %+v", conflicts)
+		}
+		c := conflicts[0]
+		if c.Sequence != 0 || c.Current.GetAction().GetAction() != "from a" || c.Siblings[0].GetAction().GetAction() != "from b" {
+			t.Fatalf("unexpected conflict %+v", c)
+		}
+	})
+	t.Run("lww replaces the head", func(t *testing.T) {
+		ds := setup(t, data.LastWriterWins{})
+		if err := ds.Replicate(s, fromB()); err != nil {
+			t.Fatal(err)
+		}
+		cs, err := ds.GetCustomerState(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cs.LastAction != "from b" {
+			t.Fatalf("expected b's later write to win, got %+v", cs)
+		}
+		if conflicts, _ := ds.Conflicts(s); len(conflicts) != 0 {
+			t.Fatalf("resolved conflicts shouldn't be listed %+v", conflicts)
+		}
+	})
+	t.Run("automatic resolution doesn't rewrite history", func(t *testing.T) {
+		ds := setup(t, data.LastWriterWins{})
+		ds.Append(s, clocked(1, "on top of a", nil))
+		if err := ds.Replicate(s, fromB()); err != data.ConflictError {
+			t.Fatalf("expected %s got %v", data.ConflictError, err)
+		}
+		cs, _ := ds.GetCustomerState(1)
+		if cs.LastAction != "on top of a" {
+			t.Fatalf("history got rewritten %+v", cs)
+		}
+	})
+	t.Run("merging clears the siblings", func(t *testing.T) {
+		ds := setup(t, data.KeepSiblings{})
+		ds.Replicate(s, fromB())
+		merged := &proto.CustomerEventLog{SequenceId: 1, Action: &proto.Action{Action: "a and b"}}
+		if err := ds.ResolveConflicts(s, merged); err != nil {
+			t.Fatal(err)
+		}
+		if conflicts, _ := ds.Conflicts(s); len(conflicts) != 0 {
+			t.Fatalf("merge should have cleared the siblings %+v", conflicts)
+		}
+		if c := data.ClockFrom(merged.Timestamp); c.Compare(data.VectorClock{"a": 1, "b": 1}) != data.After {
+			t.Fatalf("merged clock should be after both branches %v", c)
+		}
+
+		// and a replica that has b's version with a as the sibling is cleared by the merge log too
+		replica := data.New(t.TempDir())
+		defer replica.Close()
+		replica.Replicate(s, fromB())
+		replica.Replicate(s, written(0, "from a", "a", 1, data.VectorClock{"a": 1}))
+		if conflicts, _ := replica.Conflicts(s); len(conflicts) != 1 {
+			t.Fatalf("expected the replica to have a's version as a sibling %+v", conflicts)
+		}
+		if err := replica.Replicate(s, merged); err != nil {
+			t.Fatal(err)
+		}
+		if conflicts, _ := replica.Conflicts(s); len(conflicts) != 0 {
+			t.Fatalf("replicated merge should have cleared the siblings %+v", conflicts)
+		}
+	})
+	t.Run("discarding keeps the current logs", func(t *testing.T) {
+		ds := setup(t, data.KeepSiblings{})
+		ds.Replicate(s, fromB())
+		if err := ds.ResolveConflicts(s, nil); err != nil {
+			t.Fatal(err)
+		}
+		if conflicts, _ := ds.Conflicts(s); len(conflicts) != 0 {
+			t.Fatalf("expected no siblings %+v", conflicts)
+		}
+		cs, _ := ds.GetCustomerState(1)
+		if cs.LastAction != "from a" {
+			t.Fatalf("unexpected state %+v", cs)
+		}
+	})
+}
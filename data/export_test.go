@@ -0,0 +1,8 @@
+package data
+
+// exported for the data_test package only
+var (
+	StreamPrefix = streamPrefix
+	LogKey       = logKey
+	ParseLogKey  = parseLogKey
+)
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// Follower can follow a stream as it's written
+type Follower interface {
+	// Follow calls fn with the stream's logs in sequence order, starting at from (or
+	// after the last log there is, if from is nil), then with each one after that as
+	// it's written.  It returns when ctx is done, or fn returns an error.  start is
+	// called first, with the sequenceId it starts at
+	Follow(ctx context.Context, s StreamID, from *uint64, start func(uint64) error, fn func(*proto.CustomerEventLog) error) error
+}
+
+// watchers wake up followers when their streams are written to.  A wake up only says
+// "look again": the follower reads badger from where it got to, so missing one in
+// between doesn't lose anything
+type watchers struct {
+	lock    sync.Mutex
+	streams map[StreamID]map[chan struct{}]bool
+}
+
+func (w *watchers) watch(s StreamID) chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.streams == nil {
+		w.streams = map[StreamID]map[chan struct{}]bool{}
+	}
+	if w.streams[s] == nil {
+		w.streams[s] = map[chan struct{}]bool{}
+	}
+	w.streams[s][ch] = true
+	return ch
+}
+
+func (w *watchers) unwatch(s StreamID, ch chan struct{}) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.streams[s], ch)
+	if len(w.streams[s]) == 0 {
+		delete(w.streams, s)
+	}
+}
+
+func wake(chs map[chan struct{}]bool) {
+	for ch := range chs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// written wakes s's followers
+func (w *watchers) written(s StreamID) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	wake(w.streams[s])
+}
+
+// writtenAll wakes everyone: for restores, which write whatever they have
+func (w *watchers) writtenAll() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, chs := range w.streams {
+		wake(chs)
+	}
+}
+
+// Follow s; see Follower.  It's notified by this store's writes, so it's only for
+// the node's own streams.  Logs come in sequence order with no gaps: one that's
+// replaced at a sequence already sent (last writer wins, say) isn't sent again
+func (b *BadgerStore) Follow(ctx context.Context, s StreamID, from *uint64, start func(uint64) error, fn func(*proto.CustomerEventLog) error) error {
+	// watch before reading, so nothing written in between is missed
+	wake := b.watchers.watch(s)
+	defer b.watchers.unwatch(s, wake)
+
+	var next uint64
+	if from != nil {
+		next = *from
+	} else {
+		err := b.LogDB.View(func(txn *badger.Txn) error {
+			last, err := lastLog(txn, s)
+			if last != nil {
+				next = last.SequenceId + 1
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := start(next); err != nil {
+		return err
+	}
+	for {
+		logs, err := b.logsFrom(s, next, followBatch)
+		if err != nil {
+			return err
+		}
+		for _, el := range logs {
+			if err := fn(el); err != nil {
+				return err
+			}
+			next = el.SequenceId + 1
+		}
+		if len(logs) == followBatch {
+			// there's more already
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// followBatch is how many logs a follower reads at a time, catching up
+const followBatch = 256
+
+// logsFrom s, from the sequence given up to the first gap; limit of them at most
+func (b *BadgerStore) logsFrom(s StreamID, from uint64, limit int) ([]*proto.CustomerEventLog, error) {
+	var logs []*proto.CustomerEventLog
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		prefix := streamPrefix(s)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(logKey(s, from)); it.ValidForPrefix(prefix) && len(logs) < limit; it.Next() {
+			el, err := unmarshalLog(it.Item())
+			if err != nil {
+				return err
+			}
+			if el.SequenceId != from {
+				break
+			}
+			logs = append(logs, el)
+			from++
+		}
+		return nil
+	})
+	return logs, err
+}
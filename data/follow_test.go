@@ -0,0 +1,102 @@
+package data_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// follow s in the background; the sequences come out on the channel, the start
+// first
+func follow(t *testing.T, ds *data.BadgerStore, s data.StreamID, from *uint64) (<-chan uint64, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	seqs := make(chan uint64, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := ds.Follow(ctx, s, from, func(start uint64) error {
+			seqs <- start
+			return nil
+		}, func(el *proto.CustomerEventLog) error {
+			seqs <- el.SequenceId
+			return nil
+		})
+		if err != context.Canceled {
+			t.Errorf("expected Follow to stop with the context, got %v", err)
+		}
+	}()
+	return seqs, func() {
+		cancel()
+		<-done
+	}
+}
+
+func expectSeqs(t *testing.T, name string, seqs <-chan uint64, expected ...uint64) {
+	t.Helper()
+	for _, e := range expected {
+		select {
+		case got := <-seqs:
+			if got != e {
+				t.Fatalf("%s: expected %d, got %d", name, e, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: expected %d, got nothing", name, e)
+		}
+	}
+	select {
+	case got := <-seqs:
+		t.Fatalf("%s: expected nothing more, got %d", name, got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFollow(t *testing.T) {
+	ds := data.New(t.TempDir())
+	defer ds.Close()
+	s := data.CustomerStream("1")
+	appendLog := func(seq uint64) {
+		t.Helper()
+		if err := ds.Append(s, &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: fmt.Sprint(seq)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for seq := uint64(0); seq < 3; seq++ {
+		appendLog(seq)
+	}
+
+	one := uint64(1)
+	resumed, stopResumed := follow(t, ds, s, &one)
+	defer stopResumed()
+	live, stopLive := follow(t, ds, s, nil)
+	defer stopLive()
+	ahead := uint64(5)
+	early, stopEarly := follow(t, ds, s, &ahead)
+	defer stopEarly()
+	// someone else's writes don't wake anyone
+	other, stopOther := follow(t, ds, data.CustomerStream("2"), nil)
+	defer stopOther()
+
+	// the start, then what's there already
+	expectSeqs(t, "resumed", resumed, 1, 1, 2)
+	expectSeqs(t, "live", live, 3)
+	expectSeqs(t, "early", early, 5)
+	expectSeqs(t, "other", other, 0)
+
+	for seq := uint64(3); seq < 6; seq++ {
+		appendLog(seq)
+	}
+	expectSeqs(t, "resumed", resumed, 3, 4, 5)
+	expectSeqs(t, "live", live, 3, 4, 5)
+	expectSeqs(t, "early", early, 5)
+	expectSeqs(t, "other", other)
+
+	// replicated writes count too
+	if err := ds.Replicate(s, &proto.CustomerEventLog{SequenceId: 6, Action: &proto.Action{Action: "6"}}); err != nil {
+		t.Fatal(err)
+	}
+	expectSeqs(t, "live", live, 6)
+}
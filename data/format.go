@@ -0,0 +1,86 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+
+	"github.com/dgraph-io/badger"
+)
+
+// formatKey holds the version of the key layout a data directory was written with
+//
+//	'v' (no more key)
+//
+// It's one byte on its own, so it can't be any other keyspace's key
+var formatKey = []byte{'v'}
+
+// FormatVersion is the key layout this build reads and writes: 1 is length prefixed
+// stream ids (see keys.go).  Before it there was no version, and logs were
+// "<type>:<numeric id>:<zero padded sequence>"
+const FormatVersion uint64 = 1
+
+// LegacyFormatError is a data directory written before stream ids were length
+// prefixed.  Its logs can't be found where this build looks, and the ring puts its
+// streams on different nodes, so it isn't opened at all rather than reading as empty
+const LegacyFormatError Error = "Data directory has logs from before stream ids were length prefixed"
+
+// legacyLogKey is what logs were keyed as before there was a format version
+var legacyLogKey = regexp.MustCompile(`^[^:]+:[0-9]+:[0-9]{21}$`)
+
+// checkFormat makes sure b is in the layout this build knows, stamping new (or
+// unversioned but current) data directories with it.  A read only store is only
+// checked
+func (b *BadgerStore) checkFormat(readOnly bool) error {
+	var version uint64
+	var legacy []byte
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(formatKey)
+		if err == nil {
+			return item.Value(func(v []byte) error {
+				if len(v) != 8 {
+					return fmt.Errorf("format version is %d bytes, not 8", len(v))
+				}
+				version = binary.BigEndian.Uint64(v)
+				return nil
+			})
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+		// no version: it's from before there was one if it has the old keys.  Only
+		// ever looked for once
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if k := it.Item().Key(); legacyLogKey.Match(k) {
+				legacy = it.Item().KeyCopy(nil)
+				return nil
+			}
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		return err
+	case legacy != nil:
+		return fmt.Errorf("%w (%q, say): this build can't read them, and places streams on the ring differently.  Start the node on an empty data directory", LegacyFormatError, legacy)
+	case version > FormatVersion:
+		return fmt.Errorf("data directory is format version %d, and this build only knows up to %d", version, FormatVersion)
+	case version == FormatVersion || readOnly:
+		return nil
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], FormatVersion)
+	return b.LogDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(formatKey, v[:])
+	})
+}
+
+// isFormatKey is true for the format version's key, which doesn't count as data
+func isFormatKey(k []byte) bool {
+	return bytes.Equal(k, formatKey)
+}
@@ -0,0 +1,51 @@
+package data_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+func TestFormat(t *testing.T) {
+	t.Run("legacy data directories aren't opened", func(t *testing.T) {
+		dir := t.TempDir()
+		// as the numeric id builds wrote it, without a format version
+		db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte("customer:1:000000000000000000000"), []byte{})
+		})
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := data.Open(badger.DefaultOptions(dir).WithLogger(nil)); !errors.Is(err, data.LegacyFormatError) {
+			t.Errorf("expected LegacyFormatError, got %v", err)
+		}
+		if _, err := data.OpenReadOnly(dir); !errors.Is(err, data.LegacyFormatError) {
+			t.Errorf("read only: expected LegacyFormatError, got %v", err)
+		}
+	})
+
+	t.Run("current ones open again, and still count as empty when new", func(t *testing.T) {
+		dir := t.TempDir()
+		ds := data.New(dir)
+		if empty, err := ds.Empty(); err != nil || !empty {
+			t.Errorf("expected a new store to be empty, got %v (%v)", empty, err)
+		}
+		if err := ds.Append(data.CustomerStream("1"), &proto.CustomerEventLog{Action: &proto.Action{Action: "a"}}); err != nil {
+			t.Fatal(err)
+		}
+		ds.Close()
+		ds = data.New(dir)
+		defer ds.Close()
+		if agg, err := ds.GetState(data.CustomerStream("1")); err != nil || agg.Sequence() != 0 {
+			t.Errorf("expected the log back, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package data
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash"
+	"github.com/dgraph-io/badger"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// HintsFullError is returned when a node already has as many hints as it's allowed;
+// the write is lost to that replica until something else repairs it.
+const HintsFullError = Error("too many hints stored for node")
+
+// Defaults for BadgerStore.HintLimit and HintTTL
+const (
+	DefaultHintLimit = 10000
+	DefaultHintTTL   = 3 * time.Hour
+)
+
+// HintStore holds replicated writes for replicas that couldn't take them at the time
+// (hinted handoff), until they are back.
+type HintStore interface {
+	StoreHint(node string, s StreamID, el *proto.CustomerEventLog) error
+	// Hints for node, oldest first, at most limit of them
+	Hints(node string, limit int) ([]Hint, error)
+	DeleteHint(h Hint) error
+}
+
+// Hint is a write that still has to be replicated to Node
+type Hint struct {
+	Node string
+	// From is the node that coordinated the write
+	From    string
+	Stream  StreamID
+	Log     *proto.CustomerEventLog
+	Created time.Time
+
+	key []byte
+}
+
+// Hint keys are
+//
+//	'h' | uvarint(len node) node | created unix nanos (8 bytes, big endian) | hash of the value (8 bytes)
+//
+// so a node's hints are in the order they were written.  They are set with a badger
+// TTL, so expired ones go away on their own (eventually; badger only drops them in
+// compaction, Hints skips them before that).
+const hintKeyspace byte = 'h'
+
+func hintPrefix(node string) []byte {
+	return appendLengthPrefixed([]byte{hintKeyspace}, node)
+}
+
+func (b *BadgerStore) hintLimit() int {
+	if b.HintLimit == 0 {
+		return DefaultHintLimit
+	}
+	return b.HintLimit
+}
+
+func (b *BadgerStore) hintTTL() time.Duration {
+	if b.HintTTL == 0 {
+		return DefaultHintTTL
+	}
+	return b.HintTTL
+}
+
+// hintCounts is when each node's live hints were made, oldest first, so StoreHint
+// doesn't count them in badger on every write.  A node's are read from badger once,
+// the first time it gets a hint here; after that storing, delivering and expiring
+// them keep it up to date
+type hintCounts struct {
+	lock    sync.Mutex
+	created map[string][]int64
+}
+
+// live hints for node, as of now.  Holding the lock
+func (b *BadgerStore) liveHints(node string, now time.Time) ([]int64, error) {
+	created, ok := b.hints.created[node]
+	if !ok {
+		prefix := hintPrefix(node)
+		err := b.LogDB.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				created = append(created, hintCreated(it.Item().Key()).UnixNano())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if b.hints.created == nil {
+			b.hints.created = map[string][]int64{}
+		}
+	}
+	// oldest first, so the expired ones are at the front
+	for len(created) > 0 && now.Sub(time.Unix(0, created[0])) > b.hintTTL() {
+		created = created[1:]
+	}
+	b.hints.created[node] = created
+	return created, nil
+}
+
+// StoreHint for a replica that is down.  Each node gets at most HintLimit live hints.
+func (b *BadgerStore) StoreHint(node string, s StreamID, el *proto.CustomerEventLog) error {
+	v, err := protobuf.Marshal(&proto.ReplicateRequest{
+		Stream: &proto.StreamID{AggregateType: s.Type, Key: []byte(s.ID)},
+		Log:    el,
+		From:   b.NodeID,
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	prefix := hintPrefix(node)
+
+	b.hints.lock.Lock()
+	defer b.hints.lock.Unlock()
+	created, err := b.liveHints(node, now)
+	if err != nil {
+		return err
+	}
+	if len(created) >= b.hintLimit() {
+		return HintsFullError
+	}
+	k := make([]byte, len(prefix), len(prefix)+2*sequenceLen)
+	copy(k, prefix)
+	var tail [2 * sequenceLen]byte
+	binary.BigEndian.PutUint64(tail[:sequenceLen], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint64(tail[sequenceLen:], xxhash.Sum64(v))
+	k = append(k, tail[:]...)
+	err = b.LogDB.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(k, v).WithTTL(b.hintTTL()))
+	})
+	if err != nil {
+		return err
+	}
+	// in order, even if the clock went back
+	i := sort.Search(len(created), func(i int) bool { return created[i] > now.UnixNano() })
+	created = append(created, 0)
+	copy(created[i+1:], created[i:])
+	created[i] = now.UnixNano()
+	b.hints.created[node] = created
+	return nil
+}
+
+func hintCreated(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[len(key)-2*sequenceLen:])))
+}
+
+func (b *BadgerStore) hintExpired(key []byte, now time.Time) bool {
+	return now.Sub(hintCreated(key)) > b.hintTTL()
+}
+
+// Hints for node, oldest first.  Expired hints are skipped.
+func (b *BadgerStore) Hints(node string, limit int) ([]Hint, error) {
+	var hints []Hint
+	now := time.Now()
+	prefix := hintPrefix(node)
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix) && len(hints) < limit; it.Next() {
+			item := it.Item()
+			if b.hintExpired(item.Key(), now) {
+				continue
+			}
+			var req proto.ReplicateRequest
+			err := item.Value(func(v []byte) error {
+				return protobuf.Unmarshal(v, &req)
+			})
+			if err != nil {
+				return err
+			}
+			hints = append(hints, Hint{
+				Node:    node,
+				From:    req.GetFrom(),
+				Stream:  StreamID{Type: req.GetStream().GetAggregateType(), ID: string(req.GetStream().GetKey())},
+				Log:     req.GetLog(),
+				Created: hintCreated(item.Key()),
+				key:     item.KeyCopy(nil),
+			})
+		}
+		return nil
+	})
+	return hints, err
+}
+
+// DeleteHint once it has been delivered
+func (b *BadgerStore) DeleteHint(h Hint) error {
+	b.hints.lock.Lock()
+	defer b.hints.lock.Unlock()
+	err := b.LogDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(h.key)
+	})
+	if err != nil {
+		return err
+	}
+	created, ok := b.hints.created[h.Node]
+	if !ok {
+		return nil
+	}
+	c := hintCreated(h.key).UnixNano()
+	i := sort.Search(len(created), func(i int) bool { return created[i] >= c })
+	switch {
+	case i == len(created) || created[i] != c:
+		// expired already
+	case i == 0:
+		// delivered oldest first, as Hints hands them out
+		b.hints.created[h.Node] = created[1:]
+	default:
+		copy(created[i:], created[i+1:])
+		b.hints.created[h.Node] = created[:len(created)-1]
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yarbelk/distributedservice/data"
+)
+
+func TestHints(t *testing.T) {
+	s := data.CustomerStream("1")
+
+	t.Run("hints come back oldest first, per node, until deleted", func(t *testing.T) {
+		ds := data.New(t.TempDir())
+		defer ds.Close()
+		ds.NodeID = "a"
+		for seq := uint64(0); seq < 3; seq++ {
+			if err := ds.StoreHint("b", s, clocked(seq, "write", data.VectorClock{"a": seq + 1})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ds.StoreHint("c", s, clocked(0, "for c", nil)); err != nil {
+			t.Fatal(err)
+		}
+
+		hints, err := ds.Hints("b", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hints) != 3 {
+			t.Fatalf("expected 3 hints for b, got %d", len(hints))
+		}
+		for i, h := range hints {
+			if h.Log.SequenceId != uint64(i) || h.Stream != s || h.From != "a" {
+				t.Errorf("hint %d is %+v", i, h)
+			}
+		}
+
+		if err = ds.DeleteHint(hints[0]); err != nil {
+			t.Fatal(err)
+		}
+		hints, _ = ds.Hints("b", 1)
+		if len(hints) != 1 || hints[0].Log.SequenceId != 1 {
+			t.Errorf("expected sequence 1 next, got %+v", hints)
+		}
+	})
+
+	t.Run("a node's hints are bounded", func(t *testing.T) {
+		ds := data.New(t.TempDir())
+		defer ds.Close()
+		ds.HintLimit = 2
+		for seq := uint64(0); seq < 2; seq++ {
+			if err := ds.StoreHint("b", s, clocked(seq, "write", nil)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ds.StoreHint("b", s, clocked(2, "write", nil)); err != data.HintsFullError {
+			t.Errorf("expected %v, got %v", data.HintsFullError, err)
+		}
+		if err := ds.StoreHint("c", s, clocked(0, "write", nil)); err != nil {
+			t.Errorf("other nodes have their own limit, got %v", err)
+		}
+
+		hints, err := ds.Hints("b", 1)
+		if err != nil || len(hints) != 1 {
+			t.Fatalf("expected b's first hint, got %v (%v)", hints, err)
+		}
+		if err := ds.DeleteHint(hints[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.StoreHint("b", s, clocked(2, "write", nil)); err != nil {
+			t.Errorf("delivered hints don't count to the limit, got %v", err)
+		}
+		if err := ds.StoreHint("b", s, clocked(3, "write", nil)); err != data.HintsFullError {
+			t.Errorf("expected %v once it's full again, got %v", data.HintsFullError, err)
+		}
+	})
+
+	t.Run("a reopened store still counts the hints it has", func(t *testing.T) {
+		dir := t.TempDir()
+		ds := data.New(dir)
+		ds.HintLimit = 1
+		if err := ds.StoreHint("b", s, clocked(0, "write", nil)); err != nil {
+			t.Fatal(err)
+		}
+		ds.Close()
+		ds = data.New(dir)
+		defer ds.Close()
+		ds.HintLimit = 1
+		if err := ds.StoreHint("b", s, clocked(1, "write", nil)); err != data.HintsFullError {
+			t.Errorf("expected %v, got %v", data.HintsFullError, err)
+		}
+	})
+
+	t.Run("hints expire", func(t *testing.T) {
+		ds := data.New(t.TempDir())
+		defer ds.Close()
+		ds.HintLimit = 1
+		ds.HintTTL = 50 * time.Millisecond
+		if err := ds.StoreHint("b", s, clocked(0, "write", nil)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		hints, err := ds.Hints("b", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hints) != 0 {
+			t.Errorf("expected the hint to have expired, got %+v", hints)
+		}
+		if err := ds.StoreHint("b", s, clocked(1, "write", nil)); err != nil {
+			t.Errorf("expired hints don't count to the limit, got %v", err)
+		}
+	})
+}
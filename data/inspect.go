@@ -0,0 +1,101 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// OpenReadOnly opens a data directory to look at, not change.  badger can't replay
+// a store that wasn't closed properly read only, so that's an error
+// (badger.ErrReplayNeeded)
+func OpenReadOnly(dir string) (*BadgerStore, error) {
+	return Open(badger.DefaultOptions(dir).WithReadOnly(true).WithLogger(nil))
+}
+
+// Gap is sequenceIds missing from a stream, From to To inclusive
+type Gap struct {
+	From, To uint64
+}
+
+// LogProblem is a stored log that isn't what its key says: a different sequenceId
+// (GetState logs those as it replays), or something that doesn't decode at all
+type LogProblem struct {
+	// Sequence from the key
+	Sequence uint64
+	// LogSequence is the log's own, if it decoded
+	LogSequence uint64
+	Problem     string
+}
+
+// StreamReport is what Inspect found in one stream
+type StreamReport struct {
+	Stream StreamID
+	Logs   int
+	// Last sequenceId, by key
+	Last     uint64
+	Gaps     []Gap
+	Problems []LogProblem
+}
+
+// Healthy is no gaps and no problems
+func (r StreamReport) Healthy() bool {
+	return len(r.Gaps) == 0 && len(r.Problems) == 0
+}
+
+// Inspect every stream, in key order: how many logs it has and which are missing or
+// broken.  It reads every log, so it's for offline tools.  Keys in the event
+// keyspace that aren't log keys at all are returned, rather than stopping it
+func (b *BadgerStore) Inspect(fn func(StreamReport) error) (badKeys [][]byte, err error) {
+	err = b.LogDB.View(func(txn *badger.Txn) error {
+		prefix := []byte{eventKeyspace}
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		var r *StreamReport
+		el := new(proto.CustomerEventLog)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			s, seq, err := parseLogKey(item.Key())
+			if err != nil {
+				badKeys = append(badKeys, item.KeyCopy(nil))
+				continue
+			}
+			if r != nil && r.Stream != s {
+				if err := fn(*r); err != nil {
+					return err
+				}
+				r = nil
+			}
+			// sequences start at 0, and go up one at a time
+			var next uint64
+			if r == nil {
+				r = &StreamReport{Stream: s}
+			} else {
+				next = r.Last + 1
+			}
+			if seq > next {
+				r.Gaps = append(r.Gaps, Gap{From: next, To: seq - 1})
+			}
+			r.Logs++
+			r.Last = seq
+
+			el.Reset()
+			err = item.Value(func(v []byte) error {
+				return protobuf.Unmarshal(v, el)
+			})
+			switch {
+			case err != nil:
+				r.Problems = append(r.Problems, LogProblem{Sequence: seq, Problem: fmt.Sprintf("doesn't decode: %s", err)})
+			case el.SequenceId != seq:
+				r.Problems = append(r.Problems, LogProblem{Sequence: seq, LogSequence: el.SequenceId, Problem: fmt.Sprintf("the log's sequenceId is %d", el.SequenceId)})
+			}
+		}
+		if r == nil {
+			return nil
+		}
+		return fn(*r)
+	})
+	return badKeys, err
+}
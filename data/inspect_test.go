@@ -0,0 +1,81 @@
+package data_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	ds := data.New(dir)
+	good, bad := data.CustomerStream("good"), data.CustomerStream("bad")
+	for _, s := range []data.StreamID{good, bad} {
+		for seq := uint64(0); seq < 2; seq++ {
+			if err := ds.Append(s, &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: "a"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the kinds of borked GetState complains about, written around the store
+	mismatched, err := protobuf.Marshal(&proto.CustomerEventLog{SequenceId: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.LogDB.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(data.LogKey(bad, 4), mismatched); err != nil {
+			return err
+		}
+		if err := txn.Set(data.LogKey(bad, 5), []byte{0xff, 0xff}); err != nil {
+			return err
+		}
+		return txn.Set([]byte("enot a log key"), []byte{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.Close()
+
+	ro, err := data.OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	var reports []data.StreamReport
+	badKeys, err := ro.Inspect(func(r data.StreamReport) error {
+		reports = append(reports, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(badKeys) != 1 || string(badKeys[0]) != "enot a log key" {
+		t.Errorf("expected the bad key, got %q", badKeys)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected two streams, got %+v", reports)
+	}
+	// key order: "bad" first
+	if r := reports[1]; r.Stream != good || r.Logs != 2 || r.Last != 1 || !r.Healthy() {
+		t.Errorf("expected good to be fine, got %+v", r)
+	}
+	r := reports[0]
+	if r.Stream != bad || r.Logs != 4 || r.Last != 5 || r.Healthy() {
+		t.Errorf("expected bad's logs, got %+v", r)
+	}
+	if expected := []data.Gap{{From: 2, To: 3}}; !reflect.DeepEqual(r.Gaps, expected) {
+		t.Errorf("expected gaps %v, got %v", expected, r.Gaps)
+	}
+	if len(r.Problems) != 2 || r.Problems[0].Sequence != 4 || r.Problems[0].LogSequence != 3 || r.Problems[1].Sequence != 5 {
+		t.Errorf("expected the mismatch and the undecodable log, got %+v", r.Problems)
+	}
+
+	// and it is read only
+	if err := ro.Append(good, &proto.CustomerEventLog{SequenceId: 2}); err == nil {
+		t.Error("expected writing to a read only store to fail")
+	}
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Key layout.  Everything in the LogDB starts with a one byte keyspace so
+// different kinds of data can't ever collide or show up in each others scans.
+//
+// Event logs are
+//
+//	'e' | uvarint(len(type)) | type | uvarint(len(id)) | id | sequence (8 bytes, big endian)
+//
+// The lengths are what make the stream prefix safe: with opaque ids there is
+// no separator you can pick that an id can't contain; and without the length
+// a prefix scan of "1" would happily walk into "11".  Big endian sequence ids
+// keep the lexagraphical ordering badger iterates in the same as sequence order.
+const (
+	eventKeyspace byte = 'e'
+
+	sequenceLen = 8
+)
+
+// appendLengthPrefixed writes a uvarint length and then the bytes
+func appendLengthPrefixed(dst []byte, b string) []byte {
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(len(b)))
+	dst = append(dst, l[:n]...)
+	return append(dst, b...)
+}
+
+// readLengthPrefixed is the inverse of appendLengthPrefixed; returns the rest of the buffer
+func readLengthPrefixed(b []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return "", nil, fmt.Errorf("bad length prefix")
+	}
+	return string(b[n : n+int(l)]), b[n+int(l):], nil
+}
+
+// encodeStream is the length prefixed (type, id).  Used for both storage and hashing
+func encodeStream(dst []byte, s StreamID) []byte {
+	dst = appendLengthPrefixed(dst, s.Type)
+	return appendLengthPrefixed(dst, s.ID)
+}
+
+// streamPrefix is the key prefix of every log in a stream.
+func streamPrefix(s StreamID) []byte {
+	return encodeStream([]byte{eventKeyspace}, s)
+}
+
+func logKey(s StreamID, sequenceID uint64) []byte {
+	k := streamPrefix(s)
+	var seq [sequenceLen]byte
+	binary.BigEndian.PutUint64(seq[:], sequenceID)
+	return append(k, seq[:]...)
+}
+
+// keyStream is the stream of a key that starts keyspace | stream: logs and siblings
+func keyStream(key []byte) (StreamID, error) {
+	if len(key) == 0 {
+		return StreamID{}, fmt.Errorf("empty key")
+	}
+	t, rest, err := readLengthPrefixed(key[1:])
+	if err != nil {
+		return StreamID{}, fmt.Errorf("malformed key %q: %s", key, err)
+	}
+	id, _, err := readLengthPrefixed(rest)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("malformed key %q: %s", key, err)
+	}
+	return StreamID{Type: t, ID: id}, nil
+}
+
+// parseLogKey splits an event log key back into its stream and sequence id
+func parseLogKey(key []byte) (StreamID, uint64, error) {
+	var s StreamID
+	if len(key) == 0 || key[0] != eventKeyspace {
+		return s, 0, fmt.Errorf("not an event log key %q", key)
+	}
+	t, rest, err := readLengthPrefixed(key[1:])
+	if err != nil {
+		return s, 0, fmt.Errorf("malformed log key %q: %s", key, err)
+	}
+	id, rest, err := readLengthPrefixed(rest)
+	if err != nil {
+		return s, 0, fmt.Errorf("malformed log key %q: %s", key, err)
+	}
+	if len(rest) != sequenceLen {
+		return s, 0, fmt.Errorf("malformed log key %q: bad sequence", key)
+	}
+	return StreamID{Type: t, ID: id}, binary.BigEndian.Uint64(rest), nil
+}
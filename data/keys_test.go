@@ -0,0 +1,67 @@
+package data_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+func TestStreamKeys(t *testing.T) {
+	t.Run("Keys round trip for opaque ids", func(t *testing.T) {
+		for _, s := range []data.StreamID{
+			data.CustomerStream("1"),
+			data.CustomerStream("6f1c6f4e-3c4b-4d4e-9a53-0c2b0c6f1f6e"),
+			data.CustomerStream("\x00\xff:1:"),
+			{Type: "order", ID: ""},
+		} {
+			got, seq, err := data.ParseLogKey(data.LogKey(s, 42))
+			if err != nil {
+				t.Fatalf("%s: %s", s, err)
+			}
+			if got != s || seq != 42 {
+				t.Fatalf("expected %s/42, got %s/%d", s, got, seq)
+			}
+		}
+	})
+	t.Run("No stream prefix is a prefix of a different stream", func(t *testing.T) {
+		pairs := [][2]data.StreamID{
+			{data.CustomerStream("1"), data.CustomerStream("11")},
+			{data.CustomerStream("1:"), data.CustomerStream("1")},
+			{data.CustomerStream("ab"), {Type: "customera", ID: "b"}},
+		}
+		for _, p := range pairs {
+			for _, seq := range []uint64{0, 1, 1 << 40} {
+				if bytes.HasPrefix(data.LogKey(p[1], seq), data.StreamPrefix(p[0])) {
+					t.Fatalf("%s prefix matches key of %s", p[0], p[1])
+				}
+				if bytes.HasPrefix(data.LogKey(p[0], seq), data.StreamPrefix(p[1])) {
+					t.Fatalf("%s prefix matches key of %s", p[1], p[0])
+				}
+			}
+		}
+	})
+	t.Run("Sharing a prefix doesn't share a stream", func(t *testing.T) {
+		dir := t.TempDir()
+		ds := data.New(dir)
+		defer ds.Close()
+
+		for i := uint64(0); i < 3; i++ {
+			if err := ds.Append(data.CustomerStream("11"), &proto.CustomerEventLog{SequenceId: i, Action: &proto.Action{Action: "eleven"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 0 has to be valid for "1" even though "11" already has 0..2
+		if err := ds.Append(data.CustomerStream("1"), &proto.CustomerEventLog{SequenceId: 0, Action: &proto.Action{Action: "one"}}); err != nil {
+			t.Fatalf("sequence validation looked at another stream: %s", err)
+		}
+		cs, err := ds.GetCustomerState(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cs.LastAction != "one" || cs.CurrentSequence != 0 {
+			t.Fatalf("replay of \"1\" picked up logs from \"11\": %+v", cs)
+		}
+	})
+}
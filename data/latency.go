@@ -0,0 +1,87 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var writeSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "eventstore",
+	Name:      "write_seconds",
+	Help:      "How long badger write transactions for events (append, replicate, resolve) took",
+	Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 9),
+})
+
+// writeDecay is how much of the moving average each write is
+const writeDecay = 0.1
+
+// writeTimer keeps a moving average of how long writes take, and which are still
+// going.  When badger stalls (compactions falling behind) nothing finishes, so the
+// average alone would look fine right up until it doesn't
+type writeTimer struct {
+	lock     sync.Mutex
+	average  time.Duration
+	next     uint64
+	inFlight map[uint64]time.Time
+}
+
+func (w *writeTimer) start() uint64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.inFlight == nil {
+		w.inFlight = map[uint64]time.Time{}
+	}
+	w.next++
+	w.inFlight[w.next] = time.Now()
+	return w.next
+}
+
+func (w *writeTimer) done(id uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	took := time.Since(w.inFlight[id])
+	delete(w.inFlight, id)
+	if w.average == 0 {
+		w.average = took
+	} else {
+		w.average = time.Duration(writeDecay*float64(took) + (1-writeDecay)*float64(w.average))
+	}
+	writeSeconds.Observe(took.Seconds())
+}
+
+func (w *writeTimer) latency() time.Duration {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	l := w.average
+	now := time.Now()
+	for _, started := range w.inFlight {
+		if d := now.Sub(started); d > l {
+			l = d
+		}
+	}
+	return l
+}
+
+// update is LogDB.Update for event writes to s, timed.  s's followers are woken
+// once it's committed.  While the store is frozen for a snapshot it waits, untimed:
+// the hold isn't badger being slow
+func (b *BadgerStore) update(s StreamID, fn func(txn *badger.Txn) error) error {
+	b.frozen.RLock()
+	defer b.frozen.RUnlock()
+	id := b.writes.start()
+	defer b.writes.done(id)
+	err := b.LogDB.Update(fn)
+	if err == nil {
+		b.watchers.written(s)
+	}
+	return err
+}
+
+// WriteLatency is how long event writes are taking: a moving average, or how long
+// the slowest write still going has taken if that's longer
+func (b *BadgerStore) WriteLatency() time.Duration {
+	return b.writes.latency()
+}
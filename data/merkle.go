@@ -0,0 +1,200 @@
+package data
+
+import (
+	"encoding/binary"
+
+	"github.com/cespare/xxhash"
+	"github.com/dgraph-io/badger"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// Merkle trees for anti-entropy.  Each partition gets a fixed shape tree:
+// MerkleFanout children per node and MerkleDepth levels under the root, so
+// MerkleFanout^MerkleDepth leaves.  An event key lands in leaf LeafOf(key); a leaf's
+// hash covers its keys and values in key order, and every other node hashes its
+// children.  Two replicas with the same root have the same logs for the partition;
+// otherwise they walk down the levels to the leaves that differ and only compare
+// those keys.
+//
+// Nothing is kept up to date as logs are written: trees are built by scanning the
+// events, all partitions in one go.  That is O(every log) per build; fine for how often
+// anti-entropy runs, and not worth the write path cost of maintaining them.
+const (
+	MerkleFanout = 16
+	MerkleDepth  = 2
+)
+
+// MerkleLeaves is how many leaves each tree has
+const MerkleLeaves = 256 // MerkleFanout^MerkleDepth
+
+// MerkleTree levels: Levels[0] is the root, Levels[MerkleDepth] the leaves
+type MerkleTree struct {
+	Levels [MerkleDepth + 1][]uint64
+}
+
+func newMerkleTree() *MerkleTree {
+	t := &MerkleTree{}
+	n := 1
+	for l := range t.Levels {
+		t.Levels[l] = make([]uint64, n)
+		n *= MerkleFanout
+	}
+	return t
+}
+
+// EmptyMerkleTree is the tree of a partition with nothing in it: all zeros
+func EmptyMerkleTree() *MerkleTree {
+	return newMerkleTree()
+}
+
+// Root hash of the tree
+func (t *MerkleTree) Root() uint64 {
+	return t.Levels[0][0]
+}
+
+// Children of node index at level
+func (t *MerkleTree) Children(level, index int) []uint64 {
+	return t.Levels[level+1][index*MerkleFanout : (index+1)*MerkleFanout]
+}
+
+// LeafOf an event key
+func LeafOf(key []byte) int {
+	return int(xxhash.Sum64(key) % MerkleLeaves)
+}
+
+// entryHash is the hash of one key and value, length prefixed so the split between
+// them is part of it
+func entryHash(key, value []byte) uint64 {
+	d := xxhash.New()
+	var l [binary.MaxVarintLen64]byte
+	d.Write(l[:binary.PutUvarint(l[:], uint64(len(key)))])
+	d.Write(key)
+	d.Write(value)
+	return d.Sum64()
+}
+
+// combine folds hashes together in order
+func combine(hashes ...uint64) uint64 {
+	b := make([]byte, 8*len(hashes))
+	for i, h := range hashes {
+		binary.BigEndian.PutUint64(b[8*i:], h)
+	}
+	return xxhash.Sum64(b)
+}
+
+// finish hashes the inner levels from the leaves
+func (t *MerkleTree) finish() {
+	for l := MerkleDepth - 1; l >= 0; l-- {
+		for i := range t.Levels[l] {
+			t.Levels[l][i] = combine(t.Children(l, i)...)
+		}
+	}
+}
+
+// forEachLog calls fn with every event key and value, in key order, for the streams
+// include picks out.  The key and value are only valid during the call.
+func forEachLog(txn *badger.Txn, include func(StreamID) bool, fn func(s StreamID, key, value []byte) error) error {
+	prefix := []byte{eventKeyspace}
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		s, _, err := parseLogKey(item.Key())
+		if err != nil {
+			return err
+		}
+		if !include(s) {
+			continue
+		}
+		err = item.Value(func(v []byte) error {
+			return fn(s, item.Key(), v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MerkleTrees for every partition that has logs, in one scan.  partitionOf is the
+// ring's partition for a stream.
+func (b *BadgerStore) MerkleTrees(partitionOf func(StreamID) int) (map[int]*MerkleTree, error) {
+	trees := make(map[int]*MerkleTree)
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		all := func(StreamID) bool { return true }
+		return forEachLog(txn, all, func(s StreamID, key, value []byte) error {
+			p := partitionOf(s)
+			t, ok := trees[p]
+			if !ok {
+				t = newMerkleTree()
+				trees[p] = t
+			}
+			leaf := &t.Levels[MerkleDepth][LeafOf(key)]
+			*leaf = combine(*leaf, entryHash(key, value))
+			return nil
+		})
+	})
+	for _, t := range trees {
+		t.finish()
+	}
+	return trees, err
+}
+
+// KeyHash is an event key and the hash of it and its value
+type KeyHash struct {
+	Key  []byte
+	Hash uint64
+}
+
+// LeafKeys of partition that fall in leaves, in key order
+func (b *BadgerStore) LeafKeys(partitionOf func(StreamID) int, partition int, leaves []int) ([]KeyHash, error) {
+	want := make(map[int]bool, len(leaves))
+	for _, l := range leaves {
+		want[l] = true
+	}
+	var keys []KeyHash
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		inPartition := func(s StreamID) bool { return partitionOf(s) == partition }
+		return forEachLog(txn, inPartition, func(s StreamID, key, value []byte) error {
+			if want[LeafOf(key)] {
+				keys = append(keys, KeyHash{Key: append([]byte(nil), key...), Hash: entryHash(key, value)})
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// KeyedLog is a log with the stream it's in
+type KeyedLog struct {
+	Stream StreamID
+	Log    *proto.CustomerEventLog
+}
+
+// LogsByKey looks up event keys (from LeafKeys).  Keys that aren't there, or aren't
+// event keys, are skipped.
+func (b *BadgerStore) LogsByKey(keys [][]byte) ([]KeyedLog, error) {
+	var logs []KeyedLog
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		for _, k := range keys {
+			s, _, err := parseLogKey(k)
+			if err != nil {
+				continue
+			}
+			item, err := txn.Get(k)
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			el, err := unmarshalLog(item)
+			if err != nil {
+				return err
+			}
+			logs = append(logs, KeyedLog{Stream: s, Log: el})
+		}
+		return nil
+	})
+	return logs, err
+}
@@ -0,0 +1,111 @@
+package data_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// twoPartitions puts streams with odd length ids in partition 1
+func twoPartitions(s data.StreamID) int {
+	return len(s.ID) % 2
+}
+
+// replicated writes the same logs to both stores: a coordinates, b replicates
+func replicated(t *testing.T, a, b *data.BadgerStore, s data.StreamID, seq uint64) *proto.CustomerEventLog {
+	t.Helper()
+	el := &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: fmt.Sprintf("%s %d", s.ID, seq)}}
+	if err := a.Append(s, el); err != nil {
+		t.Fatal(err)
+	}
+	if b != nil {
+		if err := b.Replicate(s, el); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return el
+}
+
+func TestMerkleTrees(t *testing.T) {
+	a := data.New(t.TempDir())
+	defer a.Close()
+	a.NodeID = "a"
+	b := data.New(t.TempDir())
+	defer b.Close()
+	b.NodeID = "b"
+
+	streams := []data.StreamID{data.CustomerStream("1"), data.CustomerStream("22"), data.CustomerStream("333")}
+	for _, s := range streams {
+		for seq := uint64(0); seq < 3; seq++ {
+			replicated(t, a, b, s, seq)
+		}
+	}
+
+	ta, err := a.MerkleTrees(twoPartitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := b.MerkleTrees(twoPartitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ta) != 2 || len(tb) != 2 {
+		t.Fatalf("expected trees for 2 partitions, got %d and %d", len(ta), len(tb))
+	}
+	for p := range ta {
+		if ta[p].Root() != tb[p].Root() {
+			t.Errorf("partition %d: same logs, different roots", p)
+		}
+	}
+
+	t.Run("a missing log shows up in one leaf of its partition", func(t *testing.T) {
+		replicated(t, a, nil, streams[0], 3)
+		ta, _ := a.MerkleTrees(twoPartitions)
+		p := twoPartitions(streams[0])
+		if ta[p].Root() == tb[p].Root() {
+			t.Fatal("expected the roots to differ")
+		}
+		if other := 1 - p; ta[other].Root() != tb[other].Root() {
+			t.Error("expected the other partition to be untouched")
+		}
+		var differ []int
+		leaves := ta[p].Levels[data.MerkleDepth]
+		for i := range leaves {
+			if leaves[i] != tb[p].Levels[data.MerkleDepth][i] {
+				differ = append(differ, i)
+			}
+		}
+		if len(differ) != 1 {
+			t.Fatalf("expected one leaf to differ, got %v", differ)
+		}
+
+		keysA, err := a.LeafKeys(twoPartitions, p, differ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keysB, _ := b.LeafKeys(twoPartitions, p, differ)
+		if len(keysA) != len(keysB)+1 {
+			t.Fatalf("expected a to have one more key, got %d and %d", len(keysA), len(keysB))
+		}
+
+		var missing [][]byte
+		have := map[string]bool{}
+		for _, kh := range keysB {
+			have[string(kh.Key)] = true
+		}
+		for _, kh := range keysA {
+			if !have[string(kh.Key)] {
+				missing = append(missing, kh.Key)
+			}
+		}
+		logs, err := a.LogsByKey(missing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != 1 || logs[0].Stream != streams[0] || logs[0].Log.SequenceId != 3 {
+			t.Errorf("expected %s sequence 3, got %+v", streams[0], logs)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the store's metrics are shared by every store in the process; Metrics hands them
+// out with the per store ones, to register where they're wanted
+var (
+	eventsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "eventstore",
+		Name:      "events_written_total",
+		Help:      "Events written, by how they got here: append (coordinated here) or replicate (from another node)",
+	}, []string{"source"})
+	eventsReplayed = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "eventstore",
+		Name:      "events_replayed",
+		Help:      "Events replayed per state lookup (GetState, GetCustomerState)",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+	validationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "eventstore",
+		Name:      "validation_failures_total",
+		Help:      "Writes turned down, by reason: sequence, conflict or stale_clock",
+	}, []string{"reason"})
+)
+
+// countValidation failures; err is passed through
+func countValidation(err error) error {
+	switch err {
+	case InvalidSequenceError:
+		validationFailures.WithLabelValues("sequence").Inc()
+	case ConflictError:
+		validationFailures.WithLabelValues("conflict").Inc()
+	case StaleClockError:
+		validationFailures.WithLabelValues("stale_clock").Inc()
+	}
+	return err
+}
+
+// badgerSizes reports badger's on disk sizes; asked for on every scrape
+type badgerSizes struct {
+	store *BadgerStore
+	lsm   *prometheus.Desc
+	vlog  *prometheus.Desc
+}
+
+func (c badgerSizes) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lsm
+	ch <- c.vlog
+}
+
+func (c badgerSizes) Collect(ch chan<- prometheus.Metric) {
+	if atomic.LoadInt32(&c.store.closed) == 1 {
+		return
+	}
+	lsm, vlog := c.store.LogDB.Size()
+	ch <- prometheus.MustNewConstMetric(c.lsm, prometheus.GaugeValue, float64(lsm))
+	ch <- prometheus.MustNewConstMetric(c.vlog, prometheus.GaugeValue, float64(vlog))
+}
+
+// Metrics for a prometheus registry: events written and replayed, validation
+// failures, write latency, and the size of this store's LSM tree and value log.
+func (b *BadgerStore) Metrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		eventsWritten,
+		eventsReplayed,
+		validationFailures,
+		writeSeconds,
+		badgerSizes{
+			store: b,
+			lsm:   prometheus.NewDesc("badger_lsm_size_bytes", "Size of badger's LSM tree", nil, nil),
+			vlog:  prometheus.NewDesc("badger_vlog_size_bytes", "Size of badger's value log", nil, nil),
+		},
+	}
+}
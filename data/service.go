@@ -1,14 +1,19 @@
 package data
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	protobuf "github.com/golang/protobuf/proto"
 	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+	protov2 "google.golang.org/protobuf/proto"
 )
 
 // CustomerState is an aggregate root - need to move it to its own package
@@ -17,6 +22,10 @@ import (
 type CustomerState struct {
 	LastAction      string
 	CurrentSequence uint64
+	// Clock is the merged clock of every applied log
+	Clock VectorClock
+
+	applied bool
 }
 
 type Error string
@@ -27,21 +36,100 @@ func (e Error) Error() string {
 
 const (
 	InvalidSequenceError Error = "Invalid sequence. Does not pass validation"
+	// ConflictError is a log whose clock is concurrent with the stream: two nodes
+	// wrote without seeing each others writes.
+	ConflictError Error = "Concurrent write. Conflicts with the current state"
+	// StaleClockError is a log whose clock doesn't move the stream forward; it
+	// happened-before something that is already applied.
+	StaleClockError Error = "Stale clock. Log happened before the current state"
+	// ClosedError is what Healthy says once the store has been closed
+	ClosedError Error = "Store is closed"
 )
 
-// Apply a log "cleanly", (actually, this is a completely unvalidated apply for proof of concept)
-// actual consistency is left as an exercise for the reader.  the CustomerEventLog has a
-// 'vector' clock (also fake vector, but probably good enough anyway), and
-// between that and sequenceID you have a enough to implement a consistant
-// event sourced Customer view
-func (cs *CustomerState) Apply(l *proto.CustomerEventLog) {
+// CorruptStreamError is a stored log that can't be replayed on top of the ones before
+// it: a sequenceId out of order, a clock that doesn't follow, or one that doesn't
+// decode.  Err is which (InvalidSequenceError, ConflictError...).  It's the stored
+// history that's wrong, not the stream that's missing
+type CorruptStreamError struct {
+	// Stream is filled in by GetState; Apply doesn't know it
+	Stream   StreamID
+	Sequence uint64
+	Err      error
+}
+
+func (e *CorruptStreamError) Error() string {
+	return fmt.Sprintf("%s is corrupt at sequence %d: %s", e.Stream, e.Sequence, e.Err)
+}
+
+func (e *CorruptStreamError) Unwrap() error { return e.Err }
+
+// validClock checks that a log can causally follow the current clock.  Logs
+// without a clock are from before there were vector clocks: nothing to check.
+func validClock(current VectorClock, l *proto.CustomerEventLog) error {
+	c := ClockFrom(l.GetTimestamp())
+	if len(c) == 0 {
+		return nil
+	}
+	switch current.Compare(c) {
+	case Before:
+		return nil
+	case Concurrent:
+		return ConflictError
+	}
+	return StaleClockError
+}
+
+// Apply a log "cleanly": it has to be the next sequence, and its clock has to
+// happen-after everything applied so far.  Anything else is a *CorruptStreamError
+// (concurrent logs a ConflictError in it) rather than being applied over the top.
+func (cs *CustomerState) Apply(l *proto.CustomerEventLog) error {
+	if cs.applied && l.SequenceId != cs.CurrentSequence+1 {
+		return &CorruptStreamError{Sequence: l.SequenceId, Err: InvalidSequenceError}
+	}
+	if err := validClock(cs.Clock, l); err != nil {
+		return &CorruptStreamError{Sequence: l.SequenceId, Err: err}
+	}
 	cs.LastAction = l.GetAction().GetAction() // fun semantics of protobuf
-	cs.CurrentSequence = l.SequenceId         // should actually be validating the timestamp and prior sequences etc. but this is PoC
+	cs.CurrentSequence = l.SequenceId
+	cs.Clock = cs.Clock.Merge(ClockFrom(l.GetTimestamp()))
+	cs.applied = true
+	return nil
+}
+
+// Sequence is the last applied sequenceId
+func (cs *CustomerState) Sequence() uint64 {
+	return cs.CurrentSequence
+}
+
+// VectorClock of the customer, see Aggregate
+func (cs *CustomerState) VectorClock() VectorClock {
+	return cs.Clock
+}
+
+// Message is the CustomerState rpc message for this customer
+func (cs *CustomerState) Message(s StreamID) protobuf.Message {
+	// numeric ids still get sent back as numbers for old clients
+	id, _ := strconv.ParseUint(s.ID, 10, 64)
+	return &proto.CustomerState{
+		Id:              id,
+		Key:             []byte(s.ID),
+		LastAction:      cs.LastAction,
+		CurrentSequence: cs.CurrentSequence,
+	}
 }
 
+// Storer is anything that can replay and append to event streams.  Which aggregate
+// a stream replays into is up to the Registry the storer was given.
 type Storer interface {
-	GetCustomerState(id uint64) (CustomerState, error)
-	WriteLog(id uint64, el *proto.CustomerEventLog) error
+	GetState(s StreamID) (Aggregate, error)
+	// Append a log as the coordinating node: this stamps the log's clock
+	Append(s StreamID, el *proto.CustomerEventLog) error
+	// Replicate a log some other node coordinated
+	Replicate(s StreamID, el *proto.CustomerEventLog) error
+	// Conflicts lists the unresolved siblings of the stream
+	Conflicts(s StreamID) ([]Conflict, error)
+	// ResolveConflicts by discarding siblings (nil merged) or appending a merge
+	ResolveConflicts(s StreamID, merged *proto.CustomerEventLog) error
 }
 
 // BadgerStore is a fast DB key value store that lets you very quickly iterate over keys in lexagraphical order
@@ -55,116 +143,358 @@ type Storer interface {
 // and versioning logs.  Typically i'd do something like (and this is proto)
 type BadgerStore struct {
 	LogDB *badger.DB
+
+	// Registry of aggregate types this store knows how to replay
+	Registry *Registry
+
+	// NodeID is the entry this node increments in vector clocks; the memberlist node name
+	NodeID string
+
+	// Resolver for conflicting replicated logs.  nil is KeepSiblings
+	Resolver ConflictResolver
+
+	// HintLimit is how many hints (see HintStore) are kept per node; HintTTL is how
+	// long for.  Zero is DefaultHintLimit/DefaultHintTTL
+	HintLimit int
+	HintTTL   time.Duration
+	hints     hintCounts
+
+	// Logger nil is zap's global logger
+	Logger *zap.Logger
+
+	closed   int32
+	writes   writeTimer
+	watchers watchers
+	// frozen holds writes for snapshots: they read lock it, Freeze write locks it
+	frozen sync.RWMutex
+}
+
+func (b *BadgerStore) logger() *zap.Logger {
+	if b.Logger == nil {
+		return zap.L()
+	}
+	return b.Logger
 }
 
-func (b *BadgerStore) Close() {
-	b.LogDB.Close()
+// Close flushes and closes badger.  Nothing can use the store after this.
+func (b *BadgerStore) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return b.LogDB.Close()
 }
 
+// Healthy is nil if badger is open and can be read from.  It reads one key; cheap
+// enough for health checks.
+func (b *BadgerStore) Healthy() error {
+	if atomic.LoadInt32(&b.closed) == 1 {
+		return ClosedError
+	}
+	return b.LogDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Rewind()
+		return nil
+	})
+}
+
+// New store in path, with badger's default options.  Panics if badger won't open
 func New(path string) *BadgerStore {
-	db, err := badger.Open(badger.DefaultOptions(path))
+	b, err := Open(badger.DefaultOptions(path))
 	if err != nil {
 		panic(err)
 	}
+	return b
+}
 
-	return &BadgerStore{LogDB: db}
+// Open a store with tuned badger options.  A data directory in a key layout this
+// build doesn't know is an error (see LegacyFormatError)
+func Open(opts badger.Options) (*BadgerStore, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	b := &BadgerStore{LogDB: db, Registry: DefaultRegistry}
+	if err := b.checkFormat(opts.ReadOnly); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
 }
 
-// GetCustomerState to get a root for the customer.  totally could use snapshots etc
-func (b *BadgerStore) GetCustomerState(id uint64) (CustomerState, error) {
-	cs := new(CustomerState)
-	prefix := []byte(fmt.Sprintf("%d:", id))
-	err := b.LogDB.View(func(txn *badger.Txn) error {
+// GetState replays a stream into a new aggregate of the stream's type.  totally could use snapshots etc
+func (b *BadgerStore) GetState(s StreamID) (Aggregate, error) {
+	agg, err := b.Registry.New(s.Type)
+	if err != nil {
+		return nil, err
+	}
+	prefix := streamPrefix(s)
+	var replayed int
+	defer func() { eventsReplayed.Observe(float64(replayed)) }()
+	err = b.LogDB.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		it := txn.NewIterator(opts)
-		customerLog := new(proto.CustomerEventLog)
+		defer it.Close()
+		eventLog := new(proto.CustomerEventLog)
 		buf := make([]byte, 0, 1000) // make a capacity 1000 buffer, of length 0
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			buf = buf[:0] // we're reusing the buffer. reset length
 			item := it.Item()
 			key := item.Key()
-			splits := strings.Split(string(key), ":")
-			if len(splits) != 2 {
-				panic("lazy panic stuffs bc/ PoC")
+			_, seq, err := parseLogKey(key)
+			if err != nil {
+				return err
 			}
-			s, err := strconv.ParseUint(splits[1], 10, 64)
-			buf, err := item.ValueCopy(buf)
+			buf, err = item.ValueCopy(buf)
 			if err != nil {
 				return err
 			}
-			protobuf.Unmarshal(buf, customerLog)
+			if err = protobuf.Unmarshal(buf, eventLog); err != nil {
+				return &CorruptStreamError{Sequence: seq, Err: err}
+			}
 			// sanity checks
-			if s != customerLog.SequenceId {
-				log.Printf("heres a fun thing: the datamodel is borked for stored key %s: %+v\n", string(key), customerLog)
+			if seq != eventLog.SequenceId {
+				// heres a fun thing: the datamodel is borked
+				b.logger().Error("stored log doesn't match its key", zap.Stringer("stream", s),
+					zap.Uint64("sequence", seq), zap.Uint64("log_sequence", eventLog.SequenceId), zap.Binary("key", key))
 			}
-			cs.Apply(customerLog)
+			if err = agg.Apply(eventLog); err != nil {
+				var corrupt *CorruptStreamError
+				if !errors.As(err, &corrupt) {
+					corrupt = &CorruptStreamError{Sequence: seq, Err: err}
+				}
+				return corrupt
+			}
+			replayed++
 		}
-		defer it.Close()
 		return nil
 	})
-	return *cs, err
+	var corrupt *CorruptStreamError
+	if errors.As(err, &corrupt) {
+		corrupt.Stream = s
+		b.logger().Error("stream can't be replayed", zap.Stringer("stream", s),
+			zap.Uint64("sequence", corrupt.Sequence), zap.Error(corrupt.Err))
+	}
+	return agg, err
 }
 
-// WriteLog is not optimized/batched up for speed.  It could be.
+// GetCustomerState to get a root for the customer.  This is the pre-aggregate-registry api,
+// kept around because customers are still the main thing we store.
+func (b *BadgerStore) GetCustomerState(id uint64) (CustomerState, error) {
+	agg, err := b.GetState(CustomerStream(NumericID(id)))
+	if err != nil {
+		return CustomerState{}, err
+	}
+	return *agg.(*CustomerState), nil
+}
+
+// Append is not optimized/batched up for speed.  It could be.
 // also: copying nots on design from the protofile so they are not missed:
 
-//     Another simplificaiton is in the keying/logging system.  we are completly skipping
-//     good design of the logging format: which should have a standardized way of looking up
-//     and versioning logs.  Typically i'd do something like
+// Another simplificaiton is in the keying/logging system.  we are completly skipping
+// good design of the logging format: which should have a standardized way of looking up
+// and versioning logs.  Typically i'd do something like
 //
-//     message LogMeta {
-//       string EventType = 1;
-//       int64 EventVersion = 2;
-//       uint64 sequenceId = 3;
-//       VectorTimestamp eventTimestamp = 4;
-//       // a bunch of metadat
-//       Any EventPayload = 10;  // or byte, or anything.
-//     }
-//     in this way; you can have many versions of the same EventName that cleanly apply
-//     and its discoverable in a fast to deserialize way.  the meta data is moved
-//     out of the 'XEventLog' message.
-//     I'm not doing this because, while not hard to do, its too much effort for a
-//     PoC; but I think its important to understand that as implemented: this is
-//     _not_ a futureproof design.  Or a scalable design.
-func (b *BadgerStore) WriteLog(id uint64, el *proto.CustomerEventLog) error {
-	err := b.LogDB.Update(func(txn *badger.Txn) error {
-		if !validSequenceID(id, el.SequenceId, txn) {
-			fmt.Printf("id, el: %d, %+v\n", id, el)
-			return InvalidSequenceError
+//	message LogMeta {
+//	  string EventType = 1;
+//	  int64 EventVersion = 2;
+//	  uint64 sequenceId = 3;
+//	  VectorTimestamp eventTimestamp = 4;
+//	  // a bunch of metadat
+//	  Any EventPayload = 10;  // or byte, or anything.
+//	}
+//
+// in this way; you can have many versions of the same EventName that cleanly apply
+// and its discoverable in a fast to deserialize way.  the meta data is moved
+// out of the 'XEventLog' message.
+// I'm not doing this because, while not hard to do, its too much effort for a
+// PoC; but I think its important to understand that as implemented: this is
+// _not_ a futureproof design.  Or a scalable design.
+func (b *BadgerStore) Append(s StreamID, el *proto.CustomerEventLog) error {
+	if _, err := b.Registry.New(s.Type); err != nil {
+		return err
+	}
+	err := b.update(s, func(txn *badger.Txn) error {
+		return appendTxn(txn, s, el, nil, b.stamp())
+	})
+	if err == nil {
+		eventsWritten.WithLabelValues("append").Inc()
+	} else if IsValidationError(err) {
+		b.logger().Debug("append turned down", zap.Stringer("stream", s), zap.Uint64("sequence", el.SequenceId), zap.Error(err))
+	}
+	return countValidation(err)
+}
+
+// Stamp is who coordinated a write and when: the node entry that is incremented
+// in the clock, and the wall time recorded for last-writer-wins.
+type Stamp struct {
+	Node     string
+	WallTime int64
+}
+
+// stamp for a write this node is coordinating right now
+func (b *BadgerStore) stamp() Stamp {
+	return Stamp{Node: b.NodeID, WallTime: time.Now().UnixNano()}
+}
+
+// appendTxn is Append inside a transaction.  seen is any extra causal context to fold
+// in to the new clock on top of the stream's (the siblings a merge resolves).
+// All the validation happens before anything is written; so on an error nothing
+// has been written to txn.
+func appendTxn(txn *badger.Txn, s StreamID, el *proto.CustomerEventLog, seen VectorClock, stamp Stamp) error {
+	last, err := lastLog(txn, s)
+	if err != nil {
+		return err
+	}
+	if !validSequenceID(last, el.SequenceId) {
+		return InvalidSequenceError
+	}
+	// The client's clock is the state it saw when it decided to write.  No clock
+	// means an old client: the sequenceId passing validation is as good a causal
+	// context as we get, so treat it as having seen the current clock.
+	streamClock := ClockFrom(last.GetTimestamp())
+	clock := ClockFrom(el.GetTimestamp())
+	if len(clock) == 0 {
+		clock = streamClock
+	}
+	switch clock.Compare(streamClock) {
+	case Concurrent:
+		return ConflictError
+	case Before:
+		return StaleClockError
+	}
+	clock = clock.Merge(streamClock).Merge(seen).Increment(stamp.Node)
+	el.Timestamp = clock.Timestamp()
+	el.Timestamp.WallTime = stamp.WallTime
+	el.Timestamp.Node = stamp.Node
+
+	if err = writeLog(txn, s, el); err != nil {
+		return err
+	}
+	return clearDominated(txn, s, clock)
+}
+
+// Replicate writes a log that was coordinated (and clock stamped) by another node.
+// Getting the same log twice is fine.  Getting a different log for a sequenceId we
+// already have goes to the Resolver; a log concurrent with the stream that can't be
+// resolved is kept as a sibling, and comes back as a ConflictError.
+func (b *BadgerStore) Replicate(s StreamID, el *proto.CustomerEventLog) error {
+	if _, err := b.Registry.New(s.Type); err != nil {
+		return err
+	}
+	// a sibling has to be committed, so it can't be an error out of the txn
+	var conflicted, wrote bool
+	err := b.update(s, func(txn *badger.Txn) error {
+		clock := ClockFrom(el.GetTimestamp())
+		existing, err := getLog(txn, s, el.SequenceId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if ClockFrom(existing.GetTimestamp()).Compare(clock) == Equal {
+				return nil
+			}
+			conflicted, err = b.resolve(txn, s, existing, el)
+			return err
 		}
-		v, err := protobuf.Marshal(el)
+		last, err := lastLog(txn, s)
 		if err != nil {
 			return err
 		}
-		//
-		txn.Set([]byte(fmt.Sprintf("%d:%021d", id, el.SequenceId)), v)
-		return nil
+		if !validSequenceID(last, el.SequenceId) {
+			return InvalidSequenceError
+		}
+		streamClock := ClockFrom(last.GetTimestamp())
+		switch err = validClock(streamClock, el); err {
+		case nil:
+		case ConflictError:
+			// diverged before this sequence; there is nothing here to resolve against
+			conflicted = true
+			return recordSibling(txn, s, el)
+		default:
+			return err
+		}
+		if el.Timestamp == nil {
+			el.Timestamp = new(proto.VectorTimestamp)
+		}
+		clock = streamClock.Merge(clock)
+		el.Timestamp.Clock = clock.Timestamp().Clock
+
+		if err = writeLog(txn, s, el); err != nil {
+			return err
+		}
+		wrote = true
+		return clearDominated(txn, s, clock)
 	})
-	return err
+	if err == nil && conflicted {
+		err = ConflictError
+	}
+	if err == nil && wrote {
+		eventsWritten.WithLabelValues("replicate").Inc()
+	}
+	return countValidation(err)
 }
 
-func validSequenceID(cid, givenSID uint64, txn *badger.Txn) bool {
-	var expectedSequenceId uint64
+// marshalLog deterministically: the clock is a map, and replicas have to end up with
+// the same bytes for the same log or their merkle trees never agree
+func marshalLog(el *proto.CustomerEventLog) ([]byte, error) {
+	return protov2.MarshalOptions{Deterministic: true}.Marshal(protobuf.MessageV2(el))
+}
 
-	prefix := []byte(fmt.Sprintf("%d:", cid))
+func writeLog(txn *badger.Txn, s StreamID, el *proto.CustomerEventLog) error {
+	v, err := marshalLog(el)
+	if err != nil {
+		return err
+	}
+	return txn.Set(logKey(s, el.SequenceId), v)
+}
+
+// WriteLog appends to a customer's stream by numeric id.  see GetCustomerState
+func (b *BadgerStore) WriteLog(id uint64, el *proto.CustomerEventLog) error {
+	return b.Append(CustomerStream(NumericID(id)), el)
+}
+
+func unmarshalLog(item *badger.Item) (*proto.CustomerEventLog, error) {
+	l := new(proto.CustomerEventLog)
+	err := item.Value(func(v []byte) error {
+		return protobuf.Unmarshal(v, l)
+	})
+	return l, err
+}
+
+// getLog reads one log, nil if there isn't one with that sequenceId
+func getLog(txn *badger.Txn, s StreamID, sequenceID uint64) (*proto.CustomerEventLog, error) {
+	item, err := txn.Get(logKey(s, sequenceID))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalLog(item)
+}
+
+// lastLog is the most recent log in the stream, nil if the stream is empty.
+// iterates backwards from the end of the stream's keys, so this is one seek
+// rather than a scan of the whole stream.
+func lastLog(txn *badger.Txn, s StreamID) (*proto.CustomerEventLog, error) {
+	prefix := streamPrefix(s)
 	opts := badger.DefaultIteratorOptions
 	opts.PrefetchValues = false
+	opts.Reverse = true
 	it := txn.NewIterator(opts)
 	defer it.Close()
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		key := it.Item().Key()
-		splits := strings.Split(string(key), ":")
-		if len(splits) != 2 {
-			panic("lazy panic stuffs bc/ PoC")
-		}
-		s, err := strconv.ParseUint(splits[1], 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		expectedSequenceId = s + 1
+	it.Seek(logKey(s, math.MaxUint64))
+	if !it.ValidForPrefix(prefix) {
+		return nil, nil
 	}
-	if givenSID != expectedSequenceId {
-		return false
+	return unmarshalLog(it.Item())
+}
+
+// validSequenceID is the next sequence after the last log, or 0 for a new stream
+func validSequenceID(last *proto.CustomerEventLog, givenSID uint64) bool {
+	if last == nil {
+		return givenSID == 0
 	}
-	return true
+	return givenSID == last.SequenceId+1
 }
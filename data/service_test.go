@@ -1,12 +1,12 @@
 package data_test
 
 import (
-	"fmt"
 	"math/rand"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/yarbelk/distributedservice/data"
@@ -14,7 +14,7 @@ import (
 )
 
 func GetKeyList(b *data.BadgerStore, id uint64) ([]string, error) {
-	prefix := []byte(fmt.Sprintf("%d:", id))
+	prefix := data.StreamPrefix(data.CustomerStream(data.NumericID(id)))
 
 	keys := make([]string, 0)
 	err := b.LogDB.View(func(txn *badger.Txn) error {
@@ -52,11 +52,12 @@ func TestBadgerImplementationBasics(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		// ordering ftw - mat.MaxUint64.  this should be done programatically but...
-		if !reflect.DeepEqual([]string{"1:000000000000000000000", "1:000000000000000000001"}, keys) {
+		s := data.CustomerStream("1")
+		expected := []string{string(data.LogKey(s, 0)), string(data.LogKey(s, 1))}
+		if !reflect.DeepEqual(expected, keys) {
 			_, file, line, _ := runtime.Caller(0)
 
-			t.Logf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, []string{"1:000000000000000000000", "1:000000000000000000001"}, keys)
+			t.Logf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, expected, keys)
 			t.FailNow()
 		}
 	})
@@ -264,6 +265,22 @@ func TestBadgerImplementationBasics(t *testing.T) {
 	})
 }
 
+func TestWriteLatency(t *testing.T) {
+	ds := data.New(t.TempDir())
+	defer ds.Close()
+	if l := ds.WriteLatency(); l != 0 {
+		t.Errorf("expected no latency before any writes, got %s", l)
+	}
+	for i := uint64(0); i < 3; i++ {
+		if err := ds.WriteLog(1, &proto.CustomerEventLog{SequenceId: i, Action: &proto.Action{Action: "write"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l := ds.WriteLatency(); l <= 0 || l > time.Second {
+		t.Errorf("expected the writes to have taken a little while, got %s", l)
+	}
+}
+
 func BenchmarkLookupSpeed(b *testing.B) {
 	// or: fun explorations in typecasting int types to get random data sets.
 
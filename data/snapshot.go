@@ -0,0 +1,172 @@
+package data
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// Snapshotter can take a snapshot of its logs at the same moment as the rest of the
+// cluster: writes are held while every node opens its snapshot
+type Snapshotter interface {
+	// Freeze holds writes, once the ones in flight are done, and opens a snapshot.
+	// Writes go again once thaw is called; the snapshot stays open until it's closed
+	Freeze() (snap *Snapshot, thaw func())
+}
+
+// Snapshot is the store's logs as they were at one moment: a badger read transaction
+// kept open
+type Snapshot struct {
+	txn *badger.Txn
+}
+
+// Freeze the store; see Snapshotter
+func (b *BadgerStore) Freeze() (*Snapshot, func()) {
+	b.frozen.Lock()
+	snap := &Snapshot{txn: b.LogDB.NewTransaction(false)}
+	var once sync.Once
+	return snap, func() { once.Do(b.frozen.Unlock) }
+}
+
+// ReadTs is badger's read timestamp for the snapshot
+func (s *Snapshot) ReadTs() uint64 {
+	return s.txn.ReadTs()
+}
+
+// Dump the snapshot's logs, as DumpLogs does
+func (s *Snapshot) Dump(w io.Writer) error {
+	return DumpLogs(s.txn, w, func(StreamID) bool { return true })
+}
+
+// Highest sequenceId of each stream in the snapshot, in key order
+func (s *Snapshot) Highest(fn func(StreamID, uint64) error) error {
+	prefix := []byte{eventKeyspace}
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := s.txn.NewIterator(opts)
+	defer it.Close()
+	var last StreamID
+	var highest uint64
+	seen := false
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		stream, seq, err := parseLogKey(it.Item().Key())
+		if err != nil {
+			return err
+		}
+		if seen && stream != last {
+			if err := fn(last, highest); err != nil {
+				return err
+			}
+		}
+		last, highest, seen = stream, seq, true
+	}
+	if !seen {
+		return nil
+	}
+	return fn(last, highest)
+}
+
+// Close the snapshot's transaction; badger can't throw away versions it needs until
+// it is
+func (s *Snapshot) Close() {
+	s.txn.Discard()
+}
+
+// ReplicateDump writes the logs in a DumpLogs dump that include picks out, as
+// replicated logs: copies of a log from different nodes are the same log, and ones
+// that disagree end up as siblings.  It's for loading snapshots into a new cluster.
+// Logs the stream won't take (stale clocks, gaps) are skipped and counted
+func (b *BadgerStore) ReplicateDump(r io.Reader, include func(StreamID) bool) (written, conflicts, skipped int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		key, err := readChunk(br)
+		if err == io.EOF {
+			return written, conflicts, skipped, nil
+		}
+		if err != nil {
+			return written, conflicts, skipped, err
+		}
+		v, err := readChunk(br)
+		if err != nil {
+			return written, conflicts, skipped, err
+		}
+		s, _, err := parseLogKey(key)
+		if err != nil {
+			return written, conflicts, skipped, err
+		}
+		if !include(s) {
+			continue
+		}
+		el := new(proto.CustomerEventLog)
+		if err := protobuf.Unmarshal(v, el); err != nil {
+			return written, conflicts, skipped, err
+		}
+		switch err := b.Replicate(s, el); {
+		case err == nil:
+			written++
+		case err == ConflictError:
+			conflicts++
+		case IsValidationError(err):
+			skipped++
+		default:
+			return written, conflicts, skipped, err
+		}
+	}
+}
+
+// Manifest of a cluster wide snapshot: each node's file, what it had, and where it
+// was read
+type Manifest struct {
+	ID    string         `json:"id"`
+	Taken time.Time      `json:"taken"`
+	Nodes []ManifestNode `json:"nodes"`
+}
+
+// ManifestNode is one node's part of a snapshot.  Highest is keyed by StreamKey
+type ManifestNode struct {
+	Name       string            `json:"name"`
+	File       string            `json:"file"`
+	ReadTs     uint64            `json:"read_ts"`
+	Partitions []int             `json:"partitions"`
+	Highest    map[string]uint64 `json:"highest"`
+}
+
+// Highest sequenceId of every stream, over all the nodes
+func (m *Manifest) Highest() map[string]uint64 {
+	highest := map[string]uint64{}
+	for _, n := range m.Nodes {
+		for s, seq := range n.Highest {
+			if h, ok := highest[s]; !ok || seq > h {
+				highest[s] = seq
+			}
+		}
+	}
+	return highest
+}
+
+// StreamKey is how a manifest writes a stream: its String, which quotes the id so
+// any bytes survive json
+func StreamKey(s StreamID) string {
+	return s.String()
+}
+
+// ParseStreamKey is the inverse of StreamKey
+func ParseStreamKey(key string) (StreamID, error) {
+	i := strings.Index(key, ":")
+	if i < 0 {
+		return StreamID{}, fmt.Errorf("not a stream: %s", key)
+	}
+	id, err := strconv.Unquote(key[i+1:])
+	if err != nil {
+		return StreamID{}, fmt.Errorf("not a stream: %s", key)
+	}
+	return StreamID{Type: key[:i], ID: id}, nil
+}
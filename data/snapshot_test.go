@@ -0,0 +1,104 @@
+package data_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+func TestSnapshot(t *testing.T) {
+	ds := data.New(t.TempDir())
+	defer ds.Close()
+	write := func(id, seq uint64) error {
+		el := &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: fmt.Sprintf("%d.%d", id, seq)}}
+		return ds.Append(data.CustomerStream(data.NumericID(id)), el)
+	}
+	for id := uint64(0); id < 10; id++ {
+		for seq := uint64(0); seq <= id%3; seq++ {
+			if err := write(id, seq); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	snap, thaw := ds.Freeze()
+	defer snap.Close()
+	// writes wait for the thaw
+	written := make(chan error, 1)
+	go func() { written <- write(0, 1) }()
+	select {
+	case err := <-written:
+		t.Fatalf("expected the write to be held, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	thaw()
+	thaw() // twice is fine
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	// and the snapshot doesn't have it
+	highest := map[string]uint64{}
+	err := snap.Highest(func(s data.StreamID, seq uint64) error {
+		highest[data.StreamKey(s)] = seq
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(highest) != 10 {
+		t.Errorf("expected 10 streams, got %v", highest)
+	}
+	for id := uint64(0); id < 10; id++ {
+		key := data.StreamKey(data.CustomerStream(data.NumericID(id)))
+		if highest[key] != id%3 {
+			t.Errorf("%s: expected highest %d, got %d", key, id%3, highest[key])
+		}
+		s, err := data.ParseStreamKey(key)
+		if err != nil || s != data.CustomerStream(data.NumericID(id)) {
+			t.Errorf("expected %s to parse back, got %v %v", key, s, err)
+		}
+	}
+
+	var dump bytes.Buffer
+	if err := snap.Dump(&dump); err != nil {
+		t.Fatal(err)
+	}
+	// odd customers only, and twice: the second copies are the same logs
+	restored := data.New(t.TempDir())
+	defer restored.Close()
+	odd := func(s data.StreamID) bool {
+		id, _ := strconv.ParseUint(s.ID, 10, 64)
+		return id%2 == 1
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := restored.ReplicateDump(bytes.NewReader(dump.Bytes()), odd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := uint64(0); id < 10; id++ {
+		got, err := restored.GetCustomerState(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id%2 == 0 {
+			if got.LastAction != "" {
+				t.Errorf("customer %d: expected nothing, got %+v", id, got)
+			}
+			continue
+		}
+		expected, err := ds.GetCustomerState(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("customer %d: expected %+v, got %+v", id, expected, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/dgraph-io/badger"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// RaftKeyspace is reserved for raft group state (see the raftgroup package): its log,
+// stable store and the last applied index of each group.
+const RaftKeyspace byte = 'r'
+
+// IsValidationError is true for the errors that mean a write was rejected, as opposed
+// to the store failing to do it.
+func IsValidationError(err error) bool {
+	switch err {
+	case InvalidSequenceError, ConflictError, StaleClockError, UnknownAggregateError:
+		return true
+	}
+	return false
+}
+
+func appliedIndex(txn *badger.Txn, key []byte) (uint64, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var index uint64
+	err = item.Value(func(v []byte) error {
+		index = binary.BigEndian.Uint64(v)
+		return nil
+	})
+	return index, err
+}
+
+func setApplied(txn *badger.Txn, key []byte, index uint64) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], index)
+	return txn.Set(key, v[:])
+}
+
+// ApplyAppend is Append for a replicated state machine.  Every replica applies the
+// same command in the same order, so the write has to come out the same everywhere:
+// the clock is stamped with the command's Stamp rather than this node's, and the
+// command's index is recorded (under appliedKey) in the same transaction as the write
+// so a command replayed after a restart is skipped instead of applied twice.
+//
+// A rejected write (IsValidationError) is still an applied command; the error is
+// the command's result.
+func (b *BadgerStore) ApplyAppend(appliedKey []byte, index uint64, stamp Stamp, s StreamID, el *proto.CustomerEventLog) error {
+	if _, err := b.Registry.New(s.Type); err != nil {
+		return err
+	}
+	var result error
+	var wrote bool
+	err := b.update(s, func(txn *badger.Txn) error {
+		applied, err := appliedIndex(txn, appliedKey)
+		if err != nil {
+			return err
+		}
+		if index <= applied {
+			return nil
+		}
+		result = appendTxn(txn, s, el, nil, stamp)
+		if result != nil && !IsValidationError(result) {
+			return result
+		}
+		wrote = result == nil
+		return setApplied(txn, appliedKey, index)
+	})
+	if err != nil {
+		return err
+	}
+	if wrote {
+		eventsWritten.WithLabelValues("append").Inc()
+	}
+	return countValidation(result)
+}
+
+// AppliedIndex is the last command index recorded by ApplyAppend or RestoreLogs
+func (b *BadgerStore) AppliedIndex(appliedKey []byte) (uint64, error) {
+	var index uint64
+	err := b.LogDB.View(func(txn *badger.Txn) (err error) {
+		index, err = appliedIndex(txn, appliedKey)
+		return err
+	})
+	return index, err
+}
+
+// DumpLogs writes every event log of the streams include picks out, as seen by txn.
+// The format is just uvarint length prefixed keys and values, back to back; it's
+// meant for RestoreLogs, not for keeping.
+func DumpLogs(txn *badger.Txn, w io.Writer, include func(StreamID) bool) error {
+	bw := bufio.NewWriter(w)
+	var l [binary.MaxVarintLen64]byte
+	err := forEachLog(txn, include, func(s StreamID, key, value []byte) error {
+		for _, b := range [][]byte{key, value} {
+			n := binary.PutUvarint(l[:], uint64(len(b)))
+			if _, err := bw.Write(l[:n]); err != nil {
+				return err
+			}
+			if _, err := bw.Write(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// RestoreLogs replaces the logs of every stream include picks out with the ones in a
+// DumpLogs dump, and records index under appliedKey.  Their siblings go too: they were
+// against logs that aren't there any more.  It's a write like any other, so it waits
+// while the store is frozen for a snapshot
+func (b *BadgerStore) RestoreLogs(r io.Reader, include func(StreamID) bool, appliedKey []byte, index uint64) error {
+	b.frozen.RLock()
+	defer b.frozen.RUnlock()
+	// throw away what is there first
+	var stale [][]byte
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for _, prefix := range [][]byte{{eventKeyspace}, {conflictKeyspace}} {
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				s, err := keyStream(it.Item().Key())
+				if err != nil {
+					return err
+				}
+				if include(s) {
+					stale = append(stale, it.Item().KeyCopy(nil))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	wb := b.LogDB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range stale {
+		if err = wb.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		key, err := readChunk(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		v, err := readChunk(br)
+		if err != nil {
+			return err
+		}
+		if err = wb.Set(key, v); err != nil {
+			return err
+		}
+	}
+	var applied [8]byte
+	binary.BigEndian.PutUint64(applied[:], index)
+	if err = wb.Set(appliedKey, applied[:]); err != nil {
+		return err
+	}
+	if err = wb.Flush(); err != nil {
+		return err
+	}
+	b.watchers.writtenAll()
+	return nil
+}
+
+func readChunk(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,70 @@
+package data_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/yarbelk/distributedservice/data"
+)
+
+func TestRestoreLogs(t *testing.T) {
+	s := data.CustomerStream("1")
+	all := func(data.StreamID) bool { return true }
+
+	// what the group's snapshot has: one log
+	from := data.New(t.TempDir())
+	defer from.Close()
+	if err := from.Replicate(s, clocked(0, "snapshot", data.VectorClock{"a": 1})); err != nil {
+		t.Fatal(err)
+	}
+	var dump bytes.Buffer
+	err := from.LogDB.View(func(txn *badger.Txn) error { return data.DumpLogs(txn, &dump, all) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and a replica with two of its own, and a sibling
+	ds := data.New(t.TempDir())
+	defer ds.Close()
+	for _, el := range []struct {
+		seq   uint64
+		clock data.VectorClock
+	}{{0, data.VectorClock{"b": 1}}, {1, data.VectorClock{"b": 2}}} {
+		if err := ds.Replicate(s, clocked(el.seq, "local", el.clock)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ds.Replicate(s, clocked(1, "sibling", data.VectorClock{"b": 1, "c": 1})); err != data.ConflictError {
+		t.Fatalf("expected the sibling to be kept, got %v", err)
+	}
+
+	// restores are writes: they wait for a snapshot to be done
+	_, thaw := ds.Freeze()
+	restored := make(chan error, 1)
+	go func() { restored <- ds.RestoreLogs(bytes.NewReader(dump.Bytes()), all, []byte("rapplied"), 7) }()
+	select {
+	case err := <-restored:
+		t.Fatalf("expected the restore to be held, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	thaw()
+	if err := <-restored; err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := ds.GetCustomerState(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.LastAction != "snapshot" || cs.CurrentSequence != 0 {
+		t.Errorf("expected the snapshot's log, got %+v", cs)
+	}
+	if conflicts, err := ds.Conflicts(s); err != nil || len(conflicts) != 0 {
+		t.Errorf("expected the siblings to go with the logs, got %+v (%v)", conflicts, err)
+	}
+	if index, err := ds.AppliedIndex([]byte("rapplied")); err != nil || index != 7 {
+		t.Errorf("expected applied index 7, got %d (%v)", index, err)
+	}
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"github.com/dgraph-io/badger"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// StreamLister can list what it holds: for moving data between nodes, not for
+// serving requests.
+type StreamLister interface {
+	// Streams that have at least one log, in key order
+	Streams() ([]StreamID, error)
+	// Logs of a stream, in sequence order
+	Logs(s StreamID) ([]*proto.CustomerEventLog, error)
+}
+
+// Streams that have at least one log.  This is a scan over every event key (no values
+// though); it is all in memory so only use it for things like drains.
+func (b *BadgerStore) Streams() ([]StreamID, error) {
+	var streams []StreamID
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		prefix := []byte{eventKeyspace}
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			s, _, err := parseLogKey(it.Item().Key())
+			if err != nil {
+				return err
+			}
+			if n := len(streams); n == 0 || streams[n-1] != s {
+				streams = append(streams, s)
+			}
+		}
+		return nil
+	})
+	return streams, err
+}
+
+// Logs of a stream, in sequence order
+func (b *BadgerStore) Logs(s StreamID) ([]*proto.CustomerEventLog, error) {
+	var logs []*proto.CustomerEventLog
+	err := b.LogDB.View(func(txn *badger.Txn) error {
+		prefix := streamPrefix(s)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			el, err := unmarshalLog(it.Item())
+			if err != nil {
+				return err
+			}
+			logs = append(logs, el)
+		}
+		return nil
+	})
+	return logs, err
+}
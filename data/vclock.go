@@ -0,0 +1,107 @@
+package data
+
+import (
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// VectorClock is a counter per node id.  A node bumps its own entry when it
+// coordinates a write; replicas merge what they are sent.  Comparing two clocks
+// says if one event could have caused the other, or if they are concurrent (and
+// so a conflict that something has to resolve)
+type VectorClock map[string]uint64
+
+// Ordering of two vector clocks
+type Ordering int
+
+const (
+	Equal Ordering = iota
+	Before
+	After
+	Concurrent
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	case Concurrent:
+		return "concurrent"
+	}
+	return "unknown"
+}
+
+// ClockFrom the wire format.  the old 'timestamps' list is ignored; it never meant anything
+func ClockFrom(ts *proto.VectorTimestamp) VectorClock {
+	vc := make(VectorClock, len(ts.GetClock()))
+	for node, c := range ts.GetClock() {
+		vc[node] = c
+	}
+	return vc
+}
+
+// Timestamp is the wire format of the clock
+func (vc VectorClock) Timestamp() *proto.VectorTimestamp {
+	ts := &proto.VectorTimestamp{Clock: make(map[string]uint64, len(vc))}
+	for node, c := range vc {
+		ts.Clock[node] = c
+	}
+	return ts
+}
+
+// Copy so the clock can be changed without changing the original
+func (vc VectorClock) Copy() VectorClock {
+	c := make(VectorClock, len(vc))
+	for node, v := range vc {
+		c[node] = v
+	}
+	return c
+}
+
+// Increment the node's entry, returning a new clock
+func (vc VectorClock) Increment(node string) VectorClock {
+	c := vc.Copy()
+	c[node]++
+	return c
+}
+
+// Merge is the pairwise max of both clocks, returning a new clock.  the merged
+// clock is After (or Equal to) both.
+func (vc VectorClock) Merge(other VectorClock) VectorClock {
+	c := vc.Copy()
+	for node, v := range other {
+		if v > c[node] {
+			c[node] = v
+		}
+	}
+	return c
+}
+
+// Compare vc to other.  Before means vc happened-before other.  missing entries are zero.
+func (vc VectorClock) Compare(other VectorClock) Ordering {
+	var less, more bool
+	for node, v := range vc {
+		if o := other[node]; v < o {
+			less = true
+		} else if v > o {
+			more = true
+		}
+	}
+	for node, o := range other {
+		if _, ok := vc[node]; !ok && o > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && more:
+		return Concurrent
+	case less:
+		return Before
+	case more:
+		return After
+	}
+	return Equal
+}
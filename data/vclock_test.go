@@ -0,0 +1,178 @@
+package data_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+func TestVectorClock(t *testing.T) {
+	t.Run("Compare", func(t *testing.T) {
+		table := []struct {
+			name     string
+			a, b     data.VectorClock
+			expected data.Ordering
+		}{
+			{"empty clocks are equal", nil, data.VectorClock{}, data.Equal},
+			{"zero entries are missing entries", data.VectorClock{"a": 0}, nil, data.Equal},
+			{"same clocks are equal", data.VectorClock{"a": 1, "b": 2}, data.VectorClock{"a": 1, "b": 2}, data.Equal},
+			{"one entry behind", data.VectorClock{"a": 1}, data.VectorClock{"a": 2}, data.Before},
+			{"missing node is behind", data.VectorClock{"a": 1}, data.VectorClock{"a": 1, "b": 1}, data.Before},
+			{"ahead", data.VectorClock{"a": 2, "b": 1}, data.VectorClock{"a": 1}, data.After},
+			{"each ahead on one node", data.VectorClock{"a": 2, "b": 1}, data.VectorClock{"a": 1, "b": 2}, data.Concurrent},
+			{"disjoint nodes", data.VectorClock{"a": 1}, data.VectorClock{"b": 1}, data.Concurrent},
+		}
+		for _, tt := range table {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				if got := tt.a.Compare(tt.b); got != tt.expected {
+					t.Fatalf("%v vs %v: expected %s got %s", tt.a, tt.b, tt.expected, got)
+				}
+			})
+		}
+	})
+	t.Run("Increment and merge don't change the original", func(t *testing.T) {
+		a := data.VectorClock{"a": 1}
+		b := a.Increment("b")
+		if a.Compare(b) != data.Before || len(a) != 1 {
+			t.Fatalf("increment changed the original %v or isn't after it %v", a, b)
+		}
+		m := a.Increment("a").Merge(b)
+		if m["a"] != 2 || m["b"] != 1 {
+			t.Fatalf("expected pairwise max, got %v", m)
+		}
+		if m.Compare(a) != data.After || m.Compare(b) != data.After {
+			t.Fatalf("merge should be after both inputs %v", m)
+		}
+	})
+}
+
+func clocked(seq uint64, action string, clock data.VectorClock) *proto.CustomerEventLog {
+	return &proto.CustomerEventLog{SequenceId: seq, Timestamp: clock.Timestamp(), Action: &proto.Action{Action: action}}
+}
+
+func TestClockedWrites(t *testing.T) {
+	t.Run("Append stamps the node's entry", func(t *testing.T) {
+		ds := data.New(t.TempDir())
+		defer ds.Close()
+		ds.NodeID = "a"
+		s := data.CustomerStream("1")
+
+		el := clocked(0, "first", nil)
+		if err := ds.Append(s, el); err != nil {
+			t.Fatal(err)
+		}
+		if c := data.ClockFrom(el.Timestamp); c.Compare(data.VectorClock{"a": 1}) != data.Equal {
+			t.Fatalf("expected a:1 got %v", c)
+		}
+		// no clock from the client is the same as having seen the current state
+		if err := ds.Append(s, clocked(1, "second", nil)); err != nil {
+			t.Fatal(err)
+		}
+		agg, err := ds.GetState(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := agg.VectorClock(); c.Compare(data.VectorClock{"a": 2}) != data.Equal {
+			t.Fatalf("expected a:2 got %v", c)
+		}
+	})
+	t.Run("Append rejects clocks the stream has moved past", func(t *testing.T) {
+		ds := data.New(t.TempDir())
+		defer ds.Close()
+		ds.NodeID = "a"
+		s := data.CustomerStream("1")
+		ds.Append(s, clocked(0, "first", nil))
+		ds.Append(s, clocked(1, "second", nil))
+
+		if err := ds.Append(s, clocked(2, "stale", data.VectorClock{"a": 1})); err != data.StaleClockError {
+			t.Fatalf("expected %s got %v", data.StaleClockError, err)
+		}
+		if err := ds.Append(s, clocked(2, "concurrent", data.VectorClock{"a": 1, "b": 1})); err != data.ConflictError {
+			t.Fatalf("expected %s got %v", data.ConflictError, err)
+		}
+		if err := ds.Append(s, clocked(2, "read the latest", data.VectorClock{"a": 2})); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Replicate merges, and concurrent logs are conflicts", func(t *testing.T) {
+		ds := data.New(t.TempDir())
+		defer ds.Close()
+		ds.NodeID = "b"
+		s := data.CustomerStream("1")
+
+		first := clocked(0, "from a", data.VectorClock{"a": 1})
+		if err := ds.Replicate(s, first); err != nil {
+			t.Fatal(err)
+		}
+		// redelivery is fine
+		if err := ds.Replicate(s, clocked(0, "from a", data.VectorClock{"a": 1})); err != nil {
+			t.Fatalf("duplicate delivery should be a no-op, got %s", err)
+		}
+		// c wrote sequence 0 without seeing a's write
+		if err := ds.Replicate(s, clocked(0, "from c", data.VectorClock{"c": 1})); err != data.ConflictError {
+			t.Fatalf("expected %s got %v", data.ConflictError, err)
+		}
+		// c wrote sequence 1 on top of something a never saw
+		if err := ds.Replicate(s, clocked(1, "from c", data.VectorClock{"c": 2})); err != data.ConflictError {
+			t.Fatalf("expected %s got %v", data.ConflictError, err)
+		}
+		if err := ds.Replicate(s, clocked(1, "from a", data.VectorClock{"a": 2})); err != nil {
+			t.Fatal(err)
+		}
+		cs, err := ds.GetCustomerState(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cs.LastAction != "from a" || cs.Clock.Compare(data.VectorClock{"a": 2}) != data.Equal {
+			t.Fatalf("unexpected state %+v", cs)
+		}
+	})
+}
+
+func TestCustomerApplyValidation(t *testing.T) {
+	cs := new(data.CustomerState)
+	if err := cs.Apply(clocked(0, "first", data.VectorClock{"a": 1})); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt *data.CorruptStreamError
+	if err := cs.Apply(clocked(2, "gap", data.VectorClock{"a": 2})); !errors.As(err, &corrupt) || corrupt.Sequence != 2 || corrupt.Err != data.InvalidSequenceError {
+		t.Fatalf("expected a corrupt stream at 2 for %s got %v", data.InvalidSequenceError, err)
+	}
+	if err := cs.Apply(clocked(1, "concurrent", data.VectorClock{"b": 1})); !errors.Is(err, data.ConflictError) {
+		t.Fatalf("expected %s got %v", data.ConflictError, err)
+	}
+	if cs.LastAction != "first" {
+		t.Fatalf("a rejected log was applied: %+v", cs)
+	}
+	// logs from before vector clocks don't have one; can't check them
+	if err := cs.Apply(&proto.CustomerEventLog{SequenceId: 1, Action: &proto.Action{Action: "legacy"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayCorruptStream(t *testing.T) {
+	ds := data.New(t.TempDir())
+	defer ds.Close()
+	s := data.CustomerStream("1")
+	if err := ds.Append(s, &proto.CustomerEventLog{SequenceId: 0, Action: &proto.Action{Action: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	// written around the store: a gap
+	v, err := protobuf.Marshal(&proto.CustomerEventLog{SequenceId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.LogDB.Update(func(txn *badger.Txn) error { return txn.Set(data.LogKey(s, 2), v) }); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ds.GetState(s)
+	var corrupt *data.CorruptStreamError
+	if !errors.As(err, &corrupt) || corrupt.Stream != s || corrupt.Sequence != 2 || !errors.Is(err, data.InvalidSequenceError) {
+		t.Errorf("expected %s corrupt at 2, got %v", s, err)
+	}
+}
@@ -0,0 +1,330 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The event streams, server sent events and websockets, are the same underneath:
+//
+//   - where they start is a cursor: the sequenceId of the first log wanted.  Server
+//     sent events that reconnect send Last-Event-ID, the last one they got, so they
+//     pick up after it.  Otherwise ?from=N, or with neither only the logs written
+//     from now on.  The X-Stream-From response header says where it started
+//   - with nothing to send, there's a heartbeat every so often, so proxies (and
+//     load balancers) don't decide it's dead
+//   - each connection has a buffer of SendBuffer logs.  A client that can't keep up
+//     and lets it fill is cut off, rather than have the gateway hold on to its logs
+//     forever: it can reconnect from where it got to
+
+// StreamFromResponseHeader is where the event streams say where they started
+const StreamFromResponseHeader = "X-Stream-From"
+
+// errSlowConsumer is why a feed stopped when its buffer filled up
+var errSlowConsumer = errors.New("the client isn't keeping up")
+
+type connKey struct{}
+
+// ConnContext is for http.Server's ConnContext: with it, a server sent events client
+// that's too slow is cut off straight away, even when the gateway's stuck writing to
+// it.  Without it, the stream stops but the connection stays until the write does
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// feed is the grpc stream behind an event stream
+type feed struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	stream proto.ProtoStuff_StreamEventLogClient
+	// from is where it started, if the node said
+	from string
+	// first log, if it took one to find out the stream wasn't turned away
+	first *proto.CustomerEventLog
+}
+
+// cursor is the sequenceId the request wants to start at, if it says
+func cursor(r *http.Request) (*uint64, error) {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		last, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Last-Event-ID isn't a sequenceId: %q", id)
+		}
+		last++
+		return &last, nil
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		from, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("from isn't a sequenceId: %q", v)
+		}
+		return &from, nil
+	}
+	return nil, nil
+}
+
+// follow starts StreamEventLog for the request.  If the stream's turned away (or the
+// request's wrong) that's replied to here, and there's no feed
+func (g *Gateway) follow(w http.ResponseWriter, r *http.Request, params map[string]string) *feed {
+	from, err := cursor(r)
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, status.New(codes.InvalidArgument, err.Error()))
+		return nil
+	}
+	in := customer(params["id"])
+	in.From = from
+
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-g.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	f := &feed{ctx: ctx, cancel: cancel}
+	if f.stream, err = g.stuff.StreamEventLog(ctx, in); err != nil {
+		cancel()
+		g.reply(w, r, nil, nil, err)
+		return nil
+	}
+	// nodes say where they start before anything else, so that's it accepted.  A
+	// stream that's turned away has no headers of its own, only the status's
+	header, err := f.stream.Header()
+	if v := header.Get(service.StreamFromHeader); err == nil && len(v) > 0 {
+		f.from = v[0]
+		return f
+	}
+	if f.first, err = f.stream.Recv(); err != nil {
+		cancel()
+		if err != io.EOF {
+			// the headers (retry-after, say) came with the status
+			g.reply(w, r, nil, metadata.Join(header, f.stream.Trailer()), err)
+		}
+		return nil
+	}
+	return f
+}
+
+// sink is where a feed's logs go: server sent events, or a websocket.  Only run calls
+// these
+type sink interface {
+	log(el *proto.CustomerEventLog) error
+	heartbeat() error
+	// fail with the stream's status: it's the last thing sent
+	fail(st *status.Status)
+}
+
+// run the feed into s until the stream ends, the client goes or the gateway closes.
+// drop cuts the client off, from another goroutine, when it's too slow
+func (g *Gateway) run(f *feed, s sink, drop func()) {
+	defer f.cancel()
+	logs := make(chan *proto.CustomerEventLog, g.sendBuffer)
+	var err error // why logs was closed
+	go func() {
+		defer close(logs)
+		for {
+			el, rerr := f.stream.Recv()
+			if rerr != nil {
+				err = rerr
+				return
+			}
+			select {
+			case logs <- el:
+			default:
+				err = errSlowConsumer
+				logging.FromContext(f.ctx, g.log).Info("cutting off a slow event stream", zap.Uint64("sequence", el.GetSequenceId()))
+				f.cancel()
+				drop()
+				return
+			}
+		}
+	}()
+
+	if f.first != nil {
+		if s.log(f.first) != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(g.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-heartbeat.C:
+			if s.heartbeat() != nil {
+				return
+			}
+		case el, ok := <-logs:
+			if !ok {
+				if err != errSlowConsumer && err != io.EOF && f.ctx.Err() == nil {
+					s.fail(status.Convert(err))
+				}
+				return
+			}
+			if s.log(el) != nil {
+				return
+			}
+			heartbeat.Reset(g.heartbeat)
+		}
+	}
+}
+
+// events are server sent events: each log is an event with its sequenceId for an id,
+// and heartbeats are comments
+type events struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (e events) log(el *proto.CustomerEventLog) error {
+	b, err := marshal.Marshal(el)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(e.w, "id: %d\nevent: log\ndata: %s\n\n", el.GetSequenceId(), b); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func (e events) heartbeat() error {
+	if _, err := io.WriteString(e.w, ": heartbeat\n\n"); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func (e events) fail(st *status.Status) {
+	b, _ := marshal.Marshal(st.Proto())
+	fmt.Fprintf(e.w, "event: error\ndata: %s\n\n", b)
+	e.flusher.Flush()
+}
+
+// streamEventLog is StreamEventLog as server sent events.  If the stream is turned
+// away, that's an ordinary error reply.  If it fails part way, the last event is an
+// error, with the status
+func (g *Gateway) streamEventLog(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeStatus(w, http.StatusInternalServerError, status.New(codes.Internal, "can't stream on this connection"))
+		return
+	}
+	f := g.follow(w, r, params)
+	if f == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// or nginx sits on the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	if f.from != "" {
+		w.Header().Set(StreamFromResponseHeader, f.from)
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	g.run(f, events{w: w, flusher: flusher}, func() {
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			c.Close()
+		}
+	})
+}
+
+// frame is a websocket message: a log, a heartbeat or an error.  The id is the log's
+// sequenceId, a string like protojson has it
+type frame struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"`
+	Log   json.RawMessage `json:"log,omitempty"`
+	Error json.RawMessage `json:"error,omitempty"`
+}
+
+// socket is a websocket's sink
+type socket struct {
+	conn *websocket.Conn
+}
+
+func (s socket) log(el *proto.CustomerEventLog) error {
+	b, err := marshal.Marshal(el)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteJSON(frame{Type: "log", ID: strconv.FormatUint(el.GetSequenceId(), 10), Log: b})
+}
+
+func (s socket) heartbeat() error {
+	return s.conn.WriteJSON(frame{Type: "heartbeat"})
+}
+
+func (s socket) fail(st *status.Status) {
+	b, _ := marshal.Marshal(st.Proto())
+	s.conn.WriteJSON(frame{Type: "error", Error: b})
+}
+
+// upgrader for the websockets.  Its origin check stays: a page from somewhere else
+// doesn't get to read customers' logs with the user's cookies
+var upgrader = websocket.Upgrader{}
+
+// websocketEventLog is StreamEventLog over a websocket, with a json message for each
+// log.  If the stream is turned away, it's an ordinary error reply instead of the
+// upgrade.  Anything the client sends is ignored, apart from closing
+func (g *Gateway) websocketEventLog(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		writeStatus(w, http.StatusBadRequest, status.New(codes.InvalidArgument, "this is a websocket"))
+		return
+	}
+	f := g.follow(w, r, params)
+	if f == nil {
+		return
+	}
+	header := http.Header{}
+	if f.from != "" {
+		header.Set(StreamFromResponseHeader, f.from)
+	}
+	conn, err := upgrader.Upgrade(w, r, header)
+	if err != nil {
+		// it's replied to already
+		f.cancel()
+		return
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				f.cancel()
+				return
+			}
+		}
+	}()
+
+	closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	g.run(f, socket{conn: conn}, func() {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+		conn.Close()
+	})
+	select {
+	case <-g.done:
+		closing = websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+	default:
+	}
+	conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+}
@@ -0,0 +1,238 @@
+// Package gateway is the apis over http, with json bodies, for the teams that don't
+// speak grpc:
+//
+//	GET  /customers/{id}/state      CustomerState
+//	POST /customers/{id}/events     WriteLog; the body is a CustomerEventLog
+//	GET  /customers/{id}/events     StreamEventLog, as server sent events
+//	GET  /customers/{id}/events/ws  StreamEventLog, over a websocket
+//	GET  /cluster/members           MembershipList
+//	GET  /openapi.json              the OpenAPI document for all of the above
+//
+// It calls a node's grpc server like any other client would, so auth, rate limits and
+// load shedding are the same either way: the authorization, x-priority, x-request-id
+// and trace headers are passed on.  Bodies are protojson: 64 bit numbers are strings,
+// bytes are base64.  Errors are the grpc status (google.rpc.Status), with the http
+// code grpc-gateway would give it.
+package gateway
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// MaxBodyBytes is the biggest request body taken
+const MaxBodyBytes = 1 << 20
+
+// forwardHeaders go from the http request to the grpc metadata, as they are
+var forwardHeaders = []string{"authorization", "x-priority", logging.RequestIDHeader, "traceparent", "tracestate"}
+
+var (
+	marshal   = protojson.MarshalOptions{EmitUnpopulated: true}
+	unmarshal = protojson.UnmarshalOptions{}
+)
+
+// Config for a gateway
+type Config struct {
+	// Conn to the node's grpc server
+	Conn grpc.ClientConnInterface
+	// Logger nil is zap's global logger
+	Logger *zap.Logger
+	// Heartbeat is how often an event stream with nothing to say says so, so
+	// proxies don't time it out.  0 is DefaultHeartbeat
+	Heartbeat time.Duration
+	// SendBuffer is how many logs an event stream can be behind by before it's cut
+	// off.  0 is DefaultSendBuffer
+	SendBuffer int
+}
+
+const (
+	DefaultHeartbeat  = 15 * time.Second
+	DefaultSendBuffer = 64
+)
+
+// Gateway is an http.Handler
+type Gateway struct {
+	stuff   proto.ProtoStuffClient
+	members proto.ClusterManagmentClient
+	log     *zap.Logger
+
+	heartbeat  time.Duration
+	sendBuffer int
+
+	// done ends the event streams: they'd hold a graceful shutdown up forever
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New gateway to c's node
+func New(c Config) *Gateway {
+	if c.Heartbeat <= 0 {
+		c.Heartbeat = DefaultHeartbeat
+	}
+	if c.SendBuffer <= 0 {
+		c.SendBuffer = DefaultSendBuffer
+	}
+	return &Gateway{
+		stuff:      proto.NewProtoStuffClient(c.Conn),
+		members:    proto.NewClusterManagmentClient(c.Conn),
+		log:        logging.Or(c.Logger).Named("gateway"),
+		heartbeat:  c.Heartbeat,
+		sendBuffer: c.SendBuffer,
+		done:       make(chan struct{}),
+	}
+}
+
+// Close ends the event streams.  Calls that are in flight finish as usual
+func (g *Gateway) Close() {
+	g.closeOnce.Do(func() { close(g.done) })
+}
+
+// ServeHTTP finds the route for the request, and calls it
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/openapi.json" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI)
+		return
+	}
+	var allowed []string
+	for _, rt := range routes {
+		params, ok := rt.match(r.URL.EscapedPath())
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		rt.handle(g, w, r.WithContext(outgoing(r)), params)
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, r.Method+" isn't allowed here"))
+		return
+	}
+	writeStatus(w, http.StatusNotFound, status.Newf(codes.NotFound, "no such path: %s", r.URL.Path))
+}
+
+// outgoing is the request's context with the headers grpc needs in its metadata
+func outgoing(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, h := range forwardHeaders {
+		if v := r.Header.Values(h); len(v) > 0 {
+			md.Append(h, v...)
+		}
+	}
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// customer in the path: a numeric id, or else a key.  Numeric ids are the same as
+// their decimal key, so either way "42" is customer 42
+func customer(id string) *proto.Customer {
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil && strconv.FormatUint(n, 10) == id {
+		return &proto.Customer{Id: n}
+	}
+	return &proto.Customer{Key: []byte(id)}
+}
+
+func (g *Gateway) customerState(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var header metadata.MD
+	out, err := g.stuff.CustomerState(r.Context(), customer(params["id"]), grpc.Header(&header))
+	g.reply(w, r, out, header, err)
+}
+
+func (g *Gateway) writeLog(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	if err != nil {
+		writeStatus(w, http.StatusRequestEntityTooLarge, status.Newf(codes.InvalidArgument, "reading the body: %s", err))
+		return
+	}
+	log := new(proto.CustomerEventLog)
+	if err := unmarshal.Unmarshal(body, log); err != nil {
+		writeStatus(w, http.StatusBadRequest, status.Newf(codes.InvalidArgument, "body isn't a CustomerEventLog: %s", err))
+		return
+	}
+	c := customer(params["id"])
+	var header metadata.MD
+	out, err := g.stuff.WriteLog(r.Context(), &proto.NewCustomerLog{CustomerID: c.Id, CustomerKey: c.Key, Log: log}, grpc.Header(&header))
+	g.reply(w, r, out, header, err)
+}
+
+func (g *Gateway) membershipList(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	var header metadata.MD
+	out, err := g.members.MembershipList(r.Context(), &emptypb.Empty{}, grpc.Header(&header))
+	g.reply(w, r, out, header, err)
+}
+
+// reply with out as json, or the error's status
+func (g *Gateway) reply(w http.ResponseWriter, r *http.Request, out protobuf.Message, header metadata.MD, err error) {
+	// rate limits say when to come back
+	if v := header.Get("retry-after"); len(v) > 0 {
+		w.Header().Set("Retry-After", v[0])
+	}
+	if err != nil {
+		st := status.Convert(err)
+		logging.FromContext(r.Context(), g.log).Debug("call failed", zap.String("path", r.URL.Path), zap.Error(err))
+		writeStatus(w, HTTPStatus(st.Code()), st)
+		return
+	}
+	b, err := marshal.Marshal(out)
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError, status.Newf(codes.Internal, "can't marshal the reply: %s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func writeStatus(w http.ResponseWriter, code int, st *status.Status) {
+	b, _ := marshal.Marshal(st.Proto())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// HTTPStatus for a grpc code; the same as grpc-gateway's
+func HTTPStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return http.StatusRequestTimeout
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
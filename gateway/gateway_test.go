@@ -0,0 +1,377 @@
+package gateway_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yarbelk/distributedservice/gateway"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// stuff is a ProtoStuff with customer 42 in it, and a few more to stream
+type stuff struct {
+	proto.UnimplementedProtoStuffServer
+	written chan *proto.NewCustomerLog
+	auth    chan string
+	cut     chan uint64
+}
+
+func (s *stuff) CustomerState(ctx context.Context, in *proto.Customer) (*proto.CustomerState, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.auth <- strings.Join(md.Get("authorization"), "")
+	if in.GetId() != 42 {
+		return nil, status.Errorf(codes.NotFound, "no customer %d %q", in.GetId(), in.GetKey())
+	}
+	return &proto.CustomerState{Id: 42, LastAction: "signed up", CurrentSequence: 3}, nil
+}
+
+func (s *stuff) WriteLog(ctx context.Context, in *proto.NewCustomerLog) (*proto.ErrorDetails, error) {
+	if in.GetLog().GetAction().GetAction() == "again" {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", "3"))
+		return nil, status.Errorf(codes.ResourceExhausted, "slow down")
+	}
+	s.written <- in
+	return &proto.ErrorDetails{}, nil
+}
+
+// StreamEventLog: 42 has logs 1 and 2 then goes away, 43 has nothing, and 44 has
+// far too many
+func (s *stuff) StreamEventLog(in *proto.Customer, stream proto.ProtoStuff_StreamEventLogServer) error {
+	switch in.GetId() {
+	case 42, 43, 44:
+	case 9:
+		stream.SetHeader(metadata.Pairs("retry-after", "2"))
+		return status.Errorf(codes.ResourceExhausted, "slow down")
+	default:
+		return status.Errorf(codes.PermissionDenied, "not yours")
+	}
+	from := uint64(1)
+	if in.From != nil {
+		from = in.GetFrom()
+	}
+	if err := stream.SendHeader(metadata.Pairs(service.StreamFromHeader, fmt.Sprint(from))); err != nil {
+		return err
+	}
+	switch in.GetId() {
+	case 43:
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	case 44:
+		big := strings.Repeat("x", 1024)
+		for i := from; ; i++ {
+			if err := stream.Send(&proto.CustomerEventLog{SequenceId: i, Action: &proto.Action{Action: big}}); err != nil {
+				s.cut <- i
+				return err
+			}
+		}
+	}
+	for i := from; i <= 2; i++ {
+		if err := stream.Send(&proto.CustomerEventLog{SequenceId: i, Action: &proto.Action{Action: "bought"}}); err != nil {
+			return err
+		}
+	}
+	return status.Errorf(codes.Unavailable, "going away")
+}
+
+type members struct {
+	proto.UnimplementedClusterManagmentServer
+}
+
+func (members) MembershipList(context.Context, *emptypb.Empty) (*proto.Membership, error) {
+	return &proto.Membership{Memberlist: []*proto.Member{{Name: "a", Address: "127.0.0.1", Port: "7946"}}}, nil
+}
+
+func serve(t *testing.T, s *stuff, c gateway.Config) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	proto.RegisterProtoStuffServer(srv, s)
+	proto.RegisterClusterManagmentServer(srv, members{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c.Conn = conn
+	g := gateway.New(c)
+	h := httptest.NewUnstartedServer(g)
+	h.Config.ConnContext = gateway.ConnContext
+	h.Start()
+	t.Cleanup(h.Close)
+	t.Cleanup(g.Close)
+	return h.URL
+}
+
+func do(t *testing.T, method, url, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestGateway(t *testing.T) {
+	s := &stuff{written: make(chan *proto.NewCustomerLog, 1), auth: make(chan string, 10)}
+	url := serve(t, s, gateway.Config{})
+
+	for _, test := range []struct {
+		name, method, path, body string
+		code                     int
+		contains                 string
+	}{
+		{"state", "GET", "/customers/42/state", "", 200, `"LastAction":"signed up"`},
+		{"64 bit numbers are strings", "GET", "/customers/42/state", "", 200, `"currentSequence":"3"`},
+		{"not found", "GET", "/customers/7/state", "", 404, `"code":5`},
+		{"by key", "GET", "/customers/a%2Fb/state", "", 404, `\"a/b\"`},
+		{"members", "GET", "/cluster/members", "", 200, `"Name":"a"`},
+		{"write", "POST", "/customers/42/events", `{"sequenceId": "4", "action": {"action": "bought"}}`, 200, `"failed":false`},
+		{"bad body", "POST", "/customers/42/events", `{"sequence": 4}`, 400, `CustomerEventLog`},
+		{"rate limited", "POST", "/customers/42/events", `{"action": {"action": "again"}}`, 429, `slow down`},
+		{"wrong method", "DELETE", "/customers/42/events", "", 405, ``},
+		{"no such path", "GET", "/customers/42", "", 404, ``},
+		{"openapi", "GET", "/openapi.json", "", 200, `"/customers/{id}/state"`},
+	} {
+		resp, body := do(t, test.method, url+test.path, test.body, "Authorization", "Bearer xyz")
+		if resp.StatusCode != test.code {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.code, resp.StatusCode, body)
+		}
+		if compact := strings.ReplaceAll(body, " ", ""); !strings.Contains(compact, strings.ReplaceAll(test.contains, " ", "")) {
+			t.Errorf("%s: expected %s in %s", test.name, test.contains, body)
+		}
+		if test.name == "rate limited" && resp.Header.Get("Retry-After") != "3" {
+			t.Errorf("rate limited: expected Retry-After 3, got %q", resp.Header.Get("Retry-After"))
+		}
+		if test.name == "wrong method" && resp.Header.Get("Allow") != "POST, GET" {
+			t.Errorf("wrong method: expected Allow POST, GET, got %q", resp.Header.Get("Allow"))
+		}
+	}
+
+	if auth := <-s.auth; auth != "Bearer xyz" {
+		t.Errorf("expected the token to be passed on, got %q", auth)
+	}
+	written := <-s.written
+	if written.GetCustomerID() != 42 || written.GetLog().GetSequenceId() != 4 || written.GetLog().GetAction().GetAction() != "bought" {
+		t.Errorf("wrote the wrong thing: %v", written)
+	}
+}
+
+// event is a server sent event
+type event struct{ id, event, data string }
+
+// readEvents from body until it ends; comments are events with only data
+func readEvents(body io.Reader) []event {
+	var events []event
+	var e event
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			events = append(events, e)
+			e = event{}
+		case strings.HasPrefix(line, ": "):
+			e.data = line
+		case strings.HasPrefix(line, "id: "):
+			e.id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			e.event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			e.data = line[6:]
+		}
+	}
+	return events
+}
+
+// expectLogs are events, then the going away error
+func expectLogs(t *testing.T, events []event, ids ...string) {
+	t.Helper()
+	if len(events) != len(ids)+1 {
+		t.Fatalf("expected logs %v and an error, got %v", ids, events)
+	}
+	for i, id := range ids {
+		var log map[string]interface{}
+		if err := json.Unmarshal([]byte(events[i].data), &log); err != nil {
+			t.Fatal(err)
+		}
+		if events[i].id != id || events[i].event != "log" || log["sequenceId"] != id {
+			t.Errorf("expected log %s, got %v", id, events[i])
+		}
+	}
+	if last := events[len(ids)]; last.event != "error" || !strings.Contains(last.data, "going away") {
+		t.Errorf("expected the error last, got %v", last)
+	}
+}
+
+func TestGatewayEvents(t *testing.T) {
+	url := serve(t, &stuff{}, gateway.Config{})
+
+	resp, body := do(t, "GET", url+"/customers/7/events", "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a stream that's turned away to be 403, got %d: %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, "GET", url+"/customers/9/events", "")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("expected a rate limited stream to be 429 with Retry-After 2, got %d %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+
+	resp, body = do(t, "GET", url+"/customers/42/events?from=x", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a bad cursor to be 400, got %d: %s", resp.StatusCode, body)
+	}
+
+	for _, test := range []struct {
+		name, query, lastEventID, from string
+		ids                            []string
+	}{
+		{"from the start", "", "", "1", []string{"1", "2"}},
+		{"from", "?from=2", "", "2", []string{"2"}},
+		{"reconnected", "", "1", "2", []string{"2"}},
+		{"Last-Event-ID wins", "?from=1", "2", "3", nil},
+	} {
+		resp, body := do(t, "GET", url+"/customers/42/events"+test.query, "", "Last-Event-ID", test.lastEventID)
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("%s: expected text/event-stream, got %q", test.name, ct)
+		}
+		if from := resp.Header.Get(gateway.StreamFromResponseHeader); from != test.from {
+			t.Errorf("%s: expected it to start at %s, got %q", test.name, test.from, from)
+		}
+		expectLogs(t, readEvents(strings.NewReader(body)), test.ids...)
+	}
+}
+
+func TestGatewayEventsHeartbeat(t *testing.T) {
+	url := serve(t, &stuff{}, gateway.Config{Heartbeat: 20 * time.Millisecond})
+
+	resp, err := http.Get(url + "/customers/43/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for heartbeats := 0; heartbeats < 2 && scanner.Scan(); {
+		if scanner.Text() == ": heartbeat" {
+			heartbeats++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := wsURL(url + "/customers/43/events/ws")
+	conn, _, err := websocket.DefaultDialer.Dial(ws, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var f frame
+	if err := conn.ReadJSON(&f); err != nil || f.Type != "heartbeat" {
+		t.Errorf("expected a heartbeat, got %v %v", f, err)
+	}
+}
+
+func TestGatewayEventsSlowConsumer(t *testing.T) {
+	s := &stuff{cut: make(chan uint64, 1)}
+	url := serve(t, s, gateway.Config{SendBuffer: 4})
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url + "/customers/44/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// not reading, so it falls behind
+	select {
+	case <-s.cut:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the stream to be cut off")
+	}
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err == nil {
+		t.Error("expected the connection to be dropped, not ended")
+	}
+}
+
+// frame is a websocket message
+type frame struct {
+	Type  string
+	ID    string
+	Log   map[string]interface{}
+	Error map[string]interface{}
+}
+
+func wsURL(url string) string {
+	return "ws" + strings.TrimPrefix(url, "http")
+}
+
+func TestGatewayWebsocket(t *testing.T) {
+	url := serve(t, &stuff{}, gateway.Config{})
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(url+"/customers/7/events/ws"), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a stream that's turned away to be 403, got %v", err)
+	}
+	if resp, _ := do(t, "GET", url+"/customers/42/events/ws", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a plain get to be 400, got %d", resp.StatusCode)
+	}
+
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL(url+"/customers/42/events/ws?from=2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if from := resp.Header.Get(gateway.StreamFromResponseHeader); from != "2" {
+		t.Errorf("expected it to start at 2, got %q", from)
+	}
+	var f frame
+	if err := conn.ReadJSON(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != "log" || f.ID != "2" || f.Log["sequenceId"] != "2" {
+		t.Errorf("expected log 2, got %v", f)
+	}
+	f = frame{}
+	if err := conn.ReadJSON(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != "error" || f.Error["message"] != "going away" {
+		t.Errorf("expected the error, got %v", f)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expected it to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"encoding/json"
+	"strings"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// openAPI is the document for the routes, made from the protos when the package is
+// loaded: it can't get out of step with them
+var openAPI = func() []byte {
+	b, err := json.MarshalIndent(OpenAPI(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// schemaRef is a reference to a message's schema in the document's components
+func schemaRef(m protoreflect.MessageDescriptor) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + string(m.FullName())}
+}
+
+// schemas of messages, as protojson has them
+type schemas map[string]interface{}
+
+func (s schemas) message(m protoreflect.MessageDescriptor) map[string]interface{} {
+	switch m.FullName() {
+	case "google.protobuf.Any":
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{"@type": map[string]interface{}{"type": "string"}},
+			"additionalProperties": true,
+		}
+	case "google.protobuf.Timestamp":
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration":
+		return map[string]interface{}{"type": "string", "example": "1.5s"}
+	}
+	name := string(m.FullName())
+	if _, ok := s[name]; !ok {
+		// in before the fields, for messages that have themselves in them
+		s[name] = nil
+		properties := map[string]interface{}{}
+		fields := m.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			properties[fields.Get(i).JSONName()] = s.field(fields.Get(i))
+		}
+		s[name] = map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return schemaRef(m)
+}
+
+func (s schemas) field(f protoreflect.FieldDescriptor) map[string]interface{} {
+	if f.IsMap() {
+		return map[string]interface{}{"type": "object", "additionalProperties": s.value(f.MapValue())}
+	}
+	if f.IsList() {
+		return map[string]interface{}{"type": "array", "items": s.value(f)}
+	}
+	return s.value(f)
+}
+
+// value of a field, leaving aside whether it's repeated
+func (s schemas) value(f protoreflect.FieldDescriptor) map[string]interface{} {
+	switch f.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]interface{}{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "uint32", "minimum": 0}
+	// protojson has 64 bit numbers as strings: javascript can't hold them
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case protoreflect.StringKind:
+		return map[string]interface{}{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		var names []string
+		values := f.Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return map[string]interface{}{"type": "string", "enum": names}
+	}
+	return s.message(f.Message())
+}
+
+// fromHeader is the event streams' response headers
+var fromHeader = map[string]interface{}{
+	StreamFromResponseHeader: map[string]interface{}{
+		"description": "the sequenceId the stream starts at",
+		"schema":      map[string]interface{}{"type": "string", "format": "uint64"},
+	},
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// OpenAPI (3.0) document for the gateway.  It's served at /openapi.json
+func OpenAPI() map[string]interface{} {
+	s := schemas{}
+	errorRef := s.message((&spb.Status{}).ProtoReflect().Descriptor())
+	paths := map[string]interface{}{}
+	for _, rt := range routes {
+		op := map[string]interface{}{
+			"operationId": string(rt.rpc.Parent().Name()) + "_" + string(rt.rpc.Name()),
+			"summary":     rt.summary,
+			"tags":        []string{string(rt.rpc.Parent().FullName())},
+		}
+		var params []interface{}
+		for _, p := range rt.parameters() {
+			params = append(params, map[string]interface{}{
+				"name":        p,
+				"in":          "path",
+				"required":    true,
+				"description": "customer id, or key: a numeric id is the same customer as its decimal key",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]interface{}{"required": true, "content": jsonContent(s.message(rt.body))}
+		}
+		okCode, ok := "200", map[string]interface{}{"description": string(rt.rpc.Output().Name())}
+		if rt.rpc.IsStreamingServer() {
+			params = append(params, map[string]interface{}{
+				"name":        "from",
+				"in":          "query",
+				"description": "the sequenceId to start at; without it, only logs written from now on",
+				"schema":      map[string]interface{}{"type": "string", "format": "uint64"},
+			})
+		}
+		switch {
+		case rt.websocket:
+			okCode = "101"
+			ok["description"] = "a websocket: each message is json, {\"type\": \"log\", \"id\": sequenceId, \"log\": " + string(rt.rpc.Output().Name()) +
+				"}, {\"type\": \"heartbeat\"}, or {\"type\": \"error\", \"error\": status} if the stream fails"
+			ok["headers"] = fromHeader
+			s.message(rt.rpc.Output())
+		case rt.rpc.IsStreamingServer():
+			params = append(params, map[string]interface{}{
+				"name":        "Last-Event-ID",
+				"in":          "header",
+				"description": "the last sequenceId the client got: it starts after it.  It wins over from",
+				"schema":      map[string]interface{}{"type": "string", "format": "uint64"},
+			})
+			ok["headers"] = fromHeader
+			ok["description"] = "server sent events: each log event's data is a " + string(rt.rpc.Output().Name()) +
+				", with its sequenceId for an id.  Heartbeats are comments.  If the stream fails, an error event has the status"
+			ok["content"] = map[string]interface{}{"text/event-stream": map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string"},
+			}}
+			// the events' schema is still worth having
+			s.message(rt.rpc.Output())
+		default:
+			ok["content"] = jsonContent(s.message(rt.rpc.Output()))
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		op["responses"] = map[string]interface{}{
+			okCode:    ok,
+			"default": map[string]interface{}{"description": "the grpc status", "content": jsonContent(errorRef)},
+		}
+		path, _ := paths[rt.path].(map[string]interface{})
+		if path == nil {
+			path = map[string]interface{}{}
+			paths[rt.path] = path
+		}
+		path[strings.ToLower(rt.method)] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "distributedservice",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}(s),
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		// tokens are only wanted if the nodes have auth
+		"security": []interface{}{map[string]interface{}{}, map[string]interface{}{"bearer": []string{}}},
+	}
+}
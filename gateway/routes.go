@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yarbelk/distributedservice/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// route is a path the gateway serves, and the rpc behind it.  The OpenAPI document
+// is made from these too
+type route struct {
+	method string
+	// path with {name} for parameters: each is one segment
+	path string
+	rpc  protoreflect.MethodDescriptor
+	// body is the message the request body is, if it has one
+	body    protoreflect.MessageDescriptor
+	summary string
+	// websocket routes are upgraded; the rest of the streams are server sent events
+	websocket bool
+	handle    func(g *Gateway, w http.ResponseWriter, r *http.Request, params map[string]string)
+}
+
+func rpc(service protoreflect.ServiceDescriptor, name protoreflect.Name) protoreflect.MethodDescriptor {
+	return service.Methods().ByName(name)
+}
+
+var (
+	protoStuff       = proto.File_stuff_proto.Services().ByName("ProtoStuff")
+	clusterManagment = proto.File_managment_proto.Services().ByName("ClusterManagment")
+
+	routes = []route{
+		{
+			method:  http.MethodGet,
+			path:    "/customers/{id}/state",
+			rpc:     rpc(protoStuff, "CustomerState"),
+			summary: "The customer's state, from its event log",
+			handle:  (*Gateway).customerState,
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/customers/{id}/events",
+			rpc:     rpc(protoStuff, "WriteLog"),
+			body:    (&proto.CustomerEventLog{}).ProtoReflect().Descriptor(),
+			summary: "Append a log to the customer's event log.  It has to go to one of the customer's replicas",
+			handle:  (*Gateway).writeLog,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/customers/{id}/events",
+			rpc:     rpc(protoStuff, "StreamEventLog"),
+			summary: "The customer's logs as they're written, as server sent events",
+			handle:  (*Gateway).streamEventLog,
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/customers/{id}/events/ws",
+			rpc:       rpc(protoStuff, "StreamEventLog"),
+			summary:   "The customer's logs as they're written, over a websocket",
+			websocket: true,
+			handle:    (*Gateway).websocketEventLog,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/cluster/members",
+			rpc:     rpc(clusterManagment, "MembershipList"),
+			summary: "The members of the cluster, as this node sees them",
+			handle:  (*Gateway).membershipList,
+		},
+	}
+)
+
+// match path (escaped, so keys can have slashes) against the route's; the
+// parameters if it does
+func (rt route) match(path string) (map[string]string, bool) {
+	want := strings.Split(strings.Trim(rt.path, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, w := range want {
+		if strings.HasPrefix(w, "{") {
+			v, err := url.PathUnescape(got[i])
+			if err != nil || v == "" {
+				return nil, false
+			}
+			params[strings.Trim(w, "{}")] = v
+			continue
+		}
+		if w != got[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// parameters in the route's path
+func (rt route) parameters() []string {
+	var names []string
+	for _, w := range strings.Split(rt.path, "/") {
+		if strings.HasPrefix(w, "{") {
+			names = append(names, strings.Trim(w, "{}"))
+		}
+	}
+	return names
+}
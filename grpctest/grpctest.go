@@ -0,0 +1,60 @@
+// Package grpctest chains grpc servers together for tests of what goes along with
+// calls between nodes (trace context, request ids): each answers health checks by
+// asking the next
+package grpctest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Hop is one node in the chain: the interceptors it serves with and dials the next
+// with
+type Hop struct {
+	ServerOptions []grpc.ServerOption
+	DialOptions   []grpc.DialOption
+	// OnCheck runs in the node's Check, before it asks the next; nil does nothing
+	OnCheck func(ctx context.Context)
+}
+
+// forwarder answers health checks by asking next (if there is one): a hop between nodes
+type forwarder struct {
+	next    healthpb.HealthClient
+	onCheck func(ctx context.Context)
+	healthpb.UnimplementedHealthServer
+}
+
+func (f forwarder) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if f.onCheck != nil {
+		f.onCheck(ctx)
+	}
+	if f.next == nil {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+	}
+	return f.next.Check(ctx, in)
+}
+
+// Serve the hop, forwarding to next (nil is the end of the chain), and return a
+// client for it.  Both go when the test's done
+func (h Hop) Serve(t testing.TB, next healthpb.HealthClient) healthpb.HealthClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(h.ServerOptions...)
+	healthpb.RegisterHealthServer(srv, forwarder{next: next, onCheck: h.OnCheck})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), append([]grpc.DialOption{grpc.WithInsecure()}, h.DialOptions...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
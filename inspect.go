@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dgraph-io/badger"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/yarbelk/distributedservice/data"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// inspectCommand looks at a stopped node's data directory, without changing it:
+// `inspect -data dir [-type customer]` lists the streams with how many logs and the
+// last sequenceId, and anything wrong with them.  `-check` only lists the broken ones,
+// and fails if there are any; `-customer id` dumps one's logs as json; `-tables` is
+// badger's tables
+func inspectCommand(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	dir := fs.String("data", "", "data directory to look at. The node has to be stopped")
+	aggregate := fs.String("type", data.CustomerAggregate, "aggregate type to look at. empty is every type")
+	customer := fs.String("customer", "", "dump this id's logs as json")
+	check := fs.Bool("check", false, "only list streams with gaps or broken logs, and fail if there are any")
+	tables := fs.Bool("tables", false, "print badger's table statistics")
+	fs.Parse(args)
+	if *dir == "" {
+		return fmt.Errorf("usage: inspect -data dir [-type customer] [-check | -customer id | -tables]")
+	}
+	// badger would make an empty one
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+	store, err := data.OpenReadOnly(*dir)
+	if errors.Is(err, badger.ErrReplayNeeded) {
+		return fmt.Errorf("%w: start and stop the node on it once, or back it up with -data (which replays it) and inspect a restore", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch {
+	case *customer != "":
+		return dumpStream(store, data.StreamID{Type: *aggregate, ID: *customer})
+	case *tables:
+		return printTables(store)
+	}
+	return inspectStreams(store, *aggregate, *check)
+}
+
+// dumpStream prints a stream's logs as a json array, protojson's way.  Zeros are
+// printed too: sequenceId 0 is a sequenceId
+func dumpStream(store *data.BadgerStore, s data.StreamID) error {
+	if s.Type == "" {
+		return fmt.Errorf("-customer needs a -type")
+	}
+	logs, err := store.Logs(s)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("%s has no logs", s)
+	}
+	out := make([]json.RawMessage, 0, len(logs))
+	for _, el := range logs {
+		b, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(protobuf.MessageV2(el))
+		if err != nil {
+			return err
+		}
+		out = append(out, b)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func inspectStreams(store *data.BadgerStore, aggregate string, check bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STREAM\tLOGS\tLAST")
+	var streams, broken int
+	badKeys, err := store.Inspect(func(r data.StreamReport) error {
+		if aggregate != "" && r.Stream.Type != aggregate {
+			return nil
+		}
+		streams++
+		if !r.Healthy() {
+			broken++
+		} else if check {
+			return nil
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", r.Stream, r.Logs, r.Last)
+		for _, g := range r.Gaps {
+			if g.From == g.To {
+				fmt.Fprintf(w, "  missing %d\t\t\n", g.From)
+			} else {
+				fmt.Fprintf(w, "  missing %d-%d\t\t\n", g.From, g.To)
+			}
+		}
+		for _, p := range r.Problems {
+			fmt.Fprintf(w, "  %d: %s\t\t\n", p.Sequence, p.Problem)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, k := range badKeys {
+		fmt.Printf("not a log key: %q\n", k)
+	}
+	fmt.Printf("%d streams, %d with problems, %d bad keys\n", streams, broken, len(badKeys))
+	if check && (broken > 0 || len(badKeys) > 0) {
+		return fmt.Errorf("%d streams with problems, %d bad keys", broken, len(badKeys))
+	}
+	return nil
+}
+
+// printTables is badger's LSM tables, by level, and the sizes on disk
+func printTables(store *data.BadgerStore) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LEVEL\tTABLE\tKEYS\tFIRST\tLAST")
+	levels := map[int]struct{ tables, keys uint64 }{}
+	maxLevel := 0
+	for _, t := range store.LogDB.Tables(true) {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%q\t%q\n", t.Level, t.ID, t.KeyCount, t.Left, t.Right)
+		l := levels[t.Level]
+		l.tables++
+		l.keys += t.KeyCount
+		levels[t.Level] = l
+		if t.Level > maxLevel {
+			maxLevel = t.Level
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for level := 0; level <= maxLevel; level++ {
+		if l, ok := levels[level]; ok {
+			fmt.Printf("level %d: %d tables, %d keys\n", level, l.tables, l.keys)
+		}
+	}
+	lsm, vlog := store.LogDB.Size()
+	fmt.Printf("lsm %d bytes, value log %d bytes\n", lsm, vlog)
+	return nil
+}
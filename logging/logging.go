@@ -0,0 +1,166 @@
+// Package logging is the nodes' structured logging: zap, json by default, with a level
+// that can be changed while running (see the SetLogLevel rpc).  Every rpc gets a
+// request id, which follows it to the other nodes it calls, and a logger with it on.
+//
+// Things take a *zap.Logger; nil is zap's global logger (a no-op unless main has
+// replaced it).
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader is the grpc metadata key the request id travels in
+const RequestIDHeader = "x-request-id"
+
+// New logger writing to stderr at level: json, or console for people
+func New(level zap.AtomicLevel, format string) (*zap.Logger, error) {
+	cfg := zap.NewProductionConfig()
+	cfg.Level = level
+	cfg.Encoding = format
+	cfg.Sampling = nil
+	cfg.EncoderConfig.TimeKey = "time"
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return cfg.Build()
+}
+
+// Or is l, or the global logger if l is nil
+func Or(l *zap.Logger) *zap.Logger {
+	if l == nil {
+		return zap.L()
+	}
+	return l
+}
+
+type loggerKey struct{}
+
+// WithLogger puts l in ctx
+func WithLogger(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext is the rpc's logger (with its request id), or fallback (see Or) outside
+// of an rpc
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return Or(fallback)
+}
+
+type requestIDKey struct{}
+
+// RequestID of the rpc ctx is for; empty outside of one
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequest takes the caller's request id, or makes one up, and puts it and a
+// logger with it on in ctx
+func withRequest(ctx context.Context, l *zap.Logger, method string) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithLogger(ctx, Or(l).With(zap.String("request_id", id), zap.String("method", method)))
+}
+
+// UnaryServerInterceptor gives every rpc a request id and a logger
+func UnaryServerInterceptor(l *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withRequest(ctx, l, info.FullMethod), req)
+	}
+}
+
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s loggedStream) Context() context.Context { return s.ctx }
+
+// StreamServerInterceptor gives every stream a request id and a logger
+func StreamServerInterceptor(l *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, loggedStream{ss, withRequest(ss.Context(), l, info.FullMethod)})
+	}
+}
+
+// outgoing passes the request id on to the next node
+func outgoing(ctx context.Context) context.Context {
+	if id := RequestID(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+	}
+	return ctx
+}
+
+// DialOptions that pass request ids on in calls to other nodes
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(outgoing(ctx), desc, cc, method, opts...)
+		}),
+	}
+}
+
+// StdLogger is a standard library logger onto l, for libraries like memberlist that
+// want one.  Their "[DEBUG]", "[INFO]", "[WARN]" and "[ERR]" prefixes become levels.
+func StdLogger(l *zap.Logger) *log.Logger {
+	return log.New(stdWriter{Or(l)}, "", 0)
+}
+
+type stdWriter struct {
+	l *zap.Logger
+}
+
+var stdLevels = []struct {
+	prefix string
+	level  zapcore.Level
+}{
+	{"[DEBUG] ", zapcore.DebugLevel},
+	{"[INFO] ", zapcore.InfoLevel},
+	{"[WARN] ", zapcore.WarnLevel},
+	{"[ERR] ", zapcore.ErrorLevel},
+	{"[ERROR] ", zapcore.ErrorLevel},
+}
+
+func (w stdWriter) Write(b []byte) (int, error) {
+	msg := bytes.TrimSpace(b)
+	level := zapcore.InfoLevel
+	for _, l := range stdLevels {
+		if bytes.HasPrefix(msg, []byte(l.prefix)) {
+			level = l.level
+			msg = msg[len(l.prefix):]
+			break
+		}
+	}
+	if ce := w.l.Check(level, string(msg)); ce != nil {
+		ce.Write()
+	}
+	return len(b), nil
+}
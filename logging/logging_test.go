@@ -0,0 +1,75 @@
+package logging_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/grpctest"
+	"github.com/yarbelk/distributedservice/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// hop logs on the way through each node
+func hop(l *zap.Logger) grpctest.Hop {
+	return grpctest.Hop{
+		ServerOptions: []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor(l)),
+			grpc.ChainStreamInterceptor(logging.StreamServerInterceptor(l)),
+		},
+		DialOptions: logging.DialOptions(),
+		OnCheck:     func(ctx context.Context) { logging.FromContext(ctx, nil).Info("checked") },
+	}
+}
+
+func TestRequestIDAcrossNodes(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := zap.New(core)
+	b := hop(l.With(zap.String("node", "b"))).Serve(t, nil)
+	a := hop(l.With(zap.String("node", "a"))).Serve(t, b)
+
+	if _, err := a.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("expected a log from each node, got %d", len(entries))
+	}
+	ids := map[string]bool{}
+	for _, e := range entries {
+		f := e.ContextMap()
+		if f["method"] != "/grpc.health.v1.Health/Check" {
+			t.Errorf("method on %s's log is %v", f["node"], f["method"])
+		}
+		id, _ := f["request_id"].(string)
+		if id == "" {
+			t.Errorf("%s's log has no request id", f["node"])
+		}
+		ids[id] = true
+	}
+	if len(ids) != 1 {
+		t.Errorf("expected one request id over both nodes, got %v", ids)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	std := logging.StdLogger(zap.New(core))
+	std.Printf("[DEBUG] memberlist: dropped")
+	std.Printf("[WARN] memberlist: something")
+	std.Printf("no prefix")
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("expected the debug line dropped, got %d entries", len(entries))
+	}
+	if entries[0].Level != zapcore.WarnLevel || entries[0].Message != "memberlist: something" {
+		t.Errorf("unexpected %s %q", entries[0].Level, entries[0].Message)
+	}
+	if entries[1].Level != zapcore.InfoLevel || entries[1].Message != "no prefix" {
+		t.Errorf("unexpected %s %q", entries[1].Level, entries[1].Message)
+	}
+}
@@ -0,0 +1,458 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.15.2
+// source: aggregate.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// StreamID is the identity of one aggregate's event stream.  This is also what
+// gets consistently hashed to find the owning member.
+// key is opaque and wins if set; id is for numeric ids and is the same as
+// using its decimal string as the key.
+type StreamID struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	AggregateType string `protobuf:"bytes,1,opt,name=aggregateType,proto3" json:"aggregateType,omitempty"`
+	Id            uint64 `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	Key           []byte `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+}
+
+func (x *StreamID) Reset() {
+	*x = StreamID{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_aggregate_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamID) ProtoMessage() {}
+
+func (x *StreamID) ProtoReflect() protoreflect.Message {
+	mi := &file_aggregate_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamID.ProtoReflect.Descriptor instead.
+func (*StreamID) Descriptor() ([]byte, []int) {
+	return file_aggregate_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StreamID) GetAggregateType() string {
+	if x != nil {
+		return x.AggregateType
+	}
+	return ""
+}
+
+func (x *StreamID) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *StreamID) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+// AggregateState is the replayed root aggregate.  the state itself depends on
+// the aggregate type; customers pack a CustomerState in here, for example.
+type AggregateState struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Stream          *StreamID        `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	CurrentSequence uint64           `protobuf:"varint,2,opt,name=currentSequence,proto3" json:"currentSequence,omitempty"`
+	State           *anypb.Any       `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	Clock           *VectorTimestamp `protobuf:"bytes,4,opt,name=clock,proto3" json:"clock,omitempty"` // send this back on the next write to catch concurrent writers
+}
+
+func (x *AggregateState) Reset() {
+	*x = AggregateState{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_aggregate_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AggregateState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AggregateState) ProtoMessage() {}
+
+func (x *AggregateState) ProtoReflect() protoreflect.Message {
+	mi := &file_aggregate_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AggregateState.ProtoReflect.Descriptor instead.
+func (*AggregateState) Descriptor() ([]byte, []int) {
+	return file_aggregate_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AggregateState) GetStream() *StreamID {
+	if x != nil {
+		return x.Stream
+	}
+	return nil
+}
+
+func (x *AggregateState) GetCurrentSequence() uint64 {
+	if x != nil {
+		return x.CurrentSequence
+	}
+	return 0
+}
+
+func (x *AggregateState) GetState() *anypb.Any {
+	if x != nil {
+		return x.State
+	}
+	return nil
+}
+
+func (x *AggregateState) GetClock() *VectorTimestamp {
+	if x != nil {
+		return x.Clock
+	}
+	return nil
+}
+
+// NewEventLog is NewCustomerLog for any aggregate type.  The log format is the
+// same for every stream (so, yes, it is still called CustomerEventLog; see the
+// notes in stuff.proto about how that should be fixed)
+type NewEventLog struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Stream *StreamID         `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	Log    *CustomerEventLog `protobuf:"bytes,2,opt,name=log,proto3" json:"log,omitempty"`
+}
+
+func (x *NewEventLog) Reset() {
+	*x = NewEventLog{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_aggregate_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NewEventLog) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NewEventLog) ProtoMessage() {}
+
+func (x *NewEventLog) ProtoReflect() protoreflect.Message {
+	mi := &file_aggregate_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NewEventLog.ProtoReflect.Descriptor instead.
+func (*NewEventLog) Descriptor() ([]byte, []int) {
+	return file_aggregate_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *NewEventLog) GetStream() *StreamID {
+	if x != nil {
+		return x.Stream
+	}
+	return nil
+}
+
+func (x *NewEventLog) GetLog() *CustomerEventLog {
+	if x != nil {
+		return x.Log
+	}
+	return nil
+}
+
+// ResolveConflicts is ResolveCustomerConflicts for any stream
+type ResolveConflicts struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Stream *StreamID         `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	Merged *CustomerEventLog `protobuf:"bytes,2,opt,name=merged,proto3" json:"merged,omitempty"`
+}
+
+func (x *ResolveConflicts) Reset() {
+	*x = ResolveConflicts{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_aggregate_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ResolveConflicts) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveConflicts) ProtoMessage() {}
+
+func (x *ResolveConflicts) ProtoReflect() protoreflect.Message {
+	mi := &file_aggregate_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveConflicts.ProtoReflect.Descriptor instead.
+func (*ResolveConflicts) Descriptor() ([]byte, []int) {
+	return file_aggregate_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ResolveConflicts) GetStream() *StreamID {
+	if x != nil {
+		return x.Stream
+	}
+	return nil
+}
+
+func (x *ResolveConflicts) GetMerged() *CustomerEventLog {
+	if x != nil {
+		return x.Merged
+	}
+	return nil
+}
+
+var File_aggregate_proto protoreflect.FileDescriptor
+
+var file_aggregate_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x05, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x19, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x61, 0x6e, 0x79, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x1a, 0x0b, 0x73, 0x74, 0x75, 0x66, 0x66, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x22, 0x52, 0x0a, 0x08, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x44, 0x12, 0x24, 0x0a, 0x0d,
+	0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0d, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65, 0x54, 0x79,
+	0x70, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x03, 0x6b, 0x65, 0x79, 0x22, 0xbd, 0x01, 0x0a, 0x0e, 0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61,
+	0x74, 0x65, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x27, 0x0a, 0x06, 0x73, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e,
+	0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x44, 0x52, 0x06, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x12, 0x28, 0x0a, 0x0f, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x53, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0f, 0x63, 0x75, 0x72, 0x72, 0x65,
+	0x6e, 0x74, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x2a, 0x0a, 0x05, 0x73, 0x74,
+	0x61, 0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x41, 0x6e, 0x79, 0x52,
+	0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x2c, 0x0a, 0x05, 0x63, 0x6c, 0x6f, 0x63, 0x6b, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x56, 0x65,
+	0x63, 0x74, 0x6f, 0x72, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x63,
+	0x6c, 0x6f, 0x63, 0x6b, 0x22, 0x61, 0x0a, 0x0b, 0x4e, 0x65, 0x77, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x4c, 0x6f, 0x67, 0x12, 0x27, 0x0a, 0x06, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x49, 0x44, 0x52, 0x06, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x29, 0x0a, 0x03,
+	0x6c, 0x6f, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2e, 0x43, 0x75, 0x73, 0x74, 0x6f, 0x6d, 0x65, 0x72, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x4c,
+	0x6f, 0x67, 0x52, 0x03, 0x6c, 0x6f, 0x67, 0x22, 0x6c, 0x0a, 0x10, 0x52, 0x65, 0x73, 0x6f, 0x6c,
+	0x76, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x12, 0x27, 0x0a, 0x06, 0x73,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x44, 0x52, 0x06, 0x73, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x12, 0x2f, 0x0a, 0x06, 0x6d, 0x65, 0x72, 0x67, 0x65, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x43, 0x75, 0x73,
+	0x74, 0x6f, 0x6d, 0x65, 0x72, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x4c, 0x6f, 0x67, 0x52, 0x06, 0x6d,
+	0x65, 0x72, 0x67, 0x65, 0x64, 0x32, 0xfc, 0x01, 0x0a, 0x0a, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x12, 0x3a, 0x0a, 0x0e, 0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74,
+	0x65, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x44, 0x1a, 0x15, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e,
+	0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65, 0x53, 0x74, 0x61, 0x74, 0x65, 0x22, 0x00,
+	0x12, 0x38, 0x0a, 0x0b, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12,
+	0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x65, 0x77, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x4c, 0x6f, 0x67, 0x1a, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73, 0x22, 0x00, 0x12, 0x34, 0x0a, 0x0d, 0x4c, 0x69,
+	0x73, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x12, 0x0f, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x44, 0x1a, 0x10, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x22, 0x00,
+	0x12, 0x42, 0x0a, 0x10, 0x52, 0x65, 0x73, 0x6f, 0x6c, 0x76, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x6c,
+	0x69, 0x63, 0x74, 0x73, 0x12, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x52, 0x65, 0x73,
+	0x6f, 0x6c, 0x76, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x1a, 0x13, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x44, 0x65, 0x74, 0x61, 0x69,
+	0x6c, 0x73, 0x22, 0x00, 0x42, 0x24, 0x5a, 0x22, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x79, 0x61, 0x72, 0x62, 0x65, 0x6c, 0x6b, 0x2f, 0x67, 0x72, 0x70, 0x63, 0x73,
+	0x74, 0x75, 0x66, 0x66, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
+}
+
+var (
+	file_aggregate_proto_rawDescOnce sync.Once
+	file_aggregate_proto_rawDescData = file_aggregate_proto_rawDesc
+)
+
+func file_aggregate_proto_rawDescGZIP() []byte {
+	file_aggregate_proto_rawDescOnce.Do(func() {
+		file_aggregate_proto_rawDescData = protoimpl.X.CompressGZIP(file_aggregate_proto_rawDescData)
+	})
+	return file_aggregate_proto_rawDescData
+}
+
+var file_aggregate_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_aggregate_proto_goTypes = []interface{}{
+	(*StreamID)(nil),         // 0: proto.StreamID
+	(*AggregateState)(nil),   // 1: proto.AggregateState
+	(*NewEventLog)(nil),      // 2: proto.NewEventLog
+	(*ResolveConflicts)(nil), // 3: proto.ResolveConflicts
+	(*anypb.Any)(nil),        // 4: google.protobuf.Any
+	(*VectorTimestamp)(nil),  // 5: proto.VectorTimestamp
+	(*CustomerEventLog)(nil), // 6: proto.CustomerEventLog
+	(*ErrorDetails)(nil),     // 7: proto.ErrorDetails
+	(*Conflicts)(nil),        // 8: proto.Conflicts
+}
+var file_aggregate_proto_depIdxs = []int32{
+	0,  // 0: proto.AggregateState.stream:type_name -> proto.StreamID
+	4,  // 1: proto.AggregateState.state:type_name -> google.protobuf.Any
+	5,  // 2: proto.AggregateState.clock:type_name -> proto.VectorTimestamp
+	0,  // 3: proto.NewEventLog.stream:type_name -> proto.StreamID
+	6,  // 4: proto.NewEventLog.log:type_name -> proto.CustomerEventLog
+	0,  // 5: proto.ResolveConflicts.stream:type_name -> proto.StreamID
+	6,  // 6: proto.ResolveConflicts.merged:type_name -> proto.CustomerEventLog
+	0,  // 7: proto.EventStore.AggregateState:input_type -> proto.StreamID
+	2,  // 8: proto.EventStore.AppendEvent:input_type -> proto.NewEventLog
+	0,  // 9: proto.EventStore.ListConflicts:input_type -> proto.StreamID
+	3,  // 10: proto.EventStore.ResolveConflicts:input_type -> proto.ResolveConflicts
+	1,  // 11: proto.EventStore.AggregateState:output_type -> proto.AggregateState
+	7,  // 12: proto.EventStore.AppendEvent:output_type -> proto.ErrorDetails
+	8,  // 13: proto.EventStore.ListConflicts:output_type -> proto.Conflicts
+	7,  // 14: proto.EventStore.ResolveConflicts:output_type -> proto.ErrorDetails
+	11, // [11:15] is the sub-list for method output_type
+	7,  // [7:11] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_aggregate_proto_init() }
+func file_aggregate_proto_init() {
+	if File_aggregate_proto != nil {
+		return
+	}
+	file_stuff_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_aggregate_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamID); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_aggregate_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AggregateState); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_aggregate_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NewEventLog); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_aggregate_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ResolveConflicts); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_aggregate_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_aggregate_proto_goTypes,
+		DependencyIndexes: file_aggregate_proto_depIdxs,
+		MessageInfos:      file_aggregate_proto_msgTypes,
+	}.Build()
+	File_aggregate_proto = out.File
+	file_aggregate_proto_rawDesc = nil
+	file_aggregate_proto_goTypes = nil
+	file_aggregate_proto_depIdxs = nil
+}
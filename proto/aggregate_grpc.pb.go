@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// EventStoreClient is the client API for EventStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EventStoreClient interface {
+	AggregateState(ctx context.Context, in *StreamID, opts ...grpc.CallOption) (*AggregateState, error)
+	AppendEvent(ctx context.Context, in *NewEventLog, opts ...grpc.CallOption) (*ErrorDetails, error)
+	ListConflicts(ctx context.Context, in *StreamID, opts ...grpc.CallOption) (*Conflicts, error)
+	ResolveConflicts(ctx context.Context, in *ResolveConflicts, opts ...grpc.CallOption) (*ErrorDetails, error)
+}
+
+type eventStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventStoreClient(cc grpc.ClientConnInterface) EventStoreClient {
+	return &eventStoreClient{cc}
+}
+
+func (c *eventStoreClient) AggregateState(ctx context.Context, in *StreamID, opts ...grpc.CallOption) (*AggregateState, error) {
+	out := new(AggregateState)
+	err := c.cc.Invoke(ctx, "/proto.EventStore/AggregateState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventStoreClient) AppendEvent(ctx context.Context, in *NewEventLog, opts ...grpc.CallOption) (*ErrorDetails, error) {
+	out := new(ErrorDetails)
+	err := c.cc.Invoke(ctx, "/proto.EventStore/AppendEvent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventStoreClient) ListConflicts(ctx context.Context, in *StreamID, opts ...grpc.CallOption) (*Conflicts, error) {
+	out := new(Conflicts)
+	err := c.cc.Invoke(ctx, "/proto.EventStore/ListConflicts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventStoreClient) ResolveConflicts(ctx context.Context, in *ResolveConflicts, opts ...grpc.CallOption) (*ErrorDetails, error) {
+	out := new(ErrorDetails)
+	err := c.cc.Invoke(ctx, "/proto.EventStore/ResolveConflicts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EventStoreServer is the server API for EventStore service.
+// All implementations must embed UnimplementedEventStoreServer
+// for forward compatibility
+type EventStoreServer interface {
+	AggregateState(context.Context, *StreamID) (*AggregateState, error)
+	AppendEvent(context.Context, *NewEventLog) (*ErrorDetails, error)
+	ListConflicts(context.Context, *StreamID) (*Conflicts, error)
+	ResolveConflicts(context.Context, *ResolveConflicts) (*ErrorDetails, error)
+	mustEmbedUnimplementedEventStoreServer()
+}
+
+// UnimplementedEventStoreServer must be embedded to have forward compatible implementations.
+type UnimplementedEventStoreServer struct {
+}
+
+func (UnimplementedEventStoreServer) AggregateState(context.Context, *StreamID) (*AggregateState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AggregateState not implemented")
+}
+func (UnimplementedEventStoreServer) AppendEvent(context.Context, *NewEventLog) (*ErrorDetails, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendEvent not implemented")
+}
+func (UnimplementedEventStoreServer) ListConflicts(context.Context, *StreamID) (*Conflicts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConflicts not implemented")
+}
+func (UnimplementedEventStoreServer) ResolveConflicts(context.Context, *ResolveConflicts) (*ErrorDetails, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResolveConflicts not implemented")
+}
+func (UnimplementedEventStoreServer) mustEmbedUnimplementedEventStoreServer() {}
+
+// UnsafeEventStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventStoreServer will
+// result in compilation errors.
+type UnsafeEventStoreServer interface {
+	mustEmbedUnimplementedEventStoreServer()
+}
+
+func RegisterEventStoreServer(s grpc.ServiceRegistrar, srv EventStoreServer) {
+	s.RegisterService(&EventStore_ServiceDesc, srv)
+}
+
+func _EventStore_AggregateState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StreamID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).AggregateState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.EventStore/AggregateState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).AggregateState(ctx, req.(*StreamID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventStore_AppendEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NewEventLog)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).AppendEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.EventStore/AppendEvent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).AppendEvent(ctx, req.(*NewEventLog))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventStore_ListConflicts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StreamID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).ListConflicts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.EventStore/ListConflicts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).ListConflicts(ctx, req.(*StreamID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventStore_ResolveConflicts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveConflicts)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventStoreServer).ResolveConflicts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.EventStore/ResolveConflicts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventStoreServer).ResolveConflicts(ctx, req.(*ResolveConflicts))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EventStore_ServiceDesc is the grpc.ServiceDesc for EventStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.EventStore",
+	HandlerType: (*EventStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AggregateState",
+			Handler:    _EventStore_AggregateState_Handler,
+		},
+		{
+			MethodName: "AppendEvent",
+			Handler:    _EventStore_AppendEvent_Handler,
+		},
+		{
+			MethodName: "ListConflicts",
+			Handler:    _EventStore_ListConflicts_Handler,
+		},
+		{
+			MethodName: "ResolveConflicts",
+			Handler:    _EventStore_ResolveConflicts_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "aggregate.proto",
+}
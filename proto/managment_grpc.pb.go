@@ -21,6 +21,34 @@ const _ = grpc.SupportPackageIsVersion7
 type ClusterManagmentClient interface {
 	MembershipChanges(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (ClusterManagment_MembershipChangesClient, error)
 	MembershipList(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Membership, error)
+	// Drain this node: stop taking writes, hand everything it holds to the owners the
+	// ring gives without it, then leave the cluster and shut down.  Returns straight
+	// away; poll DrainProgress.
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainStatus, error)
+	DrainProgress(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DrainStatus, error)
+	// the node's log level: debug, info, warn or error.  Setting it takes effect straight
+	// away, and lasts until the node restarts.
+	GetLogLevel(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*LogLevel, error)
+	SetLogLevel(ctx context.Context, in *LogLevel, opts ...grpc.CallOption) (*LogLevel, error)
+	// the gossip encryption keyring.  Each goes to every member the node can see,
+	// unless local is set, and says how it went on each.  Rotating is install the new
+	// key, use it, then remove the old one; each everywhere before the next.
+	ListKeys(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	InstallKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	UseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	RemoveKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error)
+	// Backup streams the node's badger backup: everything, or only what was written
+	// since a version.  The last chunk has the version to back up since next time.
+	// Restore loads one (or a full one and its incrementals, back to back) into the
+	// node; it has to have no data yet.
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (ClusterManagment_BackupClient, error)
+	Restore(ctx context.Context, opts ...grpc.CallOption) (ClusterManagment_RestoreClient, error)
+	// Snapshot is the node's part of a cluster wide snapshot, read at the same moment
+	// as everyone else's.  The coordinator's first message holds the node's writes, and
+	// the node answers with its read timestamp.  Once every node has, the second lets
+	// the writes go, and the node streams its logs, the highest sequenceId of each
+	// stream, and last the partitions it had.
+	Snapshot(ctx context.Context, opts ...grpc.CallOption) (ClusterManagment_SnapshotClient, error)
 }
 
 type clusterManagmentClient struct {
@@ -72,12 +100,209 @@ func (c *clusterManagmentClient) MembershipList(ctx context.Context, in *emptypb
 	return out, nil
 }
 
+func (c *clusterManagmentClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainStatus, error) {
+	out := new(DrainStatus)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/Drain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) DrainProgress(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DrainStatus, error) {
+	out := new(DrainStatus)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/DrainProgress", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) GetLogLevel(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*LogLevel, error) {
+	out := new(LogLevel)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/GetLogLevel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) SetLogLevel(ctx context.Context, in *LogLevel, opts ...grpc.CallOption) (*LogLevel, error) {
+	out := new(LogLevel)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/SetLogLevel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) ListKeys(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/ListKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) InstallKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/InstallKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) UseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/UseKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) RemoveKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeyringResponse, error) {
+	out := new(KeyringResponse)
+	err := c.cc.Invoke(ctx, "/proto.ClusterManagment/RemoveKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterManagmentClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (ClusterManagment_BackupClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ClusterManagment_ServiceDesc.Streams[1], "/proto.ClusterManagment/Backup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &clusterManagmentBackupClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ClusterManagment_BackupClient interface {
+	Recv() (*BackupChunk, error)
+	grpc.ClientStream
+}
+
+type clusterManagmentBackupClient struct {
+	grpc.ClientStream
+}
+
+func (x *clusterManagmentBackupClient) Recv() (*BackupChunk, error) {
+	m := new(BackupChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *clusterManagmentClient) Restore(ctx context.Context, opts ...grpc.CallOption) (ClusterManagment_RestoreClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ClusterManagment_ServiceDesc.Streams[2], "/proto.ClusterManagment/Restore", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &clusterManagmentRestoreClient{stream}
+	return x, nil
+}
+
+type ClusterManagment_RestoreClient interface {
+	Send(*BackupChunk) error
+	CloseAndRecv() (*emptypb.Empty, error)
+	grpc.ClientStream
+}
+
+type clusterManagmentRestoreClient struct {
+	grpc.ClientStream
+}
+
+func (x *clusterManagmentRestoreClient) Send(m *BackupChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *clusterManagmentRestoreClient) CloseAndRecv() (*emptypb.Empty, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(emptypb.Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *clusterManagmentClient) Snapshot(ctx context.Context, opts ...grpc.CallOption) (ClusterManagment_SnapshotClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ClusterManagment_ServiceDesc.Streams[3], "/proto.ClusterManagment/Snapshot", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &clusterManagmentSnapshotClient{stream}
+	return x, nil
+}
+
+type ClusterManagment_SnapshotClient interface {
+	Send(*SnapshotControl) error
+	Recv() (*SnapshotMessage, error)
+	grpc.ClientStream
+}
+
+type clusterManagmentSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *clusterManagmentSnapshotClient) Send(m *SnapshotControl) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *clusterManagmentSnapshotClient) Recv() (*SnapshotMessage, error) {
+	m := new(SnapshotMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ClusterManagmentServer is the server API for ClusterManagment service.
 // All implementations must embed UnimplementedClusterManagmentServer
 // for forward compatibility
 type ClusterManagmentServer interface {
 	MembershipChanges(*emptypb.Empty, ClusterManagment_MembershipChangesServer) error
 	MembershipList(context.Context, *emptypb.Empty) (*Membership, error)
+	// Drain this node: stop taking writes, hand everything it holds to the owners the
+	// ring gives without it, then leave the cluster and shut down.  Returns straight
+	// away; poll DrainProgress.
+	Drain(context.Context, *DrainRequest) (*DrainStatus, error)
+	DrainProgress(context.Context, *emptypb.Empty) (*DrainStatus, error)
+	// the node's log level: debug, info, warn or error.  Setting it takes effect straight
+	// away, and lasts until the node restarts.
+	GetLogLevel(context.Context, *emptypb.Empty) (*LogLevel, error)
+	SetLogLevel(context.Context, *LogLevel) (*LogLevel, error)
+	// the gossip encryption keyring.  Each goes to every member the node can see,
+	// unless local is set, and says how it went on each.  Rotating is install the new
+	// key, use it, then remove the old one; each everywhere before the next.
+	ListKeys(context.Context, *KeyRequest) (*KeyringResponse, error)
+	InstallKey(context.Context, *KeyRequest) (*KeyringResponse, error)
+	UseKey(context.Context, *KeyRequest) (*KeyringResponse, error)
+	RemoveKey(context.Context, *KeyRequest) (*KeyringResponse, error)
+	// Backup streams the node's badger backup: everything, or only what was written
+	// since a version.  The last chunk has the version to back up since next time.
+	// Restore loads one (or a full one and its incrementals, back to back) into the
+	// node; it has to have no data yet.
+	Backup(*BackupRequest, ClusterManagment_BackupServer) error
+	Restore(ClusterManagment_RestoreServer) error
+	// Snapshot is the node's part of a cluster wide snapshot, read at the same moment
+	// as everyone else's.  The coordinator's first message holds the node's writes, and
+	// the node answers with its read timestamp.  Once every node has, the second lets
+	// the writes go, and the node streams its logs, the highest sequenceId of each
+	// stream, and last the partitions it had.
+	Snapshot(ClusterManagment_SnapshotServer) error
 	mustEmbedUnimplementedClusterManagmentServer()
 }
 
@@ -91,6 +316,39 @@ func (UnimplementedClusterManagmentServer) MembershipChanges(*emptypb.Empty, Clu
 func (UnimplementedClusterManagmentServer) MembershipList(context.Context, *emptypb.Empty) (*Membership, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method MembershipList not implemented")
 }
+func (UnimplementedClusterManagmentServer) Drain(context.Context, *DrainRequest) (*DrainStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedClusterManagmentServer) DrainProgress(context.Context, *emptypb.Empty) (*DrainStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainProgress not implemented")
+}
+func (UnimplementedClusterManagmentServer) GetLogLevel(context.Context, *emptypb.Empty) (*LogLevel, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLogLevel not implemented")
+}
+func (UnimplementedClusterManagmentServer) SetLogLevel(context.Context, *LogLevel) (*LogLevel, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
+}
+func (UnimplementedClusterManagmentServer) ListKeys(context.Context, *KeyRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListKeys not implemented")
+}
+func (UnimplementedClusterManagmentServer) InstallKey(context.Context, *KeyRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InstallKey not implemented")
+}
+func (UnimplementedClusterManagmentServer) UseKey(context.Context, *KeyRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UseKey not implemented")
+}
+func (UnimplementedClusterManagmentServer) RemoveKey(context.Context, *KeyRequest) (*KeyringResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveKey not implemented")
+}
+func (UnimplementedClusterManagmentServer) Backup(*BackupRequest, ClusterManagment_BackupServer) error {
+	return status.Errorf(codes.Unimplemented, "method Backup not implemented")
+}
+func (UnimplementedClusterManagmentServer) Restore(ClusterManagment_RestoreServer) error {
+	return status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedClusterManagmentServer) Snapshot(ClusterManagment_SnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
 func (UnimplementedClusterManagmentServer) mustEmbedUnimplementedClusterManagmentServer() {}
 
 // UnsafeClusterManagmentServer may be embedded to opt out of forward compatibility for this service.
@@ -143,6 +401,223 @@ func _ClusterManagment_MembershipList_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ClusterManagment_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_DrainProgress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).DrainProgress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/DrainProgress",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).DrainProgress(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_GetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).GetLogLevel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/GetLogLevel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).GetLogLevel(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogLevel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).SetLogLevel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/SetLogLevel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).SetLogLevel(ctx, req.(*LogLevel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_ListKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).ListKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/ListKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).ListKeys(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_InstallKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).InstallKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/InstallKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).InstallKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_UseKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).UseKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/UseKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).UseKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_RemoveKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterManagmentServer).RemoveKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ClusterManagment/RemoveKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterManagmentServer).RemoveKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterManagment_Backup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BackupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ClusterManagmentServer).Backup(m, &clusterManagmentBackupServer{stream})
+}
+
+type ClusterManagment_BackupServer interface {
+	Send(*BackupChunk) error
+	grpc.ServerStream
+}
+
+type clusterManagmentBackupServer struct {
+	grpc.ServerStream
+}
+
+func (x *clusterManagmentBackupServer) Send(m *BackupChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ClusterManagment_Restore_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ClusterManagmentServer).Restore(&clusterManagmentRestoreServer{stream})
+}
+
+type ClusterManagment_RestoreServer interface {
+	SendAndClose(*emptypb.Empty) error
+	Recv() (*BackupChunk, error)
+	grpc.ServerStream
+}
+
+type clusterManagmentRestoreServer struct {
+	grpc.ServerStream
+}
+
+func (x *clusterManagmentRestoreServer) SendAndClose(m *emptypb.Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *clusterManagmentRestoreServer) Recv() (*BackupChunk, error) {
+	m := new(BackupChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ClusterManagment_Snapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ClusterManagmentServer).Snapshot(&clusterManagmentSnapshotServer{stream})
+}
+
+type ClusterManagment_SnapshotServer interface {
+	Send(*SnapshotMessage) error
+	Recv() (*SnapshotControl, error)
+	grpc.ServerStream
+}
+
+type clusterManagmentSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *clusterManagmentSnapshotServer) Send(m *SnapshotMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *clusterManagmentSnapshotServer) Recv() (*SnapshotControl, error) {
+	m := new(SnapshotControl)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ClusterManagment_ServiceDesc is the grpc.ServiceDesc for ClusterManagment service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -154,6 +629,38 @@ var ClusterManagment_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "MembershipList",
 			Handler:    _ClusterManagment_MembershipList_Handler,
 		},
+		{
+			MethodName: "Drain",
+			Handler:    _ClusterManagment_Drain_Handler,
+		},
+		{
+			MethodName: "DrainProgress",
+			Handler:    _ClusterManagment_DrainProgress_Handler,
+		},
+		{
+			MethodName: "GetLogLevel",
+			Handler:    _ClusterManagment_GetLogLevel_Handler,
+		},
+		{
+			MethodName: "SetLogLevel",
+			Handler:    _ClusterManagment_SetLogLevel_Handler,
+		},
+		{
+			MethodName: "ListKeys",
+			Handler:    _ClusterManagment_ListKeys_Handler,
+		},
+		{
+			MethodName: "InstallKey",
+			Handler:    _ClusterManagment_InstallKey_Handler,
+		},
+		{
+			MethodName: "UseKey",
+			Handler:    _ClusterManagment_UseKey_Handler,
+		},
+		{
+			MethodName: "RemoveKey",
+			Handler:    _ClusterManagment_RemoveKey_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -161,6 +668,22 @@ var ClusterManagment_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _ClusterManagment_MembershipChanges_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Backup",
+			Handler:       _ClusterManagment_Backup_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Restore",
+			Handler:       _ClusterManagment_Restore_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Snapshot",
+			Handler:       _ClusterManagment_Snapshot_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "managment.proto",
 }
@@ -0,0 +1,239 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ReplicationClient is the client API for Replication service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReplicationClient interface {
+	Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (*ErrorDetails, error)
+	// anti-entropy: compare merkle trees of a partition, then fetch what differs
+	MerkleNodes(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*MerkleHashes, error)
+	MerkleLeafKeys(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*KeyHashes, error)
+	FetchLogs(ctx context.Context, in *FetchLogsRequest, opts ...grpc.CallOption) (Replication_FetchLogsClient, error)
+}
+
+type replicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReplicationClient(cc grpc.ClientConnInterface) ReplicationClient {
+	return &replicationClient{cc}
+}
+
+func (c *replicationClient) Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (*ErrorDetails, error) {
+	out := new(ErrorDetails)
+	err := c.cc.Invoke(ctx, "/proto.Replication/Replicate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) MerkleNodes(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*MerkleHashes, error) {
+	out := new(MerkleHashes)
+	err := c.cc.Invoke(ctx, "/proto.Replication/MerkleNodes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) MerkleLeafKeys(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*KeyHashes, error) {
+	out := new(KeyHashes)
+	err := c.cc.Invoke(ctx, "/proto.Replication/MerkleLeafKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) FetchLogs(ctx context.Context, in *FetchLogsRequest, opts ...grpc.CallOption) (Replication_FetchLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[0], "/proto.Replication/FetchLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationFetchLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Replication_FetchLogsClient interface {
+	Recv() (*ReplicateRequest, error)
+	grpc.ClientStream
+}
+
+type replicationFetchLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationFetchLogsClient) Recv() (*ReplicateRequest, error) {
+	m := new(ReplicateRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReplicationServer is the server API for Replication service.
+// All implementations must embed UnimplementedReplicationServer
+// for forward compatibility
+type ReplicationServer interface {
+	Replicate(context.Context, *ReplicateRequest) (*ErrorDetails, error)
+	// anti-entropy: compare merkle trees of a partition, then fetch what differs
+	MerkleNodes(context.Context, *MerkleRequest) (*MerkleHashes, error)
+	MerkleLeafKeys(context.Context, *MerkleRequest) (*KeyHashes, error)
+	FetchLogs(*FetchLogsRequest, Replication_FetchLogsServer) error
+	mustEmbedUnimplementedReplicationServer()
+}
+
+// UnimplementedReplicationServer must be embedded to have forward compatible implementations.
+type UnimplementedReplicationServer struct {
+}
+
+func (UnimplementedReplicationServer) Replicate(context.Context, *ReplicateRequest) (*ErrorDetails, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedReplicationServer) MerkleNodes(context.Context, *MerkleRequest) (*MerkleHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MerkleNodes not implemented")
+}
+func (UnimplementedReplicationServer) MerkleLeafKeys(context.Context, *MerkleRequest) (*KeyHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MerkleLeafKeys not implemented")
+}
+func (UnimplementedReplicationServer) FetchLogs(*FetchLogsRequest, Replication_FetchLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method FetchLogs not implemented")
+}
+func (UnimplementedReplicationServer) mustEmbedUnimplementedReplicationServer() {}
+
+// UnsafeReplicationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReplicationServer will
+// result in compilation errors.
+type UnsafeReplicationServer interface {
+	mustEmbedUnimplementedReplicationServer()
+}
+
+func RegisterReplicationServer(s grpc.ServiceRegistrar, srv ReplicationServer) {
+	s.RegisterService(&Replication_ServiceDesc, srv)
+}
+
+func _Replication_Replicate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplicateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Replicate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Replication/Replicate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Replicate(ctx, req.(*ReplicateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_MerkleNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).MerkleNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Replication/MerkleNodes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).MerkleNodes(ctx, req.(*MerkleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_MerkleLeafKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).MerkleLeafKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Replication/MerkleLeafKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).MerkleLeafKeys(ctx, req.(*MerkleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_FetchLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FetchLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).FetchLogs(m, &replicationFetchLogsServer{stream})
+}
+
+type Replication_FetchLogsServer interface {
+	Send(*ReplicateRequest) error
+	grpc.ServerStream
+}
+
+type replicationFetchLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationFetchLogsServer) Send(m *ReplicateRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Replication_ServiceDesc is the grpc.ServiceDesc for Replication service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Replication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Replication",
+	HandlerType: (*ReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Replicate",
+			Handler:    _Replication_Replicate_Handler,
+		},
+		{
+			MethodName: "MerkleNodes",
+			Handler:    _Replication_MerkleNodes_Handler,
+		},
+		{
+			MethodName: "MerkleLeafKeys",
+			Handler:    _Replication_MerkleLeafKeys_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "FetchLogs",
+			Handler:       _Replication_FetchLogs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "replication.proto",
+}
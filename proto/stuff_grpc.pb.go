@@ -18,9 +18,13 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ProtoStuffClient interface {
+	// StreamEventLog is the customer's logs in sequence order as they're written,
+	// starting at from.  The header has x-stream-from: the sequenceId it starts at.
 	StreamEventLog(ctx context.Context, in *Customer, opts ...grpc.CallOption) (ProtoStuff_StreamEventLogClient, error)
 	CustomerState(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*CustomerState, error)
 	WriteLog(ctx context.Context, in *NewCustomerLog, opts ...grpc.CallOption) (*ErrorDetails, error)
+	CustomerConflicts(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Conflicts, error)
+	ResolveCustomerConflicts(ctx context.Context, in *ResolveCustomerConflicts, opts ...grpc.CallOption) (*ErrorDetails, error)
 }
 
 type protoStuffClient struct {
@@ -81,13 +85,35 @@ func (c *protoStuffClient) WriteLog(ctx context.Context, in *NewCustomerLog, opt
 	return out, nil
 }
 
+func (c *protoStuffClient) CustomerConflicts(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Conflicts, error) {
+	out := new(Conflicts)
+	err := c.cc.Invoke(ctx, "/proto.ProtoStuff/CustomerConflicts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *protoStuffClient) ResolveCustomerConflicts(ctx context.Context, in *ResolveCustomerConflicts, opts ...grpc.CallOption) (*ErrorDetails, error) {
+	out := new(ErrorDetails)
+	err := c.cc.Invoke(ctx, "/proto.ProtoStuff/ResolveCustomerConflicts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProtoStuffServer is the server API for ProtoStuff service.
 // All implementations must embed UnimplementedProtoStuffServer
 // for forward compatibility
 type ProtoStuffServer interface {
+	// StreamEventLog is the customer's logs in sequence order as they're written,
+	// starting at from.  The header has x-stream-from: the sequenceId it starts at.
 	StreamEventLog(*Customer, ProtoStuff_StreamEventLogServer) error
 	CustomerState(context.Context, *Customer) (*CustomerState, error)
 	WriteLog(context.Context, *NewCustomerLog) (*ErrorDetails, error)
+	CustomerConflicts(context.Context, *Customer) (*Conflicts, error)
+	ResolveCustomerConflicts(context.Context, *ResolveCustomerConflicts) (*ErrorDetails, error)
 	mustEmbedUnimplementedProtoStuffServer()
 }
 
@@ -104,6 +130,12 @@ func (UnimplementedProtoStuffServer) CustomerState(context.Context, *Customer) (
 func (UnimplementedProtoStuffServer) WriteLog(context.Context, *NewCustomerLog) (*ErrorDetails, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method WriteLog not implemented")
 }
+func (UnimplementedProtoStuffServer) CustomerConflicts(context.Context, *Customer) (*Conflicts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CustomerConflicts not implemented")
+}
+func (UnimplementedProtoStuffServer) ResolveCustomerConflicts(context.Context, *ResolveCustomerConflicts) (*ErrorDetails, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResolveCustomerConflicts not implemented")
+}
 func (UnimplementedProtoStuffServer) mustEmbedUnimplementedProtoStuffServer() {}
 
 // UnsafeProtoStuffServer may be embedded to opt out of forward compatibility for this service.
@@ -174,6 +206,42 @@ func _ProtoStuff_WriteLog_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ProtoStuff_CustomerConflicts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Customer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProtoStuffServer).CustomerConflicts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ProtoStuff/CustomerConflicts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProtoStuffServer).CustomerConflicts(ctx, req.(*Customer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProtoStuff_ResolveCustomerConflicts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveCustomerConflicts)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProtoStuffServer).ResolveCustomerConflicts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ProtoStuff/ResolveCustomerConflicts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProtoStuffServer).ResolveCustomerConflicts(ctx, req.(*ResolveCustomerConflicts))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ProtoStuff_ServiceDesc is the grpc.ServiceDesc for ProtoStuff service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -189,6 +257,14 @@ var ProtoStuff_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "WriteLog",
 			Handler:    _ProtoStuff_WriteLog_Handler,
 		},
+		{
+			MethodName: "CustomerConflicts",
+			Handler:    _ProtoStuff_CustomerConflicts_Handler,
+		},
+		{
+			MethodName: "ResolveCustomerConflicts",
+			Handler:    _ProtoStuff_ResolveCustomerConflicts_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -0,0 +1,81 @@
+package raftgroup
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/dgraph-io/badger"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+)
+
+// fsm applies a group's RaftAppend commands to the node's store.  The store is the
+// state: it is already durable, so the applied index kept next to it is what
+// makes replaying the raft log after a restart safe.
+type fsm struct {
+	store   *data.BadgerStore
+	group   int
+	include func(data.StreamID) bool
+}
+
+// Apply returns the append's error (nil, or a validation error) as the command's result
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var cmd proto.RaftAppend
+	if err := protobuf.Unmarshal(l.Data, &cmd); err != nil {
+		return err
+	}
+	stamp := data.Stamp{Node: cmd.GetNode(), WallTime: cmd.GetWallTime()}
+	s := data.StreamID{Type: cmd.GetStream().GetAggregateType(), ID: string(cmd.GetStream().GetKey())}
+	return f.store.ApplyAppend(appliedKey(f.group), l.Index, stamp, s, cmd.GetLog())
+}
+
+// Snapshot is called on the same goroutine as Apply, so the applied index and the
+// read transaction see the same state.  Persist happens later, from the transaction.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	index, err := f.store.AppliedIndex(appliedKey(f.group))
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{
+		index:   index,
+		txn:     f.store.LogDB.NewTransaction(false),
+		include: f.include,
+	}, nil
+}
+
+// Restore replaces the group's streams with the snapshot's
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var b [8]byte
+	if _, err := io.ReadFull(rc, b[:]); err != nil {
+		return err
+	}
+	return f.store.RestoreLogs(rc, f.include, appliedKey(f.group), binary.BigEndian.Uint64(b[:]))
+}
+
+// snapshot is the applied index (8 bytes, big endian) followed by data.DumpLogs
+type snapshot struct {
+	index   uint64
+	txn     *badger.Txn
+	include func(data.StreamID) bool
+}
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], s.index)
+	_, err := sink.Write(b[:])
+	if err == nil {
+		err = data.DumpLogs(s.txn, sink, s.include)
+	}
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *snapshot) Release() {
+	s.txn.Discard()
+}
@@ -0,0 +1,272 @@
+package raftgroup_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/raftgroup"
+)
+
+// testCluster is a single raft group of n nodes in process: in memory transports,
+// and a real badger store each.
+type testCluster struct {
+	nodes []*raftgroup.Manager
+
+	lock    sync.Mutex
+	servers []raft.Server
+}
+
+// members is Servers for every node
+func (c *testCluster) members(int) []raft.Server {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]raft.Server(nil), c.servers...)
+}
+
+// drop id from the members, like a drain takes it off the ring
+func (c *testCluster) drop(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, srv := range c.servers {
+		if string(srv.ID) == id {
+			c.servers = append(c.servers[:i:i], c.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+func fastConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	conf.LogOutput = ioutil.Discard
+	return conf
+}
+
+func newTestCluster(t *testing.T, n int) *testCluster {
+	t.Helper()
+	transports := make([]*raft.InmemTransport, n)
+	servers := make([]raft.Server, n)
+	for i := range transports {
+		_, transports[i] = raft.NewInmemTransport("")
+		servers[i] = raft.Server{
+			ID:      raft.ServerID(fmt.Sprintf("node-%d", i)),
+			Address: transports[i].LocalAddr(),
+		}
+	}
+	for _, a := range transports {
+		for _, b := range transports {
+			if a != b {
+				a.Connect(b.LocalAddr(), b)
+			}
+		}
+	}
+
+	c := &testCluster{servers: servers}
+	for i := range transports {
+		store := data.New(t.TempDir())
+		trans := transports[i]
+		m := &raftgroup.Manager{
+			NodeID:    string(servers[i].ID),
+			Groups:    1,
+			Store:     store,
+			Transport: func(int) (raft.Transport, error) { return trans, nil },
+			Partition: func([]byte) int { return 0 },
+			Servers:   c.members,
+			Config:    fastConfig,
+		}
+		m.Reconcile()
+		c.nodes = append(c.nodes, m)
+		t.Cleanup(func() {
+			m.Shutdown()
+			store.Close()
+		})
+	}
+	return c
+}
+
+var stream = data.CustomerStream("1")
+
+// leader waits for one of the running nodes to be the leader
+func (c *testCluster) leader(t *testing.T, running []*raftgroup.Manager) *raftgroup.Manager {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, m := range running {
+			if m.Leader(stream) == m.NodeID {
+				return m
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+// eventually every node has applied up to seq
+func eventually(t *testing.T, nodes []*raftgroup.Manager, seq uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, m := range nodes {
+		for {
+			agg, err := m.Store.GetState(stream)
+			if err == nil && agg.Sequence() == seq {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never applied sequence %d (err: %v)", m.NodeID, seq, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func appendLog(m *raftgroup.Manager, seq uint64, action string) error {
+	return m.Append(stream, &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: action}})
+}
+
+func TestRaftGroup(t *testing.T) {
+	t.Run("appends through the leader apply on every member", func(t *testing.T) {
+		c := newTestCluster(t, 3)
+		leader := c.leader(t, c.nodes)
+		for seq := uint64(0); seq < 3; seq++ {
+			if err := appendLog(leader, seq, "write"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		eventually(t, c.nodes, 2)
+
+		// and the clocks came out the same everywhere: stamped by the leader
+		want, _ := leader.Store.GetState(stream)
+		for _, m := range c.nodes {
+			got, _ := m.Store.GetState(stream)
+			if o := got.VectorClock().Compare(want.VectorClock()); o != data.Equal {
+				t.Errorf("%s clock is %s the leader's", m.NodeID, o)
+			}
+		}
+	})
+
+	t.Run("followers send writers to the leader", func(t *testing.T) {
+		c := newTestCluster(t, 3)
+		leader := c.leader(t, c.nodes)
+		for _, m := range c.nodes {
+			if m == leader {
+				continue
+			}
+			// a follower only knows the leader once it has heard from it
+			for deadline := time.Now().Add(5 * time.Second); m.Leader(stream) == "" && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			err := appendLog(m, 0, "write")
+			var notLeader *raftgroup.NotLeaderError
+			if !errors.As(err, &notLeader) {
+				t.Fatalf("expected not leader, got %v", err)
+			}
+			if notLeader.Leader != leader.NodeID {
+				t.Errorf("expected leader %s, got %q", leader.NodeID, notLeader.Leader)
+			}
+		}
+	})
+
+	t.Run("only one of concurrent appends of a sequence wins", func(t *testing.T) {
+		c := newTestCluster(t, 3)
+		leader := c.leader(t, c.nodes)
+		var wg sync.WaitGroup
+		errs := make([]error, 10)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = appendLog(leader, 0, fmt.Sprintf("writer %d", i))
+			}(i)
+		}
+		wg.Wait()
+		var won int
+		for _, err := range errs {
+			switch err {
+			case nil:
+				won++
+			case data.InvalidSequenceError:
+			default:
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+		if won != 1 {
+			t.Errorf("expected exactly one winner, got %d", won)
+		}
+		eventually(t, c.nodes, 0)
+	})
+
+	t.Run("writes carry on after the leader goes away", func(t *testing.T) {
+		c := newTestCluster(t, 3)
+		leader := c.leader(t, c.nodes)
+		if err := appendLog(leader, 0, "before"); err != nil {
+			t.Fatal(err)
+		}
+		leader.Shutdown()
+
+		var rest []*raftgroup.Manager
+		for _, m := range c.nodes {
+			if m != leader {
+				rest = append(rest, m)
+			}
+		}
+		newLeader := c.leader(t, rest)
+		if err := appendLog(newLeader, 1, "after"); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, rest, 1)
+	})
+
+	t.Run("a node leaving hands off its leadership and waits to be removed", func(t *testing.T) {
+		c := newTestCluster(t, 3)
+		leaving := c.leader(t, c.nodes)
+		c.drop(leaving.NodeID)
+		// the Managers' Run, quicker
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, m := range c.nodes {
+			wg.Add(1)
+			go func(m *raftgroup.Manager) {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					case <-time.After(20 * time.Millisecond):
+						m.Reconcile()
+					}
+				}
+			}(m)
+		}
+		err := leaving.Leave(5 * time.Second)
+		close(stop)
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaving.Member(stream) {
+			t.Error("expected the leaving node to be out of the group")
+		}
+
+		var rest []*raftgroup.Manager
+		for _, m := range c.nodes {
+			if m != leaving {
+				rest = append(rest, m)
+			}
+		}
+		if err := appendLog(c.leader(t, rest), 0, "after"); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, rest, 0)
+	})
+}
@@ -0,0 +1,424 @@
+// Package raftgroup runs a raft group per consistent hash partition.
+// Writes to a stream go through its group's leader and are replicated by the raft
+// log, so every member applies the same appends in the same order: the sequenceId
+// check in the store becomes a linearizable compare-and-append, and two nodes can't
+// both win the same sequenceId during a partition (the minority side can't commit).
+//
+// Group p is partition p, and runs on the partition's replicas, so its members are
+// the nodes the ring says hold each of its streams.  The Manager starts and stops
+// groups as membership changes, and the leader of each group adds and removes voters
+// to match.
+package raftgroup
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+)
+
+// ErrNotMember is returned for streams whose group doesn't run on this node
+var ErrNotMember = errors.New("not a member of the stream's raft group")
+
+// NotLeaderError is returned for appends sent to a follower.  Leader is the
+// node name of the leader, if the follower knows it.
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not the raft group leader; leader unknown"
+	}
+	return fmt.Sprintf("not the raft group leader; leader is %s", e.Leader)
+}
+
+// Manager owns this node's raft groups
+type Manager struct {
+	// NodeID is the local node name; it is the raft server id
+	NodeID string
+	// Groups is how many partitions there are: one group each
+	Groups int
+	Store  *data.BadgerStore
+	// SnapshotDir holds the raft snapshots, in a directory per group.  Empty keeps
+	// them in memory (tests)
+	SnapshotDir string
+	// Transport for a group, see Mux
+	Transport func(group int) (raft.Transport, error)
+	// Partition of a stream's hash key
+	Partition func(key []byte) int
+	// Servers is who should be in the group right now.  The first server
+	// bootstraps a new group.
+	Servers func(group int) []raft.Server
+	// Config for new groups; nil is raft.DefaultConfig
+	Config func() *raft.Config
+	// ApplyTimeout for proposing an append
+	ApplyTimeout time.Duration
+	// Logger nil is zap's global logger
+	Logger *zap.Logger
+
+	lock   sync.Mutex
+	groups map[int]*group
+}
+
+type group struct {
+	raft      *raft.Raft
+	transport raft.Transport
+}
+
+// GroupOf the stream: its partition
+func (m *Manager) GroupOf(s data.StreamID) int {
+	return m.Partition(s.HashKey())
+}
+
+func (m *Manager) group(g int) *group {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.groups[g]
+}
+
+func (m *Manager) applyTimeout() time.Duration {
+	if m.ApplyTimeout == 0 {
+		return 5 * time.Second
+	}
+	return m.ApplyTimeout
+}
+
+// Member is true if the stream's group runs on this node
+func (m *Manager) Member(s data.StreamID) bool {
+	return m.group(m.GroupOf(s)) != nil
+}
+
+// Append proposes the log to the stream's group and waits for it to be applied
+// here.  The error is the append's (see data.IsValidationError), a
+// *NotLeaderError, ErrNotMember, or raft failing.
+func (m *Manager) Append(s data.StreamID, el *proto.CustomerEventLog) error {
+	grp := m.group(m.GroupOf(s))
+	if grp == nil {
+		return ErrNotMember
+	}
+	if grp.raft.State() != raft.Leader {
+		return &NotLeaderError{Leader: leaderID(grp.raft)}
+	}
+	cmd, err := protobuf.Marshal(&proto.RaftAppend{
+		Stream:   &proto.StreamID{AggregateType: s.Type, Key: []byte(s.ID)},
+		Log:      el,
+		Node:     m.NodeID,
+		WallTime: time.Now().UnixNano(),
+	})
+	if err != nil {
+		return err
+	}
+	f := grp.raft.Apply(cmd, m.applyTimeout())
+	if err = f.Error(); err != nil {
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return &NotLeaderError{Leader: leaderID(grp.raft)}
+		}
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Leader of the stream's group, as a node name.  Empty if this node isn't in the
+// group or there is no leader right now.
+func (m *Manager) Leader(s data.StreamID) string {
+	grp := m.group(m.GroupOf(s))
+	if grp == nil {
+		return ""
+	}
+	return leaderID(grp.raft)
+}
+
+// leaderID: raft only hands out the leader's address, the id comes from the configuration
+func leaderID(r *raft.Raft) string {
+	addr := r.Leader()
+	if addr == "" {
+		return ""
+	}
+	f := r.GetConfiguration()
+	if f.Error() != nil {
+		return ""
+	}
+	for _, srv := range f.Configuration().Servers {
+		if srv.Address == addr {
+			return string(srv.ID)
+		}
+	}
+	return ""
+}
+
+func (m *Manager) logger() *zap.Logger {
+	if m.Logger == nil {
+		return zap.L()
+	}
+	return m.Logger
+}
+
+// Reconcile every group with Servers: start the ones this node should be in, drop the
+// ones it has been removed from, and (on the leader) change the voters.
+func (m *Manager) Reconcile() {
+	for g := 0; g < m.Groups; g++ {
+		if err := m.reconcile(g, m.Servers(g)); err != nil {
+			m.logger().Warn("reconciling raft group failed", zap.Int("group", g), zap.Error(err))
+		}
+	}
+}
+
+// Run Reconcile every interval until stop is closed
+func (m *Manager) Run(interval time.Duration, stop <-chan struct{}) {
+	m.Reconcile()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			m.Reconcile()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func contains(servers []raft.Server, id raft.ServerID) bool {
+	for _, srv := range servers {
+		if srv.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) reconcile(g int, servers []raft.Server) error {
+	if len(servers) == 0 {
+		return nil
+	}
+	grp := m.group(g)
+	if grp != nil && grp.raft.State() == raft.Shutdown {
+		// the leader removed us (ShutdownOnRemove)
+		m.stop(g, grp)
+		grp = nil
+	}
+	if grp == nil {
+		if !contains(servers, raft.ServerID(m.NodeID)) {
+			return nil
+		}
+		return m.start(g, servers)
+	}
+	if grp.raft.State() != raft.Leader {
+		return nil
+	}
+
+	f := grp.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return err
+	}
+	current := f.Configuration().Servers
+	for _, srv := range servers {
+		found := false
+		for _, c := range current {
+			if c.ID == srv.ID && c.Address == srv.Address && c.Suffrage == raft.Voter {
+				found = true
+			}
+		}
+		if !found {
+			// AddVoter also updates the address of an existing server
+			if err := grp.raft.AddVoter(srv.ID, srv.Address, 0, m.applyTimeout()).Error(); err != nil {
+				return err
+			}
+		}
+	}
+	// removing ourselves steps down, so do that last
+	var self bool
+	for _, c := range current {
+		if contains(servers, c.ID) {
+			continue
+		}
+		if c.ID == raft.ServerID(m.NodeID) {
+			self = true
+			continue
+		}
+		if err := grp.raft.RemoveServer(c.ID, 0, m.applyTimeout()).Error(); err != nil {
+			return err
+		}
+	}
+	if self {
+		return grp.raft.RemoveServer(raft.ServerID(m.NodeID), 0, m.applyTimeout()).Error()
+	}
+	return nil
+}
+
+// Leave is for draining: it hands leadership of the groups this node leads to another
+// member, then waits for the leaders to take it out of every group (Servers has to
+// leave it out already).  It fails with the groups still holding on after timeout
+func (m *Manager) Leave(timeout time.Duration) error {
+	m.lock.Lock()
+	groups := make(map[int]*group, len(m.groups))
+	for g, grp := range m.groups {
+		groups[g] = grp
+	}
+	m.lock.Unlock()
+	for g, grp := range groups {
+		if grp.raft.State() != raft.Leader {
+			continue
+		}
+		if err := grp.raft.LeadershipTransfer().Error(); err != nil {
+			m.logger().Warn("handing off leadership failed", zap.Int("group", g), zap.Error(err))
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var holding []int
+		for g, grp := range groups {
+			if grp.raft.State() != raft.Shutdown && m.member(grp) {
+				holding = append(holding, g)
+				continue
+			}
+			// out of it: only a leader removing itself shuts down on its own
+			m.stop(g, grp)
+			delete(groups, g)
+		}
+		if len(holding) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			sort.Ints(holding)
+			return fmt.Errorf("still in raft groups %v after %s", holding, timeout)
+		}
+		// a leader that didn't go the first time (no one to go to, yet) may by now
+		for _, g := range holding {
+			if grp := groups[g]; grp.raft.State() == raft.Leader {
+				grp.raft.LeadershipTransfer()
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// member is true while this node is in the group's configuration
+func (m *Manager) member(grp *group) bool {
+	f := grp.raft.GetConfiguration()
+	if f.Error() != nil {
+		return true
+	}
+	return contains(f.Configuration().Servers, raft.ServerID(m.NodeID))
+}
+
+func (m *Manager) config() *raft.Config {
+	var conf *raft.Config
+	if m.Config != nil {
+		conf = m.Config()
+	} else {
+		conf = raft.DefaultConfig()
+	}
+	conf.LocalID = raft.ServerID(m.NodeID)
+	conf.ShutdownOnRemove = true
+	// the store is the state machine and it's durable on its own; with the applied
+	// index next to it there's nothing to restore
+	conf.NoSnapshotRestoreOnStart = true
+	return conf
+}
+
+// start the group.  Only the first of servers bootstraps it (if it has never run
+// here before); everyone else waits to hear from the leader.  That way a node
+// that is added to an existing group can't bootstrap a second one.  Nodes that
+// disagree about membership the very first time a group starts can still each
+// bootstrap; that's the price of not having a seed node.
+func (m *Manager) start(g int, servers []raft.Server) error {
+	conf := m.config()
+	store := NewLogStore(m.Store.LogDB, g)
+	var snaps raft.SnapshotStore
+	if m.SnapshotDir == "" {
+		snaps = raft.NewInmemSnapshotStore()
+	} else {
+		var err error
+		snaps, err = raft.NewFileSnapshotStore(filepath.Join(m.SnapshotDir, strconv.Itoa(g)), 2, nil)
+		if err != nil {
+			return err
+		}
+	}
+	transport, err := m.Transport(g)
+	if err != nil {
+		return err
+	}
+
+	if servers[0].ID == conf.LocalID {
+		existing, err := raft.HasExistingState(store, store, snaps)
+		if err != nil {
+			return err
+		}
+		if !existing {
+			err = raft.BootstrapCluster(conf, store, store, snaps, transport, raft.Configuration{Servers: servers})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	f := &fsm{
+		store: m.Store,
+		group: g,
+		include: func(s data.StreamID) bool {
+			return m.GroupOf(s) == g
+		},
+	}
+	r, err := raft.NewRaft(conf, f, store, store, snaps, transport)
+	if err != nil {
+		closeTransport(transport)
+		return err
+	}
+
+	m.lock.Lock()
+	if m.groups == nil {
+		m.groups = make(map[int]*group)
+	}
+	m.groups[g] = &group{raft: r, transport: transport}
+	m.lock.Unlock()
+	return nil
+}
+
+func closeTransport(t raft.Transport) {
+	if c, ok := t.(raft.WithClose); ok {
+		c.Close()
+	}
+}
+
+func (m *Manager) stop(g int, grp *group) error {
+	err := grp.raft.Shutdown().Error()
+	closeTransport(grp.transport)
+	m.lock.Lock()
+	if m.groups[g] == grp {
+		delete(m.groups, g)
+	}
+	m.lock.Unlock()
+	return err
+}
+
+// Shutdown every group
+func (m *Manager) Shutdown() error {
+	m.lock.Lock()
+	groups := make(map[int]*group, len(m.groups))
+	for g, grp := range m.groups {
+		groups[g] = grp
+	}
+	m.lock.Unlock()
+	var err error
+	for g, grp := range groups {
+		if e := m.stop(g, grp); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package raftgroup
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+var errLayerClosed = errors.New("raft stream layer closed")
+
+// Mux shares one tcp listener between every group on the node.  Each connection
+// starts with the group number (4 bytes, big endian) and is handed to that group's
+// StreamLayer.  Connections for groups this node isn't running are dropped; the
+// other end retries.
+type Mux struct {
+	ln        net.Listener
+	advertise string
+	// TLS to dial the other nodes with; nil is plain tcp.  The listener is the caller's
+	// to wrap
+	TLS *tls.Config
+
+	lock   sync.Mutex
+	layers map[int]*streamLayer
+}
+
+// NewMux serves on ln.  advertise is the address the other nodes dial (what goes in
+// the raft configuration); if it's empty it is the listener's address.
+func NewMux(ln net.Listener, advertise string) *Mux {
+	if advertise == "" {
+		advertise = ln.Addr().String()
+	}
+	m := &Mux{ln: ln, advertise: advertise, layers: make(map[int]*streamLayer)}
+	go m.serve()
+	return m
+}
+
+func (m *Mux) serve() {
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			return
+		}
+		go m.route(conn)
+	}
+}
+
+func (m *Mux) route(conn net.Conn) {
+	var b [4]byte
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	m.lock.Lock()
+	l, ok := m.layers[int(binary.BigEndian.Uint32(b[:]))]
+	m.lock.Unlock()
+	if !ok {
+		conn.Close()
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// Transport for a group, over the mux
+func (m *Mux) Transport(group int) (raft.Transport, error) {
+	l := &streamLayer{
+		mux:    m,
+		group:  group,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	m.lock.Lock()
+	old := m.layers[group]
+	m.layers[group] = l
+	m.lock.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return raft.NewNetworkTransport(l, 3, 10*time.Second, nil), nil
+}
+
+// Close the listener; the group transports are shut down with their groups
+func (m *Mux) Close() error {
+	return m.ln.Close()
+}
+
+// streamLayer is one group's view of the mux
+type streamLayer struct {
+	mux    *Mux
+	group  int
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *streamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if l.mux.TLS != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), l.mux.TLS)
+	} else {
+		conn, err = net.DialTimeout("tcp", string(address), timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(l.group))
+	if _, err = conn.Write(b[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (l *streamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errLayerClosed
+	}
+}
+
+func (l *streamLayer) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.mux.lock.Lock()
+		if l.mux.layers[l.group] == l {
+			delete(l.mux.layers, l.group)
+		}
+		l.mux.lock.Unlock()
+	})
+	return nil
+}
+
+// Addr is the advertised address: raft uses it as this server's address
+func (l *streamLayer) Addr() net.Addr {
+	return tcpAddr(l.mux.advertise)
+}
+
+type tcpAddr string
+
+func (a tcpAddr) Network() string { return "tcp" }
+func (a tcpAddr) String() string  { return string(a) }
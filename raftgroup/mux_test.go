@@ -0,0 +1,64 @@
+package raftgroup_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/raftgroup"
+	"github.com/yarbelk/distributedservice/tlsconfig"
+	"github.com/yarbelk/distributedservice/tlsconfig/tlstest"
+)
+
+func TestMuxTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t, dir, "cluster-ca")
+
+	const n = 3
+	muxes := make([]*raftgroup.Mux, n)
+	servers := make([]raft.Server, n)
+	for i := range muxes {
+		name := fmt.Sprintf("node-%d", i)
+		cert := ca.Issue(t, dir, name)
+		certs, err := tlsconfig.Load(tlsconfig.Config{Cert: cert.Cert, Key: cert.Key, CA: ca.File})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis, err := tls.Listen("tcp", "127.0.0.1:0", certs.PeerServerConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		muxes[i] = raftgroup.NewMux(lis, "")
+		muxes[i].TLS = certs.ClientConfig()
+		t.Cleanup(func() { lis.Close() })
+		servers[i] = raft.Server{ID: raft.ServerID(name), Address: raft.ServerAddress(lis.Addr().String())}
+	}
+
+	c := &testCluster{}
+	for i, mux := range muxes {
+		store := data.New(t.TempDir())
+		m := &raftgroup.Manager{
+			NodeID:    string(servers[i].ID),
+			Groups:    1,
+			Store:     store,
+			Transport: mux.Transport,
+			Partition: func([]byte) int { return 0 },
+			Servers:   func(int) []raft.Server { return servers },
+			Config:    fastConfig,
+		}
+		m.Reconcile()
+		c.nodes = append(c.nodes, m)
+		t.Cleanup(func() {
+			m.Shutdown()
+			store.Close()
+		})
+	}
+
+	leader := c.leader(t, c.nodes)
+	if err := appendLog(leader, 0, "over tls"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, c.nodes, 0)
+}
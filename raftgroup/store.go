@@ -0,0 +1,234 @@
+package raftgroup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/raft"
+	"github.com/yarbelk/distributedservice/data"
+)
+
+// errNotFound has to be exactly this: raft checks the error string for missing stable keys
+var errNotFound = errors.New("not found")
+
+// Keys for a group all start with
+//
+//	data.RaftKeyspace | uvarint(group)
+//
+// followed by 'l' | index (8 bytes, big endian) for raft log entries, 's' | key for
+// the stable store, and 'a' for the index of the last command applied to the store.
+const (
+	logSuffix     byte = 'l'
+	stableSuffix  byte = 's'
+	appliedSuffix byte = 'a'
+)
+
+func groupPrefix(group int) []byte {
+	var b [1 + binary.MaxVarintLen64]byte
+	b[0] = data.RaftKeyspace
+	n := binary.PutUvarint(b[1:], uint64(group))
+	return b[:1+n]
+}
+
+func appliedKey(group int) []byte {
+	return append(groupPrefix(group), appliedSuffix)
+}
+
+// LogStore is a raft.LogStore and raft.StableStore that lives in the node's badger
+// LogDB, next to the data it is replicating.  One per group; they share the db.
+type LogStore struct {
+	db         *badger.DB
+	logPrefix  []byte
+	stablePref []byte
+}
+
+func NewLogStore(db *badger.DB, group int) *LogStore {
+	return &LogStore{
+		db:         db,
+		logPrefix:  append(groupPrefix(group), logSuffix),
+		stablePref: append(groupPrefix(group), stableSuffix),
+	}
+}
+
+func (s *LogStore) logKey(index uint64) []byte {
+	k := make([]byte, len(s.logPrefix)+8)
+	copy(k, s.logPrefix)
+	binary.BigEndian.PutUint64(k[len(s.logPrefix):], index)
+	return k
+}
+
+// encodeLog is a fixed layout, no need for a codec:
+//
+//	index | term | type (1 byte) | appendedAt unix nanos | uvarint len data | data | uvarint len extensions | extensions
+func encodeLog(l *raft.Log) []byte {
+	b := make([]byte, 25, 41+len(l.Data)+len(l.Extensions))
+	binary.BigEndian.PutUint64(b[0:], l.Index)
+	binary.BigEndian.PutUint64(b[8:], l.Term)
+	b[16] = byte(l.Type)
+	var at int64
+	if !l.AppendedAt.IsZero() {
+		at = l.AppendedAt.UnixNano()
+	}
+	binary.BigEndian.PutUint64(b[17:], uint64(at))
+	var lb [binary.MaxVarintLen64]byte
+	for _, chunk := range [][]byte{l.Data, l.Extensions} {
+		n := binary.PutUvarint(lb[:], uint64(len(chunk)))
+		b = append(b, lb[:n]...)
+		b = append(b, chunk...)
+	}
+	return b
+}
+
+func decodeLog(b []byte, l *raft.Log) error {
+	if len(b) < 25 {
+		return fmt.Errorf("raft log entry too short")
+	}
+	l.Index = binary.BigEndian.Uint64(b[0:])
+	l.Term = binary.BigEndian.Uint64(b[8:])
+	l.Type = raft.LogType(b[16])
+	l.AppendedAt = time.Time{}
+	if at := int64(binary.BigEndian.Uint64(b[17:])); at != 0 {
+		l.AppendedAt = time.Unix(0, at)
+	}
+	rest := b[25:]
+	chunks := make([][]byte, 2)
+	for i := range chunks {
+		n, read := binary.Uvarint(rest)
+		if read <= 0 || uint64(len(rest)-read) < n {
+			return fmt.Errorf("raft log entry is corrupt")
+		}
+		if n > 0 {
+			chunks[i] = append([]byte(nil), rest[read:read+int(n)]...)
+		}
+		rest = rest[read+int(n):]
+	}
+	l.Data, l.Extensions = chunks[0], chunks[1]
+	return nil
+}
+
+func (s *LogStore) edgeIndex(reverse bool) (uint64, error) {
+	var index uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = reverse
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		if reverse {
+			it.Seek(s.logKey(math.MaxUint64))
+		} else {
+			it.Seek(s.logPrefix)
+		}
+		if it.ValidForPrefix(s.logPrefix) {
+			index = binary.BigEndian.Uint64(it.Item().Key()[len(s.logPrefix):])
+		}
+		return nil
+	})
+	return index, err
+}
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (s *LogStore) FirstIndex() (uint64, error) {
+	return s.edgeIndex(false)
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (s *LogStore) LastIndex() (uint64, error) {
+	return s.edgeIndex(true)
+}
+
+// GetLog gets a log entry at a given index.
+func (s *LogStore) GetLog(index uint64, log *raft.Log) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(s.logKey(index))
+		if err == badger.ErrKeyNotFound {
+			return raft.ErrLogNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return decodeLog(v, log)
+		})
+	})
+}
+
+// StoreLog stores a log entry.
+func (s *LogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries.
+func (s *LogStore) StoreLogs(logs []*raft.Log) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		for _, l := range logs {
+			if err := txn.Set(s.logKey(l.Index), encodeLog(l)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRange deletes a range of log entries. The range is inclusive.
+func (s *LogStore) DeleteRange(min, max uint64) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for i := min; i <= max && i >= min; i++ {
+		if err := wb.Delete(s.logKey(i)); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *LogStore) stableKey(key []byte) []byte {
+	return append(append([]byte(nil), s.stablePref...), key...)
+}
+
+// Set a stable key
+func (s *LogStore) Set(key []byte, val []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(s.stableKey(key), val)
+	})
+}
+
+// Get returns the value for key, or an empty byte slice if key was not found.
+func (s *LogStore) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(s.stableKey(key))
+		if err == badger.ErrKeyNotFound {
+			return errNotFound
+		}
+		if err != nil {
+			return err
+		}
+		val, err = item.ValueCopy(nil)
+		return err
+	})
+	return val, err
+}
+
+// SetUint64 a stable key
+func (s *LogStore) SetUint64(key []byte, val uint64) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], val)
+	return s.Set(key, b[:])
+}
+
+// GetUint64 returns the uint64 value for key, or 0 if key was not found.
+func (s *LogStore) GetUint64(key []byte) (uint64, error) {
+	b, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		return 0, fmt.Errorf("stable key %q isn't a uint64", key)
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
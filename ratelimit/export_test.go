@@ -0,0 +1,17 @@
+package ratelimit
+
+import "time"
+
+// exported for the ratelimit_test package only
+
+// SetClock for l's buckets
+func (l *Limiter) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+// Buckets l is keeping
+func (l *Limiter) Buckets() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.buckets)
+}
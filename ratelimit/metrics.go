@@ -0,0 +1,21 @@
+package ratelimit
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	checked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ratelimit",
+		Name:      "checked_total",
+		Help:      "Calls checked against a limit, by kind (client or customer), limit (the method or service it is for) and result (allowed or limited)",
+	}, []string{"kind", "limit", "result"})
+	bucketCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ratelimit",
+		Name:      "buckets",
+		Help:      "Token buckets being kept, by kind: roughly how many clients and customers have been busy lately",
+	}, []string{"kind"})
+)
+
+// Metrics for a prometheus registry: how much of the limits is being used
+func Metrics() []prometheus.Collector {
+	return []prometheus.Collector{checked, bucketCount}
+}
@@ -0,0 +1,295 @@
+// Package ratelimit is token buckets in front of the grpc apis: one per client and
+// one per customer (stream), for the methods that have limits.  A call over either
+// gets ResourceExhausted, with a retry-after header saying how many seconds until
+// it would get through.
+//
+// Limits are by method (proto.ProtoStuff/WriteLog) or by service (proto.ProtoStuff),
+// the method's winning.  A service's limit is one bucket for all its methods.
+//
+// The client is the token's subject with auth, otherwise the client certificate's
+// common name, otherwise the address it calls from.  Nodes calling each other (with
+// node tokens) aren't limited.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yarbelk/distributedservice/auth"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/rpcname"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultIdle is how long a bucket is kept after its last call
+const DefaultIdle = 10 * time.Minute
+
+// RetryAfterHeader is the header with the seconds to wait, on ResourceExhausted
+const RetryAfterHeader = "retry-after"
+
+// Limit is a token bucket: Rate calls a second, up to Burst at once
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimits in the method=rate[:burst] form they're configured in.  Burst is the
+// rate (at least 1) if it isn't given
+func ParseLimits(specs []string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("limit %q: isn't method=rate[:burst]", spec)
+		}
+		method, l := strings.TrimPrefix(spec[:i], "/"), spec[i+1:]
+		var limit Limit
+		var err error
+		burst := ""
+		if j := strings.Index(l, ":"); j >= 0 {
+			l, burst = l[:j], l[j+1:]
+		}
+		if limit.Rate, err = strconv.ParseFloat(l, 64); err != nil || limit.Rate <= 0 {
+			return nil, fmt.Errorf("limit %q: the rate has to be a number more than 0", spec)
+		}
+		limit.Burst = int(math.Max(1, math.Ceil(limit.Rate)))
+		if burst != "" {
+			if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+				return nil, fmt.Errorf("limit %q: the burst has to be a whole number more than 0", spec)
+			}
+		}
+		if _, ok := limits[method]; ok {
+			return nil, fmt.Errorf("limit %q: %s has a limit already", spec, method)
+		}
+		limits[method] = limit
+	}
+	return limits, nil
+}
+
+// Config is the limits, by method or service
+type Config struct {
+	Client   map[string]Limit
+	Customer map[string]Limit
+	// Idle buckets are forgotten after this long; 0 is DefaultIdle
+	Idle time.Duration
+
+	Logger *zap.Logger
+}
+
+// Limiter has the buckets
+type Limiter struct {
+	client   map[string]Limit
+	customer map[string]Limit
+	idle     time.Duration
+	log      *zap.Logger
+	// now is time.Now, bar tests
+	now func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	*rate.Limiter
+	kind string
+	used time.Time
+}
+
+// New limiter for c; nil if there are no limits
+func New(c Config) *Limiter {
+	if len(c.Client) == 0 && len(c.Customer) == 0 {
+		return nil
+	}
+	if c.Idle <= 0 {
+		c.Idle = DefaultIdle
+	}
+	return &Limiter{
+		client:   c.Client,
+		customer: c.Customer,
+		idle:     c.Idle,
+		log:      logging.Or(c.Logger).Named("ratelimit"),
+		now:      time.Now,
+		buckets:  map[string]*bucket{},
+	}
+}
+
+// limitFor method: its own, or its service's.  name is which
+func limitFor(limits map[string]Limit, method string) (string, Limit, bool) {
+	for _, name := range rpcname.Names(method) {
+		if l, ok := limits[name]; ok {
+			return name, l, true
+		}
+	}
+	return "", Limit{}, false
+}
+
+// take a token from the bucket for (kind, name, who); how long until there's one
+// if there isn't.  The reservation is for giving it back
+func (l *Limiter) take(kind, name, who string, limit Limit) (*rate.Reservation, time.Duration, bool) {
+	now := l.now()
+	key := kind + "\x00" + name + "\x00" + who
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{Limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst), kind: kind}
+		l.buckets[key] = b
+		bucketCount.WithLabelValues(kind).Inc()
+	}
+	b.used = now
+	r := b.ReserveN(now, 1)
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		checked.WithLabelValues(kind, name, "limited").Inc()
+		return nil, d, false
+	}
+	checked.WithLabelValues(kind, name, "allowed").Inc()
+	return r, 0, true
+}
+
+// giveBack a token taken for a call that was turned away after all
+func (l *Limiter) giveBack(r *rate.Reservation) {
+	if r == nil {
+		return
+	}
+	now := l.now()
+	l.lock.Lock()
+	r.CancelAt(now)
+	l.lock.Unlock()
+}
+
+// sweep away buckets nobody has used for a while (they're full again anyway), once
+// every idle
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.used) >= l.idle {
+			delete(l.buckets, key)
+			bucketCount.WithLabelValues(b.kind).Dec()
+		}
+	}
+}
+
+// client making the call in ctx; false for nodes
+func client(ctx context.Context) (string, bool) {
+	if claims := auth.FromContext(ctx); claims != nil {
+		if claims.Allows(auth.ScopeNode) {
+			return "", false
+		}
+		if claims.Subject != "" {
+			return "sub:" + claims.Subject, true
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown", true
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		return "cert:" + info.State.PeerCertificates[0].Subject.CommonName, true
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return "addr:" + host, true
+	}
+	return "addr:" + p.Addr.String(), true
+}
+
+func exhausted(setHeader func(metadata.MD) error, wait time.Duration, what string) error {
+	secs := int(math.Ceil(wait.Seconds()))
+	setHeader(metadata.Pairs(RetryAfterHeader, strconv.Itoa(secs)))
+	return status.Errorf(codes.ResourceExhausted, "%s: rate limited; retry after %ds", what, secs)
+}
+
+// allowClient checks the client's bucket for method
+func (l *Limiter) allowClient(ctx context.Context, method string, setHeader func(metadata.MD) error) (*rate.Reservation, error) {
+	name, limit, ok := limitFor(l.client, method)
+	if !ok {
+		return nil, nil
+	}
+	who, ok := client(ctx)
+	if !ok {
+		return nil, nil
+	}
+	r, wait, ok := l.take("client", name, who, limit)
+	if !ok {
+		logging.FromContext(ctx, l.log).Debug("client rate limited", zap.String("client", who), zap.String("limit", name))
+		return nil, exhausted(setHeader, wait, "client")
+	}
+	return r, nil
+}
+
+// allowCustomer checks the bucket for req's stream, for method
+func (l *Limiter) allowCustomer(ctx context.Context, method string, req interface{}, setHeader func(metadata.MD) error) error {
+	name, limit, ok := limitFor(l.customer, method)
+	if !ok {
+		return nil
+	}
+	stream, ok := data.RequestStream(req)
+	if !ok {
+		return nil
+	}
+	if _, ok := client(ctx); !ok {
+		return nil
+	}
+	if _, wait, ok := l.take("customer", name, stream.Type+"\x00"+stream.ID, limit); !ok {
+		logging.FromContext(ctx, l.log).Debug("customer rate limited", zap.Stringer("stream", stream), zap.String("limit", name))
+		return exhausted(setHeader, wait, "customer")
+	}
+	return nil
+}
+
+// UnaryInterceptor takes a token from the client's and the customer's buckets.  Put
+// it after auth, which says who the client is
+func (l *Limiter) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	setHeader := func(md metadata.MD) error { return grpc.SetHeader(ctx, md) }
+	r, err := l.allowClient(ctx, info.FullMethod, setHeader)
+	if err != nil {
+		return nil, err
+	}
+	// a call the customer's limit turns away doesn't count against the client
+	if err := l.allowCustomer(ctx, info.FullMethod, req, setHeader); err != nil {
+		l.giveBack(r)
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor is UnaryInterceptor for streams: the client's bucket when the
+// stream opens, the customer's for each request on it
+func (l *Limiter) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if _, err := l.allowClient(ss.Context(), info.FullMethod, ss.SetHeader); err != nil {
+		return err
+	}
+	return handler(srv, &limitedStream{ServerStream: ss, limiter: l, method: info.FullMethod})
+}
+
+type limitedStream struct {
+	grpc.ServerStream
+	limiter *Limiter
+	method  string
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.limiter.allowCustomer(s.Context(), s.method, m, s.SetHeader)
+}
@@ -0,0 +1,219 @@
+package ratelimit_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yarbelk/distributedservice/auth"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ratelimit.ParseLimits([]string{
+		"proto.ProtoStuff/WriteLog=10:20",
+		"/proto.EventStore=0.5",
+		"proto.ProtoStuff/CustomerState=2.5",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]ratelimit.Limit{
+		"proto.ProtoStuff/WriteLog":      {Rate: 10, Burst: 20},
+		"proto.EventStore":               {Rate: 0.5, Burst: 1},
+		"proto.ProtoStuff/CustomerState": {Rate: 2.5, Burst: 3},
+	}
+	for method, l := range expected {
+		if limits[method] != l {
+			t.Errorf("%s: expected %v, got %v", method, l, limits[method])
+		}
+	}
+
+	for _, bad := range []string{"WriteLog", "=10", "x=0", "x=ten", "x=1:0", "x=1:1.5"} {
+		if _, err := ratelimit.ParseLimits([]string{bad}); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+	if _, err := ratelimit.ParseLimits([]string{"x=1", "x=2"}); err == nil {
+		t.Error("expected an error for a method given twice")
+	}
+}
+
+type clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	c.lock.Unlock()
+}
+
+var key = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+
+// serve unimplemented services behind auth and l: Unimplemented got through
+func serve(t *testing.T, l *ratelimit.Limiter) proto.ProtoStuffClient {
+	t.Helper()
+	a, err := auth.New(auth.Config{Keys: []string{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(a.UnaryInterceptor, l.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(a.StreamInterceptor, l.StreamInterceptor),
+	)
+	proto.RegisterProtoStuffServer(srv, proto.UnimplementedProtoStuffServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewProtoStuffClient(conn)
+}
+
+func as(t *testing.T, sub, scope string) context.Context {
+	t.Helper()
+	k, _ := auth.ParseKey(key)
+	token, err := auth.Sign(k, auth.Claims{
+		StandardClaims: jwt.StandardClaims{Subject: sub, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		Scope:          scope,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestLimits(t *testing.T) {
+	l := ratelimit.New(ratelimit.Config{
+		Client: map[string]ratelimit.Limit{
+			"proto.ProtoStuff/WriteLog": {Rate: 1, Burst: 2},
+			// one bucket for all of them
+			"proto.ProtoStuff": {Rate: 1, Burst: 1},
+		},
+		Customer: map[string]ratelimit.Limit{
+			"proto.ProtoStuff/CustomerState":  {Rate: 0.5, Burst: 1},
+			"proto.ProtoStuff/StreamEventLog": {Rate: 0.5, Burst: 1},
+		},
+		Idle: time.Hour,
+	})
+	c := &clock{now: time.Now()}
+	l.SetClock(c.Now)
+	client := serve(t, l)
+
+	alice := as(t, "alice", "read:customer write:customer")
+	bob := as(t, "bob", "read:customer write:customer")
+	node := as(t, "a", "node")
+	write := func(ctx context.Context) (codes.Code, string) {
+		var header metadata.MD
+		_, err := client.WriteLog(ctx, &proto.NewCustomerLog{CustomerID: 1}, grpc.Header(&header))
+		return status.Code(err), strings.Join(header.Get(ratelimit.RetryAfterHeader), ",")
+	}
+	check := func(name string, got codes.Code, expected codes.Code) {
+		t.Helper()
+		if got != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, got)
+		}
+	}
+
+	// alice's burst, then she has to wait
+	for i := 0; i < 2; i++ {
+		code, _ := write(alice)
+		check("alice's burst", code, codes.Unimplemented)
+	}
+	code, retry := write(alice)
+	check("alice over", code, codes.ResourceExhausted)
+	if retry != "1" {
+		t.Errorf("expected retry-after 1, got %q", retry)
+	}
+	// bob has his own bucket, and nodes don't have one
+	code, _ = write(bob)
+	check("bob", code, codes.Unimplemented)
+	for i := 0; i < 5; i++ {
+		code, _ = write(node)
+		check("node", code, codes.Unimplemented)
+	}
+	c.Add(time.Second)
+	code, _ = write(alice)
+	check("alice a second later", code, codes.Unimplemented)
+
+	// the service's limit is shared between its other methods
+	_, err := client.CustomerConflicts(bob, &proto.Customer{Id: 1})
+	check("bob's conflicts", status.Code(err), codes.Unimplemented)
+	_, err = client.ResolveCustomerConflicts(bob, &proto.ResolveCustomerConflicts{CustomerID: 1})
+	check("bob's resolve", status.Code(err), codes.ResourceExhausted)
+
+	// customer limits are per customer, whoever is asking
+	c.Add(time.Hour)
+	_, err = client.CustomerState(alice, &proto.Customer{Id: 7})
+	check("customer 7", status.Code(err), codes.Unimplemented)
+	c.Add(time.Second)
+	_, err = client.CustomerState(bob, &proto.Customer{Id: 7})
+	check("customer 7 again", status.Code(err), codes.ResourceExhausted)
+	_, err = client.CustomerState(bob, &proto.Customer{Key: []byte("8")})
+	check("customer 8", status.Code(err), codes.Unimplemented)
+
+	c.Add(time.Second)
+	_, err = client.CustomerState(alice, &proto.Customer{Id: 7})
+	check("customer 7 after 2s", status.Code(err), codes.Unimplemented)
+
+	// a stream's request counts against its customer
+	c.Add(time.Hour)
+	stream := func(ctx context.Context) codes.Code {
+		s, err := client.StreamEventLog(ctx, &proto.Customer{Id: 9})
+		if err == nil {
+			_, err = s.Recv()
+		}
+		return status.Code(err)
+	}
+	check("stream 9", stream(alice), codes.Unimplemented)
+	check("stream 9 again", stream(bob), codes.ResourceExhausted)
+}
+
+func TestIdleBuckets(t *testing.T) {
+	l := ratelimit.New(ratelimit.Config{
+		Client: map[string]ratelimit.Limit{"proto.ProtoStuff": {Rate: 1, Burst: 1}},
+		Idle:   time.Minute,
+	})
+	c := &clock{now: time.Now()}
+	l.SetClock(c.Now)
+	client := serve(t, l)
+
+	for _, sub := range []string{"a", "b", "c"} {
+		client.CustomerState(as(t, sub, "read:customer"), &proto.Customer{Id: 1})
+	}
+	if n := l.Buckets(); n != 3 {
+		t.Errorf("expected 3 buckets, got %d", n)
+	}
+	c.Add(2 * time.Minute)
+	client.CustomerState(as(t, "d", "read:customer"), &proto.Customer{Id: 1})
+	if n := l.Buckets(); n != 1 {
+		t.Errorf("expected the idle buckets to go, got %d", n)
+	}
+	if ratelimit.New(ratelimit.Config{}) != nil {
+		t.Error("expected no limiter without limits")
+	}
+}
@@ -0,0 +1,33 @@
+// Package rpcname takes grpc's full method names, "/pkg.Service/Method", apart one
+// way for everything that decides on a call by its service or method: node only
+// services, methods open without a token, limits, admission, readiness, metrics and
+// spans
+package rpcname
+
+import "strings"
+
+// Split a full method name into its service and method.  A name without a method is
+// all service
+func Split(full string) (service, method string) {
+	full = strings.TrimPrefix(full, "/")
+	if i := strings.LastIndex(full, "/"); i >= 0 {
+		return full[:i], full[i+1:]
+	}
+	return full, ""
+}
+
+// Service of a full method name
+func Service(full string) string {
+	service, _ := Split(full)
+	return service
+}
+
+// Names a setting for a call can be under, the method's first: "pkg.Service/Method",
+// then "pkg.Service"
+func Names(full string) []string {
+	service, method := Split(full)
+	if method == "" {
+		return []string{service}
+	}
+	return []string{service + "/" + method, service}
+}
@@ -0,0 +1,27 @@
+package rpcname_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/rpcname"
+)
+
+func TestSplit(t *testing.T) {
+	for _, tt := range []struct {
+		full, service, method string
+		names                 []string
+	}{
+		{"/proto.ProtoStuff/WriteLog", "proto.ProtoStuff", "WriteLog", []string{"proto.ProtoStuff/WriteLog", "proto.ProtoStuff"}},
+		{"proto.ProtoStuff/WriteLog", "proto.ProtoStuff", "WriteLog", []string{"proto.ProtoStuff/WriteLog", "proto.ProtoStuff"}},
+		{"/grpc.health.v1.Health", "grpc.health.v1.Health", "", []string{"grpc.health.v1.Health"}},
+	} {
+		service, method := rpcname.Split(tt.full)
+		if service != tt.service || method != tt.method {
+			t.Errorf("%s: expected %s and %s, got %s and %s", tt.full, tt.service, tt.method, service, method)
+		}
+		if names := rpcname.Names(tt.full); !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: expected names %v, got %v", tt.full, tt.names, names)
+		}
+	}
+}
@@ -0,0 +1,750 @@
+// Package server puts a node together: storage, memberlist, the ring, replication and
+// the grpc apis; and takes it apart again in the right order.  main is the config
+// package around this, and tests can run whole nodes in process with it.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yarbelk/distributedservice/auth"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/gateway"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/raftgroup"
+	"github.com/yarbelk/distributedservice/ratelimit"
+	"github.com/yarbelk/distributedservice/service"
+	"github.com/yarbelk/distributedservice/tlsconfig"
+	"github.com/yarbelk/distributedservice/tracing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// healthInterval is how often the health checks run
+const healthInterval = time.Second
+
+// DefaultShutdownTimeout is how long in flight rpcs get to finish when shutting down
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Config of a node.  The zero values are mostly not useful; config.Default has the defaults.
+type Config struct {
+	// Memberlist config: its Name is the node's name.  Delegate and Events are set by New
+	Memberlist *memberlist.Config
+	// Join these gossip addresses after starting
+	Join []string
+	// ClusterName and ClusterID are gossiped; nodes with different ones aren't let in
+	ClusterName string
+	ClusterID   string
+	// GossipKeys (base64, the primary first) encrypt gossip; none is plaintext.  With a
+	// KeyringFile they're only used if it doesn't exist yet: it has the keys as the
+	// keyring rpcs leave them
+	GossipKeys  []string
+	KeyringFile string
+
+	// Address to serve grpc on; Advertise is the address gossiped for it, if that can't
+	// be worked out from Address
+	Address   string
+	Advertise string
+
+	Partitions        int
+	ReplicationFactor int
+
+	DataDir string
+	// Badger options for the store; nil is badger's defaults.  DataDir is used for its
+	// directories either way
+	Badger *badger.Options
+	// Resolver for conflicting replicated logs; nil keeps siblings
+	Resolver  data.ConflictResolver
+	HintLimit int
+	HintTTL   time.Duration
+
+	// AntiEntropyInterval of 0 is no anti-entropy
+	AntiEntropyInterval time.Duration
+	AntiEntropyRate     float64
+
+	// Raft orders appends through a raft group per partition; false is best effort
+	// replication
+	Raft        bool
+	RaftAddress string
+	RaftDir     string
+
+	// DebugAddress serves /metrics (prometheus) and /debug/vars (expvar); empty is off
+	DebugAddress string
+	// HTTPAddress serves the apis as http and json (see gateway); empty is off
+	HTTPAddress string
+	// EventsHeartbeat and EventsSendBuffer are the gateway's Heartbeat and
+	// SendBuffer; 0 is its default
+	EventsHeartbeat  time.Duration
+	EventsSendBuffer int
+
+	// The node isn't ready until it sees MinMembers members (itself included), and
+	// membership hasn't changed for ReadySettle
+	MinMembers  int
+	ReadySettle time.Duration
+
+	// MaxHealthScore is the memberlist health score past which the services that
+	// need the cluster report NOT_SERVING
+	MaxHealthScore int
+	// Admission: calls to the apis are turned away (low priority ones first) when
+	// there are MaxInFlight of them, writes are taking MaxWriteLatency, or the heap is
+	// MaxHeap bytes.  Zero doesn't look at that one; see service.Admission
+	MaxInFlight     int
+	MaxWriteLatency time.Duration
+	MaxHeap         uint64
+	// Reflection registers grpc server reflection, for grpcurl and friends
+	Reflection bool
+	// TLS for grpc and raft, and calling other nodes; nil is plaintext
+	TLS *tlsconfig.Config
+	// Auth is the keys for the apis' bearer tokens; nil (or no keys) is no auth.  The
+	// nodes sign their own tokens with them to call each other
+	Auth *auth.Config
+	// RateLimit for the apis, per client and per customer; nil is no limits
+	RateLimit *ratelimit.Config
+
+	// Logger for everything; nil is zap's global logger.  It's given to memberlist too,
+	// unless its config has a LogOutput or Logger already
+	Logger *zap.Logger
+	// LogLevel of Logger, for the log level rpcs; nil turns them off
+	LogLevel *zap.AtomicLevel
+
+	// ShutdownTimeout is how long in flight rpcs and streams get before they're cut off.
+	// Zero is DefaultShutdownTimeout
+	ShutdownTimeout time.Duration
+}
+
+// Server is a running node
+type Server struct {
+	config Config
+	log    *zap.Logger
+
+	store      *data.BadgerStore
+	members    *memberlist.Memberlist
+	peers      *cluster.Peers
+	handoff    *service.Handoff
+	groups     *raftgroup.Manager
+	mux        *raftgroup.Mux
+	grpc       *grpc.Server
+	lis        net.Listener
+	debug      *http.Server
+	debugLis   net.Listener
+	gateway    *gateway.Gateway
+	http       *http.Server
+	httpLis    net.Listener
+	httpConn   *grpc.ClientConn
+	metrics    *prometheus.Registry
+	aggregates *service.Aggregates
+	ring       *service.Ring
+	health     *health.Server
+	readiness  *service.Readiness
+	checks     *service.HealthChecks
+	ae         *service.AntiEntropy
+	certs      *tlsconfig.Certs
+	auth       *auth.Authenticator
+
+	// background loops (anti-entropy, raft reconciling) stop when stop is closed
+	stop       chan struct{}
+	background sync.WaitGroup
+
+	shutdown    sync.Once
+	done        chan struct{}
+	shutdownErr error
+}
+
+// advertiseAddr is the grpc address to gossip.  An explicit -advertise wins, then
+// the bind address if it is a real one, otherwise the gossip ip with the bind port.
+func advertiseAddr(advertise, bind string, gossipIP net.IP) string {
+	if advertise != "" {
+		return advertise
+	}
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return bind
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return bind
+	}
+	return net.JoinHostPort(gossipIP.String(), port)
+}
+
+// New starts everything but the grpc server: call Serve for that.  If it fails part
+// way, what it started is shut down again.
+func New(cfg Config) (*Server, error) {
+	if cfg.Memberlist == nil {
+		return nil, fmt.Errorf("no memberlist config")
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	s := &Server{
+		config: cfg,
+		log:    logging.Or(cfg.Logger).With(zap.String("node", cfg.Memberlist.Name)),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := s.start(); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) start() error {
+	cfg := s.config
+	mlConfig := cfg.Memberlist
+
+	// the store and peers come before the memberlist: Handoff gets join events
+	// from the moment it's created
+	badgerOpts := badger.DefaultOptions(cfg.DataDir)
+	if cfg.Badger != nil {
+		badgerOpts = *cfg.Badger
+		badgerOpts.Dir, badgerOpts.ValueDir = cfg.DataDir, cfg.DataDir
+	}
+	store, err := data.Open(badgerOpts)
+	if err != nil {
+		return err
+	}
+	s.store = store
+	s.store.NodeID = mlConfig.Name
+	s.store.HintLimit = cfg.HintLimit
+	s.store.HintTTL = cfg.HintTTL
+	s.store.Resolver = cfg.Resolver
+	s.store.Logger = s.log.Named("data")
+	dialOptions := []grpc.DialOption{grpc.WithInsecure()}
+	if cfg.TLS != nil {
+		tlsConfig := *cfg.TLS
+		if tlsConfig.Logger == nil {
+			tlsConfig.Logger = s.log
+		}
+		if s.certs, err = tlsconfig.Load(tlsConfig); err != nil {
+			return err
+		}
+		// replication and draining are between nodes
+		s.certs.NodeOnly(proto.Replication_ServiceDesc.ServiceName, proto.ClusterManagment_ServiceDesc.ServiceName)
+		dialOptions[0] = grpc.WithTransportCredentials(s.certs.Credentials())
+	}
+	if cfg.Auth != nil {
+		authConfig := *cfg.Auth
+		if authConfig.Logger == nil {
+			authConfig.Logger = s.log
+		}
+		if s.auth, err = auth.New(authConfig); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(s.auth.NodeCredentials(mlConfig.Name)))
+	}
+	dialOptions = append(dialOptions, tracing.DialOptions()...)
+	dialOptions = append(dialOptions, logging.DialOptions()...)
+	s.peers = &cluster.Peers{DialOptions: dialOptions}
+
+	// the ring follows memberlist from here on (service.Ring): this node goes on when
+	// the memberlist is created, everyone else as they're seen
+	ch := service.NewHashList(cfg.Partitions, cfg.ReplicationFactor)
+
+	// grab the grpc port now, so the advertised address is a real one even with port 0
+	lis, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return err
+	}
+	s.lis = lis
+
+	s.health = health.NewServer()
+	s.readiness = service.NewReadiness(s.health,
+		healthpb.Health_ServiceDesc.ServiceName,
+		proto.Replication_ServiceDesc.ServiceName,
+	)
+	s.readiness.Logger = s.log
+
+	keys, err := cluster.LoadKeys(cfg.KeyringFile, cfg.GossipKeys)
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		mlConfig.Keyring = keys.Keyring
+	}
+	delegate := &cluster.Delegate{Meta: cluster.Meta{Cluster: cfg.ClusterName, ClusterID: cfg.ClusterID}}
+	sameCluster := cluster.SameCluster{Name: cfg.ClusterName, ID: cfg.ClusterID}
+	mlConfig.Alive = sameCluster
+	mlConfig.Merge = sameCluster
+	s.ring = &service.Ring{HashList: ch}
+	s.handoff = &service.Handoff{Hints: s.store, Peers: s.peers, Logger: s.log.Named("handoff")}
+	if mlConfig.LogOutput == nil && mlConfig.Logger == nil {
+		mlConfig.Logger = logging.StdLogger(s.log.Named("memberlist"))
+	}
+	mlConfig.Delegate = delegate
+	mlConfig.Events = cluster.Events{
+		s.ring,
+		s.handoff,
+		s.readiness,
+	}
+	s.members, err = memberlist.Create(mlConfig)
+	if err != nil {
+		return err
+	}
+	s.ring.MemberList = s.members
+	var raftLis net.Listener
+	if cfg.Raft {
+		if raftLis, err = net.Listen("tcp", cfg.RaftAddress); err != nil {
+			return err
+		}
+		if s.certs != nil {
+			raftLis = tls.NewListener(raftLis, s.certs.PeerServerConfig())
+		}
+	}
+	// the gossip address isn't known until memberlist has picked it; so tell everyone
+	// where the grpc server is after the fact.
+	local := s.members.LocalNode()
+	var raftAddr string
+	if raftLis != nil {
+		raftAddr = advertiseAddr("", raftLis.Addr().String(), local.Addr)
+	}
+	grpcAddr := advertiseAddr(cfg.Advertise, lis.Addr().String(), local.Addr)
+	delegate.Update(func(meta *cluster.Meta) {
+		meta.GRPCAddr = grpcAddr
+		meta.RaftAddr = raftAddr
+	})
+	if err = s.members.UpdateNode(time.Second); err != nil {
+		return err
+	}
+	if len(cfg.Join) > 0 {
+		if _, err = s.members.Join(cfg.Join); err != nil {
+			return err
+		}
+	}
+
+	s.aggregates = &service.Aggregates{
+		Storage:           s.store,
+		MemberList:        s.members,
+		HashList:          ch,
+		ReplicationFactor: cfg.ReplicationFactor,
+		Peers:             s.peers,
+		Hints:             s.store,
+		FromNode:          s.fromNode,
+		Logger:            s.log,
+	}
+	if raftLis != nil {
+		s.mux = raftgroup.NewMux(raftLis, raftAddr)
+		if s.certs != nil {
+			s.mux.TLS = s.certs.ClientConfig()
+		}
+		s.groups = &raftgroup.Manager{
+			NodeID:      local.Name,
+			Groups:      cfg.Partitions,
+			Store:       s.store,
+			SnapshotDir: filepath.Join(cfg.RaftDir, local.Name),
+			Transport:   s.mux.Transport,
+			Partition:   ch.FindPartitionID,
+			Servers:     s.aggregates.GroupServers,
+			Logger:      s.log.Named("raft"),
+		}
+		s.goBackground(func() { s.groups.Run(5*time.Second, s.stop) })
+		s.aggregates.Consensus = s.groups
+	}
+	replication := &service.Replication{Storage: s.store}
+	// with raft the groups keep the replicas in step; anti-entropy writing around
+	// them would only get in the way
+	if !cfg.Raft && cfg.AntiEntropyInterval > 0 {
+		replication.AntiEntropy = &service.AntiEntropy{
+			Aggregates: s.aggregates,
+			Store:      s.store,
+			Partitions: cfg.Partitions,
+			Interval:   cfg.AntiEntropyInterval,
+			Rate:       cfg.AntiEntropyRate,
+		}
+		s.ae = replication.AntiEntropy
+	}
+
+	s.metrics = prometheus.NewRegistry()
+	collectors := []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		service.ClusterMetrics(s.members, ch, cfg.Partitions),
+	}
+	collectors = append(collectors, service.RPCMetrics()...)
+	collectors = append(collectors, s.store.Metrics()...)
+	collectors = append(collectors, ratelimit.Metrics()...)
+	collectors = append(collectors, service.AdmissionMetrics()...)
+	collectors = append(collectors, service.AntiEntropyMetrics()...)
+	for _, c := range collectors {
+		if err := s.metrics.Register(c); err != nil {
+			return err
+		}
+	}
+	if cfg.DebugAddress != "" {
+		if s.debugLis, err = net.Listen("tcp", cfg.DebugAddress); err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(s.metrics, promhttp.HandlerOpts{}))
+		mux.Handle("/debug/vars", expvar.Handler())
+		s.debug = &http.Server{Handler: mux}
+		go func() {
+			if err := s.debug.Serve(s.debugLis); err != http.ErrServerClosed {
+				s.log.Error("debug server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	unary := []grpc.UnaryServerInterceptor{
+		tracing.UnaryServerInterceptor,
+		logging.UnaryServerInterceptor(s.log),
+		service.MetricsUnaryInterceptor,
+	}
+	stream := []grpc.StreamServerInterceptor{
+		tracing.StreamServerInterceptor,
+		logging.StreamServerInterceptor(s.log),
+		service.MetricsStreamInterceptor,
+	}
+	var opts []grpc.ServerOption
+	if s.certs != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.certs.ServerConfig())))
+		unary = append(unary, s.certs.UnaryInterceptor)
+		stream = append(stream, s.certs.StreamInterceptor)
+	}
+	if s.auth != nil {
+		if cfg.Reflection {
+			s.auth.Open("grpc.reflection.v1alpha.ServerReflection")
+		}
+		unary = append(unary, s.auth.UnaryInterceptor)
+		stream = append(stream, s.auth.StreamInterceptor)
+	}
+	if cfg.RateLimit != nil {
+		limits := *cfg.RateLimit
+		if limits.Logger == nil {
+			limits.Logger = s.log
+		}
+		// after auth: the limits are per client, and it says who that is
+		if limiter := ratelimit.New(limits); limiter != nil {
+			unary = append(unary, limiter.UnaryInterceptor)
+			stream = append(stream, limiter.StreamInterceptor)
+		}
+	}
+	unary = append(unary, s.readiness.UnaryInterceptor)
+	stream = append(stream, s.readiness.StreamInterceptor)
+	// last, so in flight is what's in the handlers
+	admission := &service.Admission{
+		Services:        []string{proto.ProtoStuff_ServiceDesc.ServiceName, proto.EventStore_ServiceDesc.ServiceName},
+		MaxInFlight:     cfg.MaxInFlight,
+		MaxWriteLatency: cfg.MaxWriteLatency,
+		WriteLatency:    s.store.WriteLatency,
+		MaxHeap:         cfg.MaxHeap,
+		FromNode:        s.fromNode,
+		Logger:          s.log,
+	}
+	unary = append(unary, admission.UnaryInterceptor)
+	stream = append(stream, admission.StreamInterceptor)
+	opts = append(opts, grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	s.grpc = grpc.NewServer(opts...)
+
+	proto.RegisterProtoStuffServer(s.grpc, &service.Customer{Aggregates: s.aggregates, Follower: s.store, Logger: s.log})
+	proto.RegisterEventStoreServer(s.grpc, s.aggregates)
+	proto.RegisterReplicationServer(s.grpc, replication)
+	// a drain ends with the node leaving the cluster; then it's time to go
+	proto.RegisterClusterManagmentServer(s.grpc, &service.Management{
+		Aggregates: s.aggregates,
+		Streams:    s.store,
+		Meta:       delegate,
+		LogLevel:   cfg.LogLevel,
+		Drained:    func() { go s.Shutdown() },
+		Keys:       keys,
+		Backups:    s.store,
+		Snapshots:  s.store,
+	})
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	if cfg.Reflection {
+		reflection.Register(s.grpc)
+	}
+	if cfg.HTTPAddress != "" {
+		if err := s.serveHTTP(grpcAddr, dialOptions[0]); err != nil {
+			return err
+		}
+	}
+
+	// everything needs badger; everything but replication needs the cluster too
+	storeOK := service.HealthCheck(s.store.Healthy)
+	clusterOK := service.MemberlistCheck(s.members, cfg.MaxHealthScore)
+	s.checks = &service.HealthChecks{
+		Health:    s.health,
+		Readiness: s.readiness,
+		Logger:    s.log,
+		Services: []service.HealthService{
+			{Name: proto.ProtoStuff_ServiceDesc.ServiceName, Checks: []service.HealthCheck{storeOK, clusterOK}},
+			{Name: proto.EventStore_ServiceDesc.ServiceName, Checks: []service.HealthCheck{storeOK, clusterOK}},
+			{Name: proto.Replication_ServiceDesc.ServiceName, Checks: []service.HealthCheck{storeOK}},
+			{Name: proto.ClusterManagment_ServiceDesc.ServiceName, Checks: []service.HealthCheck{clusterOK}},
+		},
+	}
+	s.goBackground(func() { s.checks.Run(healthInterval, s.stop) })
+
+	s.readiness.Set(service.Converging)
+	s.goBackground(s.getReady)
+	return nil
+}
+
+// serveHTTP starts the gateway: it calls the grpc server at addr like any other
+// client, so it's as the node's certificate with tls but without the node's tokens:
+// the caller's go along instead
+func (s *Server) serveHTTP(addr string, transport grpc.DialOption) error {
+	var err error
+	if s.httpLis, err = net.Listen("tcp", s.config.HTTPAddress); err != nil {
+		return err
+	}
+	if s.httpConn, err = grpc.Dial(addr, transport); err != nil {
+		return err
+	}
+	s.gateway = gateway.New(gateway.Config{
+		Conn:       s.httpConn,
+		Logger:     s.log,
+		Heartbeat:  s.config.EventsHeartbeat,
+		SendBuffer: s.config.EventsSendBuffer,
+	})
+	// with the connections in their contexts, event streams that fall behind can be
+	// cut off
+	s.http = &http.Server{Handler: s.gateway, ConnContext: gateway.ConnContext}
+	go func() {
+		if err := s.http.Serve(s.httpLis); err != http.ErrServerClosed {
+			s.log.Error("http server failed", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// fromNode is true if the rpc came from another node: a cluster CA certificate, or a
+// token with the node scope.  Without tls or auth every caller is as good as a node
+func (s *Server) fromNode(ctx context.Context) bool {
+	if s.certs == nil && s.auth == nil {
+		return true
+	}
+	if s.certs != nil && s.certs.FromNode(ctx) {
+		return true
+	}
+	c := auth.FromContext(ctx)
+	return c != nil && c.Allows(auth.ScopeNode)
+}
+
+// getReady waits for membership to settle, makes sure the ring has everyone on it,
+// then pulls what this node is missing (one anti-entropy round) before saying it's
+// ready.  With raft groups there's nothing to pull: the groups catch their members up.
+func (s *Server) getReady() {
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for s.members.NumMembers() < s.config.MinMembers || !s.readiness.Settled(s.config.ReadySettle) {
+		select {
+		case <-tick.C:
+		case <-s.stop:
+			return
+		}
+	}
+	s.ring.Sync()
+
+	if s.ae != nil {
+		s.readiness.Set(service.Transferring)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-s.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err := s.ae.Round(ctx)
+		cancel()
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		if err != nil {
+			// the rounds that follow will keep trying
+			s.log.Warn("pulling missing logs failed", zap.Error(err))
+		}
+		s.goBackground(func() { s.ae.Run(s.stop) })
+	}
+	s.readiness.Set(service.Ready)
+	s.checks.Check()
+}
+
+// Ready is closed once the node is serving
+func (s *Server) Ready() <-chan struct{} {
+	return s.readiness.Ready()
+}
+
+func (s *Server) goBackground(fn func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		fn()
+	}()
+}
+
+// Addr the grpc server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.lis.Addr()
+}
+
+// DebugAddr is where /metrics and /debug/vars are served; nil without a DebugAddress
+func (s *Server) DebugAddr() net.Addr {
+	if s.debugLis == nil {
+		return nil
+	}
+	return s.debugLis.Addr()
+}
+
+// HTTPAddr is where the http gateway is served; nil without an HTTPAddress
+func (s *Server) HTTPAddr() net.Addr {
+	if s.httpLis == nil {
+		return nil
+	}
+	return s.httpLis.Addr()
+}
+
+// Members is the node's memberlist
+func (s *Server) Members() *memberlist.Memberlist {
+	return s.members
+}
+
+// Serve grpc until the server is shut down, and the shutdown has finished.  If grpc
+// fails on its own, that shuts everything down too.
+func (s *Server) Serve() error {
+	err := s.grpc.Serve(s.lis)
+	if err == grpc.ErrServerStopped {
+		// shut down before it got going
+		err = nil
+	}
+	if shutdownErr := s.Shutdown(); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+// ShutdownOn shuts the server down on the first of sigs
+func (s *Server) ShutdownOn(sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		defer signal.Stop(ch)
+		select {
+		case sig := <-ch:
+			s.log.Info("shutting down", zap.Stringer("signal", sig))
+			s.Shutdown()
+		case <-s.done:
+		}
+	}()
+}
+
+// Shutdown the node, in order:
+//
+//   - stop the http gateway: end its event streams, and give its other calls until
+//     the shutdown timeout
+//   - stop accepting connections, and give the rpcs and streams in flight until the
+//     shutdown timeout to finish; then cut them off
+//   - stop anti-entropy and the raft groups
+//   - leave the gossip cluster (so the others know straight away, instead of
+//     waiting to notice it's dead)
+//   - close the connections to the other nodes
+//   - flush and close badger
+//
+// It's safe to call more than once, and from more than one place: they all wait for
+// the one shutdown.
+func (s *Server) Shutdown() error {
+	s.shutdown.Do(func() {
+		s.shutdownErr = s.close()
+		close(s.done)
+	})
+	<-s.done
+	return s.shutdownErr
+}
+
+// close whatever has been started
+func (s *Server) close() error {
+	timeout := s.config.ShutdownTimeout
+	if s.health != nil {
+		s.health.Shutdown()
+	}
+	// the gateway's calls go through grpc, so it stops first; its event streams
+	// would never finish on their own
+	if s.http != nil {
+		s.gateway.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := s.http.Shutdown(ctx); err != nil {
+			s.http.Close()
+		}
+		cancel()
+	} else if s.httpLis != nil {
+		s.httpLis.Close()
+	}
+	if s.grpc != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(timeout):
+			s.log.Warn("rpcs still running; stopping them", zap.Duration("timeout", timeout))
+			s.grpc.Stop()
+			<-stopped
+		}
+	} else if s.lis != nil {
+		s.lis.Close()
+	}
+	if s.httpConn != nil {
+		s.httpConn.Close()
+	}
+	if s.debug != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		s.debug.Shutdown(ctx)
+		cancel()
+	}
+
+	close(s.stop)
+	s.background.Wait()
+	if s.groups != nil {
+		s.groups.Shutdown()
+	}
+	if s.mux != nil {
+		s.mux.Close()
+	}
+
+	if s.members != nil {
+		if err := s.members.Leave(timeout); err != nil {
+			s.log.Warn("leaving the cluster failed", zap.Error(err))
+		}
+		if err := s.members.Shutdown(); err != nil {
+			s.log.Warn("stopping memberlist failed", zap.Error(err))
+		}
+	}
+	if s.peers != nil {
+		s.peers.Close()
+	}
+	// with memberlist stopped no more replays start; the ones running give up
+	// now the connections are closed
+	if s.handoff != nil {
+		s.handoff.Wait()
+	}
+	if s.store != nil {
+		return s.store.Close()
+	}
+	return nil
+}
@@ -0,0 +1,554 @@
+package server_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/auth"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/server"
+	"github.com/yarbelk/distributedservice/tlsconfig"
+	"github.com/yarbelk/distributedservice/tlsconfig/tlstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(b []byte) (int, error) {
+	w.t.Log(string(b))
+	return len(b), nil
+}
+
+func testConfig(t *testing.T, name string, join ...string) server.Config {
+	ml := memberlist.DefaultLocalConfig()
+	ml.Name = name
+	ml.BindAddr = "127.0.0.1"
+	ml.BindPort = 0
+	ml.LogOutput = testWriter{t}
+	return server.Config{
+		Memberlist:        ml,
+		Join:              join,
+		Address:           "127.0.0.1:0",
+		Partitions:        7,
+		ReplicationFactor: 20,
+		DataDir:           t.TempDir(),
+		ShutdownTimeout:   500 * time.Millisecond,
+		ReadySettle:       100 * time.Millisecond,
+	}
+}
+
+func gossipAddr(s *server.Server) string {
+	n := s.Members().LocalNode()
+	return fmt.Sprintf("%s:%d", n.Addr, n.Port)
+}
+
+func ready(t *testing.T, s *server.Server) {
+	t.Helper()
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("never got ready")
+	}
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	b, err := server.New(testConfig(t, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve()
+	defer b.Shutdown()
+
+	cfgA := testConfig(t, "a", gossipAddr(b))
+	a, err := server.New(cfgA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ShutdownOn(syscall.SIGTERM)
+	served := make(chan error, 1)
+	go func() { served <- a.Serve() }()
+
+	for deadline := time.Now().Add(5 * time.Second); b.Members().NumMembers() < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Members().NumMembers() != 2 {
+		t.Fatalf("a never joined b")
+	}
+	ready(t, a)
+
+	// a stream that never ends on its own: the shutdown has to cut it off
+	conn, err := grpc.Dial(a.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := proto.NewProtoStuffClient(conn).StreamEventLog(context.Background(), &proto.Customer{Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// open once it says where it starts
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("never shut down")
+	}
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			break
+		}
+	}
+	// it left, rather than b having to notice it's gone
+	if n := b.Members().NumMembers(); n != 1 {
+		t.Errorf("expected a to have left, b sees %d members", n)
+	}
+	// and badger has let go of the data dir (it's locked while it's open)
+	store := data.New(cfgA.DataDir)
+	store.Close()
+}
+
+func TestNotServingUntilReady(t *testing.T) {
+	cfgA := testConfig(t, "a")
+	cfgA.MinMembers = 2
+	cfgA.AntiEntropyInterval = time.Minute
+	cfgA.Reflection = true
+	a, err := server.New(cfgA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve()
+	defer a.Shutdown()
+
+	conn, err := grpc.Dial(a.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	healthClient := healthpb.NewHealthClient(conn)
+	stuff := proto.NewProtoStuffClient(conn)
+	ctx := context.Background()
+
+	resp, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected NOT_SERVING on its own, got %v (err %v)", resp, err)
+	}
+	if _, err := stuff.CustomerState(ctx, &proto.Customer{Id: 1}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable before it's ready, got %v", err)
+	}
+
+	b, err := server.New(testConfig(t, "b", gossipAddr(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve()
+	defer b.Shutdown()
+	ready(t, a)
+
+	resp, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected SERVING, got %v (err %v)", resp, err)
+	}
+	if _, err := stuff.CustomerState(ctx, &proto.Customer{Id: 1}); status.Code(err) == codes.Unavailable {
+		t.Errorf("still Unavailable once ready: %v", err)
+	}
+	resp, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: "proto.EventStore"})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected proto.EventStore SERVING, got %v (err %v)", resp, err)
+	}
+
+	// reflection lists what's there
+	refl, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := refl.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := refl.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, svc := range listed.GetListServicesResponse().GetService() {
+		found = found || svc.Name == "proto.EventStore"
+	}
+	if !found {
+		t.Errorf("reflection doesn't list proto.EventStore: %v", listed)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	cfg := testConfig(t, "a")
+	cfg.DebugAddress = "127.0.0.1:0"
+	a, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve()
+	defer a.Shutdown()
+	ready(t, a)
+
+	conn, err := grpc.Dial(a.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stuff := proto.NewProtoStuffClient(conn)
+	ctx := context.Background()
+	if _, err := stuff.WriteLog(ctx, &proto.NewCustomerLog{
+		CustomerID: 1,
+		Log:        &proto.CustomerEventLog{SequenceId: 0, Action: &proto.Action{Action: "write"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stuff.CustomerState(ctx, &proto.Customer{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", a.DebugAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`grpc_server_handled_total{grpc_code="OK",grpc_method="WriteLog",grpc_service="proto.ProtoStuff"}`,
+		`grpc_server_handling_seconds_count{grpc_code="OK",grpc_method="CustomerState",grpc_service="proto.ProtoStuff"}`,
+		`eventstore_events_written_total{source="append"}`,
+		`eventstore_events_replayed_count`,
+		`badger_lsm_size_bytes`,
+		`badger_vlog_size_bytes`,
+		`memberlist_members 1`,
+		`memberlist_health_score 0`,
+		`ring_partitions{member="a"} 7`,
+		`antientropy_rounds_total`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("no %s in /metrics", want)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t, dir, "cluster-ca")
+	withTLS := func(name string, join ...string) server.Config {
+		cert := ca.Issue(t, dir, name)
+		cfg := testConfig(t, name, join...)
+		cfg.TLS = &tlsconfig.Config{Cert: cert.Cert, Key: cert.Key, CA: ca.File}
+		// a's first anti-entropy round pulls from b over replication
+		cfg.MinMembers = 2
+		cfg.AntiEntropyInterval = time.Minute
+		return cfg
+	}
+	a, err := server.New(withTLS("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve()
+	defer a.Shutdown()
+	b, err := server.New(withTLS("b", gossipAddr(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve()
+	defer b.Shutdown()
+	ready(t, a)
+	ready(t, b)
+
+	creds, err := tlsconfig.ClientCredentials(ca.File, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := func(s *server.Server) *grpc.ClientConn {
+		conn, err := grpc.Dial(s.Addr().String(), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	ctx := context.Background()
+
+	// written on a, replicated to b over mtls
+	_, err = proto.NewProtoStuffClient(dial(a)).WriteLog(ctx, &proto.NewCustomerLog{
+		CustomerID: 1,
+		Log:        &proto.CustomerEventLog{SequenceId: 0, Action: &proto.Action{Action: "over tls"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := proto.NewProtoStuffClient(dial(b)).CustomerState(ctx, &proto.Customer{Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.GetLastAction() != "over tls" {
+		t.Errorf("b doesn't have a's write: %v", state)
+	}
+
+	// a client isn't a node
+	_, err = proto.NewReplicationClient(dial(b)).Replicate(ctx, &proto.ReplicateRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated replicating without a node certificate, got %v", err)
+	}
+	// and plaintext gets nowhere
+	plain, err := grpc.Dial(a.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if _, err := healthpb.NewHealthClient(plain).Check(ctx, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable over plaintext, got %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey := "MDEyMzQ1Njc4OWFiY2RlZg==" // 0123456789abcdef
+	newKey := "ZmVkY2JhOTg3NjU0MzIxMA==" // fedcba9876543210
+	withKeys := func(name string, keys []string, join ...string) server.Config {
+		cfg := testConfig(t, name, join...)
+		cfg.ClusterName = "test"
+		cfg.GossipKeys = keys
+		cfg.KeyringFile = filepath.Join(t.TempDir(), "keyring.json")
+		return cfg
+	}
+	a, err := server.New(withKeys("a", []string{oldKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve()
+	defer a.Shutdown()
+	b, err := server.New(withKeys("b", []string{oldKey}, gossipAddr(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve()
+	defer b.Shutdown()
+	ready(t, a)
+	ready(t, b)
+
+	conn, err := grpc.Dial(a.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := proto.NewClusterManagmentClient(conn)
+	ctx := context.Background()
+	for _, step := range []struct {
+		name string
+		call func(context.Context, *proto.KeyRequest, ...grpc.CallOption) (*proto.KeyringResponse, error)
+		key  string
+		keys string
+	}{
+		{"install", client.InstallKey, newKey, oldKey + " " + newKey},
+		{"use", client.UseKey, newKey, newKey + " " + oldKey},
+		{"remove", client.RemoveKey, oldKey, newKey},
+	} {
+		resp, err := step.call(ctx, &proto.KeyRequest{Key: step.key})
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if len(resp.GetNodes()) != 2 {
+			t.Fatalf("%s: expected both nodes, got %v", step.name, resp)
+		}
+		for _, n := range resp.GetNodes() {
+			if got := strings.Join(n.GetKeys(), " "); n.GetError() != "" || got != step.keys {
+				t.Errorf("%s on %s: expected keys %s, got %s (%s)", step.name, n.GetNode(), step.keys, got, n.GetError())
+			}
+		}
+	}
+	if _, err := client.InstallKey(ctx, &proto.KeyRequest{Key: "c2hvcnQ="}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a short key, got %v", err)
+	}
+
+	// the cluster has moved on: the old key doesn't get in, the new one does
+	if c, err := server.New(withKeys("c", []string{oldKey}, gossipAddr(a))); err == nil {
+		c.Shutdown()
+		t.Error("joined with the old key")
+	}
+	d, err := server.New(withKeys("d", []string{newKey}, gossipAddr(b)))
+	if err != nil {
+		t.Fatalf("joining with the new key: %s", err)
+	}
+	d.Shutdown()
+
+	// nor does a node from another cluster, whatever key it has
+	other := withKeys("e", []string{newKey}, gossipAddr(a))
+	other.ClusterName = "other"
+	if e, err := server.New(other); err == nil {
+		e.Shutdown()
+		t.Error("a node from another cluster joined")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	withAuth := func(name string, join ...string) server.Config {
+		cfg := testConfig(t, name, join...)
+		cfg.Auth = &auth.Config{Keys: []string{key}}
+		// a's first anti-entropy round pulls from b over replication, with a node token
+		cfg.MinMembers = 2
+		cfg.AntiEntropyInterval = time.Minute
+		return cfg
+	}
+	a, err := server.New(withAuth("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve()
+	defer a.Shutdown()
+	b, err := server.New(withAuth("b", gossipAddr(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve()
+	defer b.Shutdown()
+	ready(t, a)
+	ready(t, b)
+
+	k, err := auth.ParseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(scope string, customers ...string) grpc.CallOption {
+		tok, err := auth.Sign(k, auth.Claims{
+			StandardClaims: jwt.StandardClaims{Subject: "test", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+			Scope:          scope,
+			Customers:      customers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return grpc.PerRPCCredentials(auth.Token(tok))
+	}
+	dial := func(s *server.Server) proto.ProtoStuffClient {
+		conn, err := grpc.Dial(s.Addr().String(), grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return proto.NewProtoStuffClient(conn)
+	}
+	ctx := context.Background()
+	write := &proto.NewCustomerLog{
+		CustomerID: 1,
+		Log:        &proto.CustomerEventLog{SequenceId: 0, Action: &proto.Action{Action: "with a token"}},
+	}
+
+	if _, err := dial(a).WriteLog(ctx, write); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated writing without a token, got %v", err)
+	}
+	if _, err := dial(a).WriteLog(ctx, write, token("read:customer")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied writing with a read token, got %v", err)
+	}
+	if _, err := dial(a).WriteLog(ctx, write, token("write:customer", "2-10")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied writing someone else's customer, got %v", err)
+	}
+	// written on a, replicated to b: the nodes use their own tokens
+	if _, err := dial(a).WriteLog(ctx, write, token("write:customer", "1")); err != nil {
+		t.Fatal(err)
+	}
+	state, err := dial(b).CustomerState(ctx, &proto.Customer{Id: 1}, token("read:customer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.GetLastAction() != "with a token" {
+		t.Errorf("b doesn't have a's write: %v", state)
+	}
+
+	// health checks don't need a token
+	conn, err := grpc.Dial(a.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("health check: %s", err)
+	}
+}
+
+func TestHTTPGateway(t *testing.T) {
+	cfg := testConfig(t, "a")
+	cfg.HTTPAddress = "127.0.0.1:0"
+	a, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve()
+	defer a.Shutdown()
+	ready(t, a)
+	url := fmt.Sprintf("http://%s", a.HTTPAddr())
+
+	resp, err := http.Post(url+"/customers/7/events", "application/json",
+		strings.NewReader(`{"sequenceId": "0", "action": {"action": "signed up"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("write: expected 200, got %s", resp.Status)
+	}
+	for path, want := range map[string]string{
+		"/customers/7/state": `"LastAction":"signed up"`,
+		"/cluster/members":   `"Name":"a"`,
+		"/openapi.json":      `"operationId": "ProtoStuff_WriteLog"`,
+	} {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+			t.Errorf("%s: expected 200 with %s, got %s: %s", path, want, resp.Status, body)
+		}
+	}
+
+	// an event stream that's still open doesn't hold the shutdown up
+	resp, err = http.Get(url + "/customers/7/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	start := time.Now()
+	if err := a.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took >= cfg.ShutdownTimeout {
+		t.Errorf("shutting down took %s: the stream held it up", took)
+	}
+}
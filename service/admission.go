@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/rpcname"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Priority of a call, for shedding: low goes first when the node is busy, normal
+// when it's over its limits, high never
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	}
+	return "high"
+}
+
+// PriorityHeader is how clients say how much a call matters: low or normal.  Without
+// it reads are low and writes normal.  High is for appends another node has passed on
+// (it has done its part already); clients can't ask for it, or pass for a node
+const PriorityHeader = "x-priority"
+
+// shedLowAt is the load (see Admission) low priority calls are turned away at
+const shedLowAt = 0.8
+
+// reads are the methods that are low priority by default
+var reads = map[string]bool{
+	"StreamEventLog":    true,
+	"CustomerState":     true,
+	"CustomerConflicts": true,
+	"AggregateState":    true,
+	"ListConflicts":     true,
+}
+
+var (
+	admissionRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "admission",
+		Name:      "rejected_total",
+		Help:      "Calls turned away before doing any work, by reason (in_flight, write_latency, heap or deadline) and priority",
+	}, []string{"reason", "priority"})
+	admissionInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "admission",
+		Name:      "in_flight",
+		Help:      "Calls to the apis being worked on",
+	})
+	admissionLoad = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "admission",
+		Name:      "load",
+		Help:      "The node's load as admission sees it: the highest of in flight calls, write latency and heap over their limits.  Low priority calls are turned away from 0.8, the rest from 1",
+	})
+)
+
+// AdmissionMetrics for a prometheus registry
+func AdmissionMetrics() []prometheus.Collector {
+	return []prometheus.Collector{admissionRejected, admissionInFlight, admissionLoad}
+}
+
+// Admission decides which calls to the apis get to do any work, so a node that's
+// falling behind (badger stalling on compactions, say) turns calls away with
+// Unavailable instead of piling them up in goroutines.  Its load is the highest of
+// calls in flight, the store's write latency and the heap, each over its limit; past
+// shedLowAt low priority calls are turned away, past 1 normal ones too.
+//
+// Calls whose deadline has already gone are dropped, as are writes with less time
+// left than writes are taking.
+//
+// Streams are checked when they open, but don't count as in flight: they spend most
+// of their time waiting
+type Admission struct {
+	// Services it's in front of; anything else goes straight through
+	Services []string
+	// MaxInFlight calls; 0 doesn't count them
+	MaxInFlight int
+	// MaxWriteLatency of WriteLatency (the store's); 0 doesn't look
+	MaxWriteLatency time.Duration
+	WriteLatency    func() time.Duration
+	// MaxHeap bytes in use; 0 doesn't look
+	MaxHeap uint64
+	// FromNode is true if the rpc came from another node: only then is a forwarded
+	// append high priority.  nil trusts no one
+	FromNode func(context.Context) bool
+	// Logger nil is zap's global logger
+	Logger *zap.Logger
+
+	inFlight int64
+
+	heapLock    sync.Mutex
+	heap        uint64
+	heapSampled time.Time
+}
+
+// heapSample is how often the heap is looked at: ReadMemStats stops the world
+const heapSample = 250 * time.Millisecond
+
+func (a *Admission) heapInUse() uint64 {
+	a.heapLock.Lock()
+	defer a.heapLock.Unlock()
+	if time.Since(a.heapSampled) >= heapSample {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		a.heap, a.heapSampled = m.HeapInuse, time.Now()
+	}
+	return a.heap
+}
+
+// load is the highest of the limits, and which one it is.  inFlight is the calls
+// already going: MaxInFlight of them is a load of 1
+func (a *Admission) load(inFlight int64) (float64, string) {
+	load, reason := 0.0, ""
+	if a.MaxInFlight > 0 {
+		load, reason = float64(inFlight)/float64(a.MaxInFlight), "in_flight"
+	}
+	if a.MaxWriteLatency > 0 && a.WriteLatency != nil {
+		if l := float64(a.WriteLatency()) / float64(a.MaxWriteLatency); l > load {
+			load, reason = l, "write_latency"
+		}
+	}
+	if a.MaxHeap > 0 {
+		if l := float64(a.heapInUse()) / float64(a.MaxHeap); l > load {
+			load, reason = l, "heap"
+		}
+	}
+	admissionLoad.Set(load)
+	return load, reason
+}
+
+func (a *Admission) covers(method string) (string, bool) {
+	svc, m := rpcname.Split(method)
+	for _, s := range a.Services {
+		if s == svc {
+			return m, true
+		}
+	}
+	return m, false
+}
+
+func (a *Admission) priority(ctx context.Context, method string) Priority {
+	if forwarded(ctx, a.FromNode) {
+		return PriorityHigh
+	}
+	p := PriorityNormal
+	if reads[method] {
+		p = PriorityLow
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		switch strings.ToLower(strings.Join(md.Get(PriorityHeader), "")) {
+		case "low":
+			p = PriorityLow
+		case "normal":
+			p = PriorityNormal
+		}
+	}
+	return p
+}
+
+func (a *Admission) reject(ctx context.Context, method string, p Priority, reason string, err error) error {
+	admissionRejected.WithLabelValues(reason, p.String()).Inc()
+	logging.FromContext(ctx, a.Logger).Debug("call turned away",
+		zap.String("method", method), zap.Stringer("priority", p), zap.String("reason", reason))
+	return err
+}
+
+// admit a call to method, counting it as in flight if it's let in.  done is for when
+// it's finished
+func (a *Admission) admit(ctx context.Context, method string, count bool) (done func(), err error) {
+	m, ok := a.covers(method)
+	if !ok {
+		return func() {}, nil
+	}
+	p := a.priority(ctx, m)
+	if err := ctx.Err(); err != nil {
+		return nil, a.reject(ctx, method, p, "deadline", status.FromContextError(err).Err())
+	}
+	if deadline, ok := ctx.Deadline(); ok && !reads[m] && a.WriteLatency != nil {
+		if left, writes := time.Until(deadline), a.WriteLatency(); left < writes {
+			return nil, a.reject(ctx, method, p, "deadline",
+				status.Errorf(codes.DeadlineExceeded, "%s left, and writes are taking %s", left.Round(time.Millisecond), writes.Round(time.Millisecond)))
+		}
+	}
+
+	n := atomic.LoadInt64(&a.inFlight)
+	if count {
+		n = atomic.AddInt64(&a.inFlight, 1) - 1
+		admissionInFlight.Inc()
+		done = func() {
+			atomic.AddInt64(&a.inFlight, -1)
+			admissionInFlight.Dec()
+		}
+	} else {
+		done = func() {}
+	}
+	if p == PriorityHigh {
+		return done, nil
+	}
+	load, reason := a.load(n)
+	if load >= 1 || p == PriorityLow && load >= shedLowAt {
+		done()
+		return nil, a.reject(ctx, method, p, reason, status.Errorf(codes.Unavailable, "node is overloaded (%s); try again, or another node", reason))
+	}
+	return done, nil
+}
+
+// UnaryInterceptor turns calls away when the node is overloaded, or they're out of
+// time
+func (a *Admission) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	done, err := a.admit(ctx, info.FullMethod, true)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return handler(ctx, req)
+}
+
+// StreamInterceptor is UnaryInterceptor for streams, when they open
+func (a *Admission) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	done, err := a.admit(ss.Context(), info.FullMethod, false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return handler(srv, ss)
+}
@@ -0,0 +1,156 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	writeLog      = "/proto.ProtoStuff/WriteLog"
+	customerState = "/proto.ProtoStuff/CustomerState"
+)
+
+// call method through a; handler blocks until release is closed, if it isn't nil.
+// called is closed once the handler has it
+func call(ctx context.Context, a *service.Admission, method string, release chan struct{}) (called chan struct{}, result chan error) {
+	called, result = make(chan struct{}), make(chan error, 1)
+	go func() {
+		_, err := a.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(called)
+			if release != nil {
+				<-release
+			}
+			return nil, nil
+		})
+		result <- err
+	}()
+	return called, result
+}
+
+// do a call that doesn't block, and its code
+func do(ctx context.Context, a *service.Admission, method string) codes.Code {
+	_, result := call(ctx, a, method, nil)
+	return status.Code(<-result)
+}
+
+func TestAdmissionInFlight(t *testing.T) {
+	// the test's "node" is anything with a peer; clients don't have one here
+	fromNode := func(ctx context.Context) bool {
+		_, ok := peer.FromContext(ctx)
+		return ok
+	}
+	a := &service.Admission{Services: []string{"proto.ProtoStuff"}, MaxInFlight: 5, FromNode: fromNode}
+	ctx := context.Background()
+	release := make(chan struct{})
+	var results []chan error
+	hold := func(n int) {
+		for i := 0; i < n; i++ {
+			called, result := call(ctx, a, writeLog, release)
+			<-called
+			results = append(results, result)
+		}
+	}
+
+	hold(3)
+	if got := do(ctx, a, customerState); got != codes.OK {
+		t.Errorf("read at 3/5: expected OK, got %s", got)
+	}
+	hold(1)
+	if got := do(ctx, a, customerState); got != codes.Unavailable {
+		t.Errorf("read at 4/5: expected Unavailable, got %s", got)
+	}
+	low := metadata.NewIncomingContext(ctx, metadata.Pairs(service.PriorityHeader, "low"))
+	if got := do(low, a, writeLog); got != codes.Unavailable {
+		t.Errorf("low priority write at 4/5: expected Unavailable, got %s", got)
+	}
+	// clients can't make themselves high priority
+	high := metadata.NewIncomingContext(ctx, metadata.Pairs(service.PriorityHeader, "high"))
+	if got := do(high, a, customerState); got != codes.Unavailable {
+		t.Errorf("read asking for high priority at 4/5: expected Unavailable, got %s", got)
+	}
+	if got := do(ctx, a, writeLog); got != codes.OK {
+		t.Errorf("write at 4/5: expected OK, got %s", got)
+	}
+	hold(1)
+	if got := do(ctx, a, writeLog); got != codes.Unavailable {
+		t.Errorf("write at 5/5: expected Unavailable, got %s", got)
+	}
+	forwarded := metadata.NewIncomingContext(ctx, metadata.Pairs(service.ForwardedKey, "b"))
+	// or pass for a node
+	if got := do(forwarded, a, writeLog); got != codes.Unavailable {
+		t.Errorf("client's write claiming to be forwarded at 5/5: expected Unavailable, got %s", got)
+	}
+	forwarded = peer.NewContext(forwarded, &peer.Peer{})
+	if got := do(forwarded, a, writeLog); got != codes.OK {
+		t.Errorf("forwarded write at 5/5: expected OK, got %s", got)
+	}
+	if got := do(ctx, a, "/proto.Replication/Replicate"); got != codes.OK {
+		t.Errorf("a service admission isn't in front of: expected OK, got %s", got)
+	}
+
+	close(release)
+	for _, r := range results {
+		if err := <-r; err != nil {
+			t.Error(err)
+		}
+	}
+	if got := do(ctx, a, writeLog); got != codes.OK {
+		t.Errorf("write once they're done: expected OK, got %s", got)
+	}
+}
+
+func TestAdmissionWriteLatency(t *testing.T) {
+	latency := 100 * time.Millisecond
+	a := &service.Admission{
+		Services:        []string{"proto.ProtoStuff"},
+		MaxWriteLatency: time.Second,
+		WriteLatency:    func() time.Duration { return latency },
+	}
+	ctx := context.Background()
+	if got := do(ctx, a, writeLog); got != codes.OK {
+		t.Errorf("writes taking 100ms: expected OK, got %s", got)
+	}
+	latency = 900 * time.Millisecond
+	if got, expected := []codes.Code{do(ctx, a, customerState), do(ctx, a, writeLog)}, []codes.Code{codes.Unavailable, codes.OK}; got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("writes taking 900ms: expected reads %s and writes %s, got %s and %s", expected[0], expected[1], got[0], got[1])
+	}
+	latency = 2 * time.Second
+	if got := do(ctx, a, writeLog); got != codes.Unavailable {
+		t.Errorf("writes taking 2s: expected Unavailable, got %s", got)
+	}
+}
+
+func TestAdmissionDeadlines(t *testing.T) {
+	a := &service.Admission{
+		Services:     []string{"proto.ProtoStuff"},
+		WriteLatency: func() time.Duration { return 500 * time.Millisecond },
+	}
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	called, result := call(expired, a, customerState, nil)
+	if err := <-result; status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded for a call out of time, got %v", err)
+	}
+	select {
+	case <-called:
+		t.Error("a call out of time got to the handler")
+	default:
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if got := do(short, a, writeLog); got != codes.DeadlineExceeded {
+		t.Errorf("write with 100ms left while writes take 500ms: expected DeadlineExceeded, got %s", got)
+	}
+	if got := do(short, a, customerState); got != codes.OK {
+		t.Errorf("read with 100ms left: expected OK, got %s", got)
+	}
+}
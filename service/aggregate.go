@@ -0,0 +1,339 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/buraksezer/consistent"
+	protobuf "github.com/golang/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Aggregates serves the generic EventStore api: any registered aggregate type, addressed
+// by its stream.  Customer is a thin layer over the top of this.
+type Aggregates struct {
+	Storage data.Storer
+
+	MemberList *memberlist.Memberlist
+
+	HashList *consistent.Consistent
+
+	// ReplicationFactor is how many nodes hold each stream, this one included.
+	// 1 (or 0) turns replication off
+	ReplicationFactor int
+	// Peers to send replicated logs over
+	Peers *cluster.Peers
+	// Hints keeps the logs for replicas that are down, for Handoff to replay. nil
+	// drops them
+	Hints data.HintStore
+	// Consensus, if set, orders appends instead: they go through the stream's raft
+	// group and ReplicationFactor/replicate aren't used for them.
+	Consensus Consensus
+	// FromNode is true if the rpc came from another node of the cluster, so it can be
+	// trusted with x-raft-forwarded.  nil trusts no one
+	FromNode func(context.Context) bool
+
+	// Logger for when there's no rpc to log for (rpcs have their own, with their
+	// request id on).  nil is zap's global logger
+	Logger *zap.Logger
+
+	// draining is set (atomically) once a drain starts: no more writes
+	draining int32
+
+	proto.UnimplementedEventStoreServer
+}
+
+// logger for ctx: the rpc's if it's in one
+func (a *Aggregates) logger(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, a.Logger)
+}
+
+// SetDraining stops this node coordinating writes
+func (a *Aggregates) SetDraining() {
+	atomic.StoreInt32(&a.draining, 1)
+}
+
+// Draining is true once SetDraining has been called
+func (a *Aggregates) Draining() bool {
+	return atomic.LoadInt32(&a.draining) == 1
+}
+
+// drainingError is for writes sent to a draining node; Unavailable so clients retry
+// (somewhere else, once the ring has caught up)
+func drainingError() (*proto.ErrorDetails, error) {
+	return &proto.ErrorDetails{
+			Failed:    true,
+			ErrorCode: 1,
+			ErrorMsg:  "Node is draining",
+		},
+		status.Errorf(codes.Unavailable, "node is draining")
+}
+
+// streamKey is the key/id rule from the protos: an opaque key wins, otherwise
+// the numeric id.
+func streamID(in *proto.StreamID) data.StreamID {
+	return data.StreamID{Type: in.GetAggregateType(), ID: data.KeyOrID(in.GetKey(), in.GetId())}
+}
+
+func streamProto(s data.StreamID) *proto.StreamID {
+	return &proto.StreamID{AggregateType: s.Type, Key: []byte(s.ID)}
+}
+
+// storageError maps the storage errors on to grpc codes
+func storageError(err error) error {
+	var corrupt *data.CorruptStreamError
+	if errors.As(err, &corrupt) {
+		return status.Errorf(codes.DataLoss, err.Error())
+	}
+	switch err {
+	case data.UnknownAggregateError:
+		return status.Errorf(codes.InvalidArgument, err.Error())
+	case data.ConflictError:
+		return status.Errorf(codes.Aborted, err.Error())
+	case data.StaleClockError, data.NotEmptyError:
+		return status.Errorf(codes.FailedPrecondition, err.Error())
+	case context.DeadlineExceeded, context.Canceled:
+		return status.FromContextError(err).Err()
+	}
+	return status.Errorf(codes.Unknown, err.Error())
+}
+
+// replicaCount is how many nodes hold each stream: never more than there are, and
+// at least one.
+func (a *Aggregates) replicaCount() int {
+	return replicaCount(a.HashList, a.ReplicationFactor)
+}
+
+func replicaCount(hashList *consistent.Consistent, replicationFactor int) int {
+	n := replicationFactor
+	if members := len(hashList.GetMembers()); n > members {
+		n = members
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// replicaSet is every node that holds the stream, this one included
+func (a *Aggregates) replicaSet(s data.StreamID) ([]*memberlist.Node, error) {
+	return Replicas(a.HashList, a.ReplicationFactor, s)
+}
+
+// Replicas of a stream on a ring: the owner and the next replicationFactor-1.  If
+// there are fewer members than the replication factor, everyone is a replica.
+func Replicas(hashList *consistent.Consistent, replicationFactor int, s data.StreamID) ([]*memberlist.Node, error) {
+	n := replicaCount(hashList, replicationFactor)
+	if n <= 1 {
+		return []*memberlist.Node{hashList.LocateKey(s.HashKey()).(WrappedNode).Node}, nil
+	}
+	owners, err := hashList.GetClosestN(s.HashKey(), n)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*memberlist.Node, 0, len(owners))
+	for _, o := range owners {
+		nodes = append(nodes, o.(WrappedNode).Node)
+	}
+	return nodes, nil
+}
+
+// isReplica is the filtering on member list and consistent hash.  Any replica can
+// coordinate a write; which is exactly how two nodes end up writing the same
+// sequenceId during a partition (see data.ConflictResolver)
+func (a *Aggregates) isReplica(s data.StreamID) bool {
+	nodes, err := a.replicaSet(s)
+	if err != nil {
+		return false
+	}
+	local := a.MemberList.LocalNode().Name
+	for _, n := range nodes {
+		if n.Name == local {
+			return true
+		}
+	}
+	return false
+}
+
+// replicas of the stream, not counting this node.
+func (a *Aggregates) replicas(s data.StreamID) ([]*memberlist.Node, error) {
+	nodes, err := a.replicaSet(s)
+	if err != nil {
+		return nil, err
+	}
+	local := a.MemberList.LocalNode().Name
+	replicas := make([]*memberlist.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Name != local {
+			replicas = append(replicas, n)
+		}
+	}
+	return replicas, nil
+}
+
+// replicate a log this node has already written to the rest of the stream's replicas.
+// This is best effort: the write is already durable here.  Replicas that aren't
+// memberlist members any more (dead, or left), or that can't be reached, get a hint
+// instead; other failures are only logged.
+func (a *Aggregates) replicate(ctx context.Context, s data.StreamID, el *proto.CustomerEventLog) {
+	if a.Peers == nil {
+		return
+	}
+	replicas, err := a.replicas(s)
+	if err != nil {
+		a.logger(ctx).Error("can't find replicas", zap.Stringer("stream", s), zap.Error(err))
+		return
+	}
+	req := &proto.ReplicateRequest{Stream: streamProto(s), Log: el, From: a.MemberList.LocalNode().Name}
+	live := liveMembers(a.MemberList)
+	var wg sync.WaitGroup
+	for _, n := range replicas {
+		wg.Add(1)
+		go func(n *memberlist.Node) {
+			defer wg.Done()
+			if !live[n.Name] {
+				// no point waiting on an rpc to find out
+				a.hint(ctx, n, s, el, notMemberError)
+				return
+			}
+			conn, err := a.Peers.Conn(n)
+			if err != nil {
+				a.hint(ctx, n, s, el, err)
+				return
+			}
+			_, err = proto.NewReplicationClient(conn).Replicate(ctx, req)
+			if unreachable(err) {
+				a.hint(ctx, n, s, el, err)
+			} else if err != nil {
+				a.logger(ctx).Warn("replicating failed", zap.Stringer("stream", s),
+					zap.Uint64("sequence", el.SequenceId), zap.String("peer", n.Name), zap.Error(err))
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+// AggregateState replays the stream and sends back whatever the aggregate type
+// says its state looks like.
+func (a *Aggregates) AggregateState(ctx context.Context, in *proto.StreamID) (*proto.AggregateState, error) {
+	s := streamID(in)
+	agg, err := replay(ctx, a.Storage, s)
+	var corrupt *data.CorruptStreamError
+	if err == data.UnknownAggregateError || errors.As(err, &corrupt) {
+		return nil, storageError(err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Cant Find it, originally: %s", err)
+	}
+	state, err := anypb.New(protobuf.MessageV2(agg.Message(s)))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can't pack aggregate state: %s", err)
+	}
+	return &proto.AggregateState{
+		Stream:          in,
+		CurrentSequence: agg.Sequence(),
+		State:           state,
+		Clock:           agg.VectorClock().Timestamp(),
+	}, nil
+}
+
+// AppendEvent is WriteLog for any aggregate.  It has to be sent to one of the
+// stream's replicas, which coordinates the write and replicates it to the others.
+// With Consensus, any member of the stream's group will do.
+func (a *Aggregates) AppendEvent(ctx context.Context, in *proto.NewEventLog) (*proto.ErrorDetails, error) {
+	if a.Draining() {
+		return drainingError()
+	}
+	if a.Consensus != nil {
+		return a.consensusAppend(ctx, in)
+	}
+	s := streamID(in.GetStream())
+	if !a.isReplica(s) {
+		return &proto.ErrorDetails{
+				Failed:    true,
+				ErrorCode: 1,
+				ErrorMsg:  "Wrong Node",
+			},
+			status.Errorf(codes.FailedPrecondition, "Wrong member")
+	}
+
+	el := in.GetLog()
+	err := txn(ctx, "append", s, func() error { return a.Storage.Append(s, el) })
+	if err != nil {
+		return &proto.ErrorDetails{
+			Failed:    true,
+			ErrorCode: 1,
+			ErrorMsg:  err.Error(),
+		}, storageError(err)
+	}
+	// Append stamped the clock on el; that is what the replicas merge
+	a.replicate(ctx, s, el)
+
+	return new(proto.ErrorDetails), nil
+}
+
+func conflictsProto(conflicts []data.Conflict) *proto.Conflicts {
+	out := &proto.Conflicts{Conflicts: make([]*proto.Conflict, 0, len(conflicts))}
+	for _, c := range conflicts {
+		out.Conflicts = append(out.Conflicts, &proto.Conflict{
+			SequenceId: c.Sequence,
+			Current:    c.Current,
+			Siblings:   c.Siblings,
+		})
+	}
+	return out
+}
+
+// ListConflicts this node has recorded for the stream.  Each replica resolves on its
+// own, so different replicas can have different (or no) siblings.
+func (a *Aggregates) ListConflicts(ctx context.Context, in *proto.StreamID) (*proto.Conflicts, error) {
+	conflicts, err := a.Storage.Conflicts(streamID(in))
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return conflictsProto(conflicts), nil
+}
+
+// ResolveConflicts either discards this node's siblings, or appends a merge log that
+// is replicated like any other write.  With Consensus there aren't any new
+// siblings; a merge is just an append.
+func (a *Aggregates) ResolveConflicts(ctx context.Context, in *proto.ResolveConflicts) (*proto.ErrorDetails, error) {
+	s := streamID(in.GetStream())
+	merged := in.GetMerged()
+	if merged != nil && a.Draining() {
+		return drainingError()
+	}
+	if merged != nil && a.Consensus != nil {
+		return a.consensusAppend(ctx, &proto.NewEventLog{Stream: in.GetStream(), Log: merged})
+	}
+	if merged != nil && !a.isReplica(s) {
+		return &proto.ErrorDetails{
+				Failed:    true,
+				ErrorCode: 1,
+				ErrorMsg:  "Wrong Node",
+			},
+			status.Errorf(codes.FailedPrecondition, "Wrong member")
+	}
+	err := txn(ctx, "resolve", s, func() error { return a.Storage.ResolveConflicts(s, merged) })
+	if err != nil {
+		return &proto.ErrorDetails{
+			Failed:    true,
+			ErrorCode: 1,
+			ErrorMsg:  err.Error(),
+		}, storageError(err)
+	}
+	if merged != nil {
+		a.replicate(ctx, s, merged)
+	}
+	return new(proto.ErrorDetails), nil
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+)
+
+// MerkleStore is what anti-entropy needs from storage on top of data.Storer.
+// data.BadgerStore is one.
+type MerkleStore interface {
+	MerkleTrees(partitionOf func(data.StreamID) int) (map[int]*data.MerkleTree, error)
+	LeafKeys(partitionOf func(data.StreamID) int, partition int, leaves []int) ([]data.KeyHash, error)
+	LogsByKey(keys [][]byte) ([]data.KeyedLog, error)
+}
+
+var (
+	aeRounds = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "antientropy",
+		Name:      "rounds_total",
+		Help:      "Anti-entropy rounds finished",
+	})
+	aePartitionsCompared = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "antientropy",
+		Name:      "partitions_compared_total",
+		Help:      "Partitions compared with their other replicas",
+	})
+	aePartitionsDiffered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "antientropy",
+		Name:      "partitions_differed_total",
+		Help:      "Partitions that had logs to pull from a replica",
+	})
+	aeLogsRepaired = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "antientropy",
+		Name:      "logs_repaired_total",
+		Help:      "Logs pulled from replicas",
+	})
+	aeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "antientropy",
+		Name:      "errors_total",
+		Help:      "Partitions that couldn't be synced with a replica",
+	})
+	aeRoundProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "antientropy",
+		Name:      "round_progress",
+		Help:      "How far through the current round, 0 to 1",
+	})
+	aeLastRoundSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "antientropy",
+		Name:      "last_round_seconds",
+		Help:      "How long the last finished round took",
+	})
+)
+
+// AntiEntropyMetrics for a prometheus registry: rounds, and how much they found
+func AntiEntropyMetrics() []prometheus.Collector {
+	return []prometheus.Collector{aeRounds, aePartitionsCompared, aePartitionsDiffered,
+		aeLogsRepaired, aeErrors, aeRoundProgress, aeLastRoundSeconds}
+}
+
+// AntiEntropy repairs replicas that drifted (lost writes, hints that expired) by
+// comparing merkle trees (see data.MerkleTree) of each partition this node holds
+// with the partition's other replicas, and pulling whatever they have that it
+// doesn't.  Pulling only: every replica runs it, so things move both ways.
+//
+// Pulled logs go through Storage.Replicate like any other replicated write, so
+// they can still turn out to be conflicts.
+type AntiEntropy struct {
+	Aggregates *Aggregates
+	Store      MerkleStore
+	// Partitions on the ring
+	Partitions int
+	// Interval between rounds; trees are rebuilt at most this often
+	Interval time.Duration
+	// Rate limits a round to this many partitions a second.  0 is flat out.
+	Rate float64
+
+	lock  sync.Mutex
+	trees map[int]*data.MerkleTree
+	built time.Time
+}
+
+func (ae *AntiEntropy) partitionOf(s data.StreamID) int {
+	return ae.Aggregates.HashList.FindPartitionID(s.HashKey())
+}
+
+func (ae *AntiEntropy) interval() time.Duration {
+	if ae.Interval == 0 {
+		return time.Minute
+	}
+	return ae.Interval
+}
+
+func (ae *AntiEntropy) rebuild() error {
+	trees, err := ae.Store.MerkleTrees(ae.partitionOf)
+	if err != nil {
+		return err
+	}
+	ae.lock.Lock()
+	ae.trees, ae.built = trees, time.Now()
+	ae.lock.Unlock()
+	return nil
+}
+
+// Tree of a partition; from the last rebuild unless that is more than an interval old.
+// The other replicas ask for these too, so they can be that stale: a change since
+// then is only seen next round.
+func (ae *AntiEntropy) Tree(partition int) (*data.MerkleTree, error) {
+	ae.lock.Lock()
+	stale := ae.trees == nil || time.Since(ae.built) > ae.interval()
+	ae.lock.Unlock()
+	if stale {
+		if err := ae.rebuild(); err != nil {
+			return nil, err
+		}
+	}
+	ae.lock.Lock()
+	defer ae.lock.Unlock()
+	if t, ok := ae.trees[partition]; ok {
+		return t, nil
+	}
+	return data.EmptyMerkleTree(), nil
+}
+
+// Run a round every Interval until stop is closed.  The first one is an Interval in:
+// a node does one round on its own while it's getting ready (see Readiness).
+func (ae *AntiEntropy) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(ae.interval()):
+		case <-stop:
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if err := ae.Round(ctx); err != nil {
+			ae.Aggregates.logger(ctx).Warn("anti-entropy round failed", zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+// Round compares every partition this node holds with its other live replicas
+func (ae *AntiEntropy) Round(ctx context.Context) error {
+	start := time.Now()
+	if err := ae.rebuild(); err != nil {
+		return err
+	}
+	a := ae.Aggregates
+	local := a.MemberList.LocalNode().Name
+	var pace time.Duration
+	if ae.Rate > 0 {
+		pace = time.Duration(float64(time.Second) / ae.Rate)
+	}
+
+	// the ring keeps dead nodes; no use trying them
+	live := liveMembers(a.MemberList)
+	aeRoundProgress.Set(0)
+	for p := 0; p < ae.Partitions; p++ {
+		owners, err := a.HashList.GetClosestNForPartition(p, a.replicaCount())
+		if err != nil {
+			return err
+		}
+		var replicas []*memberlist.Node
+		held := false
+		for _, o := range owners {
+			n := o.(WrappedNode).Node
+			if n.Name == local {
+				held = true
+			} else if live[n.Name] {
+				replicas = append(replicas, n)
+			}
+		}
+		if !held || len(replicas) == 0 {
+			continue
+		}
+
+		for _, n := range replicas {
+			repaired, err := ae.Sync(ctx, p, n)
+			if err != nil {
+				aeErrors.Inc()
+				a.logger(ctx).Warn("anti-entropy failed", zap.Int("partition", p), zap.String("peer", n.Name), zap.Error(err))
+			}
+			if repaired > 0 {
+				aePartitionsDiffered.Inc()
+				aeLogsRepaired.Add(float64(repaired))
+			}
+		}
+		aePartitionsCompared.Inc()
+		aeRoundProgress.Set(float64(p+1) / float64(ae.Partitions))
+
+		if pace > 0 {
+			select {
+			case <-time.After(pace):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	aeRoundProgress.Set(1)
+	aeRounds.Inc()
+	aeLastRoundSeconds.Set(time.Since(start).Seconds())
+	return nil
+}
+
+// Sync one partition from n: walk the trees down to the leaves that differ, then
+// fetch the logs n has that this node doesn't (or has different versions of).
+// Returns how many logs were pulled.
+func (ae *AntiEntropy) Sync(ctx context.Context, partition int, n *memberlist.Node) (int, error) {
+	local, err := ae.Tree(partition)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := ae.Aggregates.Peers.Conn(n)
+	if err != nil {
+		return 0, err
+	}
+	client := proto.NewReplicationClient(conn)
+
+	differ := []uint32{0}
+	for level := 0; level <= data.MerkleDepth; level++ {
+		remote, err := client.MerkleNodes(ctx, &proto.MerkleRequest{
+			Partition: uint32(partition),
+			Level:     uint32(level),
+			Indexes:   differ,
+		})
+		if err != nil {
+			return 0, err
+		}
+		if len(remote.GetHashes()) != len(differ) {
+			return 0, fmt.Errorf("asked for %d hashes, got %d", len(differ), len(remote.GetHashes()))
+		}
+		var next []uint32
+		for i, index := range differ {
+			if remote.Hashes[i] == local.Levels[level][index] {
+				continue
+			}
+			if level == data.MerkleDepth {
+				next = append(next, index)
+				continue
+			}
+			for c := uint32(0); c < data.MerkleFanout; c++ {
+				next = append(next, index*data.MerkleFanout+c)
+			}
+		}
+		if len(next) == 0 {
+			return 0, nil
+		}
+		differ = next
+	}
+
+	remoteKeys, err := client.MerkleLeafKeys(ctx, &proto.MerkleRequest{
+		Partition: uint32(partition),
+		Level:     data.MerkleDepth,
+		Indexes:   differ,
+	})
+	if err != nil {
+		return 0, err
+	}
+	leaves := make([]int, len(differ))
+	for i, l := range differ {
+		leaves[i] = int(l)
+	}
+	localKeys, err := ae.Store.LeafKeys(ae.partitionOf, partition, leaves)
+	if err != nil {
+		return 0, err
+	}
+	have := make(map[string]uint64, len(localKeys))
+	for _, kh := range localKeys {
+		have[string(kh.Key)] = kh.Hash
+	}
+	var want [][]byte
+	for _, kh := range remoteKeys.GetKeys() {
+		if h, ok := have[string(kh.Key)]; !ok || h != kh.Hash {
+			want = append(want, kh.Key)
+		}
+	}
+	if len(want) == 0 {
+		return 0, nil
+	}
+
+	stream, err := client.FetchLogs(ctx, &proto.FetchLogsRequest{Keys: want})
+	if err != nil {
+		return 0, err
+	}
+	var repaired int
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return repaired, nil
+		}
+		if err != nil {
+			return repaired, err
+		}
+		switch err = ae.Aggregates.Storage.Replicate(streamID(req.GetStream()), req.GetLog()); err {
+		case nil, data.ConflictError:
+			// a conflict is still repaired: it's recorded as a sibling now
+			repaired++
+		default:
+			ae.Aggregates.logger(ctx).Warn("anti-entropy repair failed", zap.Stringer("stream", streamID(req.GetStream())),
+				zap.Uint64("sequence", req.GetLog().GetSequenceId()), zap.String("peer", n.Name), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/buraksezer/consistent"
+	"github.com/cespare/xxhash"
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc"
+)
+
+type hasher struct{}
+
+func (hasher) Sum64(b []byte) uint64 { return xxhash.Sum64(b) }
+
+const testPartitions = 7
+
+func testRing(names ...string) *consistent.Consistent {
+	ring := consistent.New(nil, consistent.Config{
+		Hasher:            hasher{},
+		PartitionCount:    testPartitions,
+		ReplicationFactor: 20,
+		Load:              1.25,
+	})
+	for _, n := range names {
+		ring.Add(service.WrappedNode{Node: &memberlist.Node{Name: n}})
+	}
+	return ring
+}
+
+func TestAntiEntropySync(t *testing.T) {
+	ring := testRing("a", "b")
+	streams := []data.StreamID{data.CustomerStream("1"), data.CustomerStream("2"), data.CustomerStream("3")}
+
+	a := data.New(t.TempDir())
+	defer a.Close()
+	a.NodeID = "a"
+	b := data.New(t.TempDir())
+	defer b.Close()
+	b.NodeID = "b"
+	for i, s := range streams {
+		for seq := uint64(0); seq < 3; seq++ {
+			el := &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: "write"}}
+			if err := a.Append(s, el); err != nil {
+				t.Fatal(err)
+			}
+			// b lost the tail of every stream but the first
+			if i == 0 || seq == 0 {
+				if err := b.Replicate(s, el); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	// a serves its trees
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	proto.RegisterReplicationServer(srv, &service.Replication{
+		Storage: a,
+		AntiEntropy: &service.AntiEntropy{
+			Aggregates: &service.Aggregates{Storage: a, HashList: ring},
+			Store:      a,
+			Partitions: testPartitions,
+		},
+	})
+	go srv.Serve(lis)
+	defer srv.Stop()
+	meta, _ := json.Marshal(cluster.Meta{GRPCAddr: lis.Addr().String()})
+	nodeA := &memberlist.Node{Name: "a", Meta: meta, State: memberlist.StateAlive}
+
+	peers := &cluster.Peers{DialOptions: []grpc.DialOption{grpc.WithInsecure()}}
+	defer peers.Close()
+	ae := &service.AntiEntropy{
+		Aggregates: &service.Aggregates{Storage: b, HashList: ring, Peers: peers},
+		Store:      b,
+		Partitions: testPartitions,
+	}
+
+	var repaired int
+	for p := 0; p < testPartitions; p++ {
+		n, err := ae.Sync(context.Background(), p, nodeA)
+		if err != nil {
+			t.Fatalf("partition %d: %s", p, err)
+		}
+		repaired += n
+	}
+	if repaired != 4 {
+		t.Errorf("expected 4 logs repaired, got %d", repaired)
+	}
+	for _, s := range streams {
+		agg, err := b.GetState(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if agg.Sequence() != 2 {
+			t.Errorf("%s: expected sequence 2 after anti-entropy, got %d", s, agg.Sequence())
+		}
+	}
+
+	t.Run("nothing to do once they agree", func(t *testing.T) {
+		ae := &service.AntiEntropy{
+			Aggregates: &service.Aggregates{Storage: b, HashList: ring, Peers: peers},
+			Store:      b,
+			Partitions: testPartitions,
+		}
+		for p := 0; p < testPartitions; p++ {
+			if n, err := ae.Sync(context.Background(), p, nodeA); err != nil || n != 0 {
+				t.Errorf("partition %d: repaired %d (err %v)", p, n, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/yarbelk/distributedservice/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// backupChunk is how much of a backup goes in each message
+const backupChunk = 64 << 10
+
+// chunkWriter sends what's written to it as BackupChunks, none bigger than
+// backupChunk: badger writes whole batches, which can be past grpc's message limit
+type chunkWriter func(*proto.BackupChunk) error
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	for sent := 0; sent < len(p); {
+		n := len(p) - sent
+		if n > backupChunk {
+			n = backupChunk
+		}
+		// grpc has the message until it's sent, and badger reuses p
+		if err := w(&proto.BackupChunk{Data: append([]byte(nil), p[sent:sent+n]...)}); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return len(p), nil
+}
+
+// Backup streams the store's backup, then the version to back up since next time
+func (m *Management) Backup(in *proto.BackupRequest, s proto.ClusterManagment_BackupServer) error {
+	if m.Backups == nil {
+		return status.Error(codes.Unimplemented, "this node can't back up")
+	}
+	w := bufio.NewWriterSize(chunkWriter(s.Send), backupChunk)
+	version, err := m.Backups.Backup(w, in.GetSince())
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return rpcError(err)
+	}
+	return s.Send(&proto.BackupChunk{Version: version})
+}
+
+// chunkReader reads the data out of BackupChunks as they come
+type chunkReader struct {
+	recv func() (*proto.BackupChunk, error)
+	data []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		c, err := r.recv()
+		if err != nil {
+			return 0, err
+		}
+		r.data = c.GetData()
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Restore the streamed backup into the store, which has to be empty
+func (m *Management) Restore(s proto.ClusterManagment_RestoreServer) error {
+	if m.Backups == nil {
+		return status.Error(codes.Unimplemented, "this node can't restore")
+	}
+	err := m.Backups.Restore(&chunkReader{recv: s.Recv})
+	if err == io.ErrUnexpectedEOF {
+		return status.Error(codes.InvalidArgument, "the backup is cut short")
+	}
+	if err != nil {
+		return rpcError(err)
+	}
+	return s.SendAndClose(&emptypb.Empty{})
+}
+
+// rpcError is the stream's own errors as they are, and the store's as storageErrors
+func rpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return storageError(err)
+}
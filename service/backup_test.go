@@ -0,0 +1,125 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func managementClient(t *testing.T, m *service.Management) proto.ClusterManagmentClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	proto.RegisterClusterManagmentServer(srv, m)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewClusterManagmentClient(conn)
+}
+
+// backup from client into chunks, and the version it ends at
+func backup(t *testing.T, client proto.ClusterManagmentClient, since uint64) ([]*proto.BackupChunk, uint64) {
+	t.Helper()
+	stream, err := client.Backup(context.Background(), &proto.BackupRequest{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []*proto.BackupChunk
+	for {
+		c, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, c)
+	}
+	if len(chunks) == 0 {
+		t.Fatal("expected a backup")
+	}
+	return chunks, chunks[len(chunks)-1].GetVersion()
+}
+
+func restore(client proto.ClusterManagmentClient, chunks []*proto.BackupChunk) error {
+	stream, err := client.Restore(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if err := stream.Send(c); err != nil {
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func TestBackupRestore(t *testing.T) {
+	store := data.New(t.TempDir())
+	defer store.Close()
+	from := managementClient(t, &service.Management{Backups: store})
+	// bigger than a chunk, so it's split
+	big := strings.Repeat("x", 200<<10)
+	for id := uint64(0); id < 5; id++ {
+		if err := store.WriteLog(id, &proto.CustomerEventLog{SequenceId: 0, Action: &proto.Action{Action: big}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	full, version := backup(t, from, 0)
+	// more for some, and some new customers
+	for id := uint64(3); id < 7; id++ {
+		seq := uint64(0)
+		if id < 5 {
+			seq = 1
+		}
+		if err := store.WriteLog(id, &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: "more"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	incremental, _ := backup(t, from, version)
+
+	restored := data.New(t.TempDir())
+	defer restored.Close()
+	to := managementClient(t, &service.Management{Backups: restored})
+	if err := restore(to, append(full, incremental...)); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(0); id < 7; id++ {
+		expected, err := store.GetCustomerState(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := restored.GetCustomerState(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("customer %d: expected %+v, got %+v", id, expected, got)
+		}
+	}
+
+	if err := restore(to, full); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected restoring over data to fail, got %v", err)
+	}
+	if err := restore(managementClient(t, &service.Management{}), full); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected restoring without a store to be unimplemented, got %v", err)
+	}
+}
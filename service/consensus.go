@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/raft"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/raftgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Consensus replaces best effort replication with something that agrees on the order
+// of appends: a raftgroup.Manager.  Append returns a *raftgroup.NotLeaderError
+// when it has to be sent somewhere else.
+type Consensus interface {
+	// Member is true if this node holds the stream
+	Member(s data.StreamID) bool
+	Append(s data.StreamID, el *proto.CustomerEventLog) error
+	// Leave hands off leadership and waits until this node is out of every group,
+	// for draining
+	Leave(timeout time.Duration) error
+}
+
+// forwardedKey marks an append a follower has passed on to its leader.  It's only
+// passed on once: if leadership moved again in the meantime the client retries.
+const forwardedKey = "x-raft-forwarded"
+
+// forwarded is true if another node passed the call on.  Anyone can send the
+// header, so it only counts if fromNode says the caller is a node; nil says nobody is
+func forwarded(ctx context.Context, fromNode func(context.Context) bool) bool {
+	if fromNode == nil || !fromNode(ctx) {
+		return false
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get(forwardedKey)) > 0
+}
+
+func (a *Aggregates) member(name string) *memberlist.Node {
+	for _, n := range a.MemberList.Members() {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// consensusAppend is AppendEvent when there is a Consensus: followers forward the
+// append to their leader.
+func (a *Aggregates) consensusAppend(ctx context.Context, in *proto.NewEventLog) (*proto.ErrorDetails, error) {
+	s := streamID(in.GetStream())
+	if !a.Consensus.Member(s) {
+		return &proto.ErrorDetails{
+				Failed:    true,
+				ErrorCode: 1,
+				ErrorMsg:  "Wrong Node",
+			},
+			status.Errorf(codes.FailedPrecondition, "Wrong member")
+	}
+
+	err := a.Consensus.Append(s, in.GetLog())
+	var notLeader *raftgroup.NotLeaderError
+	if errors.As(err, &notLeader) {
+		leader := a.member(notLeader.Leader)
+		if leader == nil || a.Peers == nil || forwarded(ctx, a.FromNode) {
+			return &proto.ErrorDetails{
+					Failed:    true,
+					ErrorCode: 1,
+					ErrorMsg:  err.Error(),
+				},
+				status.Errorf(codes.Unavailable, err.Error())
+		}
+		conn, err := a.Peers.Conn(leader)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "can't reach leader %s: %s", leader.Name, err)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, forwardedKey, a.MemberList.LocalNode().Name)
+		return proto.NewEventStoreClient(conn).AppendEvent(ctx, in)
+	}
+	if err != nil {
+		return &proto.ErrorDetails{
+			Failed:    true,
+			ErrorCode: 1,
+			ErrorMsg:  err.Error(),
+		}, storageError(err)
+	}
+	return new(proto.ErrorDetails), nil
+}
+
+// GroupServers is the raft membership for a group: the replicas of its partition
+// (partition == group number), addressed by their gossiped raft address.
+func (a *Aggregates) GroupServers(group int) []raft.Server {
+	owners, err := a.HashList.GetClosestNForPartition(group, a.replicaCount())
+	if err != nil {
+		return nil
+	}
+	servers := make([]raft.Server, 0, len(owners))
+	for _, o := range owners {
+		node := o.(WrappedNode).Node
+		meta, err := cluster.NodeMeta(node)
+		if err != nil || meta.RaftAddr == "" {
+			continue
+		}
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(node.Name),
+			Address: raft.ServerAddress(meta.RaftAddr),
+		})
+	}
+	return servers
+}
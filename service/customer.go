@@ -2,15 +2,16 @@ package service
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"strconv"
-	"time"
 
-	"github.com/buraksezer/consistent"
 	"github.com/hashicorp/memberlist"
 	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/logging"
 	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -22,31 +23,39 @@ func (wn WrappedNode) String() string {
 	return wn.Name
 }
 
+// Customer is the original customer only api.  It is a compatibility layer over
+// Aggregates, using the customer aggregate type for every stream.
 type Customer struct {
-	Storage data.Storer
-
-	MemberList *memberlist.Memberlist
-
-	HashList *consistent.Consistent
+	Aggregates *Aggregates
+	// Follower is the store's streams, as they're written, for StreamEventLog.  nil
+	// turns it off
+	Follower data.Follower
+	// Logger nil is the Aggregates'
+	Logger *zap.Logger
 
 	proto.UnimplementedProtoStuffServer
 }
 
-// StreamEventLog is 100% fake.  pretend its listening for new written logs using something
-// like badger's DB.NewStream(); with prefix := []byte(fmt.Sprintf("%d:",in.Customer.ID))
-// and applying/sending.  a bit more work than i want to do now; but this lets you send persisted
-// events only in very timely manner to subscribers.  Streaming is fun.
+// StreamFromHeader is the header StreamEventLog sends before any logs: the sequenceId
+// the stream starts at.  A stream that's turned away doesn't get one
+const StreamFromHeader = "x-stream-from"
+
+// StreamEventLog follows the customer's stream: its logs from in.From (or from now
+// on), in sequence order, as they're written.  They come straight from badger as
+// they're committed, so a slow reader just reads behind; nothing piles up.  Like
+// CustomerState it's this node's copy, so ask one of the customer's replicas.
 func (c *Customer) StreamEventLog(in *proto.Customer, s proto.ProtoStuff_StreamEventLogServer) error {
-	for i := 0; true; i++ {
-		<-time.After(1 * time.Second)
-		s.Send(&proto.CustomerEventLog{
-			SequenceId: uint64(i),
-			Timestamp:  &proto.VectorTimestamp{Timestamps: []int64{time.Now().Unix()}},
-			Action:     &proto.Action{Action: fmt.Sprintf("Action %d", i)},
-		})
-		i++
+	if c.Follower == nil {
+		return status.Errorf(codes.Unimplemented, "this node can't follow streams")
+	}
+	stream := data.CustomerStream(data.KeyOrID(in.GetKey(), in.GetId()))
+	err := c.Follower.Follow(s.Context(), stream, in.From, func(from uint64) error {
+		return s.SendHeader(metadata.Pairs(StreamFromHeader, strconv.FormatUint(from, 10)))
+	}, s.Send)
+	if _, ok := status.FromError(err); ok {
+		return err
 	}
-	return nil
+	return storageError(err)
 }
 
 // CustomerState is a straight lookup.  Internally badger uses ristretto now (I believe; it is in
@@ -55,8 +64,15 @@ func (c *Customer) StreamEventLog(in *proto.Customer, s proto.ProtoStuff_StreamE
 // but canonical store
 func (c *Customer) CustomerState(ctx context.Context, in *proto.Customer) (*proto.CustomerState, error) {
 	// we are assuming its asking the right node.
-	cs, err := c.Storage.GetCustomerState(in.Id)
+	agg, err := replay(ctx, c.Aggregates.Storage, data.CustomerStream(data.KeyOrID(in.GetKey(), in.GetId())))
+	if err != nil {
+		c.logger(ctx).Debug("can't get customer state", zap.Uint64("customer_id", in.GetId()), zap.Error(err))
+	}
 
+	var corrupt *data.CorruptStreamError
+	if errors.As(err, &corrupt) {
+		return nil, storageError(err)
+	}
 	if err != nil {
 		// if you have slower canonical backing store:
 		//   if cs, err = c.SlowStorage.CustomerState(ctx, in); err == nil {
@@ -64,8 +80,10 @@ func (c *Customer) CustomerState(ctx context.Context, in *proto.Customer) (*prot
 		//   }
 		return nil, status.Errorf(codes.NotFound, "Cant Find it, originally: %s", err)
 	}
+	cs := agg.(*data.CustomerState)
 	out := &proto.CustomerState{
 		Id:              in.Id,
+		Key:             in.Key,
 		LastAction:      cs.LastAction,
 		CurrentSequence: cs.CurrentSequence,
 	}
@@ -78,25 +96,34 @@ func (c *Customer) CustomerState(ctx context.Context, in *proto.Customer) (*prot
 // If you're using this as a caching layer; then WriteLog is only here for hot loading data based
 // on predicted usage.
 func (c *Customer) WriteLog(ctx context.Context, el *proto.NewCustomerLog) (*proto.ErrorDetails, error) {
-	// handle filtering on member list and consistent hash
-	// (not really tested for replicationFactors)
-	if c.HashList.LocateKey([]byte(strconv.FormatUint(el.GetCustomerID(), 10))).String() != c.MemberList.LocalNode().Name {
-		return &proto.ErrorDetails{
-				Failed:    true,
-				ErrorCode: 1,
-				ErrorMsg:  "Wrong Node",
-			},
-			status.Errorf(codes.FailedPrecondition, "Wrong member")
+	details, err := c.Aggregates.AppendEvent(ctx, &proto.NewEventLog{
+		Stream: &proto.StreamID{AggregateType: data.CustomerAggregate, Id: el.GetCustomerID(), Key: el.GetCustomerKey()},
+		Log:    el.GetLog(),
+	})
+	if err != nil {
+		c.logger(ctx).Debug("write turned down", zap.Uint64("customer_id", el.GetCustomerID()),
+			zap.Uint64("sequence", el.GetLog().GetSequenceId()), zap.Error(err))
 	}
+	return details, err
+}
 
-	err := c.Storage.WriteLog(el.GetCustomerID(), el.GetLog())
-	if err != nil {
-		return &proto.ErrorDetails{
-			Failed:    true,
-			ErrorCode: 1,
-			ErrorMsg:  err.Error(),
-		}, status.Errorf(codes.Unknown, err.Error())
+// logger for ctx: the rpc's if it's in one
+func (c *Customer) logger(ctx context.Context) *zap.Logger {
+	if c.Logger != nil {
+		return logging.FromContext(ctx, c.Logger)
 	}
+	return c.Aggregates.logger(ctx)
+}
+
+// CustomerConflicts is ListConflicts for the customer's stream
+func (c *Customer) CustomerConflicts(ctx context.Context, in *proto.Customer) (*proto.Conflicts, error) {
+	return c.Aggregates.ListConflicts(ctx, &proto.StreamID{AggregateType: data.CustomerAggregate, Id: in.GetId(), Key: in.GetKey()})
+}
 
-	return new(proto.ErrorDetails), nil
+// ResolveCustomerConflicts is ResolveConflicts for the customer's stream
+func (c *Customer) ResolveCustomerConflicts(ctx context.Context, in *proto.ResolveCustomerConflicts) (*proto.ErrorDetails, error) {
+	return c.Aggregates.ResolveConflicts(ctx, &proto.ResolveConflicts{
+		Stream: &proto.StreamID{AggregateType: data.CustomerAggregate, Id: in.GetCustomerID(), Key: in.GetCustomerKey()},
+		Merged: in.GetMerged(),
+	})
 }
@@ -2,11 +2,16 @@ package service_test
 
 import (
 	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/yarbelk/distributedservice/data"
 	"github.com/yarbelk/distributedservice/proto"
 	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // MockStorer because we are not trying to test the Storer; do that in that package.
@@ -21,23 +26,38 @@ type MockStorer struct {
 	log                *proto.CustomerEventLog
 }
 
-func (m *MockStorer) GetCustomerState(id uint64) (data.CustomerState, error) {
+func (m *MockStorer) GetState(s data.StreamID) (data.Aggregate, error) {
 	m.customerStateCalled = true
-	return m.customerState, m.customerStateError
+	cs := m.customerState
+	return &cs, m.customerStateError
 }
 
-func (m *MockStorer) WriteLog(id uint64, el *proto.CustomerEventLog) error {
+func (m *MockStorer) Append(s data.StreamID, el *proto.CustomerEventLog) error {
 	m.writeLogCalled = true
 	m.log = el
 	return nil
 }
 
+func (m *MockStorer) Replicate(s data.StreamID, el *proto.CustomerEventLog) error {
+	m.writeLogCalled = true
+	m.log = el
+	return nil
+}
+
+func (m *MockStorer) Conflicts(s data.StreamID) ([]data.Conflict, error) {
+	return nil, nil
+}
+
+func (m *MockStorer) ResolveConflicts(s data.StreamID, merged *proto.CustomerEventLog) error {
+	return nil
+}
+
 // These are testing stubs: basically to show _some_ of what I'm thinking
 // I put them together from quick notes i made in-lieu of formal TDD due to
 // time constraints
 
 func TestSimpleLookup(t *testing.T) {
-	c := service.Customer{Storage: &MockStorer{customerState: data.CustomerState{LastAction: "fake", CurrentSequence: 0}}}
+	c := service.Customer{Aggregates: &service.Aggregates{Storage: &MockStorer{customerState: data.CustomerState{LastAction: "fake", CurrentSequence: 0}}}}
 
 	var tests = []struct {
 		name     string
@@ -60,6 +80,18 @@ func TestSimpleLookup(t *testing.T) {
 	}
 }
 
+func TestCorruptStream(t *testing.T) {
+	// a gap in what's stored isn't a customer that isn't there
+	corrupt := &data.CorruptStreamError{Stream: data.CustomerStream("1"), Sequence: 2, Err: data.InvalidSequenceError}
+	c := service.Customer{Aggregates: &service.Aggregates{Storage: &MockStorer{customerStateError: corrupt}}}
+	if _, err := c.CustomerState(context.Background(), &proto.Customer{Id: 1}); status.Code(err) != codes.DataLoss {
+		t.Errorf("CustomerState: expected DataLoss, got %v", err)
+	}
+	if _, err := c.Aggregates.AggregateState(context.Background(), &proto.StreamID{AggregateType: data.CustomerAggregate, Id: 1}); status.Code(err) != codes.DataLoss {
+		t.Errorf("AggregateState: expected DataLoss, got %v", err)
+	}
+}
+
 func TestHashFilterServer(t *testing.T) {
 	// SEtup the mock storage
 	// setup a memberlist with mock transport etc (hashicorp is awesome and gives you all this stuff for testing)
@@ -91,3 +123,70 @@ func TestHashFilterClient(t *testing.T) {
 		// blah
 	})
 }
+
+func TestStreamEventLog(t *testing.T) {
+	store := data.New(t.TempDir())
+	defer store.Close()
+	s := data.CustomerStream("7")
+	for seq := uint64(0); seq < 2; seq++ {
+		if err := store.Append(s, &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: "write"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	proto.RegisterProtoStuffServer(srv, &service.Customer{Aggregates: &service.Aggregates{Storage: store}, Follower: store})
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := proto.NewProtoStuffClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	from := uint64(0)
+	resumed, err := client.StreamEventLog(ctx, &proto.Customer{Id: 7, From: &from})
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := client.StreamEventLog(ctx, &proto.Customer{Key: []byte("7")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, stream := range map[string]proto.ProtoStuff_StreamEventLogClient{"resumed": resumed, "live": live} {
+		header, err := stream.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{"resumed": "0", "live": "2"}[name]
+		if got := header.Get(service.StreamFromHeader); len(got) != 1 || got[0] != expected {
+			t.Errorf("%s: expected to start at %s, got %v", name, expected, got)
+		}
+	}
+	if err := store.Append(s, &proto.CustomerEventLog{SequenceId: 2, Action: &proto.Action{Action: "write"}}); err != nil {
+		t.Fatal(err)
+	}
+	for stream, expected := range map[proto.ProtoStuff_StreamEventLogClient][]uint64{resumed: {0, 1, 2}, live: {2}} {
+		for _, seq := range expected {
+			el, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if el.GetSequenceId() != seq {
+				t.Errorf("expected %d, got %d", seq, el.GetSequenceId())
+			}
+		}
+	}
+
+	// no follower: nothing to stream
+	srv2 := &service.Customer{Aggregates: &service.Aggregates{Storage: store}}
+	if err := srv2.StreamEventLog(&proto.Customer{Id: 7}, nil); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented without a follower, got %v", err)
+	}
+}
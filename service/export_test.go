@@ -0,0 +1,4 @@
+package service
+
+// exported for the service_test package only
+const ForwardedKey = forwardedKey
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// hintBatch is how many hints are read at a time when replaying
+const hintBatch = 100
+
+// unreachable is true for the errors that mean the replica didn't get the log at
+// all, as opposed to getting it and turning it down.
+func unreachable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return true
+	}
+	return false
+}
+
+// hint a log for a replica that can't take it right now
+func (a *Aggregates) hint(ctx context.Context, n *memberlist.Node, s data.StreamID, el *proto.CustomerEventLog, why error) {
+	l := a.logger(ctx).With(zap.Stringer("stream", s), zap.Uint64("sequence", el.SequenceId), zap.String("peer", n.Name))
+	if a.Hints == nil {
+		l.Warn("replicating failed", zap.Error(why))
+		return
+	}
+	if err := a.Hints.StoreHint(n.Name, s, el); err != nil {
+		l.Error("replicating failed, and can't store a hint", zap.NamedError("why", why), zap.Error(err))
+		return
+	}
+	l.Debug("stored a hint", zap.NamedError("why", why))
+}
+
+// Handoff replays hints when memberlist says their node is alive again.  It's the
+// memberlist.EventDelegate: set it as the config's Events before creating the
+// memberlist.
+type Handoff struct {
+	Hints data.HintStore
+	Peers *cluster.Peers
+	// Logger nil is zap's global logger
+	Logger *zap.Logger
+
+	lock      sync.Mutex
+	replaying map[string]bool
+	wait      sync.WaitGroup
+}
+
+// NotifyJoin a node joined (or came back)
+func (h *Handoff) NotifyJoin(n *memberlist.Node) { h.start(n) }
+
+// NotifyLeave nothing to do; the hints wait for it to come back, or expire
+func (h *Handoff) NotifyLeave(n *memberlist.Node) {}
+
+// NotifyUpdate a node's meta changed; it might have a new grpc address
+func (h *Handoff) NotifyUpdate(n *memberlist.Node) { h.start(n) }
+
+// start replaying in the background: memberlist calls the delegate with its locks held
+func (h *Handoff) start(n *memberlist.Node) {
+	node := *n
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.replaying == nil {
+		h.replaying = make(map[string]bool)
+	}
+	if h.replaying[node.Name] {
+		return
+	}
+	h.replaying[node.Name] = true
+	h.wait.Add(1)
+	go func() {
+		defer h.wait.Done()
+		defer func() {
+			h.lock.Lock()
+			delete(h.replaying, node.Name)
+			h.lock.Unlock()
+		}()
+		if err := h.Replay(&node); err != nil {
+			logging.Or(h.Logger).Warn("replaying hints stopped", zap.String("peer", node.Name), zap.Error(err))
+		}
+	}()
+}
+
+// Wait for the replays that are running.  Stop memberlist first, or more can start.
+func (h *Handoff) Wait() {
+	h.wait.Wait()
+}
+
+// Replay every hint held for n, oldest first.  Hints n turns down (a conflict, say)
+// are still delivered; it stops at the first one that doesn't get there.
+func (h *Handoff) Replay(n *memberlist.Node) error {
+	for {
+		hints, err := h.Hints.Hints(n.Name, hintBatch)
+		if err != nil || len(hints) == 0 {
+			return err
+		}
+		conn, err := h.Peers.Conn(n)
+		if err != nil {
+			return err
+		}
+		client := proto.NewReplicationClient(conn)
+		for _, hint := range hints {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err = client.Replicate(ctx, &proto.ReplicateRequest{
+				Stream: streamProto(hint.Stream),
+				Log:    hint.Log,
+				From:   hint.From,
+			})
+			cancel()
+			if unreachable(err) {
+				return err
+			}
+			if err != nil {
+				logging.Or(h.Logger).Warn("hint turned down", zap.Stringer("stream", hint.Stream),
+					zap.Uint64("sequence", hint.Log.SequenceId), zap.String("peer", n.Name), zap.Error(err))
+			}
+			if err = h.Hints.DeleteHint(hint); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// notMemberError is why a replica memberlist has dropped gets a hint
+var notMemberError = errors.New("node isn't a member: it's dead or it left")
+
+// liveMembers are the names of the nodes memberlist has as members (alive or
+// suspect).  The nodes it hands out can't say themselves: their Node.State is never
+// set, memberlist keeps the state next to the node instead
+func liveMembers(ml *memberlist.Memberlist) map[string]bool {
+	live := map[string]bool{}
+	for _, n := range ml.Members() {
+		live[n.Name] = true
+	}
+	return live
+}
@@ -0,0 +1,173 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc"
+)
+
+// replicaServer runs the Replication service over store, and returns the node as
+// memberlist would hand it out
+func replicaServer(t *testing.T, store data.Storer) *memberlist.Node {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	proto.RegisterReplicationServer(srv, &service.Replication{Storage: store})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return replicaNode(t, lis.Addr().String())
+}
+
+func replicaNode(t *testing.T, addr string) *memberlist.Node {
+	meta, err := json.Marshal(cluster.Meta{GRPCAddr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &memberlist.Node{Name: "b", Meta: meta, State: memberlist.StateAlive}
+}
+
+func TestHandoffReplay(t *testing.T) {
+	s := data.CustomerStream("1")
+	hinted := func(t *testing.T) *data.BadgerStore {
+		coordinator := data.New(t.TempDir())
+		t.Cleanup(func() { coordinator.Close() })
+		coordinator.NodeID = "a"
+		for seq := uint64(0); seq < 3; seq++ {
+			el := &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: "write"}}
+			if err := coordinator.Append(s, el); err != nil {
+				t.Fatal(err)
+			}
+			if err := coordinator.StoreHint("b", s, el); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return coordinator
+	}
+	peers := &cluster.Peers{DialOptions: []grpc.DialOption{grpc.WithInsecure()}}
+	defer peers.Close()
+
+	t.Run("hints are delivered and dropped once the node is back", func(t *testing.T) {
+		coordinator := hinted(t)
+		replica := data.New(t.TempDir())
+		defer replica.Close()
+		node := replicaServer(t, replica)
+
+		h := &service.Handoff{Hints: coordinator, Peers: peers}
+		if err := h.Replay(node); err != nil {
+			t.Fatal(err)
+		}
+		agg, err := replica.GetState(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if agg.Sequence() != 2 {
+			t.Errorf("expected the replica at sequence 2, got %d", agg.Sequence())
+		}
+		if hints, _ := coordinator.Hints("b", 10); len(hints) != 0 {
+			t.Errorf("expected no hints left, got %d", len(hints))
+		}
+	})
+
+	t.Run("hints are kept while the node can't be reached", func(t *testing.T) {
+		coordinator := hinted(t)
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := lis.Addr().String()
+		lis.Close()
+
+		h := &service.Handoff{Hints: coordinator, Peers: &cluster.Peers{DialOptions: []grpc.DialOption{grpc.WithInsecure()}}}
+		if err := h.Replay(replicaNode(t, addr)); err == nil {
+			t.Error("expected replay to fail")
+		}
+		if hints, _ := coordinator.Hints("b", 10); len(hints) != 3 {
+			t.Errorf("expected all 3 hints kept, got %d", len(hints))
+		}
+	})
+}
+
+func TestReplicateHintsLeftNodes(t *testing.T) {
+	// b counts the replicates it's sent
+	replica := data.New(t.TempDir())
+	defer replica.Close()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return handler(ctx, req)
+	}))
+	proto.RegisterReplicationServer(srv, &service.Replication{Storage: replica})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	a, _ := newMember(t, "a", "127.0.0.1:1")
+	b, _ := newMember(t, "b", lis.Addr().String())
+	if _, err := b.Join([]string{fmt.Sprintf("%s:%d", a.LocalNode().Addr, a.LocalNode().Port)}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); a.NumMembers() < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ring := testRing()
+	for _, n := range a.Members() {
+		ring.Add(service.WrappedNode{Node: n})
+	}
+
+	coordinator := data.New(t.TempDir())
+	defer coordinator.Close()
+	coordinator.NodeID = "a"
+	peers := &cluster.Peers{DialOptions: []grpc.DialOption{grpc.WithInsecure()}}
+	defer peers.Close()
+	aggs := &service.Aggregates{Storage: coordinator, MemberList: a, HashList: ring, ReplicationFactor: 2, Peers: peers, Hints: coordinator}
+	write := func(seq uint64) {
+		t.Helper()
+		_, err := aggs.AppendEvent(context.Background(), &proto.NewEventLog{
+			Stream: &proto.StreamID{AggregateType: data.CustomerAggregate, Id: 1},
+			Log:    &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: "write"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(0)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected b to be replicated to while it's a member, got %d calls", n)
+	}
+
+	// b leaves; the ring doesn't change, nothing's taken it off
+	if err := b.Leave(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); a.NumMembers() > 1 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	write(1)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected no replicate to a node that left, got %d more", n-1)
+	}
+	hints, err := coordinator.Hints("b", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hints) != 1 || hints[0].Log.SequenceId != 1 {
+		t.Errorf("expected a hint for b's missed log, got %+v", hints)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/logging"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// DefaultMaxHealthScore is the memberlist health score (see
+// memberlist.GetHealthScore) past which a node counts itself unhealthy.  0 is
+// perfectly healthy; it goes up as the node misses probes and gets accused of
+// being dead.
+const DefaultMaxHealthScore = 4
+
+// HealthCheck is something a service depends on; nil is healthy
+type HealthCheck func() error
+
+// HealthService is a grpc service, and the checks it needs to pass to be SERVING
+type HealthService struct {
+	Name   string
+	Checks []HealthCheck
+}
+
+// HealthChecks keeps the grpc health service's per service statuses up to date.
+// A service is SERVING if all its checks pass and the node is ready (or it is one of
+// the services Readiness always serves).  The whole server ("") is SERVING if the
+// node is ready and every service is.
+type HealthChecks struct {
+	Health    *health.Server
+	Readiness *Readiness
+	Services  []HealthService
+	// Logger nil is zap's global logger
+	Logger *zap.Logger
+
+	lock sync.Mutex
+	// last errors, to only log changes
+	failing map[string]string
+}
+
+// MemberlistCheck fails when memberlist's health score is over max
+func MemberlistCheck(ml *memberlist.Memberlist, max int) HealthCheck {
+	return func() error {
+		if score := ml.GetHealthScore(); score > max {
+			return fmt.Errorf("memberlist health score is %d (over %d)", score, max)
+		}
+		return nil
+	}
+}
+
+// Check everything once and set the statuses
+func (h *HealthChecks) Check() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.failing == nil {
+		h.failing = make(map[string]string)
+	}
+	ready := h.Readiness == nil || h.Readiness.State() == Ready
+	all := true
+	for _, svc := range h.Services {
+		err := h.check(svc)
+		h.logChange(svc.Name, err)
+		serving := err == nil && (ready || h.Readiness.always(svc.Name))
+		all = all && err == nil
+		h.Health.SetServingStatus(svc.Name, servingStatus(serving))
+	}
+	// before it's ready Readiness has "" NOT_SERVING; leave it be
+	if ready {
+		h.Health.SetServingStatus("", servingStatus(all))
+	}
+}
+
+func (h *HealthChecks) check(svc HealthService) error {
+	for _, c := range svc.Checks {
+		if err := c(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HealthChecks) logChange(name string, err error) {
+	was, failing := h.failing[name]
+	switch {
+	case err != nil && was != err.Error():
+		logging.Or(h.Logger).Warn("unhealthy", zap.String("service", name), zap.Error(err))
+		h.failing[name] = err.Error()
+	case err == nil && failing:
+		logging.Or(h.Logger).Info("healthy again", zap.String("service", name))
+		delete(h.failing, name)
+	}
+}
+
+// Run checks every interval until stop is closed
+func (h *HealthChecks) Run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		h.Check()
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func servingStatus(serving bool) healthpb.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,56 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthChecks(t *testing.T) {
+	var storeErr error
+	h := health.NewServer()
+	ready := service.NewReadiness(h, "Replication")
+	checks := &service.HealthChecks{
+		Health:    h,
+		Readiness: ready,
+		Services: []service.HealthService{
+			{Name: "EventStore", Checks: []service.HealthCheck{func() error { return storeErr }}},
+			{Name: "Replication"},
+		},
+	}
+	status := func(name string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	checks.Check()
+	if status("") != healthpb.HealthCheckResponse_NOT_SERVING || status("EventStore") != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected NOT_SERVING before ready")
+	}
+	if status("Replication") != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected always served services to be SERVING before ready")
+	}
+
+	ready.Set(service.Ready)
+	checks.Check()
+	if status("") != healthpb.HealthCheckResponse_SERVING || status("EventStore") != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected SERVING once ready")
+	}
+
+	storeErr = errors.New("badger's gone")
+	checks.Check()
+	if status("") != healthpb.HealthCheckResponse_NOT_SERVING || status("EventStore") != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected a failing check to make it NOT_SERVING")
+	}
+	if status("Replication") != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected services without the failing check to keep SERVING")
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// keyringCall is one of the keyring rpcs on another node
+type keyringCall func(proto.ClusterManagmentClient, context.Context, *proto.KeyRequest) (*proto.KeyringResponse, error)
+
+// ListKeys on every member
+func (m *Management) ListKeys(ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+	return m.keyring(ctx, in, nil, func(c proto.ClusterManagmentClient, ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+		return c.ListKeys(ctx, in)
+	})
+}
+
+// InstallKey on every member: they can all decrypt with it
+func (m *Management) InstallKey(ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+	return m.keyring(ctx, in, m.Keys.Install, func(c proto.ClusterManagmentClient, ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+		return c.InstallKey(ctx, in)
+	})
+}
+
+// UseKey on every member: they encrypt with it.  It has to be installed everywhere first
+func (m *Management) UseKey(ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+	return m.keyring(ctx, in, m.Keys.Use, func(c proto.ClusterManagmentClient, ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+		return c.UseKey(ctx, in)
+	})
+}
+
+// RemoveKey from every member.  The primary key can't be removed
+func (m *Management) RemoveKey(ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+	return m.keyring(ctx, in, m.Keys.Remove, func(c proto.ClusterManagmentClient, ctx context.Context, in *proto.KeyRequest) (*proto.KeyringResponse, error) {
+		return c.RemoveKey(ctx, in)
+	})
+}
+
+// keyring changes this node's keys (nil change is just listing them), then has every
+// other member do the same unless the request is local.  A node failing doesn't fail
+// the rpc: it's in the response, and the operator can try again
+func (m *Management) keyring(ctx context.Context, in *proto.KeyRequest, change func([]byte) error, call keyringCall) (*proto.KeyringResponse, error) {
+	if m.Keys == nil {
+		return nil, status.Error(codes.FailedPrecondition, "gossip isn't encrypted: start the nodes with a key")
+	}
+	a := m.Aggregates
+	local := &proto.NodeKeys{Node: a.MemberList.LocalNode().Name}
+	if change != nil {
+		key, err := cluster.DecodeKey(in.GetKey())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := change(key); err != nil {
+			local.Error = err.Error()
+		}
+	}
+	local.Keys = m.Keys.List()
+	out := &proto.KeyringResponse{Nodes: []*proto.NodeKeys{local}}
+	if in.GetLocal() {
+		return out, nil
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	req := &proto.KeyRequest{Key: in.GetKey(), Local: true}
+	for _, n := range a.MemberList.Members() {
+		if n.Name == local.Node {
+			continue
+		}
+		wg.Add(1)
+		go func(n *memberlist.Node) {
+			defer wg.Done()
+			nodes := []*proto.NodeKeys{{Node: n.Name}}
+			conn, err := a.Peers.Conn(n)
+			if err == nil {
+				ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				var resp *proto.KeyringResponse
+				resp, err = call(proto.NewClusterManagmentClient(conn), ctx, req)
+				cancel()
+				if err == nil {
+					nodes = resp.GetNodes()
+				}
+			}
+			if err != nil {
+				nodes[0].Error = err.Error()
+				a.logger(ctx).Warn("keyring change failed", zap.String("peer", n.Name), zap.Error(err))
+			}
+			lock.Lock()
+			out.Nodes = append(out.Nodes, nodes...)
+			lock.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	sort.Slice(out.Nodes, func(i, j int) bool { return out.Nodes[i].Node < out.Nodes[j].Node })
+	return out, nil
+}
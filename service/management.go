@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Management is the ClusterManagment api.  So far that is the members, draining a
+// node, its log level, the gossip keyring, backups and snapshots.
+type Management struct {
+	Aggregates *Aggregates
+	// LogLevel of the node's logger, for Get/SetLogLevel.  nil turns them off
+	LogLevel *zap.AtomicLevel
+	Streams  data.StreamLister
+	// Meta is this node's gossiped meta; a drain sets Draining in it
+	Meta *cluster.Delegate
+	// Drained is called once the node has left the cluster: shut it down
+	Drained func()
+	// Keys is the gossip keyring, for the keyring rpcs.  nil is unencrypted gossip
+	Keys *cluster.Keys
+	// Backups is the store, for Backup and Restore.  nil turns them off
+	Backups data.Backuper
+	// Snapshots is the store, for the cluster wide Snapshot.  nil turns it off
+	Snapshots data.Snapshotter
+	// GroupLeaveTimeout is how long a drain waits to be out of every raft group; 0 is
+	// a minute
+	GroupLeaveTimeout time.Duration
+
+	lock  sync.Mutex
+	drain drainStatus
+
+	proto.UnimplementedClusterManagmentServer
+}
+
+type drainStatus struct {
+	state                        proto.DrainStatus_State
+	streams, total, logs, failed uint64
+	lastError                    string
+}
+
+func (m *Management) status() *proto.DrainStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	d := m.drain
+	return &proto.DrainStatus{
+		State:         d.state,
+		Streams:       d.streams,
+		TotalStreams:  d.total,
+		Logs:          d.logs,
+		FailedStreams: d.failed,
+		Error:         d.lastError,
+	}
+}
+
+func (m *Management) update(fn func(*drainStatus)) {
+	m.lock.Lock()
+	fn(&m.drain)
+	m.lock.Unlock()
+}
+
+// Drain starts draining the node; a failed drain can be started again (with force, to
+// give up on what didn't make it)
+func (m *Management) Drain(ctx context.Context, in *proto.DrainRequest) (*proto.DrainStatus, error) {
+	m.lock.Lock()
+	switch m.drain.state {
+	case proto.DrainStatus_DRAINING, proto.DrainStatus_LEAVING:
+		m.lock.Unlock()
+		return m.status(), nil
+	}
+	m.drain = drainStatus{state: proto.DrainStatus_DRAINING}
+	m.lock.Unlock()
+
+	go m.run(in.GetForce())
+	return m.status(), nil
+}
+
+// DrainProgress of this node
+func (m *Management) DrainProgress(ctx context.Context, in *emptypb.Empty) (*proto.DrainStatus, error) {
+	return m.status(), nil
+}
+
+func (m *Management) run(force bool) {
+	a := m.Aggregates
+	local := a.MemberList.LocalNode().Name
+	l := a.logger(context.Background()).With(zap.Bool("force", force))
+	l.Info("draining")
+
+	// stop coordinating writes, and tell everyone else to stop sending them here
+	a.SetDraining()
+	if m.Meta != nil {
+		m.Meta.Update(func(meta *cluster.Meta) { meta.Draining = true })
+		if err := a.MemberList.UpdateNode(time.Second); err != nil {
+			l.Warn("couldn't gossip draining", zap.Error(err))
+		}
+	}
+	a.HashList.Remove(local)
+
+	// with raft groups, the other members already have everything: the leaders only
+	// have to drop this node, once it isn't leading any of them
+	var err error
+	if a.Consensus == nil {
+		err = m.handoff()
+	} else {
+		err = a.Consensus.Leave(m.groupLeaveTimeout())
+	}
+	if err != nil {
+		m.update(func(d *drainStatus) { d.lastError = err.Error() })
+		l.Error("drain failed", zap.Error(err))
+	}
+
+	m.lock.Lock()
+	failed := m.drain.failed > 0 || m.drain.lastError != ""
+	if failed && !force {
+		m.drain.state = proto.DrainStatus_FAILED
+		m.lock.Unlock()
+		return
+	}
+	m.drain.state = proto.DrainStatus_LEAVING
+	m.lock.Unlock()
+
+	if err := a.MemberList.Leave(10 * time.Second); err != nil {
+		l.Warn("leaving the cluster", zap.Error(err))
+	}
+	if m.Drained != nil {
+		m.Drained()
+	}
+}
+
+func (m *Management) groupLeaveTimeout() time.Duration {
+	if m.GroupLeaveTimeout == 0 {
+		return time.Minute
+	}
+	return m.GroupLeaveTimeout
+}
+
+// handoff every stream to the replicas the ring has for it now that this node is off it
+func (m *Management) handoff() error {
+	if len(m.Aggregates.HashList.GetMembers()) == 0 {
+		return fmt.Errorf("no other nodes to hand off to")
+	}
+	streams, err := m.Streams.Streams()
+	if err != nil {
+		return err
+	}
+	m.update(func(d *drainStatus) { d.total = uint64(len(streams)) })
+	for _, s := range streams {
+		logs, err := m.handoffStream(s)
+		m.update(func(d *drainStatus) {
+			d.logs += logs
+			if err != nil {
+				d.failed++
+				d.lastError = fmt.Sprintf("%s: %s", s, err)
+				return
+			}
+			d.streams++
+		})
+		if err != nil {
+			m.Aggregates.logger(context.Background()).Warn("handing off failed", zap.Stringer("stream", s), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// handoffStream sends a stream's logs, then its siblings, to each of its new replicas.
+// Each Replicate that gets an answer is an acknowledgement; a conflict (Aborted) too:
+// it's been kept as a sibling over there.
+func (m *Management) handoffStream(s data.StreamID) (uint64, error) {
+	a := m.Aggregates
+	logs, err := m.Streams.Logs(s)
+	if err != nil {
+		return 0, err
+	}
+	conflicts, err := a.Storage.Conflicts(s)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range conflicts {
+		logs = append(logs, c.Siblings...)
+	}
+	replicas, err := a.replicaSet(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var acked uint64
+	for _, n := range replicas {
+		conn, err := a.Peers.Conn(n)
+		if err != nil {
+			return acked, err
+		}
+		client := proto.NewReplicationClient(conn)
+		for _, el := range logs {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err = client.Replicate(ctx, &proto.ReplicateRequest{
+				Stream: streamProto(s),
+				Log:    el,
+				From:   el.GetTimestamp().GetNode(),
+			})
+			cancel()
+			if err != nil && status.Code(err) != codes.Aborted {
+				return acked, fmt.Errorf("%s: %s", n.Name, err)
+			}
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// MembershipList is the members this node can see, at their grpc addresses (their
+// gossip ones if they haven't said)
+func (m *Management) MembershipList(ctx context.Context, in *emptypb.Empty) (*proto.Membership, error) {
+	out := &proto.Membership{}
+	for _, n := range m.Aggregates.MemberList.Members() {
+		host, port := n.Addr.String(), strconv.Itoa(int(n.Port))
+		if meta, err := cluster.NodeMeta(n); err == nil && meta.GRPCAddr != "" {
+			if h, p, err := net.SplitHostPort(meta.GRPCAddr); err == nil {
+				host, port = h, p
+			}
+		}
+		out.Memberlist = append(out.Memberlist, &proto.Member{Name: n.Name, Address: host, Port: port})
+	}
+	sort.Slice(out.Memberlist, func(i, j int) bool { return out.Memberlist[i].Name < out.Memberlist[j].Name })
+	return out, nil
+}
+
+// GetLogLevel of this node
+func (m *Management) GetLogLevel(ctx context.Context, in *emptypb.Empty) (*proto.LogLevel, error) {
+	if m.LogLevel == nil {
+		return nil, status.Errorf(codes.Unimplemented, "this node's log level can't be changed")
+	}
+	return &proto.LogLevel{Level: m.LogLevel.String()}, nil
+}
+
+// SetLogLevel of this node, until it restarts
+func (m *Management) SetLogLevel(ctx context.Context, in *proto.LogLevel) (*proto.LogLevel, error) {
+	if m.LogLevel == nil {
+		return nil, status.Errorf(codes.Unimplemented, "this node's log level can't be changed")
+	}
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(in.GetLevel())); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	was := m.LogLevel.Level()
+	m.LogLevel.SetLevel(level)
+	m.Aggregates.logger(ctx).Info("log level changed", zap.Stringer("from", was), zap.Stringer("to", level))
+	return &proto.LogLevel{Level: level.String()}, nil
+}
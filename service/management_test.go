@@ -0,0 +1,186 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/buraksezer/consistent"
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(b []byte) (int, error) {
+	w.t.Log(string(b))
+	return len(b), nil
+}
+
+func newMember(t *testing.T, name, grpcAddr string) (*memberlist.Memberlist, *cluster.Delegate) {
+	cfg := memberlist.DefaultLocalConfig()
+	cfg.Name = name
+	cfg.BindAddr = "127.0.0.1"
+	cfg.BindPort = 0
+	cfg.LogOutput = testWriter{t}
+	delegate := &cluster.Delegate{Meta: cluster.Meta{GRPCAddr: grpcAddr}}
+	cfg.Delegate = delegate
+	ml, err := memberlist.Create(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ml.Shutdown() })
+	return ml, delegate
+}
+
+func TestDrain(t *testing.T) {
+	// b is where everything ends up
+	storeB := data.New(t.TempDir())
+	defer storeB.Close()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	proto.RegisterReplicationServer(srv, &service.Replication{Storage: storeB})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	a, metaA := newMember(t, "a", "127.0.0.1:1")
+	b, _ := newMember(t, "b", lis.Addr().String())
+	if _, err := b.Join([]string{fmt.Sprintf("%s:%d", a.LocalNode().Addr, a.LocalNode().Port)}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); a.NumMembers() < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ring := consistent.New(nil, consistent.Config{Hasher: hasher{}, PartitionCount: testPartitions, ReplicationFactor: 20, Load: 1.25})
+	for _, n := range a.Members() {
+		ring.Add(service.WrappedNode{Node: n})
+	}
+
+	storeA := data.New(t.TempDir())
+	defer storeA.Close()
+	storeA.NodeID = "a"
+	streams := []data.StreamID{data.CustomerStream("1"), data.CustomerStream("2"), data.CustomerStream("3")}
+	for _, s := range streams {
+		for seq := uint64(0); seq < 2; seq++ {
+			if err := storeA.Append(s, &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: "write"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	peers := &cluster.Peers{DialOptions: []grpc.DialOption{grpc.WithInsecure()}}
+	defer peers.Close()
+	aggs := &service.Aggregates{Storage: storeA, MemberList: a, HashList: ring, ReplicationFactor: 1, Peers: peers}
+	drained := make(chan struct{})
+	mgmt := &service.Management{
+		Aggregates: aggs,
+		Streams:    storeA,
+		Meta:       metaA,
+		Drained:    func() { close(drained) },
+	}
+	if _, err := mgmt.Drain(context.Background(), &proto.DrainRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-drained:
+	case <-time.After(10 * time.Second):
+		t.Fatal("never drained")
+	}
+
+	st, _ := mgmt.DrainProgress(context.Background(), nil)
+	if st.State != proto.DrainStatus_LEAVING || st.Streams != 3 || st.Logs != 6 || st.FailedStreams != 0 {
+		t.Errorf("unexpected drain status %v", st)
+	}
+	for _, s := range streams {
+		agg, err := storeB.GetState(s)
+		if err != nil || agg.Sequence() != 1 {
+			t.Errorf("%s wasn't handed off to b: %v (err %v)", s, agg, err)
+		}
+	}
+
+	_, err = aggs.AppendEvent(context.Background(), &proto.NewEventLog{
+		Stream: &proto.StreamID{AggregateType: data.CustomerAggregate, Id: 1},
+		Log:    &proto.CustomerEventLog{SequenceId: 2},
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a drained node to turn writes away, got %v", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); b.NumMembers() > 1 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.NumMembers() != 1 {
+		t.Errorf("expected a to have left, b sees %v", b.Members())
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	ctx := context.Background()
+	m := &service.Management{Aggregates: &service.Aggregates{}}
+	if _, err := m.GetLogLevel(ctx, &emptypb.Empty{}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented without a level, got %v", err)
+	}
+
+	level := zap.NewAtomicLevel()
+	m.LogLevel = &level
+	got, err := m.SetLogLevel(ctx, &proto.LogLevel{Level: "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetLevel() != "debug" || level.Level() != zapcore.DebugLevel {
+		t.Errorf("level is %s (returned %s), expected debug", level.Level(), got.GetLevel())
+	}
+	if _, err := m.SetLogLevel(ctx, &proto.LogLevel{Level: "loud"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a bad level, got %v", err)
+	}
+	got, err = m.GetLogLevel(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetLevel() != "debug" {
+		t.Errorf("bad level changed it to %s", got.GetLevel())
+	}
+}
+
+func TestMembershipList(t *testing.T) {
+	a, _ := newMember(t, "a", "10.0.0.1:8080")
+	b, _ := newMember(t, "b", "")
+	if _, err := b.Join([]string{fmt.Sprintf("%s:%d", a.LocalNode().Addr, a.LocalNode().Port)}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); a.NumMembers() < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	m := &service.Management{Aggregates: &service.Aggregates{MemberList: a}}
+	got, err := m.MembershipList(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*proto.Member{
+		{Name: "a", Address: "10.0.0.1", Port: "8080"},
+		// no grpc address: the gossip one
+		{Name: "b", Address: b.LocalNode().Addr.String(), Port: fmt.Sprint(b.LocalNode().Port)},
+	}
+	if len(got.GetMemberlist()) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got.GetMemberlist())
+	}
+	for i, e := range expected {
+		if g := got.GetMemberlist()[i]; g.GetName() != e.Name || g.GetAddress() != e.Address || g.GetPort() != e.Port {
+			t.Errorf("expected %v, got %v", e, g)
+		}
+	}
+}
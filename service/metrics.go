@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/buraksezer/consistent"
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yarbelk/distributedservice/rpcname"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grpc_server",
+		Name:      "handled_total",
+		Help:      "RPCs finished, by service, method and status code",
+	}, []string{"grpc_service", "grpc_method", "grpc_code"})
+	rpcSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grpc_server",
+		Name:      "handling_seconds",
+		Help:      "How long RPCs took, by service, method and status code.  Streams are counted from open to close",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"grpc_service", "grpc_method", "grpc_code"})
+)
+
+// RPCMetrics for a prometheus registry: the counts and latencies the Metrics
+// interceptors record
+func RPCMetrics() []prometheus.Collector {
+	return []prometheus.Collector{rpcHandled, rpcSeconds}
+}
+
+func observeRPC(method string, start time.Time, err error) {
+	svc, m := rpcname.Split(method)
+	code := status.Code(err).String()
+	rpcHandled.WithLabelValues(svc, m, code).Inc()
+	rpcSeconds.WithLabelValues(svc, m, code).Observe(time.Since(start).Seconds())
+}
+
+// MetricsUnaryInterceptor counts and times rpcs.  Put it first, so it sees what
+// the interceptors after it turn away too.
+func MetricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+// MetricsStreamInterceptor counts and times streams
+func MetricsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
+
+// clusterMetrics reports memberlist's view and the ring's, on every scrape
+type clusterMetrics struct {
+	members    *memberlist.Memberlist
+	ring       *consistent.Consistent
+	partitions int
+
+	memberCount, healthScore, partitionsOwned *prometheus.Desc
+}
+
+// ClusterMetrics for a prometheus registry: the member count and health score from
+// memberlist, and how many of the ring's partitions each member owns
+func ClusterMetrics(ml *memberlist.Memberlist, ring *consistent.Consistent, partitions int) prometheus.Collector {
+	return clusterMetrics{
+		members:    ml,
+		ring:       ring,
+		partitions: partitions,
+		memberCount: prometheus.NewDesc("memberlist_members",
+			"Members memberlist knows are alive (or suspect), this node included", nil, nil),
+		healthScore: prometheus.NewDesc("memberlist_health_score",
+			"memberlist's health score for this node; 0 is healthy, higher is worse", nil, nil),
+		partitionsOwned: prometheus.NewDesc("ring_partitions",
+			"Partitions of the ring each member owns (is first replica for)", []string{"member"}, nil),
+	}
+}
+
+func (c clusterMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.memberCount
+	ch <- c.healthScore
+	ch <- c.partitionsOwned
+}
+
+func (c clusterMetrics) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.memberCount, prometheus.GaugeValue, float64(c.members.NumMembers()))
+	ch <- prometheus.MustNewConstMetric(c.healthScore, prometheus.GaugeValue, float64(c.members.GetHealthScore()))
+
+	owned := make(map[string]int)
+	for _, m := range c.ring.GetMembers() {
+		owned[m.String()] = 0
+	}
+	if len(owned) > 0 {
+		for p := 0; p < c.partitions; p++ {
+			if m := c.ring.GetPartitionOwner(p); m != nil {
+				owned[m.String()]++
+			}
+		}
+	}
+	for m, n := range owned {
+		ch <- prometheus.MustNewConstMetric(c.partitionsOwned, prometheus.GaugeValue, float64(n), m)
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/rpcname"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// ReadyState is how far through starting up a node is
+type ReadyState int
+
+const (
+	// Joining the gossip cluster
+	Joining ReadyState = iota
+	// Converging waits for enough members, and for membership to stop changing
+	Converging
+	// Transferring pulls what this node is missing for the partitions it holds
+	Transferring
+	// Ready to serve
+	Ready
+)
+
+func (s ReadyState) String() string {
+	switch s {
+	case Joining:
+		return "joining"
+	case Converging:
+		return "converging"
+	case Transferring:
+		return "transferring"
+	case Ready:
+		return "ready"
+	}
+	return "unknown"
+}
+
+// Readiness gates the grpc apis until the node is ready: until then they're
+// Unavailable, and the grpc health service says NOT_SERVING.  The services in
+// Always are served regardless: health itself, and node to node traffic like
+// replication (a node that's getting ready is pulling from nodes that might be
+// too).
+//
+// It's also a memberlist.EventDelegate, to know when membership last changed.
+type Readiness struct {
+	Health *health.Server
+	Always []string
+	// Logger nil is zap's global logger
+	Logger *zap.Logger
+
+	lock    sync.Mutex
+	state   ReadyState
+	changed time.Time
+	ready   chan struct{}
+}
+
+// NewReadiness starts out Joining; and so NOT_SERVING, if there's a health server
+func NewReadiness(h *health.Server, always ...string) *Readiness {
+	r := &Readiness{Health: h, Always: always, changed: time.Now(), ready: make(chan struct{})}
+	if h != nil {
+		h.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return r
+}
+
+// State the node is in
+func (r *Readiness) State() ReadyState {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.state
+}
+
+// Set the state.  Ready is for good: there's no going back from it
+func (r *Readiness) Set(s ReadyState) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.state == Ready || r.state == s {
+		return
+	}
+	logging.Or(r.Logger).Info("readiness", zap.Stringer("state", s))
+	r.state = s
+	if s == Ready {
+		close(r.ready)
+		if r.Health != nil {
+			r.Health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+}
+
+// Ready is closed once the node is
+func (r *Readiness) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// Settled is true if membership hasn't changed for d
+func (r *Readiness) Settled(d time.Duration) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return time.Since(r.changed) >= d
+}
+
+func (r *Readiness) change() {
+	r.lock.Lock()
+	r.changed = time.Now()
+	r.lock.Unlock()
+}
+
+// NotifyJoin membership changed
+func (r *Readiness) NotifyJoin(n *memberlist.Node) { r.change() }
+
+// NotifyLeave membership changed
+func (r *Readiness) NotifyLeave(n *memberlist.Node) { r.change() }
+
+// NotifyUpdate membership changed
+func (r *Readiness) NotifyUpdate(n *memberlist.Node) { r.change() }
+
+// always served, ready or not
+func (r *Readiness) always(service string) bool {
+	for _, svc := range r.Always {
+		if svc == service {
+			return true
+		}
+	}
+	return false
+}
+
+// check if method (/package.Service/Method) can be served now
+func (r *Readiness) check(method string) error {
+	if r.always(rpcname.Service(method)) {
+		return nil
+	}
+	if s := r.State(); s != Ready {
+		return status.Errorf(codes.Unavailable, "node isn't ready: %s", s)
+	}
+	return nil
+}
+
+// UnaryInterceptor turns rpcs away until the node is ready
+func (r *Readiness) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := r.check(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor turns streams away until the node is ready
+func (r *Readiness) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := r.check(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Replication is the internal api other nodes send replicated logs to.
+type Replication struct {
+	Storage data.Storer
+	// AntiEntropy answers the merkle tree rpcs.  nil turns them off
+	AntiEntropy *AntiEntropy
+
+	proto.UnimplementedReplicationServer
+}
+
+// Replicate merges a log coordinated by another node.  A ConflictError here means
+// the stream has diverged between replicas; that comes back as Aborted.
+func (r *Replication) Replicate(ctx context.Context, in *proto.ReplicateRequest) (*proto.ErrorDetails, error) {
+	s := streamID(in.GetStream())
+	err := txn(ctx, "replicate", s, func() error { return r.Storage.Replicate(s, in.GetLog()) })
+	if err != nil {
+		return &proto.ErrorDetails{
+			Failed:    true,
+			ErrorCode: 1,
+			ErrorMsg:  err.Error(),
+		}, storageError(err)
+	}
+	return new(proto.ErrorDetails), nil
+}
+
+func (r *Replication) merkle() error {
+	if r.AntiEntropy == nil {
+		return status.Errorf(codes.Unimplemented, "anti-entropy isn't running on this node")
+	}
+	return nil
+}
+
+// MerkleNodes of this node's tree for the partition
+func (r *Replication) MerkleNodes(ctx context.Context, in *proto.MerkleRequest) (*proto.MerkleHashes, error) {
+	if err := r.merkle(); err != nil {
+		return nil, err
+	}
+	if in.GetLevel() > data.MerkleDepth {
+		return nil, status.Errorf(codes.InvalidArgument, "the trees are only %d levels deep", data.MerkleDepth)
+	}
+	tree, err := r.AntiEntropy.Tree(int(in.GetPartition()))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can't build merkle tree: %s", err)
+	}
+	level := tree.Levels[in.GetLevel()]
+	out := &proto.MerkleHashes{Hashes: make([]uint64, 0, len(in.GetIndexes()))}
+	for _, i := range in.GetIndexes() {
+		if int(i) >= len(level) {
+			return nil, status.Errorf(codes.InvalidArgument, "level %d has %d nodes", in.GetLevel(), len(level))
+		}
+		out.Hashes = append(out.Hashes, level[i])
+	}
+	return out, nil
+}
+
+// MerkleLeafKeys are the keys (and hashes) under some leaves of the partition
+func (r *Replication) MerkleLeafKeys(ctx context.Context, in *proto.MerkleRequest) (*proto.KeyHashes, error) {
+	if err := r.merkle(); err != nil {
+		return nil, err
+	}
+	leaves := make([]int, 0, len(in.GetIndexes()))
+	for _, i := range in.GetIndexes() {
+		leaves = append(leaves, int(i))
+	}
+	keys, err := r.AntiEntropy.Store.LeafKeys(r.AntiEntropy.partitionOf, int(in.GetPartition()), leaves)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can't list leaf keys: %s", err)
+	}
+	out := &proto.KeyHashes{Keys: make([]*proto.KeyHash, 0, len(keys))}
+	for _, kh := range keys {
+		out.Keys = append(out.Keys, &proto.KeyHash{Key: kh.Key, Hash: kh.Hash})
+	}
+	return out, nil
+}
+
+// FetchLogs streams the logs for keys, in the order they were asked for.  From is the
+// node that coordinated each one.
+func (r *Replication) FetchLogs(in *proto.FetchLogsRequest, stream proto.Replication_FetchLogsServer) error {
+	if err := r.merkle(); err != nil {
+		return err
+	}
+	logs, err := r.AntiEntropy.Store.LogsByKey(in.GetKeys())
+	if err != nil {
+		return status.Errorf(codes.Internal, "can't read logs: %s", err)
+	}
+	for _, kl := range logs {
+		err = stream.Send(&proto.ReplicateRequest{
+			Stream: streamProto(kl.Stream),
+			Log:    kl.Log,
+			From:   kl.Log.GetTimestamp().GetNode(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
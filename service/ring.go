@@ -0,0 +1,87 @@
+package service
+
+import (
+	"github.com/buraksezer/consistent"
+	"github.com/cespare/xxhash"
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+)
+
+type hasher struct{}
+
+// Sum64 on type to conform to expectations of consistent library
+func (h hasher) Sum64(data []byte) uint64 {
+	return xxhash.Sum64(data)
+}
+
+// NewHashList is the consistent hash ring, as every node makes it: with the same
+// members, a stream is on the same nodes for everyone.  replicationFactor is its
+// virtual nodes too
+func NewHashList(partitions, replicationFactor int) *consistent.Consistent {
+	return consistent.New(nil, consistent.Config{
+		Hasher:            hasher{},
+		ReplicationFactor: replicationFactor,
+		Load:              1.25,
+		PartitionCount:    partitions,
+	})
+}
+
+// Ring keeps the consistent hash ring in step with memberlist.  Nodes that join go
+// on the ring, and only come off once they say they're draining.  Nodes that die or
+// stop (a restart leaves too) stay on: they're still their streams' replicas, so
+// writes for them are hinted, and they catch up through the hints and anti-entropy
+// when they're back.
+//
+// Nothing moves data when the ring changes; that's what drains and anti-entropy are for.
+type Ring struct {
+	HashList *consistent.Consistent
+	// MemberList is what Sync goes by; it's set once memberlist is created
+	MemberList *memberlist.Memberlist
+}
+
+func (r *Ring) has(name string) bool {
+	for _, m := range r.HashList.GetMembers() {
+		if m.String() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func draining(n *memberlist.Node) bool {
+	meta, err := cluster.NodeMeta(n)
+	return err == nil && meta.Draining
+}
+
+// NotifyJoin a node joined (or came back)
+func (r *Ring) NotifyJoin(n *memberlist.Node) {
+	if !draining(n) && !r.has(n.Name) {
+		r.HashList.Add(WrappedNode{Node: n})
+	}
+}
+
+// NotifyLeave a node left or died.  It stays on the ring unless it was draining (in
+// case the update saying so was missed)
+func (r *Ring) NotifyLeave(n *memberlist.Node) {
+	if draining(n) {
+		r.HashList.Remove(n.Name)
+	}
+}
+
+// NotifyUpdate a node's meta changed
+func (r *Ring) NotifyUpdate(n *memberlist.Node) {
+	switch {
+	case draining(n):
+		r.HashList.Remove(n.Name)
+	case !r.has(n.Name):
+		r.HashList.Add(WrappedNode{Node: n})
+	}
+}
+
+// Sync puts every member on the ring and takes the draining ones off, in case it
+// missed any events
+func (r *Ring) Sync() {
+	for _, n := range r.MemberList.Members() {
+		r.NotifyUpdate(n)
+	}
+}
@@ -0,0 +1,53 @@
+package service_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/cluster"
+	"github.com/yarbelk/distributedservice/service"
+)
+
+func onRing(r *service.Ring, name string) bool {
+	for _, m := range r.HashList.GetMembers() {
+		if m.String() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRingLeave(t *testing.T) {
+	a, _ := newMember(t, "a", "127.0.0.1:1")
+	b, _ := newMember(t, "b", "127.0.0.1:2")
+	if _, err := b.Join([]string{fmt.Sprintf("%s:%d", a.LocalNode().Addr, a.LocalNode().Port)}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); a.NumMembers() < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// c died while nobody was listening: it's still c's streams' replica
+	r := &service.Ring{HashList: testRing("c"), MemberList: a}
+	r.Sync()
+	if !onRing(r, "a") || !onRing(r, "b") || !onRing(r, "c") {
+		t.Fatalf("expected a, b and c on the ring, got %v", r.HashList.GetMembers())
+	}
+
+	// as memberlist hands them out: no State
+	r.NotifyLeave(&memberlist.Node{Name: "b"})
+	if !onRing(r, "b") {
+		t.Error("expected b to stay on the ring when it goes away")
+	}
+
+	meta, err := json.Marshal(cluster.Meta{GRPCAddr: "127.0.0.1:2", Draining: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.NotifyLeave(&memberlist.Node{Name: "b", Meta: meta})
+	if onRing(r, "b") {
+		t.Error("expected b off the ring once it left draining")
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultSnapshotHold is how long a snapshot holds writes for, if the coordinator
+// doesn't say
+const DefaultSnapshotHold = 10 * time.Second
+
+// highestBatch is how many streams' highest sequenceIds go in a message
+const highestBatch = 1000
+
+// Snapshot is this node's part of a cluster wide snapshot; see the proto
+func (m *Management) Snapshot(s proto.ClusterManagment_SnapshotServer) error {
+	if m.Snapshots == nil {
+		return status.Error(codes.Unimplemented, "this node can't take snapshots")
+	}
+	in, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	hold := time.Duration(in.GetHoldMillis()) * time.Millisecond
+	if hold <= 0 {
+		hold = DefaultSnapshotHold
+	}
+
+	snap, thaw := m.Snapshots.Freeze()
+	defer snap.Close()
+	defer thaw()
+	// a coordinator that's gone quiet doesn't get to hold the writes forever
+	held := time.AfterFunc(hold, thaw)
+	defer held.Stop()
+	if err := s.Send(&proto.SnapshotMessage{Node: m.nodeName(), ReadTs: snap.ReadTs()}); err != nil {
+		return err
+	}
+	if in, err = s.Recv(); err != nil {
+		return err
+	}
+	if !in.GetRelease() {
+		return status.Error(codes.InvalidArgument, "expected the release")
+	}
+	if !held.Stop() {
+		return status.Errorf(codes.Aborted, "writes were let go after %s, before every node was held: the snapshot wouldn't be consistent", hold)
+	}
+	thaw()
+
+	w := bufio.NewWriterSize(chunkWriter(func(c *proto.BackupChunk) error {
+		return s.Send(&proto.SnapshotMessage{Chunk: c})
+	}), backupChunk)
+	err = snap.Dump(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return rpcError(err)
+	}
+
+	partitions := map[int]bool{}
+	var batch []*proto.StreamSequence
+	err = snap.Highest(func(st data.StreamID, seq uint64) error {
+		if m.Aggregates != nil && m.Aggregates.HashList != nil {
+			partitions[m.Aggregates.HashList.FindPartitionID(st.HashKey())] = true
+		}
+		batch = append(batch, &proto.StreamSequence{Stream: streamProto(st), Sequence: seq})
+		if len(batch) < highestBatch {
+			return nil
+		}
+		err := s.Send(&proto.SnapshotMessage{Highest: batch})
+		batch = nil
+		return err
+	})
+	if err != nil {
+		return rpcError(err)
+	}
+	last := &proto.SnapshotMessage{Highest: batch}
+	for p := range partitions {
+		last.Partitions = append(last.Partitions, uint32(p))
+	}
+	sort.Slice(last.Partitions, func(i, j int) bool { return last.Partitions[i] < last.Partitions[j] })
+	return s.Send(last)
+}
+
+func (m *Management) nodeName() string {
+	if m.Aggregates == nil || m.Aggregates.MemberList == nil {
+		return ""
+	}
+	return m.Aggregates.MemberList.LocalNode().Name
+}
+
+// ReshardStats is what a Reshard loaded
+type ReshardStats struct {
+	// Logs written, counting each replica's copy
+	Logs int
+	// Conflicts are logs replicas had different versions of: they're siblings now
+	Conflicts int
+	// Skipped logs the streams wouldn't take
+	Skipped int
+}
+
+// Reshard loads a cluster wide snapshot, the manifest and its files in dir, into the
+// empty stores of a new cluster, by node name.  It can have any number of nodes: each
+// stream goes to its replicas on the new cluster's ring (partitions and
+// replicationFactor are its config), from every node that had a copy.  After, every
+// replica is checked against the manifest's highest sequenceIds
+func Reshard(m *data.Manifest, dir string, stores map[string]*data.BadgerStore, partitions, replicationFactor int) (stats ReshardStats, err error) {
+	hashList := NewHashList(partitions, replicationFactor)
+	defer func() {
+		// consistent panics when the members can't take the partitions
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the ring can't put %d partitions on %d nodes: %v", partitions, len(stores), r)
+		}
+	}()
+	for name, store := range stores {
+		empty, err := store.Empty()
+		if err != nil {
+			return stats, err
+		}
+		if !empty {
+			return stats, fmt.Errorf("%s: %w", name, data.NotEmptyError)
+		}
+		hashList.Add(WrappedNode{Node: &memberlist.Node{Name: name}})
+	}
+	replicaOf := func(name string) func(data.StreamID) bool {
+		return func(s data.StreamID) bool {
+			replicas, err := Replicas(hashList, replicationFactor, s)
+			if err != nil {
+				return false
+			}
+			for _, r := range replicas {
+				if r.Name == name {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	for name, store := range stores {
+		for _, n := range m.Nodes {
+			f, err := os.Open(filepath.Join(dir, n.File))
+			if err != nil {
+				return stats, err
+			}
+			written, conflicts, skipped, err := store.ReplicateDump(bufio.NewReader(f), replicaOf(name))
+			f.Close()
+			if err != nil {
+				return stats, fmt.Errorf("loading %s into %s: %w", n.File, name, err)
+			}
+			stats.Logs += written
+			stats.Conflicts += conflicts
+			stats.Skipped += skipped
+		}
+	}
+
+	// every replica has every stream, up to where the snapshot had it
+	expected := m.Highest()
+	for name, store := range stores {
+		got := map[string]uint64{}
+		snap, thaw := store.Freeze()
+		thaw()
+		err = snap.Highest(func(s data.StreamID, seq uint64) error {
+			got[data.StreamKey(s)] = seq
+			return nil
+		})
+		snap.Close()
+		if err != nil {
+			return stats, err
+		}
+		for key, seq := range expected {
+			s, err := data.ParseStreamKey(key)
+			if err != nil {
+				return stats, err
+			}
+			if !replicaOf(name)(s) {
+				continue
+			}
+			if h, ok := got[key]; !ok || h < seq {
+				return stats, fmt.Errorf("%s is missing logs of %s: the snapshot has up to %d", name, key, seq)
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,171 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// snapshot one node: hold, release, then its file and manifest entry
+func snapshot(t *testing.T, client proto.ClusterManagmentClient, name, dir string) data.ManifestNode {
+	t.Helper()
+	stream, err := client.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&proto.SnapshotControl{}); err != nil {
+		t.Fatal(err)
+	}
+	held, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&proto.SnapshotControl{Release: true}); err != nil {
+		t.Fatal(err)
+	}
+	node := data.ManifestNode{Name: name, File: name + ".snap", ReadTs: held.GetReadTs(), Highest: map[string]uint64{}}
+	f, err := os.Create(filepath.Join(dir, node.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return node
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(msg.GetChunk().GetData()); err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range msg.GetHighest() {
+			node.Highest[data.StreamKey(data.StreamID{Type: h.GetStream().GetAggregateType(), ID: string(h.GetStream().GetKey())})] = h.GetSequence()
+		}
+		for _, p := range msg.GetPartitions() {
+			node.Partitions = append(node.Partitions, int(p))
+		}
+	}
+}
+
+func TestSnapshotReshard(t *testing.T) {
+	const customers, partitions, replicationFactor = 30, 7, 2
+	// three nodes, each with the customers the old ring gave it
+	old := map[string]*data.BadgerStore{}
+	for _, name := range []string{"a", "b", "c"} {
+		old[name] = data.New(t.TempDir())
+		defer old[name].Close()
+	}
+	ring := service.NewHashList(partitions, replicationFactor)
+	for name := range old {
+		ring.Add(service.WrappedNode{Node: &memberlist.Node{Name: name}})
+	}
+	for id := uint64(0); id < customers; id++ {
+		s := data.CustomerStream(data.NumericID(id))
+		replicas, err := service.Replicas(ring, replicationFactor, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for seq := uint64(0); seq <= id%3; seq++ {
+			el := &proto.CustomerEventLog{SequenceId: seq, Action: &proto.Action{Action: fmt.Sprintf("%d.%d", id, seq)}}
+			if err := old[replicas[0].Name].Append(s, el); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range replicas[1:] {
+				if err := old[r.Name].Replicate(s, el); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	manifest := &data.Manifest{ID: "test", Taken: time.Now()}
+	for name, store := range old {
+		client := managementClient(t, &service.Management{Snapshots: store, Aggregates: &service.Aggregates{HashList: ring}})
+		node := snapshot(t, client, name, dir)
+		if len(node.Partitions) == 0 {
+			t.Errorf("%s: expected its partitions", name)
+		}
+		manifest.Nodes = append(manifest.Nodes, node)
+	}
+	if h := manifest.Highest(); len(h) != customers {
+		t.Fatalf("expected every customer in the manifest, got %v", h)
+	}
+
+	// onto two
+	stores := map[string]*data.BadgerStore{}
+	for _, name := range []string{"x", "y"} {
+		stores[name] = data.New(t.TempDir())
+		defer stores[name].Close()
+	}
+	stats, err := service.Reshard(manifest, dir, stores, partitions, replicationFactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Conflicts != 0 || stats.Skipped != 0 {
+		t.Errorf("expected no conflicts or skips, got %+v", stats)
+	}
+	for id := uint64(0); id < customers; id++ {
+		s := data.CustomerStream(data.NumericID(id))
+		replicas, _ := service.Replicas(ring, replicationFactor, s)
+		expected, err := old[replicas[0].Name].GetCustomerState(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// two nodes, replication factor two: both have everything
+		for name, store := range stores {
+			got, err := store.GetCustomerState(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("%s, customer %d: expected %+v, got %+v", name, id, expected, got)
+			}
+		}
+	}
+
+	if _, err := service.Reshard(manifest, dir, stores, partitions, replicationFactor); !errors.Is(err, data.NotEmptyError) {
+		t.Errorf("expected resharding into used stores to fail, got %v", err)
+	}
+}
+
+func TestSnapshotHeldTooLong(t *testing.T) {
+	store := data.New(t.TempDir())
+	defer store.Close()
+	client := managementClient(t, &service.Management{Snapshots: store})
+	stream, err := client.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&proto.SnapshotControl{HoldMillis: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	// the writes were let go without us
+	if err := store.WriteLog(1, &proto.CustomerEventLog{Action: &proto.Action{Action: "go"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&proto.SnapshotControl{Release: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Aborted {
+		t.Errorf("expected the snapshot to be aborted, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// replay a stream (GetState) in a span under ctx's
+func replay(ctx context.Context, store data.Storer, s data.StreamID) (data.Aggregate, error) {
+	_, span := tracing.Start(ctx, "replay", attribute.String("stream", s.String()))
+	agg, err := store.GetState(s)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("sequence", int64(agg.Sequence())))
+	}
+	tracing.End(span, err)
+	return agg, err
+}
+
+// txn is a badger transaction (one of the store's writes) in a span under ctx's.  A
+// call whose deadline has gone (or that was cancelled) while it waited doesn't get
+// as far as badger: nobody is waiting for the answer
+func txn(ctx context.Context, name string, s data.StreamID, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, span := tracing.Start(ctx, "badger."+name, attribute.String("stream", s.String()))
+	err := fn()
+	tracing.End(span, err)
+	return err
+}
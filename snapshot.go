@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yarbelk/distributedservice/config"
+	"github.com/yarbelk/distributedservice/data"
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/service"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// manifestFile is the manifest's name in a snapshot's directory
+const manifestFile = "manifest.json"
+
+// snapshotNode is one member's part of a snapshot, while it's taken
+type snapshotNode struct {
+	member *proto.Member
+	stream proto.ClusterManagment_SnapshotClient
+	node   data.ManifestNode
+}
+
+// eachNode runs fn on every node at once, and returns the first error
+func eachNode(nodes []*snapshotNode, fn func(*snapshotNode) error) error {
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *snapshotNode) {
+			defer wg.Done()
+			if err := fn(n); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", n.member.GetName(), err)
+			}
+		}(i, n)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotCommand takes a snapshot of the whole cluster, as it was at one moment:
+// `snapshot -addr host:port [tls flags] -out dir [-hold 10s]`.  Every member holds its
+// writes and opens a badger read transaction; once they all have, the writes go again
+// and each streams what it read into dir/<member>.snap.  dir/manifest.json says which
+// member had which partitions, and the highest sequenceId of every stream.  If any
+// member can't be held in time, or any part doesn't make it, none of it is kept: the
+// parts are written to a directory in dir, and only moved out of it once they all are
+func snapshotCommand(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	node := addNodeFlags(fs, "grpc address of any node in the cluster")
+	out := fs.String("out", "", "directory to write the snapshot to. It's made if it isn't there")
+	hold := fs.Duration("hold", service.DefaultSnapshotHold, "longest a member holds its writes for while the rest are got ready")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("usage: snapshot -addr host:port [tls flags] -out dir [-hold 10s]")
+	}
+	// it goes as milliseconds in a uint32, and 0 is the member's default
+	if *hold < time.Millisecond || *hold > math.MaxUint32*time.Millisecond {
+		return fmt.Errorf("-hold: %s isn't between 1ms and %s", *hold, math.MaxUint32*time.Millisecond)
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(*out, ".snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	conn, err := node.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	members, err := proto.NewClusterManagmentClient(conn).MembershipList(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		return err
+	}
+	// cancelling any stream lets that member's writes go: a failure anywhere is
+	// everyone's
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var nodes []*snapshotNode
+	for _, m := range members.GetMemberlist() {
+		nodes = append(nodes, &snapshotNode{
+			member: m,
+			node:   data.ManifestNode{Name: m.GetName(), File: filepath.Base(m.GetName()) + ".snap", Highest: map[string]uint64{}},
+		})
+	}
+
+	manifest := &data.Manifest{ID: time.Now().UTC().Format("20060102T150405Z"), Taken: time.Now().UTC()}
+	err = eachNode(nodes, func(n *snapshotNode) error {
+		c, err := node.dialAddr(net.JoinHostPort(n.member.GetAddress(), n.member.GetPort()))
+		if err != nil {
+			return err
+		}
+		// closed once the snapshot's done with, one way or the other
+		go func() {
+			<-ctx.Done()
+			c.Close()
+		}()
+		if n.stream, err = proto.NewClusterManagmentClient(c).Snapshot(ctx); err != nil {
+			return err
+		}
+		if err := n.stream.Send(&proto.SnapshotControl{HoldMillis: uint32(hold.Milliseconds())}); err != nil {
+			return err
+		}
+		held, err := n.stream.Recv()
+		if err != nil {
+			return err
+		}
+		n.node.ReadTs = held.GetReadTs()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't hold every member, so no snapshot: %w", err)
+	}
+	// everyone is at the same moment: let them go
+	err = eachNode(nodes, func(n *snapshotNode) error {
+		return n.stream.Send(&proto.SnapshotControl{Release: true})
+	})
+	if err != nil {
+		return err
+	}
+	err = eachNode(nodes, func(n *snapshotNode) error {
+		f, err := os.Create(filepath.Join(tmp, n.node.File))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		for {
+			msg, err := n.stream.Recv()
+			if err == io.EOF {
+				return f.Sync()
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(msg.GetChunk().GetData()); err != nil {
+				return err
+			}
+			for _, h := range msg.GetHighest() {
+				s := data.StreamID{Type: h.GetStream().GetAggregateType(), ID: string(h.GetStream().GetKey())}
+				n.node.Highest[data.StreamKey(s)] = h.GetSequence()
+			}
+			for _, p := range msg.GetPartitions() {
+				n.node.Partitions = append(n.node.Partitions, int(p))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nodes {
+		manifest.Nodes = append(manifest.Nodes, n.node)
+	}
+	f, err := os.Create(filepath.Join(tmp, manifestFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	// the manifest last: it's what says the snapshot's all there
+	for _, n := range nodes {
+		if err := os.Rename(filepath.Join(tmp, n.node.File), filepath.Join(*out, n.node.File)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(filepath.Join(tmp, manifestFile), filepath.Join(*out, manifestFile)); err != nil {
+		return err
+	}
+	fmt.Printf("snapshot %s of %d members, %d streams, in %s\n", manifest.ID, len(manifest.Nodes), len(manifest.Highest()), *out)
+	return nil
+}
+
+// restoreSnapshot is `restore -snapshot dir -nodes name=dir,...`: a cluster wide
+// snapshot into the empty data directories of a new cluster, re-sharded by its ring
+func restoreSnapshot(dir, nodes string, partitions, replicationFactor int) error {
+	f, err := os.Open(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return err
+	}
+	manifest := new(data.Manifest)
+	err = json.NewDecoder(f).Decode(manifest)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", manifestFile, err)
+	}
+
+	stores := map[string]*data.BadgerStore{}
+	for _, n := range strings.Split(nodes, ",") {
+		i := strings.Index(n, "=")
+		if i <= 0 || i == len(n)-1 {
+			return fmt.Errorf("-nodes: expected name=dir, got %q", n)
+		}
+		if err := os.MkdirAll(n[i+1:], 0o755); err != nil {
+			return err
+		}
+		store, err := openStore(n[i+1:])
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		stores[n[:i]] = store
+	}
+	if replicationFactor > len(stores) {
+		fmt.Fprintf(os.Stderr, "only %d nodes for a replication factor of %d: each has everything\n", len(stores), replicationFactor)
+	}
+	stats, err := service.Reshard(manifest, dir, stores, partitions, replicationFactor)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored snapshot %s onto %d nodes: %d logs, %d conflicts (now siblings), %d skipped\n",
+		manifest.ID, len(stores), stats.Logs, stats.Conflicts, stats.Skipped)
+	return nil
+}
+
+// snapshotFlags are restore's, for a snapshot
+type snapshotFlags struct {
+	dir, nodes                    *string
+	partitions, replicationFactor *int
+}
+
+func addSnapshotFlags(fs *flag.FlagSet) snapshotFlags {
+	c := config.Default()
+	return snapshotFlags{
+		dir:               fs.String("snapshot", "", "restore this cluster wide snapshot's directory instead of backups"),
+		nodes:             fs.String("nodes", "", "with -snapshot, the new cluster: name=datadir,... the names are its members'"),
+		partitions:        fs.Int("partitions", c.Partitions, "with -snapshot, the new cluster's partitions"),
+		replicationFactor: fs.Int("rep-factor", c.ReplicationFactor, "with -snapshot, the new cluster's replication factor"),
+	}
+}
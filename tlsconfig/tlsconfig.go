@@ -0,0 +1,381 @@
+// Package tlsconfig is the nodes' TLS.  A node has one certificate (signed by the
+// cluster CA) that it serves grpc and raft with, and shows other nodes when it calls
+// them.  Node to node traffic is always mutual: both ends have to have a certificate
+// from the cluster CA.  The public apis can ask clients for certificates too (from the
+// client CA, or the cluster CA); the node only services (replication, management) want
+// a node's certificate whatever that is set to.
+//
+// The certificates and CAs are read again whenever their files change, so they can be
+// rotated without restarting.  Open connections keep what they were made with.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yarbelk/distributedservice/logging"
+	"github.com/yarbelk/distributedservice/rpcname"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Client auth settings for the public apis
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Config is where the certificates are.  Cert, Key and CA are needed; the rest is
+// optional
+type Config struct {
+	// Cert and Key of this node, signed by CA
+	Cert string
+	Key  string
+	// CA is the cluster's: it signs the nodes' certificates
+	CA string
+	// ClientCA signs the public apis' client certificates, as well as CA
+	ClientCA string
+	// ClientAuth is what the public apis ask of clients: none (the default), optional
+	// (verified if given) or require
+	ClientAuth string
+	// PeerName is the name to check other nodes' certificates for.  Empty is the
+	// address they are called on
+	PeerName string
+
+	Logger *zap.Logger
+}
+
+// Certs is a node's certificates, loaded
+type Certs struct {
+	config     Config
+	log        *zap.Logger
+	clientAuth tls.ClientAuthType
+
+	cert     *file
+	ca       *file
+	clientCA *file
+
+	// nodeOnly services want a cluster CA certificate from whoever calls them
+	nodeOnly map[string]bool
+}
+
+// Load the certificates in c; they're checked for changes on every handshake
+func Load(c Config) (*Certs, error) {
+	if c.Cert == "" || c.Key == "" || c.CA == "" {
+		return nil, fmt.Errorf("tls needs a cert, key and ca")
+	}
+	certs := &Certs{
+		config:   c,
+		log:      logging.Or(c.Logger).Named("tls"),
+		nodeOnly: map[string]bool{},
+	}
+	switch c.ClientAuth {
+	case "", ClientAuthNone, ClientAuthOptional:
+		certs.clientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		certs.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("client auth %q isn't none, optional or require", c.ClientAuth)
+	}
+
+	certs.cert = &file{paths: []string{c.Cert, c.Key}, load: loadKeyPair}
+	certs.ca = &file{paths: []string{c.CA}, load: loadPool}
+	files := []*file{certs.cert, certs.ca}
+	if c.ClientCA != "" {
+		certs.clientCA = &file{paths: []string{c.ClientCA}, load: loadPool}
+		files = append(files, certs.clientCA)
+	}
+	for _, f := range files {
+		if _, err := f.get(certs.log); err != nil {
+			return nil, err
+		}
+	}
+	return certs, nil
+}
+
+// NodeOnly makes services (full grpc names) take calls from other nodes only: with a
+// certificate from the cluster CA
+func (c *Certs) NodeOnly(services ...string) {
+	for _, s := range services {
+		c.nodeOnly[s] = true
+	}
+}
+
+func (c *Certs) certificate() (*tls.Certificate, error) {
+	v, err := c.cert.get(c.log)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*tls.Certificate), nil
+}
+
+func (c *Certs) pool() (*x509.CertPool, error) {
+	v, err := c.ca.get(c.log)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*caFile).pool, nil
+}
+
+// clientPool is the cluster CA and the client CA: who can call the public apis
+func (c *Certs) clientPool() (*x509.CertPool, error) {
+	if c.clientCA == nil {
+		return c.pool()
+	}
+	ca, err := c.ca.get(c.log)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := c.clientCA.get(c.log)
+	if err != nil {
+		return nil, err
+	}
+	both := x509.NewCertPool()
+	both.AppendCertsFromPEM(ca.(*caFile).pem)
+	both.AppendCertsFromPEM(clients.(*caFile).pem)
+	return both, nil
+}
+
+func (c *Certs) server(clientAuth tls.ClientAuthType, pool func() (*x509.CertPool, error)) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// a new config for every handshake, with whatever is on disk now
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, err := c.certificate()
+			if err != nil {
+				return nil, err
+			}
+			clients, err := pool()
+			if err != nil {
+				return nil, err
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    clients,
+			}, nil
+		},
+	}
+}
+
+// ServerConfig for the grpc port.  Clients' certificates are verified if they have
+// them; NodeOnly and ClientAuth decide if they have to
+func (c *Certs) ServerConfig() *tls.Config {
+	return c.server(c.clientAuth, c.clientPool)
+}
+
+// PeerServerConfig for ports only other nodes use (raft): a cluster CA certificate
+// is required
+func (c *Certs) PeerServerConfig() *tls.Config {
+	return c.server(tls.RequireAndVerifyClientCert, c.pool)
+}
+
+// ClientConfig for calling other nodes: this node's certificate, and theirs has to be
+// from the cluster CA
+func (c *Certs) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.certificate()
+		},
+		// the CA can change, so it's checked in VerifyConnection instead of by crypto/tls
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			pool, err := c.pool()
+			if err != nil {
+				return err
+			}
+			name := c.config.PeerName
+			if name == "" {
+				name = cs.ServerName
+			}
+			return verify(cs.PeerCertificates, pool, name, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+// Credentials for calling other nodes over grpc
+func (c *Certs) Credentials() credentials.TransportCredentials {
+	return credentials.NewTLS(c.ClientConfig())
+}
+
+func verify(chain []*x509.Certificate, roots *x509.CertPool, name string, usage x509.ExtKeyUsage) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
+
+// fromNode is nil if the rpc came from another node: its certificate is the cluster
+// CA's.  crypto/tls has checked it's from one of the CAs already
+func (c *Certs) fromNode(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return status.Error(codes.Unauthenticated, "a node certificate is needed")
+	}
+	pool, err := c.pool()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := verify(info.State.PeerCertificates, pool, "", x509.ExtKeyUsageClientAuth); err != nil {
+		return status.Errorf(codes.PermissionDenied, "not a node certificate: %s", err)
+	}
+	return nil
+}
+
+// FromNode is true if the rpc came from another node, going by its certificate
+func (c *Certs) FromNode(ctx context.Context) bool {
+	return c.fromNode(ctx) == nil
+}
+
+// UnaryInterceptor turns away calls to NodeOnly services from anything but a node
+func (c *Certs) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if c.nodeOnly[rpcname.Service(info.FullMethod)] {
+		if err := c.fromNode(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor turns away streams to NodeOnly services from anything but a node
+func (c *Certs) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if c.nodeOnly[rpcname.Service(info.FullMethod)] {
+		if err := c.fromNode(ss.Context()); err != nil {
+			return err
+		}
+	}
+	return handler(srv, ss)
+}
+
+// ClientCredentials for tools calling a node: ca is the cluster CA, and cert and key
+// (optional) the tool's certificate.  serverName is the name to check the node's
+// certificate for; empty is the address it's called on.
+func ClientCredentials(ca, cert, key, serverName string) (credentials.TransportCredentials, error) {
+	pem, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates", ca)
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool, ServerName: serverName}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+// file is something loaded from files, loaded again when they change
+type file struct {
+	paths []string
+	load  func(paths []string) (interface{}, error)
+
+	lock     sync.Mutex
+	modTimes []time.Time
+	value    interface{}
+}
+
+// get the value; if the files have changed since they were loaded, they are loaded
+// again.  If that fails the old value is kept
+func (f *file) get(l *zap.Logger) (interface{}, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	modTimes := make([]time.Time, len(f.paths))
+	for i, p := range f.paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			if f.value != nil {
+				l.Warn("can't stat certificate; keeping the old one", zap.String("file", p), zap.Error(err))
+				return f.value, nil
+			}
+			return nil, err
+		}
+		modTimes[i] = st.ModTime()
+	}
+	if f.value != nil && equalTimes(modTimes, f.modTimes) {
+		return f.value, nil
+	}
+	v, err := f.load(f.paths)
+	if err != nil {
+		if f.value != nil {
+			l.Warn("can't reload certificate; keeping the old one", zap.Strings("files", f.paths), zap.Error(err))
+			return f.value, nil
+		}
+		return nil, err
+	}
+	if f.value != nil {
+		l.Info("reloaded certificate", zap.Strings("files", f.paths))
+	}
+	f.value, f.modTimes = v, modTimes
+	return v, nil
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadKeyPair(paths []string) (interface{}, error) {
+	pair, err := tls.LoadX509KeyPair(paths[0], paths[1])
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+// caFile is a CA's certificates, as a pool and as they are in the file
+type caFile struct {
+	pem  []byte
+	pool *x509.CertPool
+}
+
+func loadPool(paths []string) (interface{}, error) {
+	pem, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates", paths[0])
+	}
+	return &caFile{pem: pem, pool: pool}, nil
+}
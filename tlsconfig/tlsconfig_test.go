@@ -0,0 +1,220 @@
+package tlsconfig_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yarbelk/distributedservice/proto"
+	"github.com/yarbelk/distributedservice/tlsconfig"
+	"github.com/yarbelk/distributedservice/tlsconfig/tlstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// serve nothing but unimplemented services: a call that gets Unimplemented got past tls
+func serve(t *testing.T, certs *tlsconfig.Certs) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(certs.ServerConfig())),
+		grpc.ChainUnaryInterceptor(certs.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(certs.StreamInterceptor),
+	)
+	proto.RegisterProtoStuffServer(srv, proto.UnimplementedProtoStuffServer{})
+	proto.RegisterReplicationServer(srv, proto.UnimplementedReplicationServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+type calls struct {
+	public, replication codes.Code
+}
+
+func call(t *testing.T, addr string, creds credentials.TransportCredentials) calls {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = proto.NewProtoStuffClient(conn).CustomerState(ctx, &proto.Customer{})
+	public := status.Code(err)
+	_, err = proto.NewReplicationClient(conn).Replicate(ctx, &proto.ReplicateRequest{})
+	return calls{public: public, replication: status.Code(err)}
+}
+
+func load(t *testing.T, c tlsconfig.Config) *tlsconfig.Certs {
+	t.Helper()
+	certs, err := tlsconfig.Load(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs.NodeOnly(proto.Replication_ServiceDesc.ServiceName)
+	return certs
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clusterCA := tlstest.NewCA(t, dir, "cluster-ca")
+	clientCA := tlstest.NewCA(t, dir, "client-ca")
+	rogueCA := tlstest.NewCA(t, dir, "rogue-ca")
+	a := clusterCA.Issue(t, dir, "a")
+	b := clusterCA.Issue(t, dir, "b")
+	client := clientCA.Issue(t, dir, "client")
+	rogue := rogueCA.Issue(t, dir, "rogue")
+
+	nodeB := load(t, tlsconfig.Config{Cert: b.Cert, Key: b.Key, CA: clusterCA.File})
+	anonymous, err := tlsconfig.ClientCredentials(clusterCA.File, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withClientCert, err := tlsconfig.ClientCredentials(clusterCA.File, client.Cert, client.Key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withRogueCert, err := tlsconfig.ClientCredentials(clusterCA.File, rogue.Cert, rogue.Key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		clientAuth string
+		creds      credentials.TransportCredentials
+		expected   calls
+	}{
+		{"node", tlsconfig.ClientAuthNone, nodeB.Credentials(), calls{codes.Unimplemented, codes.Unimplemented}},
+		{"anonymous", tlsconfig.ClientAuthNone, anonymous, calls{codes.Unimplemented, codes.Unauthenticated}},
+		{"client cert", tlsconfig.ClientAuthOptional, withClientCert, calls{codes.Unimplemented, codes.PermissionDenied}},
+		{"anonymous, optional", tlsconfig.ClientAuthOptional, anonymous, calls{codes.Unimplemented, codes.Unauthenticated}},
+		{"anonymous, required", tlsconfig.ClientAuthRequire, anonymous, calls{codes.Unavailable, codes.Unavailable}},
+		{"client cert, required", tlsconfig.ClientAuthRequire, withClientCert, calls{codes.Unimplemented, codes.PermissionDenied}},
+		// crypto/tls doesn't send a certificate the server won't take: it's anonymous
+		{"rogue cert", tlsconfig.ClientAuthOptional, withRogueCert, calls{codes.Unimplemented, codes.Unauthenticated}},
+		{"rogue cert, required", tlsconfig.ClientAuthRequire, withRogueCert, calls{codes.Unavailable, codes.Unavailable}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nodeA := load(t, tlsconfig.Config{
+				Cert:       a.Cert,
+				Key:        a.Key,
+				CA:         clusterCA.File,
+				ClientCA:   clientCA.File,
+				ClientAuth: test.clientAuth,
+			})
+			if got := call(t, serve(t, nodeA), test.creds); got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestNodesNeedTheClusterCA(t *testing.T) {
+	dir := t.TempDir()
+	clusterCA := tlstest.NewCA(t, dir, "cluster-ca")
+	otherCA := tlstest.NewCA(t, dir, "other-ca")
+	a := clusterCA.Issue(t, dir, "a")
+	b := otherCA.Issue(t, dir, "b")
+
+	nodeA := load(t, tlsconfig.Config{Cert: a.Cert, Key: a.Key, CA: clusterCA.File})
+	// b trusts a, but a doesn't trust b
+	nodeB := load(t, tlsconfig.Config{Cert: b.Cert, Key: b.Key, CA: clusterCA.File})
+	if got := call(t, serve(t, nodeA), nodeB.Credentials()); got.replication != codes.Unavailable {
+		t.Errorf("b got through with a certificate from another CA: %v", got)
+	}
+
+	// and the peer name is checked instead of the address, if there is one
+	nodeC := load(t, tlsconfig.Config{Cert: a.Cert, Key: a.Key, CA: clusterCA.File, PeerName: "not-a"})
+	if got := call(t, serve(t, nodeA), nodeC.Credentials()); got.replication != codes.Unavailable {
+		t.Errorf("a's certificate passed for not-a: %v", got)
+	}
+}
+
+// handshake with addr, and the serial number of the certificate it has.  With tls 1.3
+// a client only hears its certificate was turned down when it reads; the server
+// writes a byte if it wasn't
+func handshake(addr string, cfg *tls.Config) (int64, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	clusterCA := tlstest.NewCA(t, dir, "cluster-ca")
+	a := clusterCA.Issue(t, dir, "a")
+	b := clusterCA.Issue(t, dir, "b")
+	nodeA := load(t, tlsconfig.Config{Cert: a.Cert, Key: a.Key, CA: clusterCA.File})
+	nodeB := load(t, tlsconfig.Config{Cert: b.Cert, Key: b.Key, CA: clusterCA.File})
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", nodeA.PeerServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			if conn.(*tls.Conn).Handshake() == nil {
+				conn.Write([]byte{1})
+			}
+			conn.Close()
+		}
+	}()
+	addr := lis.Addr().String()
+
+	if got, err := handshake(addr, nodeB.ClientConfig()); err != nil || got != a.Serial {
+		t.Fatalf("expected a's certificate %d, got %d (%v)", a.Serial, got, err)
+	}
+	// a new certificate, same files
+	renewed := clusterCA.Issue(t, dir, "a")
+	if got, err := handshake(addr, nodeB.ClientConfig()); err != nil || got != renewed.Serial {
+		t.Errorf("expected the renewed certificate %d, got %d (%v)", renewed.Serial, got, err)
+	}
+
+	// a whole new CA (both nodes have the file): b's old certificate stops working, and
+	// works again when b has one from it too
+	newCA := tlstest.NewCA(t, dir, "cluster-ca")
+	newCA.Issue(t, dir, "a")
+	if _, err := handshake(addr, nodeB.ClientConfig()); err == nil {
+		t.Error("b's certificate from the old CA still works")
+	}
+	newCA.Issue(t, dir, "b")
+	if _, err := handshake(addr, nodeB.ClientConfig()); err != nil {
+		t.Errorf("b's certificate from the new CA doesn't work: %s", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t, dir, "ca")
+	a := ca.Issue(t, dir, "a")
+	for name, c := range map[string]tlsconfig.Config{
+		"no ca":          {Cert: a.Cert, Key: a.Key},
+		"missing file":   {Cert: a.Cert, Key: a.Key, CA: dir + "/nope.pem"},
+		"key isn't a ca": {Cert: a.Cert, Key: a.Key, CA: a.Key},
+		"bad auth":       {Cert: a.Cert, Key: a.Key, CA: ca.File, ClientAuth: "sometimes"},
+	} {
+		if _, err := tlsconfig.Load(c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
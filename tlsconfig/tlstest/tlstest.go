@@ -0,0 +1,113 @@
+// Package tlstest makes CAs and certificates for tests, written to a test's temp dir
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var serial int64
+
+// CA that signs test certificates.  File is its certificate
+type CA struct {
+	File string
+
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// Cert is a certificate's files, and its serial number to tell them apart
+type Cert struct {
+	Cert, Key string
+	Serial    int64
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func write(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+	// written next to it and renamed, so nothing ever reads half a file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// NewCA called name, in dir
+func NewCA(t *testing.T, dir, name string) *CA {
+	t.Helper()
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&serial, 1)),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name+".pem")
+	write(t, file, "CERTIFICATE", der)
+	return &CA{File: file, cert: cert, key: key}
+}
+
+// Issue a certificate called name, good for 127.0.0.1 and localhost, as a server and
+// a client.  Its files are name.pem and name-key.pem in dir; issuing the same name
+// again replaces them
+func (ca *CA) Issue(t *testing.T, dir, name string) Cert {
+	t.Helper()
+	key := newKey(t)
+	n := atomic.AddInt64(&serial, 1)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(n),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost", name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Cert{Cert: filepath.Join(dir, name+".pem"), Key: filepath.Join(dir, name+"-key.pem"), Serial: n}
+	write(t, c.Key, "EC PRIVATE KEY", keyDER)
+	write(t, c.Cert, "CERTIFICATE", der)
+	return c
+}
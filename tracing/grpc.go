@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/yarbelk/distributedservice/rpcname"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// InstrumentationName the spans here are made under
+const InstrumentationName = "github.com/yarbelk/distributedservice/tracing"
+
+// the trace goes from node to node as w3c traceparent headers, in the grpc metadata;
+// whatever the global propagator is
+var propagator = propagation.TraceContext{}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// metadataCarrier is grpc metadata as an otel TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// rpcAttributes for /package.Service/Method
+func rpcAttributes(fullMethod string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.RPCSystemKey.String("grpc")}
+	if service, method := rpcname.Split(fullMethod); method != "" {
+		attrs = append(attrs, semconv.RPCServiceKey.String(service), semconv.RPCMethodKey.String(method))
+	}
+	return attrs
+}
+
+// End a span with the rpc's status
+func endRPC(span trace.Span, err error) {
+	s := status.Convert(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(s.Code())))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, s.Message())
+	}
+	span.End()
+}
+
+// serverSpan starts the span for an rpc this node is serving; a child of the caller's,
+// if the caller sent one along
+func serverSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+	return tracer().Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(rpcAttributes(fullMethod)...),
+	)
+}
+
+// clientSpan starts the span for a call to another node, and puts it in the outgoing
+// metadata so the other node's spans are its children
+func clientSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	ctx, span := tracer().Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(rpcAttributes(fullMethod)...),
+	)
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// UnaryServerInterceptor has a span for every rpc served
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := serverSpan(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	endRPC(span, err)
+	return resp, err
+}
+
+// tracedStream is a ServerStream with the span's context
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s tracedStream) Context() context.Context { return s.ctx }
+
+// StreamServerInterceptor has a span for every stream served, open to close
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := serverSpan(ss.Context(), info.FullMethod)
+	err := handler(srv, tracedStream{ss, ctx})
+	endRPC(span, err)
+	return err
+}
+
+// UnaryClientInterceptor has a span for every call to another node, and sends the
+// trace along with it
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := clientSpan(ctx, method)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	endRPC(span, err)
+	return err
+}
+
+// StreamClientInterceptor sends the trace along with streams to other nodes.  The span
+// is only for opening the stream; the other node's span covers the rest.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, span := clientSpan(ctx, method)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	endRPC(span, err)
+	return cs, err
+}
+
+// DialOptions to trace calls to other nodes with
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor),
+	}
+}
+
+// Start a span under whatever ctx has; for the work inside an rpc (badger
+// transactions, replaying a stream)
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End a span, recording err if there was one
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,140 @@
+// Package tracing is opentelemetry for the nodes: setting up where spans go, and the
+// grpc interceptors that start spans for rpcs and carry the trace from node to node.
+//
+// Spans go to the global tracer provider; until Setup is called that is otel's no-op
+// one, and tracing costs next to nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// ServiceName spans are reported under
+const ServiceName = "distributedservice"
+
+// Config for Setup
+type Config struct {
+	// Exporter is the name of a registered exporter (see RegisterExporter): stdout,
+	// file, otlp; or none for no tracing
+	Exporter string
+	// File to append spans to, for the file exporter
+	File string
+	// OTLPEndpoint is the collector's grpc address, for the otlp exporter
+	OTLPEndpoint string
+	// SampleRatio of new traces to record; traces started on other nodes follow
+	// what the other node decided
+	SampleRatio float64
+	// Node is this node's name, on every span
+	Node string
+}
+
+// ExporterFunc makes a span exporter from the config
+type ExporterFunc func(Config) (sdktrace.SpanExporter, error)
+
+var (
+	lock      sync.Mutex
+	exporters = map[string]ExporterFunc{
+		"stdout": func(Config) (sdktrace.SpanExporter, error) {
+			return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		},
+		// json spans, one a line, appended to a file; for working offline
+		"file": func(c Config) (sdktrace.SpanExporter, error) {
+			if c.File == "" {
+				return nil, fmt.Errorf("the file exporter needs a file")
+			}
+			f, err := os.OpenFile(c.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return nil, err
+			}
+			e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			return fileExporter{e, f}, nil
+		},
+		"otlp": func(c Config) (sdktrace.SpanExporter, error) {
+			opts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+			if c.OTLPEndpoint != "" {
+				opts = append(opts, otlptracegrpc.WithEndpoint(c.OTLPEndpoint))
+			}
+			return otlptracegrpc.New(context.Background(), opts...)
+		},
+	}
+)
+
+// fileExporter closes its file when it's shut down
+type fileExporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	err := e.Exporter.Shutdown(ctx)
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RegisterExporter makes another exporter available to Setup, by name
+func RegisterExporter(name string, fn ExporterFunc) {
+	lock.Lock()
+	defer lock.Unlock()
+	exporters[name] = fn
+}
+
+// Exporters that are registered, for flag help
+func Exporters() []string {
+	lock.Lock()
+	defer lock.Unlock()
+	names := []string{"none"}
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Setup the global tracer provider and the w3c trace context propagator.  The
+// function it returns flushes whatever spans are still buffered and stops the
+// exporter: call it on the way out.
+func Setup(cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if cfg.Exporter == "" || cfg.Exporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+	lock.Lock()
+	newExporter, ok := exporters[cfg.Exporter]
+	lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(ServiceName),
+		semconv.ServiceInstanceIDKey.String(cfg.Node),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,83 @@
+package tracing_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yarbelk/distributedservice/grpctest"
+	"github.com/yarbelk/distributedservice/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// hop carries the trace through each node
+func hop() grpctest.Hop {
+	return grpctest.Hop{
+		ServerOptions: []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor),
+			grpc.ChainStreamInterceptor(tracing.StreamServerInterceptor),
+		},
+		DialOptions: tracing.DialOptions(),
+	}
+}
+
+func TestTraceAcrossNodes(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(context.Background())
+
+	b := hop().Serve(t, nil)
+	a := hop().Serve(t, b)
+
+	ctx, root := tracing.Start(context.Background(), "test")
+	if _, err := a.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	spans := exporter.GetSpans()
+	// the test's client call, a serving it, a calling b, b serving it, and the root
+	if len(spans) != 5 {
+		t.Fatalf("expected 5 spans, got %d: %v", len(spans), spans)
+	}
+	traceID := root.SpanContext().TraceID()
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != traceID {
+			t.Errorf("span %s isn't in the test's trace", s.Name)
+		}
+	}
+	// each span's parent is the one before it: finished innermost first
+	for i := 0; i < len(spans)-1; i++ {
+		if spans[i].Parent.SpanID() != spans[i+1].SpanContext.SpanID() {
+			t.Errorf("%s (%s) isn't a child of the span before it", spans[i].Name, spans[i].SpanKind)
+		}
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := tracing.Setup(tracing.Config{Exporter: "file", File: file, SampleRatio: 1, Node: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracing.Start(context.Background(), "written down")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Name":"written down"`) {
+		t.Errorf("span isn't in the file: %s", b)
+	}
+}